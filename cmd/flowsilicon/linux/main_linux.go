@@ -6,10 +6,13 @@
 package main
 
 import (
+	"errors"
 	"flowsilicon/internal/config"
 	"flowsilicon/internal/key"
 	"flowsilicon/internal/logger"
+	"flowsilicon/internal/middleware"
 	"flowsilicon/internal/model"
+	"flowsilicon/internal/proxy"
 	"flowsilicon/internal/web"
 	"fmt"
 	"os"
@@ -108,6 +111,18 @@ func main() {
 		logger.Info("确保API密钥表存在成功")
 	}
 
+	// 处理主密钥轮换命令：使用新主密钥重新加密所有API密钥后退出
+	if len(os.Args) > 1 && os.Args[1] == "rotate-master-key" {
+		if err := config.RotateMasterKey(os.Getenv(config.NewMasterKeyEnv)); err != nil {
+			logger.Error("轮换主密钥失败: %v", err)
+			fmt.Printf("轮换主密钥失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("主密钥轮换完成")
+		config.CloseConfigDB()
+		os.Exit(0)
+	}
+
 	// 设置数据文件路径
 	config.SetDailyFilePath(getAbsolutePath("data/daily.json"))
 
@@ -178,6 +193,10 @@ func main() {
 
 	// 加载API密钥
 	if err := config.LoadApiKeysFromDB(); err != nil {
+		// 主密钥不正确时无法解密已保存的密钥，继续运行会以空列表工作，直接退出由用户检查主密钥
+		if errors.Is(err, config.ErrApiKeyDecrypt) {
+			logger.Fatal("加载API密钥失败: %v，请检查环境变量 %s 或主密钥文件", err, config.MasterKeyEnv)
+		}
 		logger.Error("加载API密钥失败: %v", err)
 		// 继续执行，因为这不是致命错误
 	} else {
@@ -195,13 +214,25 @@ func main() {
 	key.StartKeyManager()
 	logger.Info("API密钥管理器已启动")
 
+	// 启动视频任务清理
+	proxy.StartVideoJobCleaner()
+
+	// 启动归档图片清理
+	proxy.StartImageGalleryCleaner()
+
 	// 输出模型策略配置
 	logModelStrategies()
 
 	// 创建Gin路由
 	router := gin.Default()
 	// 设置受信任的代理
-	router.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	trustedProxies := middleware.DefaultTrustedProxies
+	if cfg.IPFilter.TrustedProxies != nil {
+		trustedProxies = cfg.IPFilter.TrustedProxies
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Error("设置受信任的代理失败: %v", err)
+	}
 
 	// 设置API代理
 	web.SetupApiProxy(router)
@@ -222,7 +253,7 @@ func main() {
 	// 在goroutine中启动服务器
 	go func() {
 		logger.Info("服务器启动在 :%d", serverPort)
-		if err := router.Run(fmt.Sprintf(":%d", serverPort)); err != nil {
+		if err := web.RunServer(router, serverPort); err != nil {
 			logger.Error("服务器启动失败: %v", err)
 			os.Exit(1)
 		}
@@ -232,7 +263,7 @@ func main() {
 	time.Sleep(500 * time.Millisecond)
 
 	// 打印访问信息
-	logger.Info("流动硅基服务已启动，请访问 http://localhost:%d", serverPort)
+	logger.Info("流动硅基服务已启动，请访问 %s", web.ServerURL(serverPort))
 
 	// 等待信号
 	<-sigChan
@@ -356,36 +387,19 @@ func ensureDirectoriesExist() error {
 func logModelStrategies() {
 	cfg := config.GetConfig()
 
-	if len(cfg.App.ModelKeyStrategies) == 0 {
+	if len(cfg.App.ModelKeyStrategies) == 0 && len(cfg.App.ModelKeySelectors) == 0 {
 		logger.Info("未配置任何模型特定策略")
 		return
 	}
 
 	logger.Info("===== 模型特定策略配置 =====")
 	for model, strategyID := range cfg.App.ModelKeyStrategies {
-		var strategyName string
-		switch strategyID {
-		case 1:
-			strategyName = "高成功率"
-		case 2:
-			strategyName = "高分数"
-		case 3:
-			strategyName = "低RPM"
-		case 4:
-			strategyName = "低TPM"
-		case 5:
-			strategyName = "高余额"
-		case 6:
-			strategyName = "普通"
-		case 7:
-			strategyName = "低余额"
-		case 8:
-			strategyName = "免费"
-		default:
-			strategyName = "普通"
-		}
+		strategyName := key.StrategyDisplayName(strategyID)
 		logger.Info("模型: %s, 策略: %s (%d)", model, strategyName, strategyID)
 	}
+	for model, selectorName := range cfg.App.ModelKeySelectors {
+		logger.Info("模型: %s, 策略: %s", model, selectorName)
+	}
 	logger.Info("==========================")
 }
 
@@ -1,34 +1,119 @@
 /**
   @author: Hanhai
-  @desc: JWT认证相关功能模块，提供token生成、解析和密码哈希等功能
+  @desc: JWT认证相关功能模块，提供token生成、解析、会话失效和密码哈希等功能
 **/
 
 package auth
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"flowsilicon/internal/config"
 	"flowsilicon/internal/logger"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	// 默认的密钥
-	secretKey = []byte("flowsilicon_default_secret_key")
+	// 令牌签名密钥，首次使用时从数据库加载
+	secretKey []byte
+	// 当前会话版本号，令牌中的版本号与之不一致时视为失效
+	sessionEpoch int64
+	// 是否已加载签名密钥和会话版本号
+	secretLoaded bool
+	// 互斥锁保护签名密钥和会话版本号
+	secretMutex sync.RWMutex
 )
 
-// GenerateToken 生成简单的认证Token
-// 格式: timestamp.expiration.signature
+// loadSecret 获取签名密钥和会话版本号，首次调用时从数据库加载
+// 数据库不可用时使用随机生成的临时密钥，重启后已签发的令牌将失效
+func loadSecret() ([]byte, int64) {
+	secretMutex.RLock()
+	if secretLoaded {
+		defer secretMutex.RUnlock()
+		return secretKey, sessionEpoch
+	}
+	secretMutex.RUnlock()
+
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	if secretLoaded {
+		return secretKey, sessionEpoch
+	}
+
+	secret, err := config.GetAuthSecret()
+	if err != nil {
+		logger.Error("加载令牌签名密钥失败，使用临时密钥: %v", err)
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logger.Error("生成临时签名密钥失败: %v", err)
+		}
+	}
+
+	epoch, err := config.GetSessionEpoch()
+	if err != nil {
+		logger.Error("加载会话版本号失败: %v", err)
+	}
+
+	secretKey = secret
+	sessionEpoch = epoch
+	secretLoaded = true
+	return secretKey, sessionEpoch
+}
+
+// InvalidateAllSessions 使所有已签发的令牌失效，修改密码时调用
+func InvalidateAllSessions() error {
+	loadSecret()
+
+	epoch, err := config.IncrementSessionEpoch()
+	if err != nil {
+		return err
+	}
+
+	secretMutex.Lock()
+	sessionEpoch = epoch
+	secretMutex.Unlock()
+
+	config.RevokeAllLoginSessions()
+
+	logger.Info("已使所有登录会话失效，当前会话版本号: %d", epoch)
+	return nil
+}
+
+// signToken 计算令牌数据的签名
+func signToken(secret []byte, data string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TokenClaims 令牌中携带的信息
+type TokenClaims struct {
+	IssuedAt  int64  // 签发时间戳
+	ExpiresAt int64  // 过期时间戳
+	Username  string // 用户名，为空表示使用全局密码登录
+	SessionID string // 对应的登录会话ID
+}
+
+// GenerateToken 生成认证Token
+// 格式: timestamp.expiration.epoch.username.session.signature
 // timestamp: 当前时间戳
-// expiration: 过期时间（分钟）
-// signature: HMAC-SHA256(timestamp.expiration, secretKey)
-func GenerateToken(expirationMinutes int) (string, error) {
+// expiration: 过期时间戳
+// epoch: 会话版本号
+// username: base64url编码的用户名
+// session: 登录会话ID
+// signature: HMAC-SHA256(timestamp.expiration.epoch.username.session, secretKey)
+func GenerateToken(username, sessionID string, expirationMinutes int) (string, error) {
+	secret, epoch := loadSecret()
 	now := time.Now().Unix()
 
 	// 确保有一个最小的过期时间（1分钟）
@@ -38,83 +123,128 @@ func GenerateToken(expirationMinutes int) (string, error) {
 
 	expiration := now + int64(expirationMinutes*60)
 
-	data := fmt.Sprintf("%d.%d", now, expiration)
-
-	// 计算签名
-	h := hmac.New(sha256.New, secretKey)
-	h.Write([]byte(data))
-	signature := hex.EncodeToString(h.Sum(nil))
+	data := fmt.Sprintf("%d.%d.%d.%s.%s", now, expiration, epoch,
+		base64.RawURLEncoding.EncodeToString([]byte(username)), sessionID)
 
 	// 生成完整token
-	token := fmt.Sprintf("%s.%s", data, signature)
+	token := fmt.Sprintf("%s.%s", data, signToken(secret, data))
 	return token, nil
 }
 
 // ParseToken 解析令牌
 func ParseToken(tokenString string) (bool, error) {
+	if _, err := ParseTokenClaims(tokenString); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ParseTokenClaims 解析令牌并返回其中携带的信息
+func ParseTokenClaims(tokenString string) (*TokenClaims, error) {
 	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
-		return false, errors.New("invalid token format")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid token format")
 	}
 
 	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	expiration, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	epoch, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查token是否过期
 	now := time.Now().Unix()
 	if now > expiration {
-		return false, errors.New("token expired")
+		return nil, errors.New("token expired")
 	}
 
 	// 验证签名
-	data := fmt.Sprintf("%d.%d", timestamp, expiration)
-	h := hmac.New(sha256.New, secretKey)
-	h.Write([]byte(data))
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
+	secret, currentEpoch := loadSecret()
+	data := strings.Join(parts[:5], ".")
+	if !hmac.Equal([]byte(parts[5]), []byte(signToken(secret, data))) {
+		return nil, errors.New("invalid signature")
+	}
 
-	if parts[2] != expectedSignature {
-		return false, errors.New("invalid signature")
+	// 检查会话是否已因修改密码而失效
+	if epoch != currentEpoch {
+		return nil, errors.New("session revoked")
 	}
 
-	return true, nil
+	return &TokenClaims{
+		IssuedAt:  timestamp,
+		ExpiresAt: expiration,
+		Username:  string(username),
+		SessionID: parts[4],
+	}, nil
 }
 
-// HashPassword 使用SHA256对密码进行哈希
-func HashPassword(password string) string {
+// HashPassword 使用bcrypt对密码进行加盐哈希
+func HashPassword(password string) (string, error) {
 	if password == "" {
-		return ""
+		return "", nil
 	}
 
-	// 计算SHA256哈希
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
 }
 
-// VerifyPassword 验证密码
+// isLegacyPasswordHash 判断是否为旧版本的无盐SHA256哈希
+func isLegacyPasswordHash(storedPassword string) bool {
+	if len(storedPassword) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(storedPassword)
+	return err == nil
+}
+
+// VerifyPassword 验证密码，同时兼容旧版本的SHA256哈希
 func VerifyPassword(inputPassword, storedPassword string) bool {
 	// 如果存储的密码为空，则不需要验证
 	if storedPassword == "" {
 		return true
 	}
 
-	// 对输入密码进行哈希处理
-	hashedInput := HashPassword(inputPassword)
+	if isLegacyPasswordHash(storedPassword) {
+		hash := sha256.Sum256([]byte(inputPassword))
+		return hmac.Equal([]byte(hex.EncodeToString(hash[:])), []byte(storedPassword))
+	}
 
-	// 对比哈希值
-	return hashedInput == storedPassword
+	return bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(inputPassword)) == nil
+}
+
+// PasswordNeedsRehash 判断存储的密码哈希是否需要升级为当前的哈希方式
+func PasswordNeedsRehash(storedPassword string) bool {
+	if storedPassword == "" {
+		return false
+	}
+	if isLegacyPasswordHash(storedPassword) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(storedPassword))
+	return err != nil || cost < bcrypt.DefaultCost
 }
 
 // GenerateCookie 生成包含Token的Cookie值
-func GenerateCookie(expirationMinutes int) (string, error) {
+func GenerateCookie(username, sessionID string, expirationMinutes int) (string, error) {
 	// 生成令牌
-	tokenString, err := GenerateToken(expirationMinutes)
+	tokenString, err := GenerateToken(username, sessionID, expirationMinutes)
 	if err != nil {
 		logger.Error("生成令牌失败: %v", err)
 		return "", err
@@ -127,17 +257,25 @@ func GenerateCookie(expirationMinutes int) (string, error) {
 
 // ParseCookie 解析Cookie中的Token
 func ParseCookie(cookieValue string) (bool, error) {
+	if _, err := ParseCookieClaims(cookieValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ParseCookieClaims 解析Cookie中的Token并返回其中携带的信息
+func ParseCookieClaims(cookieValue string) (*TokenClaims, error) {
 	if cookieValue == "" {
-		return false, errors.New("空Cookie值")
+		return nil, errors.New("空Cookie值")
 	}
 
 	// 解码Cookie值
 	tokenBytes, err := base64.StdEncoding.DecodeString(cookieValue)
 	if err != nil {
 		logger.Error("解码Cookie值失败: %v", err)
-		return false, err
+		return nil, err
 	}
 
 	// 解析令牌
-	return ParseToken(string(tokenBytes))
+	return ParseTokenClaims(string(tokenBytes))
 }
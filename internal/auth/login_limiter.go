@@ -0,0 +1,134 @@
+/**
+  @author: Hanhai
+  @desc: 登录防暴力破解，按IP和全局统计登录失败次数，超过阈值后暂时锁定
+**/
+
+package auth
+
+import (
+	"flowsilicon/internal/config"
+	"sync"
+	"time"
+)
+
+// 登录限制的默认参数
+const (
+	defaultLoginMaxAttempts       = 5
+	defaultLoginWindow            = 15 * time.Minute
+	defaultLoginLockout           = 15 * time.Minute
+	defaultLoginGlobalMaxFailures = 100
+)
+
+// loginFailures 登录失败记录
+type loginFailures struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+var (
+	// 按IP统计的登录失败记录
+	ipLoginFailures = make(map[string]*loginFailures)
+	// 全局登录失败记录
+	globalLoginFailures = &loginFailures{}
+	// 互斥锁保护登录失败记录
+	loginFailuresMutex sync.Mutex
+)
+
+// loginLimits 获取登录限制参数，未配置时使用默认值
+func loginLimits() (maxAttempts int, window, lockout time.Duration, globalMax int) {
+	maxAttempts = defaultLoginMaxAttempts
+	window = defaultLoginWindow
+	lockout = defaultLoginLockout
+	globalMax = defaultLoginGlobalMaxFailures
+
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return
+	}
+	if cfg.Security.LoginMaxAttempts > 0 {
+		maxAttempts = cfg.Security.LoginMaxAttempts
+	}
+	if cfg.Security.LoginWindowMinutes > 0 {
+		window = time.Duration(cfg.Security.LoginWindowMinutes) * time.Minute
+	}
+	if cfg.Security.LoginLockoutMinutes > 0 {
+		lockout = time.Duration(cfg.Security.LoginLockoutMinutes) * time.Minute
+	}
+	if cfg.Security.LoginGlobalMaxFailures > 0 {
+		globalMax = cfg.Security.LoginGlobalMaxFailures
+	}
+	return
+}
+
+// pruneFailures 移除统计窗口之外的失败记录
+func (f *loginFailures) pruneFailures(now time.Time, window time.Duration) {
+	kept := f.failures[:0]
+	for _, t := range f.failures {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	f.failures = kept
+}
+
+// CheckLoginAllowed 检查IP当前是否允许登录，被锁定时返回剩余锁定时间
+func CheckLoginAllowed(ip string) (time.Duration, bool) {
+	now := time.Now()
+
+	loginFailuresMutex.Lock()
+	defer loginFailuresMutex.Unlock()
+
+	if now.Before(globalLoginFailures.lockedUntil) {
+		return globalLoginFailures.lockedUntil.Sub(now), false
+	}
+	if record, exists := ipLoginFailures[ip]; exists && now.Before(record.lockedUntil) {
+		return record.lockedUntil.Sub(now), false
+	}
+	return 0, true
+}
+
+// RecordLoginFailure 记录一次登录失败，达到阈值时锁定该IP或暂停所有登录
+// 返回该IP是否因此被锁定
+func RecordLoginFailure(ip string) bool {
+	maxAttempts, window, lockout, globalMax := loginLimits()
+	now := time.Now()
+
+	loginFailuresMutex.Lock()
+	defer loginFailuresMutex.Unlock()
+
+	record, exists := ipLoginFailures[ip]
+	if !exists {
+		record = &loginFailures{}
+		ipLoginFailures[ip] = record
+	}
+	record.pruneFailures(now, window)
+	record.failures = append(record.failures, now)
+
+	globalLoginFailures.pruneFailures(now, window)
+	globalLoginFailures.failures = append(globalLoginFailures.failures, now)
+	if len(globalLoginFailures.failures) >= globalMax {
+		globalLoginFailures.lockedUntil = now.Add(lockout)
+		globalLoginFailures.failures = nil
+	}
+
+	if len(record.failures) >= maxAttempts {
+		record.lockedUntil = now.Add(lockout)
+		record.failures = nil
+		return true
+	}
+
+	// 清理已过期且没有失败记录的IP，避免记录无限增长
+	for key, r := range ipLoginFailures {
+		if len(r.failures) == 0 && now.After(r.lockedUntil) {
+			delete(ipLoginFailures, key)
+		}
+	}
+	return false
+}
+
+// RecordLoginSuccess 登录成功后清除该IP的失败记录
+func RecordLoginSuccess(ip string) {
+	loginFailuresMutex.Lock()
+	defer loginFailuresMutex.Unlock()
+	delete(ipLoginFailures, ip)
+}
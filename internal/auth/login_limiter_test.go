@@ -0,0 +1,101 @@
+/**
+  @author: Hanhai
+  @desc: 登录防暴力破解的测试，按配置的阈值锁定IP和全局登录，登录成功后重置
+**/
+
+package auth
+
+import (
+	"flowsilicon/internal/config"
+	"testing"
+	"time"
+)
+
+// setupLoginLimiterTest 使用指定阈值并清空登录失败记录
+func setupLoginLimiterTest(t *testing.T, maxAttempts, globalMax int) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Security.LoginMaxAttempts = maxAttempts
+	cfg.Security.LoginGlobalMaxFailures = globalMax
+	cfg.Security.LoginLockoutMinutes = 10
+	previous := config.GetConfig()
+	config.UpdateConfig(cfg)
+
+	reset := func() {
+		loginFailuresMutex.Lock()
+		ipLoginFailures = make(map[string]*loginFailures)
+		globalLoginFailures = &loginFailures{}
+		loginFailuresMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		reset()
+		config.UpdateConfig(previous)
+	})
+}
+
+func TestLoginLockoutPerIP(t *testing.T) {
+	setupLoginLimiterTest(t, 3, 100)
+	const ip = "10.0.0.1"
+
+	for i := 1; i <= 2; i++ {
+		if locked := RecordLoginFailure(ip); locked {
+			t.Fatalf("第%d次失败不应锁定", i)
+		}
+		if _, allowed := CheckLoginAllowed(ip); !allowed {
+			t.Fatalf("第%d次失败后不应锁定", i)
+		}
+	}
+	if locked := RecordLoginFailure(ip); !locked {
+		t.Fatal("达到阈值时应锁定")
+	}
+	retryAfter, allowed := CheckLoginAllowed(ip)
+	if allowed || retryAfter <= 9*time.Minute || retryAfter > 10*time.Minute {
+		t.Errorf("CheckLoginAllowed = %v, %v, want 约10分钟的锁定", retryAfter, allowed)
+	}
+	if _, allowed := CheckLoginAllowed("10.0.0.2"); !allowed {
+		t.Error("锁定一个IP不应影响其他IP")
+	}
+
+	// 锁定时间结束后允许登录
+	loginFailuresMutex.Lock()
+	ipLoginFailures[ip].lockedUntil = time.Now().Add(-time.Second)
+	loginFailuresMutex.Unlock()
+	if _, allowed := CheckLoginAllowed(ip); !allowed {
+		t.Error("锁定时间结束后应允许登录")
+	}
+}
+
+func TestLoginSuccessResetsFailures(t *testing.T) {
+	setupLoginLimiterTest(t, 3, 100)
+	const ip = "10.0.0.3"
+
+	RecordLoginFailure(ip)
+	RecordLoginFailure(ip)
+	RecordLoginSuccess(ip)
+
+	// 登录成功后重新计数
+	RecordLoginFailure(ip)
+	if locked := RecordLoginFailure(ip); locked {
+		t.Error("登录成功后失败次数应重置")
+	}
+	if _, allowed := CheckLoginAllowed(ip); !allowed {
+		t.Error("登录成功后失败次数应重置")
+	}
+}
+
+func TestLoginGlobalLockout(t *testing.T) {
+	setupLoginLimiterTest(t, 3, 4)
+
+	// 每个IP的失败次数都未达到阈值，但全局失败次数达到阈值
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+		RecordLoginFailure(ip)
+	}
+	if _, allowed := CheckLoginAllowed("10.0.1.9"); !allowed {
+		t.Fatal("未达到全局阈值时不应锁定")
+	}
+	RecordLoginFailure("10.0.1.4")
+	if _, allowed := CheckLoginAllowed("10.0.1.9"); allowed {
+		t.Error("达到全局阈值后应暂停所有登录")
+	}
+}
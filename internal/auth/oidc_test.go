@@ -0,0 +1,158 @@
+/**
+  @author: Hanhai
+  @desc: 单点登录的测试，使用模拟的身份提供方验证ID令牌声明的检查
+**/
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockOIDCIssuer 模拟的身份提供方，令牌端点返回使用claims签名的ID令牌
+type mockOIDCIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	nonce  string
+	claims map[string]interface{}
+}
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockOIDCIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.signIDToken(t)})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	cfg := &config.Config{}
+	cfg.OIDC.Enabled = true
+	cfg.OIDC.Issuer = issuer.server.URL
+	cfg.OIDC.ClientID = "flowsilicon-test"
+	previous := config.GetConfig()
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+	return issuer
+}
+
+// signIDToken 使用标准声明和测试指定的声明生成ID令牌
+func (m *mockOIDCIssuer) signIDToken(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":   m.server.URL,
+		"aud":   "flowsilicon-test",
+		"sub":   "user-123",
+		"nonce": m.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range m.claims {
+		claims[name] = value
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login 完成一次登录流程，返回回调的处理结果
+func (m *mockOIDCIssuer) login(t *testing.T, claims map[string]interface{}) (*OIDCIdentity, error) {
+	t.Helper()
+	ctx := context.Background()
+	redirectURI := "http://localhost/auth/oidc/callback"
+
+	authURL, state, err := BeginOIDCLogin(ctx, redirectURI, "/")
+	if err != nil {
+		t.Fatalf("发起登录失败: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nonce = parsed.Query().Get("nonce")
+	m.claims = claims
+
+	identity, _, err := CompleteOIDCLogin(ctx, state, "test-code", redirectURI)
+	return identity, err
+}
+
+func TestCompleteOIDCLoginRequiresVerifiedEmail(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"缺少email_verified", map[string]interface{}{"email": "alice@example.com"}},
+		{"email_verified为false", map[string]interface{}{"email": "alice@example.com", "email_verified": false}},
+		{"email_verified为字符串", map[string]interface{}{"email": "alice@example.com", "email_verified": "true"}},
+	}
+	for _, tt := range tests {
+		identity, err := issuer.login(t, tt.claims)
+		if err == nil || !strings.Contains(err.Error(), "邮箱尚未验证") {
+			t.Errorf("%s: 应拒绝登录，实际 identity=%+v err=%v", tt.name, identity, err)
+		}
+	}
+
+	identity, err := issuer.login(t, map[string]interface{}{"email": "Alice@Example.com", "email_verified": true})
+	if err != nil {
+		t.Fatalf("邮箱已验证时应登录成功: %v", err)
+	}
+	if identity.Issuer != issuer.server.URL || identity.Subject != "user-123" || identity.Email != "alice@example.com" {
+		t.Errorf("返回的身份不正确: %+v", identity)
+	}
+}
+
+func TestCompleteOIDCLoginRejectsMissingSubject(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+
+	_, err := issuer.login(t, map[string]interface{}{"sub": "", "email": "alice@example.com", "email_verified": true})
+	if err == nil {
+		t.Fatal("缺少用户标识时应拒绝登录")
+	}
+}
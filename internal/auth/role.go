@@ -0,0 +1,35 @@
+/**
+  @author: Hanhai
+  @desc: 管理界面用户角色定义与权限判断
+**/
+
+package auth
+
+// 用户角色
+const (
+	RoleAdmin    = "admin"    // 管理员，可修改设置和重启系统
+	RoleOperator = "operator" // 运维人员，可管理密钥和模型
+	RoleViewer   = "viewer"   // 访客，只能查看统计信息
+)
+
+// 各角色的权限等级，等级高的角色拥有等级低的角色的全部权限
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// IsValidRole 判断角色是否有效
+func IsValidRole(role string) bool {
+	_, exists := roleLevels[role]
+	return exists
+}
+
+// RoleAllows 判断角色是否拥有所需角色的权限
+func RoleAllows(role, required string) bool {
+	level, exists := roleLevels[role]
+	if !exists {
+		return false
+	}
+	return level >= roleLevels[required]
+}
@@ -0,0 +1,42 @@
+/**
+  @author: Hanhai
+  @desc: 用户角色权限判断的测试
+**/
+
+package auth
+
+import "testing"
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleOperator, true},
+		{RoleAdmin, RoleViewer, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleOperator, RoleOperator, true},
+		{RoleOperator, RoleViewer, true},
+		{RoleViewer, RoleAdmin, false},
+		{RoleViewer, RoleOperator, false},
+		{RoleViewer, RoleViewer, true},
+		{"", RoleViewer, false},
+		{"superuser", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := RoleAllows(tt.role, tt.required); got != tt.want {
+			t.Errorf("RoleAllows(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+
+	for _, role := range []string{RoleAdmin, RoleOperator, RoleViewer} {
+		if !IsValidRole(role) {
+			t.Errorf("IsValidRole(%q) = false", role)
+		}
+	}
+	if IsValidRole("Admin") || IsValidRole("") {
+		t.Error("未定义的角色不应有效")
+	}
+}
@@ -0,0 +1,92 @@
+/**
+  @author: Hanhai
+  @desc: 登录会话管理，创建会话并签发Cookie，验证Cookie时检查会话是否仍然有效
+**/
+
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flowsilicon/internal/config"
+	"time"
+)
+
+// Session 已认证的登录会话
+type Session struct {
+	ID       string // 会话ID
+	Username string // 用户名，为空表示使用全局密码登录
+	Role     string // 当前角色
+}
+
+// generateSessionID 生成随机的会话ID
+func generateSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateSession 创建登录会话并返回对应的Cookie值
+func CreateSession(username, ip, userAgent string, expirationMinutes int) (string, error) {
+	if expirationMinutes <= 0 {
+		expirationMinutes = 1
+	}
+
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	err = config.CreateLoginSession(config.LoginSession{
+		ID:        sessionID,
+		Username:  username,
+		IP:        ip,
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(time.Duration(expirationMinutes) * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return GenerateCookie(username, sessionID, expirationMinutes)
+}
+
+// ResolveSession 验证Cookie并获取对应的用户和角色
+// 会话被撤销或过期后失效；使用全局密码登录的会话视为管理员；
+// 用户会话使用用户当前的角色，用户被禁用、删除或修改密码后会话失效
+func ResolveSession(cookieValue string) (*Session, error) {
+	claims, err := ParseCookieClaims(cookieValue)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, exists := config.GetLoginSession(claims.SessionID)
+	if !exists || stored.Revoked || stored.Username != claims.Username {
+		return nil, errors.New("session revoked")
+	}
+	if stored.ExpiresAt < time.Now().Unix() {
+		return nil, errors.New("session expired")
+	}
+
+	session := &Session{ID: stored.ID, Role: RoleAdmin}
+	if claims.Username != "" {
+		user, exists := config.GetUser(claims.Username)
+		if !exists {
+			return nil, errors.New("user not found")
+		}
+		if user.Disabled {
+			return nil, errors.New("user disabled")
+		}
+		if claims.IssuedAt < user.PasswordChangedAt {
+			return nil, errors.New("session revoked")
+		}
+		session.Username = user.Username
+		session.Role = user.Role
+	}
+
+	config.TouchLoginSession(stored.ID)
+	return session, nil
+}
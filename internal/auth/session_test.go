@@ -0,0 +1,141 @@
+/**
+  @author: Hanhai
+  @desc: 登录会话的测试，会话撤销、用户状态变化和会话版本号递增后令牌失效
+**/
+
+package auth
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"path/filepath"
+	"testing"
+)
+
+// setupSessionTest 使用临时目录中的数据库，并重新加载签名密钥
+func setupSessionTest(t *testing.T) {
+	t.Helper()
+	logger.InitLogger()
+	if err := config.InitConfigDB(filepath.Join(t.TempDir(), "config.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	resetSecretForTest()
+	t.Cleanup(func() {
+		config.CloseConfigDB()
+		resetSecretForTest()
+	})
+}
+
+func resetSecretForTest() {
+	secretMutex.Lock()
+	secretKey, sessionEpoch, secretLoaded = nil, 0, false
+	secretMutex.Unlock()
+}
+
+func TestResolveSessionRevocation(t *testing.T) {
+	setupSessionTest(t)
+
+	cookie, err := CreateSession("", "127.0.0.1", "test", 60)
+	if err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	session, err := ResolveSession(cookie)
+	if err != nil || session.Role != RoleAdmin {
+		t.Fatalf("全局密码登录的会话应为管理员: %+v, %v", session, err)
+	}
+
+	if err := config.RevokeLoginSession(session.ID); err != nil {
+		t.Fatalf("撤销会话失败: %v", err)
+	}
+	if _, err := ResolveSession(cookie); err == nil {
+		t.Error("撤销后的会话仍然有效")
+	}
+	if _, err := ResolveSession("not-a-cookie"); err == nil {
+		t.Error("无效的Cookie应验证失败")
+	}
+}
+
+func TestResolveSessionFollowsUser(t *testing.T) {
+	setupSessionTest(t)
+
+	if err := config.CreateUser("alice", "hash", RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := CreateSession("alice", "127.0.0.1", "test", 60)
+	if err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	if session, err := ResolveSession(cookie); err != nil || session.Username != "alice" || session.Role != RoleOperator {
+		t.Fatalf("ResolveSession = %+v, %v", session, err)
+	}
+
+	// 会话使用用户当前的角色
+	if err := config.SetUserRole("alice", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if session, err := ResolveSession(cookie); err != nil || session.Role != RoleViewer {
+		t.Fatalf("修改角色后 ResolveSession = %+v, %v", session, err)
+	}
+
+	// 用户被禁用后会话失效
+	if err := config.SetUserDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveSession(cookie); err == nil {
+		t.Error("用户被禁用后会话仍然有效")
+	}
+
+	// 撤销用户的所有会话
+	config.SetUserDisabled("alice", false)
+	if _, err := ResolveSession(cookie); err != nil {
+		t.Fatalf("重新启用用户后会话应恢复: %v", err)
+	}
+	if count := config.RevokeUserLoginSessions("alice"); count != 1 {
+		t.Errorf("撤销的会话数 = %d, want 1", count)
+	}
+	if _, err := ResolveSession(cookie); err == nil {
+		t.Error("撤销用户会话后会话仍然有效")
+	}
+}
+
+func TestInvalidateAllSessionsBumpsEpoch(t *testing.T) {
+	setupSessionTest(t)
+
+	oldToken, err := GenerateToken("", "session-old", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCookie, err := CreateSession("", "127.0.0.1", "test", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, epochBefore := loadSecret()
+
+	if err := InvalidateAllSessions(); err != nil {
+		t.Fatalf("使所有会话失效失败: %v", err)
+	}
+	if _, epochAfter := loadSecret(); epochAfter != epochBefore+1 {
+		t.Errorf("会话版本号 = %d, want %d", epochAfter, epochBefore+1)
+	}
+
+	// 旧版本号签发的令牌失效，即使对应的会话记录仍然存在
+	if _, err := ParseTokenClaims(oldToken); err == nil {
+		t.Error("版本号递增后旧令牌仍然有效")
+	}
+	if _, err := ResolveSession(oldCookie); err == nil {
+		t.Error("使所有会话失效后旧会话仍然有效")
+	}
+
+	// 版本号保存在数据库中，重新加载后保持一致
+	resetSecretForTest()
+	if _, epoch := loadSecret(); epoch != epochBefore+1 {
+		t.Errorf("重新加载的会话版本号 = %d, want %d", epoch, epochBefore+1)
+	}
+	newCookie, err := CreateSession("", "127.0.0.1", "test", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveSession(newCookie); err != nil {
+		t.Errorf("新会话应有效: %v", err)
+	}
+}
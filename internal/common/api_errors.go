@@ -7,6 +7,7 @@ package common
 
 import (
 	"fmt"
+	"time"
 )
 
 // ApiError API错误结构
@@ -30,3 +31,27 @@ func NewApiError(message string, code int) error {
 
 // ErrNoActiveKeys 没有可用的API密钥错误
 var ErrNoActiveKeys = NewApiError("没有可用的API密钥", 500)
+
+// ErrKeysSaturated 所有API密钥并发已满且排队等待超时错误
+var ErrKeysSaturated = NewApiError("所有API密钥并发已满，等待超时", 503)
+
+// ErrQueueFull 请求队列已满错误
+var ErrQueueFull = NewApiError("请求队列已满", 503)
+
+// ErrKeysCoolingDown 所有API密钥都处于限流冷却错误
+var ErrKeysCoolingDown = NewApiError("所有API密钥都处于限流冷却", 429)
+
+// CooldownError 所有API密钥都处于限流冷却时返回的错误，包含最短的剩余冷却时间
+type CooldownError struct {
+	RetryAfter time.Duration
+}
+
+// Error 实现 error 接口
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%v，%v后可重试", ErrKeysCoolingDown, e.RetryAfter)
+}
+
+// Unwrap 使 errors.Is 可以匹配 ErrKeysCoolingDown
+func (e *CooldownError) Unwrap() error {
+	return ErrKeysCoolingDown
+}
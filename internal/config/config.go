@@ -33,6 +33,14 @@ var (
 type Config struct {
 	Server struct {
 		Port int `mapstructure:"port"`
+		TLS  struct {
+			Enabled           bool   `mapstructure:"enabled"`             // 是否启用HTTPS，修改后需要重启
+			CertFile          string `mapstructure:"cert_file"`           // 证书文件路径，文件更新后自动重新加载
+			KeyFile           string `mapstructure:"key_file"`            // 私钥文件路径
+			SelfSigned        bool   `mapstructure:"self_signed"`         // 未指定证书时自动生成自签名证书
+			ClientCAFile      string `mapstructure:"client_ca_file"`      // 验证客户端证书使用的CA证书文件，修改路径后需要重启，文件内容更新后自动重新加载
+			RequireClientCert bool   `mapstructure:"require_client_cert"` // 是否要求代理接口的请求提供有效的客户端证书，修改后需要重启
+		} `mapstructure:"tls"`
 	} `mapstructure:"server"`
 	ApiProxy struct {
 		BaseURL    string      `mapstructure:"base_url"`
@@ -53,6 +61,11 @@ type Config struct {
 		ExpirationMinutes int    `mapstructure:"expiration_minutes"` // 登录过期时间（分钟），0表示关闭浏览器即过期
 		ApiKeyEnabled     bool   `mapstructure:"api_key_enabled"`    // 是否启用API密钥验证
 		ApiKey            string `mapstructure:"api_key"`            // API密钥
+		// 登录防暴力破解
+		LoginMaxAttempts       int `mapstructure:"login_max_attempts"`        // 单个IP在统计窗口内允许的最大失败次数
+		LoginWindowMinutes     int `mapstructure:"login_window_minutes"`      // 登录失败统计窗口（分钟）
+		LoginLockoutMinutes    int `mapstructure:"login_lockout_minutes"`     // 超过失败次数后的锁定时间（分钟）
+		LoginGlobalMaxFailures int `mapstructure:"login_global_max_failures"` // 统计窗口内所有IP允许的最大失败次数，超过后暂停所有登录
 	} `mapstructure:"security"`
 	App struct {
 		Title                  string  `mapstructure:"title"`                    // 应用标题
@@ -75,11 +88,90 @@ type Config struct {
 		RefreshUsedKeysInterval   int  `mapstructure:"refresh_used_keys_interval"`    // 刷新已使用密钥余额的间隔（分钟）
 		// 模型特定的密钥选择策略
 		ModelKeyStrategies map[string]int `mapstructure:"model_key_strategies"` // 模型特定的密钥选择策略
+		// 可插拔密钥选择策略配置
+		ModelKeySelectors     map[string]string  `mapstructure:"model_key_selectors"`     // 模型到策略名称的映射，优先于策略ID
+		RequestTypeStrategies map[string]string  `mapstructure:"request_type_strategies"` // 请求类型到策略名称的映射，default为兜底策略
+		CompositeWeights      map[string]float64 `mapstructure:"composite_weights"`       // 加权组合策略的因子权重
+		// 并发控制配置
+		MaxConcurrencyPerKey     int `mapstructure:"max_concurrency_per_key"`     // 每个密钥的最大并发请求数，0表示不限制
+		ConcurrencyWaitTimeoutMs int `mapstructure:"concurrency_wait_timeout_ms"` // 所有密钥满载时的排队等待时间（毫秒）
 		// 系统托盘图标设置
 		HideIcon bool `mapstructure:"hide_icon"` // 是否隐藏系统托盘图标
 		// 禁用的模型列表
 		DisabledModels []string `mapstructure:"disabled_models"` // 禁用的模型ID列表
 	} `mapstructure:"app"`
+	RequestQueue struct {
+		MaxLength      int               `mapstructure:"max_length"`      // 队列最大长度
+		PriorityHeader string            `mapstructure:"priority_header"` // 指定优先级类别的请求头
+		DefaultClass   string            `mapstructure:"default_class"`   // 默认优先级类别
+		Classes        map[string]int    `mapstructure:"classes"`         // 优先级类别，数值越大越优先
+		ClientClasses  map[string]string `mapstructure:"client_classes"`  // 客户端API密钥到优先级类别的映射
+	} `mapstructure:"request_queue"`
+	KeyGroups struct {
+		ModelRules  map[string][]string `mapstructure:"model_rules"`  // 模型到允许使用的密钥标签，模型名支持以*结尾的前缀匹配
+		ClientRules map[string][]string `mapstructure:"client_rules"` // 客户端API密钥到允许使用的密钥标签
+	} `mapstructure:"key_groups"`
+	Prompts struct {
+		ClientNames map[string]string `mapstructure:"client_names"` // 客户端API密钥到客户端名称的映射，用于提示词模板的{{client_name}}变量
+	} `mapstructure:"prompts"`
+	Reasoning struct {
+		Mode        string            `mapstructure:"mode"`         // 推理内容的默认返回方式：passthrough原样返回、strip移除、think以<think>标签合并到content
+		ClientModes map[string]string `mapstructure:"client_modes"` // 客户端API密钥到推理内容返回方式的映射
+	} `mapstructure:"reasoning"`
+	Video struct {
+		DownloadResults bool   `mapstructure:"download_results"` // 任务完成后是否将视频下载到本地保存
+		StorageDir      string `mapstructure:"storage_dir"`      // 视频保存目录，为空时使用数据目录下的videos
+		RetentionDays   int    `mapstructure:"retention_days"`   // 任务记录和本地视频的保留天数，0表示不清理
+	} `mapstructure:"video"`
+	Images struct {
+		Archive       bool   `mapstructure:"archive"`         // 是否将生成的图片下载到本地归档，并改写为本服务的访问地址
+		StorageDir    string `mapstructure:"storage_dir"`     // 图片保存目录，为空时使用数据目录下的images
+		PublicBaseURL string `mapstructure:"public_base_url"` // 改写图片地址时使用的外部访问地址，为空时根据请求地址生成
+		RetentionDays int    `mapstructure:"retention_days"`  // 归档图片的保留天数，0表示不按时间清理
+		MaxImages     int    `mapstructure:"max_images"`      // 最多保留的归档图片数量，0表示不限制
+	} `mapstructure:"images"`
+	Embeddings struct {
+		BatchSize        int  `mapstructure:"batch_size"`         // 单次上游请求的最大输入数量，超过时拆分为多个请求并行发送，0表示使用默认值32，负数表示不拆分
+		MaxParallel      int  `mapstructure:"max_parallel"`       // 拆分后同时发送的最大请求数
+		Coalesce         bool `mapstructure:"coalesce"`           // 是否将同一模型的并发小请求合并为一次上游请求
+		CoalesceWindowMs int  `mapstructure:"coalesce_window_ms"` // 合并请求时等待其他请求加入的时间（毫秒）
+	} `mapstructure:"embeddings"`
+	StructuredOutput struct {
+		Enforce           bool `mapstructure:"enforce"`             // 是否按请求的json_schema校验非流式对话响应
+		MaxRepairAttempts int  `mapstructure:"max_repair_attempts"` // 响应不符合Schema时要求模型修正的最大次数
+	} `mapstructure:"structured_output"`
+	Vision struct {
+		RejectUnsupported  bool `mapstructure:"reject_unsupported"`   // 是否拒绝向不支持图片输入的模型发送带图片的请求
+		InlineRemoteImages bool `mapstructure:"inline_remote_images"` // 是否下载远程图片并以base64 data URL发送到上游
+		MaxImageSizeMB     int  `mapstructure:"max_image_size_mb"`    // 单张图片的最大大小（MB）
+		MaxImages          int  `mapstructure:"max_images"`           // 单次请求的最大图片数量，0表示不限制
+		FetchTimeout       int  `mapstructure:"fetch_timeout"`        // 下载远程图片的超时时间（秒）
+	} `mapstructure:"vision"`
+	Streaming struct {
+		KeepaliveInterval int `mapstructure:"keepalive_interval"` // 上游持续没有数据时发送SSE保活注释的间隔（秒），0使用默认的15秒，负数表示不发送
+	} `mapstructure:"streaming"`
+	OIDC struct {
+		Enabled        bool     `mapstructure:"enabled"`         // 是否启用OpenID Connect单点登录
+		Issuer         string   `mapstructure:"issuer"`          // 身份提供方的发行方地址
+		ClientID       string   `mapstructure:"client_id"`       // 客户端ID
+		ClientSecret   string   `mapstructure:"client_secret"`   // 客户端密钥，公共客户端可留空
+		RedirectURL    string   `mapstructure:"redirect_url"`    // 回调地址，为空时根据请求地址自动生成
+		Scopes         []string `mapstructure:"scopes"`          // 申请的权限范围
+		AllowedDomains []string `mapstructure:"allowed_domains"` // 允许登录的邮箱域名，为空表示不限制
+		AllowedGroups  []string `mapstructure:"allowed_groups"`  // 允许登录的用户组，为空表示不限制
+		GroupsClaim    string   `mapstructure:"groups_claim"`    // ID令牌中用户组的声明名称
+		DefaultRole    string   `mapstructure:"default_role"`    // 首次单点登录时自动创建的用户角色
+	} `mapstructure:"oidc"`
+	Cors struct {
+		Proxy CorsPolicy `mapstructure:"proxy"` // /v1等代理接口的跨域策略
+		Admin CorsPolicy `mapstructure:"admin"` // 管理界面和管理接口的跨域策略
+	} `mapstructure:"cors"`
+	IPFilter struct {
+		TrustedProxies []string     `mapstructure:"trusted_proxies"` // 受信任的反向代理，只有来自这些地址的X-Forwarded-For才会被采用
+		Proxy          IPFilterRule `mapstructure:"proxy"`           // /v1等代理接口的访问控制
+		Api            IPFilterRule `mapstructure:"api"`             // /api代理接口的访问控制
+		Admin          IPFilterRule `mapstructure:"admin"`           // 管理界面和管理接口的访问控制
+	} `mapstructure:"ip_filter"`
 	Log struct {
 		MaxSizeMB int    `mapstructure:"max_size_mb"` // 日志文件最大大小（MB）
 		Level     string `mapstructure:"level"`       // 日志等级（debug, info, warn, error, fatal）
@@ -109,6 +201,10 @@ type ApiKey struct {
 	Delete bool `json:"delete"` // 是否标记为删除
 	// 新增使用标记字段
 	IsUsed bool `json:"is_used"` // 是否被使用过
+	// 标签，用于密钥分组和分组路由
+	Tags []string `json:"tags"` // 密钥标签
+	// 运行时并发统计，不持久化
+	InFlight int `json:"in_flight"` // 当前进行中的请求数
 }
 
 // RequestStats 请求统计结构
@@ -118,12 +214,38 @@ type RequestStats struct {
 	TokenCount   int   `json:"token_count"`   // 令牌数
 }
 
+// 未设置抖动比例时使用的默认值
+const defaultRetryJitterRatio = 0.2
+
 // RetryConfig 重试配置
 type RetryConfig struct {
 	MaxRetries           int   `yaml:"max_retries" mapstructure:"max_retries"`                         // 最大重试次数
 	RetryDelayMs         int   `yaml:"retry_delay_ms" mapstructure:"retry_delay_ms"`                   // 重试间隔（毫秒）
 	RetryOnStatusCodes   []int `yaml:"retry_on_status_codes" mapstructure:"retry_on_status_codes"`     // 需要重试的HTTP状态码
 	RetryOnNetworkErrors bool  `yaml:"retry_on_network_errors" mapstructure:"retry_on_network_errors"` // 是否对网络错误进行重试
+	// 指数退避与限流冷却
+	BackoffMultiplier   float64 `yaml:"backoff_multiplier" mapstructure:"backoff_multiplier"`         // 重试间隔的指数退避倍数
+	MaxRetryDelayMs     int     `yaml:"max_retry_delay_ms" mapstructure:"max_retry_delay_ms"`         // 重试间隔上限（毫秒）
+	JitterRatio         float64 `yaml:"jitter_ratio" mapstructure:"jitter_ratio"`                     // 重试间隔的随机抖动比例（0~1），0表示不抖动
+	RateLimitCooldownMs int     `yaml:"rate_limit_cooldown_ms" mapstructure:"rate_limit_cooldown_ms"` // 429响应未携带限流头时的密钥冷却时间（毫秒）
+	MaxCooldownMs       int     `yaml:"max_cooldown_ms" mapstructure:"max_cooldown_ms"`               // 密钥冷却时间上限（毫秒）
+}
+
+// CorsPolicy 跨域策略
+type CorsPolicy struct {
+	Enabled          bool     `mapstructure:"enabled"`           // 是否允许跨域访问
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`   // 允许的来源，支持*和*.example.com形式的通配
+	AllowedMethods   []string `mapstructure:"allowed_methods"`   // 允许的HTTP方法
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`   // 允许的请求头，包含*时允许浏览器请求的所有头
+	ExposedHeaders   []string `mapstructure:"exposed_headers"`   // 允许浏览器读取的响应头
+	AllowCredentials bool     `mapstructure:"allow_credentials"` // 是否允许携带Cookie等凭证，仅对明确列出的来源生效
+	MaxAge           int      `mapstructure:"max_age"`           // 预检结果的缓存时间（秒）
+}
+
+// IPFilterRule IP访问控制规则，支持单个IP和CIDR网段
+type IPFilterRule struct {
+	Allow []string `mapstructure:"allow"` // 允许列表，为空表示允许所有未被拒绝的IP
+	Deny  []string `mapstructure:"deny"`  // 拒绝列表，优先于允许列表
 }
 
 // standardizeModelKeyStrategies 统一模型名称的大小写处理
@@ -219,12 +341,12 @@ func AddApiKey(key string, balance float64) {
 		var exists bool
 		var isDeleted bool
 		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM `+apikeysTableName+` WHERE key = ?), 
-			(SELECT is_delete FROM `+apikeysTableName+` WHERE key = ?)`, key, key).Scan(&exists, &isDeleted)
+			(SELECT is_delete FROM `+apikeysTableName+` WHERE key = ?)`, apiKeyLookupID(key), apiKeyLookupID(key)).Scan(&exists, &isDeleted)
 
 		if err == nil && exists && isDeleted {
 			// 密钥存在但被逻辑删除，恢复它
 			_, err := db.Exec(`UPDATE `+apikeysTableName+` SET is_delete = ?, balance = ? WHERE key = ?`,
-				false, balance, key)
+				false, balance, apiKeyLookupID(key))
 			if err == nil {
 				// 重新加载密钥
 				if loadErr := LoadApiKeysFromDB(); loadErr != nil {
@@ -296,7 +418,7 @@ func UpdateApiKeyBalance(key string, balance float64) bool {
 			balance,
 			apiKeys[keyIndex].Disabled,
 			apiKeys[keyIndex].DisabledAt,
-			key,
+			apiKeyLookupID(key),
 		)
 
 		if err != nil {
@@ -328,7 +450,7 @@ func UpdateApiKeyLastUsed(key string, timestamp int64) bool {
 				var err error
 				for retries := 0; retries < 3; retries++ {
 					_, err = db.Exec(`UPDATE `+apikeysTableName+` 
-						SET last_used = ? WHERE key = ?`, timestamp, key)
+						SET last_used = ? WHERE key = ?`, timestamp, apiKeyLookupID(key))
 					if err == nil {
 						break // 成功执行SQL，跳出循环
 					}
@@ -372,7 +494,7 @@ func MarkApiKeyAsUsed(key string) bool {
 				var err error
 				for retries := 0; retries < 3; retries++ {
 					_, err = db.Exec(`UPDATE `+apikeysTableName+` 
-						SET is_used = ? WHERE key = ?`, true, key)
+						SET is_used = ? WHERE key = ?`, true, apiKeyLookupID(key))
 					if err == nil {
 						break // 成功执行SQL，跳出循环
 					}
@@ -559,7 +681,7 @@ func UpdateApiKeySuccess(key string) bool {
 					_, err = db.Exec(`UPDATE `+apikeysTableName+` 
 						SET total_calls = ?, success_calls = ?, success_rate = ?, consecutive_failures = ? 
 						WHERE key = ?`,
-						apiKeys[i].TotalCalls, apiKeys[i].SuccessCalls, apiKeys[i].SuccessRate, 0, key)
+						apiKeys[i].TotalCalls, apiKeys[i].SuccessCalls, apiKeys[i].SuccessRate, 0, apiKeyLookupID(key))
 					if err == nil {
 						break // 成功执行SQL，跳出循环
 					}
@@ -607,7 +729,7 @@ func UpdateApiKeyFailure(key string) bool {
 					_, err = db.Exec(`UPDATE `+apikeysTableName+` 
 						SET total_calls = ?, success_rate = ?, consecutive_failures = ? 
 						WHERE key = ?`,
-						apiKeys[i].TotalCalls, apiKeys[i].SuccessRate, apiKeys[i].ConsecutiveFailures, key)
+						apiKeys[i].TotalCalls, apiKeys[i].SuccessRate, apiKeys[i].ConsecutiveFailures, apiKeyLookupID(key))
 					if err == nil {
 						break // 成功执行SQL，跳出循环
 					}
@@ -676,7 +798,7 @@ func DisableApiKey(key string) bool {
 		_, err := db.Exec(`UPDATE `+apikeysTableName+` 
 			SET disabled = ?, disabled_at = ? 
 			WHERE key = ?`,
-			true, keyDisabledAt, key)
+			true, keyDisabledAt, apiKeyLookupID(key))
 		if err != nil {
 			logger.Error("更新API密钥禁用状态到数据库失败: %v", err)
 		} else {
@@ -740,7 +862,7 @@ func EnableApiKey(key string) bool {
 		_, err := db.Exec(`UPDATE `+apikeysTableName+` 
 			SET disabled = ?, disabled_at = ?, consecutive_failures = ? 
 			WHERE key = ?`,
-			false, 0, 0, key)
+			false, 0, 0, apiKeyLookupID(key))
 		if err != nil {
 			logger.Error("更新API密钥启用状态到数据库失败: %v", err)
 		} else {
@@ -1300,7 +1422,17 @@ func EnsureDefaultConfig(dbPath string) error {
 
 		// 插入默认配置
 		defaultConfig := fmt.Sprintf(`{
-			"Server":{"Port":3016},
+			"Server":{
+				"Port":3016,
+				"TLS":{
+					"Enabled":false,
+					"CertFile":"",
+					"KeyFile":"",
+					"SelfSigned":false,
+					"ClientCAFile":"",
+					"RequireClientCert":false
+				}
+			},
 			"ApiProxy":{
 				"BaseURL":"https://api.siliconflow.cn",
 				"ModelIndex":0,
@@ -1308,7 +1440,12 @@ func EnsureDefaultConfig(dbPath string) error {
 					"MaxRetries":2,
 					"RetryDelayMs":1000,
 					"RetryOnStatusCodes":[500,502,503,504],
-					"RetryOnNetworkErrors":true
+					"RetryOnNetworkErrors":true,
+					"BackoffMultiplier":2,
+					"MaxRetryDelayMs":30000,
+					"JitterRatio":0.2,
+					"RateLimitCooldownMs":60000,
+					"MaxCooldownMs":600000
 				}
 			},
 			"Proxy":{
@@ -1323,7 +1460,11 @@ func EnsureDefaultConfig(dbPath string) error {
 				"Password":"",
 				"ExpirationMinutes":1,
 				"ApiKeyEnabled":false,
-				"ApiKey":""
+				"ApiKey":"",
+				"LoginMaxAttempts":5,
+				"LoginWindowMinutes":15,
+				"LoginLockoutMinutes":15,
+				"LoginGlobalMaxFailures":100
 			},
 			"App":{
 				"Title":"流动硅基 FlowSilicon %s",
@@ -1343,9 +1484,102 @@ func EnsureDefaultConfig(dbPath string) error {
 				"AutoDeleteZeroBalanceKeys":false,
 				"RefreshUsedKeysInterval":60,
 				"ModelKeyStrategies":{},
+				"ModelKeySelectors":{},
+				"RequestTypeStrategies":{},
+				"CompositeWeights":{},
+				"MaxConcurrencyPerKey":0,
+				"ConcurrencyWaitTimeoutMs":30000,
 				"HideIcon":false,
 				"DisabledModels":[]
 			},
+			"RequestQueue":{
+				"MaxLength":100,
+				"PriorityHeader":"X-Priority-Class",
+				"DefaultClass":"interactive",
+				"Classes":{"interactive":10,"batch":1},
+				"ClientClasses":{}
+			},
+			"KeyGroups":{
+				"ModelRules":{},
+				"ClientRules":{}
+			},
+			"Prompts":{
+				"ClientNames":{}
+			},
+			"Reasoning":{
+				"Mode":"passthrough",
+				"ClientModes":{}
+			},
+			"Video":{
+				"DownloadResults":false,
+				"StorageDir":"",
+				"RetentionDays":7
+			},
+			"Images":{
+				"Archive":false,
+				"StorageDir":"",
+				"PublicBaseURL":"",
+				"RetentionDays":30,
+				"MaxImages":1000
+			},
+			"Embeddings":{
+				"BatchSize":32,
+				"MaxParallel":4,
+				"Coalesce":false,
+				"CoalesceWindowMs":20
+			},
+			"StructuredOutput":{
+				"Enforce":true,
+				"MaxRepairAttempts":2
+			},
+			"Vision":{
+				"RejectUnsupported":true,
+				"InlineRemoteImages":false,
+				"MaxImageSizeMB":10,
+				"MaxImages":10,
+				"FetchTimeout":15
+			},
+			"Streaming":{
+				"KeepaliveInterval":15
+			},
+			"OIDC":{
+				"Enabled":false,
+				"Issuer":"",
+				"ClientID":"",
+				"ClientSecret":"",
+				"RedirectURL":"",
+				"Scopes":["openid","email","profile"],
+				"AllowedDomains":[],
+				"AllowedGroups":[],
+				"GroupsClaim":"groups",
+				"DefaultRole":"viewer"
+			},
+			"Cors":{
+				"Proxy":{
+					"Enabled":true,
+					"AllowedOrigins":["*"],
+					"AllowedMethods":["GET","POST","PUT","DELETE","OPTIONS"],
+					"AllowedHeaders":["Authorization","Content-Type","Accept","X-Priority-Class","X-Requested-With"],
+					"ExposedHeaders":["X-Request-ID","Retry-After","X-RateLimit-Limit-Requests","X-RateLimit-Remaining-Requests","X-RateLimit-Reset-Requests","X-RateLimit-Limit-Tokens","X-RateLimit-Remaining-Tokens","X-RateLimit-Reset-Tokens"],
+					"AllowCredentials":false,
+					"MaxAge":600
+				},
+				"Admin":{
+					"Enabled":false,
+					"AllowedOrigins":[],
+					"AllowedMethods":["GET","POST","PUT","DELETE","OPTIONS"],
+					"AllowedHeaders":["Content-Type","Accept","X-Requested-With"],
+					"ExposedHeaders":["X-Request-ID"],
+					"AllowCredentials":true,
+					"MaxAge":600
+				}
+			},
+			"IPFilter":{
+				"TrustedProxies":["127.0.0.1","::1"],
+				"Proxy":{"Allow":[],"Deny":[]},
+				"Api":{"Allow":[],"Deny":[]},
+				"Admin":{"Allow":[],"Deny":[]}
+			},
 			"Log":{"MaxSizeMB":1, "Level":"warn"}
 		}`, version)
 
@@ -1404,7 +1638,7 @@ func MarkApiKeyAsUnused(key string) bool {
 				var err error
 				for retries := 0; retries < 3; retries++ {
 					_, err = db.Exec(`UPDATE `+apikeysTableName+` 
-						SET is_used = ? WHERE key = ?`, false, key)
+						SET is_used = ? WHERE key = ?`, false, apiKeyLookupID(key))
 					if err == nil {
 						break // 成功执行SQL，跳出循环
 					}
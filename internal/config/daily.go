@@ -25,6 +25,8 @@ type DailyStats struct {
 	Date     string                `json:"date"`
 	Requests DailyRequestStats     `json:"requests"`
 	Tokens   DailyTokenStats       `json:"tokens"`
+	Audio    DailyAudioStats       `json:"audio"`
+	Schema   DailySchemaStats      `json:"structured_output"`
 	Models   map[string]ModelStats `json:"models"`
 	Hourly   []HourlyStats         `json:"hourly"`
 }
@@ -43,10 +45,28 @@ type DailyTokenStats struct {
 	Completion int `json:"completion"`
 }
 
+// DailyAudioStats 每日语音用量统计
+type DailyAudioStats struct {
+	Seconds    float64 `json:"seconds"`    // 语音转文字处理的音频时长（秒）
+	Characters int     `json:"characters"` // 文字转语音合成的字符数
+}
+
+// DailySchemaStats 每日结构化输出校验统计
+type DailySchemaStats struct {
+	Checked  int `json:"checked"`  // 校验的响应数
+	Valid    int `json:"valid"`    // 首次即符合Schema的响应数
+	Repaired int `json:"repaired"` // 经修正后符合Schema的响应数
+	Failed   int `json:"failed"`   // 修正后仍不符合Schema的响应数
+	Repairs  int `json:"repairs"`  // 发送的修正请求数
+}
+
 // ModelStats 模型使用统计
 type ModelStats struct {
-	Requests int `json:"requests"`
-	Tokens   int `json:"tokens"`
+	Requests     int     `json:"requests"`
+	Tokens       int     `json:"tokens"`
+	AudioSeconds float64 `json:"audio_seconds,omitempty"` // 语音模型处理的音频时长（秒）
+	Characters   int     `json:"characters,omitempty"`    // 语音模型合成的字符数
+	SchemaFailed int     `json:"schema_failed,omitempty"` // 结构化输出校验失败的响应数
 }
 
 // HourlyStats 每小时统计
@@ -387,6 +407,79 @@ func AddDailyRequestStat(apiKey, model string, requestCount, promptTokens, compl
 	}()
 }
 
+// AddDailyAudioStat 添加语音请求统计，语音转文字按音频时长计量，文字转语音按字符数计量
+func AddDailyAudioStat(apiKey, model string, seconds float64, characters int, isSuccess bool) {
+	AddDailyRequestStat(apiKey, model, 1, 0, 0, isSuccess)
+	if !isSuccess || (seconds <= 0 && characters <= 0) {
+		return
+	}
+
+	dailyDataLock.Lock()
+	defer dailyDataLock.Unlock()
+
+	today := time.Now().Format("2006-01-02")
+	for i := range dailyData.DailyStats {
+		todayStats := &dailyData.DailyStats[i]
+		if todayStats.Date != today {
+			continue
+		}
+		todayStats.Audio.Seconds += seconds
+		todayStats.Audio.Characters += characters
+		if model != "" {
+			modelStats := todayStats.Models[model]
+			modelStats.AudioSeconds += seconds
+			modelStats.Characters += characters
+			todayStats.Models[model] = modelStats
+		}
+		break
+	}
+
+	// 异步保存数据
+	go func() {
+		if err := saveDailyData(); err != nil {
+			logger.Error("保存每日统计数据失败: %v", err)
+		}
+	}()
+}
+
+// AddDailySchemaStat 添加结构化输出校验统计，repairs为发送的修正请求数，valid为最终是否符合Schema
+func AddDailySchemaStat(model string, repairs int, valid bool) {
+	dailyDataLock.Lock()
+	defer dailyDataLock.Unlock()
+
+	ensureTodayDataExistsLocked()
+	today := time.Now().Format("2006-01-02")
+	for i := range dailyData.DailyStats {
+		todayStats := &dailyData.DailyStats[i]
+		if todayStats.Date != today {
+			continue
+		}
+		todayStats.Schema.Checked++
+		todayStats.Schema.Repairs += repairs
+		switch {
+		case !valid:
+			todayStats.Schema.Failed++
+			if model != "" {
+				modelStats := todayStats.Models[model]
+				modelStats.SchemaFailed++
+				todayStats.Models[model] = modelStats
+			}
+		case repairs > 0:
+			todayStats.Schema.Repaired++
+		default:
+			todayStats.Schema.Valid++
+		}
+		break
+	}
+
+	// 异步保存数据
+	go func() {
+		if err := saveDailyData(); err != nil {
+			logger.Error("保存每日统计数据失败: %v", err)
+		}
+	}()
+}
+
 // GetDailyStats 获取指定日期的统计数据
 func GetDailyStats(date string) (*DailyStats, error) {
 	dailyDataLock.RLock()
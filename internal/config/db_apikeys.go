@@ -1,6 +1,6 @@
 /**
   @author: Hanhai
-  @desc: API密钥数据库管理模块，提供使用SQLite存储和读取API密钥的功能
+  @desc: API密钥数据库管理模块，提供使用SQLite加密存储和读取API密钥的功能
 **/
 
 package config
@@ -60,10 +60,55 @@ func InitApiKeysDB() error {
 		tpm INTEGER NOT NULL,
 		score REAL NOT NULL,
 		is_delete BOOLEAN NOT NULL,
-		is_used BOOLEAN NOT NULL DEFAULT FALSE
+		is_used BOOLEAN NOT NULL DEFAULT FALSE,
+		tags TEXT NOT NULL DEFAULT '',
+		key_cipher TEXT NOT NULL DEFAULT ''
 	)`
-	_, err := db.Exec(query)
-	return err
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// 检查tags字段是否存在，旧版本的表需要添加该字段
+	var tagsColumnExists int
+	err := db.QueryRow("SELECT count(*) FROM pragma_table_info('" + apikeysTableName + "') WHERE name='tags'").Scan(&tagsColumnExists)
+	if err != nil {
+		logger.Error("检查tags字段存在失败: %v", err)
+		return err
+	}
+
+	if tagsColumnExists == 0 {
+		_, err = db.Exec("ALTER TABLE " + apikeysTableName + " ADD COLUMN tags TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			logger.Error("添加tags字段失败: %v", err)
+			return err
+		}
+		logger.Info("成功添加tags字段到" + apikeysTableName + "表")
+	}
+
+	// 检查key_cipher字段是否存在，旧版本的表以明文保存密钥
+	var cipherColumnExists int
+	err = db.QueryRow("SELECT count(*) FROM pragma_table_info('" + apikeysTableName + "') WHERE name='key_cipher'").Scan(&cipherColumnExists)
+	if err != nil {
+		logger.Error("检查key_cipher字段存在失败: %v", err)
+		return err
+	}
+
+	if cipherColumnExists == 0 {
+		_, err = db.Exec("ALTER TABLE " + apikeysTableName + " ADD COLUMN key_cipher TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			logger.Error("添加key_cipher字段失败: %v", err)
+			return err
+		}
+		logger.Info("成功添加key_cipher字段到" + apikeysTableName + "表")
+	}
+
+	// 将明文保存的密钥迁移为加密存储
+	if err := migrateApiKeysEncryption(); err != nil {
+		logger.Error("迁移API密钥加密存储失败: %v", err)
+		return err
+	}
+
+	return nil
 }
 
 // LoadApiKeysFromDB 从数据库加载API密钥
@@ -103,10 +148,16 @@ func LoadApiKeysFromDB() error {
 		}
 	}
 
+	// 先加载主密钥，数据库只有一个连接，不能在遍历查询结果时再查询数据库
+	if _, err := getMasterKey(); err != nil {
+		logger.Error("获取主密钥失败: %v", err)
+		return err
+	}
+
 	// 查询所有密钥，包括被逻辑删除的密钥
 	rows, err := db.Query(`SELECT 
-		key, balance, last_used, total_calls, success_calls, success_rate, 
-		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used 
+		key_cipher, balance, last_used, total_calls, success_calls, success_rate, 
+		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used, tags 
 		FROM ` + apikeysTableName)
 	if err != nil {
 		// 如果是因为表不存在，尝试重新创建表
@@ -132,8 +183,9 @@ func LoadApiKeysFromDB() error {
 	// 处理查询结果
 	for rows.Next() {
 		var key ApiKey
+		var tags, keyCipher string
 		if err := rows.Scan(
-			&key.Key,
+			&keyCipher,
 			&key.Balance,
 			&key.LastUsed,
 			&key.TotalCalls,
@@ -148,10 +200,21 @@ func LoadApiKeysFromDB() error {
 			&key.Score,
 			&key.Delete,
 			&key.IsUsed,
+			&tags,
 		); err != nil {
 			logger.Error("扫描API密钥数据失败: %v", err)
-			continue
+			return fmt.Errorf("读取API密钥数据失败: %w", err)
+		}
+		// 任何一个密钥无法解密都不加载，避免之后保存时用不完整的列表覆盖数据库
+		key.Key, err = DecryptApiKey(keyCipher)
+		if err != nil {
+			logger.Error("解密API密钥失败: %v", err)
+			if errors.Is(err, ErrApiKeyDecrypt) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", ErrApiKeyDecrypt, err)
 		}
+		key.Tags = ParseTags(tags)
 
 		// 添加到加载的密钥列表，包括被标记为删除的密钥
 		loadedKeys = append(loadedKeys, key)
@@ -204,6 +267,12 @@ func SaveApiKeysToDB() error {
 	keysMutex.RLock()
 	defer keysMutex.RUnlock()
 
+	currentKey, err := getMasterKey()
+	if err != nil {
+		logger.Error("获取主密钥失败，拒绝保存API密钥: %v", err)
+		return err
+	}
+
 	// 开始事务
 	tx, err := db.Begin()
 	if err != nil {
@@ -211,6 +280,12 @@ func SaveApiKeysToDB() error {
 	}
 	defer tx.Rollback()
 
+	// 数据库中存在无法解密的密钥时拒绝保存，清空表会让这些密钥永久丢失
+	if err := verifyStoredApiKeys(tx, currentKey); err != nil {
+		logger.Error("拒绝保存API密钥: %v", err)
+		return err
+	}
+
 	// 清空表
 	_, err = tx.Exec("DELETE FROM " + apikeysTableName)
 	if err != nil {
@@ -220,8 +295,8 @@ func SaveApiKeysToDB() error {
 	// 准备插入语句
 	stmt, err := tx.Prepare(`INSERT INTO ` + apikeysTableName + ` 
 		(key, balance, last_used, total_calls, success_calls, success_rate, 
-		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used, tags, key_cipher) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -235,9 +310,16 @@ func SaveApiKeysToDB() error {
 		// 清空RecentRequests数组，不需要存储到数据库
 		keyCopy.RecentRequests = nil
 
+		// 加密密钥，key字段只保存查询标识
+		keyCipher, err := EncryptApiKey(keyCopy.Key)
+		if err != nil {
+			logger.Error("加密API密钥失败: %v", err)
+			return err
+		}
+
 		// 插入数据库
 		_, err = stmt.Exec(
-			keyCopy.Key,
+			apiKeyLookupID(keyCopy.Key),
 			keyCopy.Balance,
 			keyCopy.LastUsed,
 			keyCopy.TotalCalls,
@@ -252,6 +334,8 @@ func SaveApiKeysToDB() error {
 			keyCopy.Score,
 			keyCopy.Delete,
 			keyCopy.IsUsed,
+			JoinTags(keyCopy.Tags),
+			keyCipher,
 		)
 		if err != nil {
 			logger.Error("插入API密钥失败: %v", err)
@@ -280,12 +364,19 @@ func AddApiKeyToDB(key ApiKey) error {
 	keyCopy := key
 	keyCopy.RecentRequests = nil
 
+	// 加密密钥，key字段只保存查询标识
+	keyCipher, err := EncryptApiKey(keyCopy.Key)
+	if err != nil {
+		logger.Error("加密API密钥失败: %v", err)
+		return err
+	}
+
 	// 插入到数据库
-	_, err := db.Exec(`INSERT OR REPLACE INTO `+apikeysTableName+` 
+	_, err = db.Exec(`INSERT OR REPLACE INTO `+apikeysTableName+` 
 		(key, balance, last_used, total_calls, success_calls, success_rate, 
-		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		keyCopy.Key,
+		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used, tags, key_cipher) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		apiKeyLookupID(keyCopy.Key),
 		keyCopy.Balance,
 		keyCopy.LastUsed,
 		keyCopy.TotalCalls,
@@ -300,6 +391,8 @@ func AddApiKeyToDB(key ApiKey) error {
 		keyCopy.Score,
 		keyCopy.Delete,
 		keyCopy.IsUsed,
+		JoinTags(keyCopy.Tags),
+		keyCipher,
 	)
 
 	if err != nil {
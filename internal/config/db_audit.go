@@ -0,0 +1,113 @@
+/**
+  @author: Hanhai
+  @desc: 审计日志存储，记录登录、登出和会话撤销等安全相关操作
+**/
+
+package config
+
+import (
+	"errors"
+	"flowsilicon/internal/logger"
+	"time"
+)
+
+const (
+	// 审计日志表名
+	auditTableName = "audit_log"
+	// 审计日志最多保留的条数
+	maxAuditEntries = 10000
+)
+
+// 审计操作类型
+const (
+	AuditActionLogin         = "login"          // 登录
+	AuditActionLogout        = "logout"         // 登出
+	AuditActionLoginLocked   = "login_locked"   // 登录被锁定拒绝
+	AuditActionSessionRevoke = "session_revoke" // 撤销会话
+)
+
+// AuditEntry 审计日志条目
+type AuditEntry struct {
+	ID        int64  `json:"id"`        // 条目ID
+	Timestamp int64  `json:"timestamp"` // 时间戳
+	Action    string `json:"action"`    // 操作类型
+	Username  string `json:"username"`  // 用户名，为空表示使用全局密码
+	IP        string `json:"ip"`        // 客户端IP
+	Success   bool   `json:"success"`   // 是否成功
+	Detail    string `json:"detail"`    // 详细信息
+}
+
+// InitAuditDB 创建审计日志表
+func InitAuditDB() error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS ` + auditTableName + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		username TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		success BOOLEAN NOT NULL,
+		detail TEXT NOT NULL
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// AddAuditEntry 添加审计日志条目，并清理超出保留条数的旧记录
+func AddAuditEntry(action, username, ip string, success bool, detail string) {
+	if db == nil {
+		return
+	}
+
+	_, err := ExecWithRetry("写入审计日志", 3,
+		`INSERT INTO `+auditTableName+` (timestamp, action, username, ip, success, detail) VALUES (?, ?, ?, ?, ?, ?)`,
+		time.Now().Unix(), action, username, ip, success, detail)
+	if err != nil {
+		logger.Error("写入审计日志失败: %v", err)
+		return
+	}
+
+	if _, err := ExecWithRetry("清理审计日志", 3,
+		`DELETE FROM `+auditTableName+` WHERE id <= (SELECT MAX(id) FROM `+auditTableName+`) - ?`, maxAuditEntries); err != nil {
+		logger.Error("清理审计日志失败: %v", err)
+	}
+}
+
+// GetAuditEntries 获取最近的审计日志，action为空时返回所有类型
+func GetAuditEntries(action string, limit int) ([]AuditEntry, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接未初始化")
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, timestamp, action, username, ip, success, detail FROM ` + auditTableName
+	args := []interface{}{}
+	if action != "" {
+		query += ` WHERE action = ?`
+		args = append(args, action)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.Username,
+			&entry.IP, &entry.Success, &entry.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,95 @@
+/**
+  @author: Hanhai
+  @desc: 认证相关数据的数据库存储，包括每个安装独立的签名密钥和会话版本号
+**/
+
+package config
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flowsilicon/internal/logger"
+	"strconv"
+)
+
+const (
+	// 令牌签名密钥在配置表中的键
+	authSecretKey = "auth_secret"
+	// 会话版本号在配置表中的键，修改密码时递增使所有会话失效
+	sessionEpochKey = "session_epoch"
+)
+
+// getConfigValue 从配置表读取指定键的值，不存在时返回false
+func getConfigValue(key string) (string, bool, error) {
+	if db == nil {
+		return "", false, errors.New("数据库连接未初始化，请先调用InitConfigDB")
+	}
+
+	var value string
+	err := db.QueryRow("SELECT value FROM "+configTableName+" WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// setConfigValue 保存指定键的值到配置表
+func setConfigValue(key, value string) error {
+	if db == nil {
+		return errors.New("数据库连接未初始化，请先调用InitConfigDB")
+	}
+
+	_, err := ExecWithRetry("保存"+key, 3,
+		"INSERT INTO "+configTableName+" (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		key, value)
+	return err
+}
+
+// GetAuthSecret 获取令牌签名密钥，不存在时随机生成并保存到数据库
+func GetAuthSecret() ([]byte, error) {
+	value, exists, err := getConfigValue(authSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	if exists && value != "" {
+		return hex.DecodeString(value)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := setConfigValue(authSecretKey, hex.EncodeToString(secret)); err != nil {
+		return nil, err
+	}
+
+	logger.Info("已生成新的令牌签名密钥")
+	return secret, nil
+}
+
+// GetSessionEpoch 获取当前会话版本号
+func GetSessionEpoch() (int64, error) {
+	value, exists, err := getConfigValue(sessionEpochKey)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// IncrementSessionEpoch 递增会话版本号并返回新值
+func IncrementSessionEpoch() (int64, error) {
+	epoch, err := GetSessionEpoch()
+	if err != nil {
+		return 0, err
+	}
+	epoch++
+	if err := setConfigValue(sessionEpochKey, strconv.FormatInt(epoch, 10)); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
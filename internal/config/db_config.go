@@ -29,6 +29,8 @@ const (
 var (
 	// 数据库实例
 	db *sql.DB
+	// 数据库文件路径
+	dbFilePath string
 )
 
 // InitConfigDB 初始化配置数据库
@@ -44,6 +46,8 @@ func InitConfigDB(dbPath string) error {
 		dbPath = filepath.Join(dataDir, dbFileName)
 	}
 
+	dbFilePath = dbPath
+
 	// 打开数据库连接
 	var err error
 	db, err = sql.Open("sqlite", dbPath)
@@ -94,6 +98,37 @@ func InitConfigDB(dbPath string) error {
 	}
 
 	logger.Info("配置表初始化成功")
+
+	// 创建用户表并加载用户
+	if err := InitUsersDB(); err != nil {
+		logger.Error("初始化用户表失败: %v", err)
+		return err
+	}
+
+	// 创建会话表并加载未过期的会话
+	if err := InitSessionsDB(); err != nil {
+		logger.Error("初始化会话表失败: %v", err)
+		return err
+	}
+
+	// 创建审计日志表
+	if err := InitAuditDB(); err != nil {
+		logger.Error("初始化审计日志表失败: %v", err)
+		return err
+	}
+
+	// 创建视频任务表
+	if err := InitVideoJobsDB(); err != nil {
+		logger.Error("初始化视频任务表失败: %v", err)
+		return err
+	}
+
+	// 创建图库表
+	if err := InitImageGalleryDB(); err != nil {
+		logger.Error("初始化图库表失败: %v", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -105,6 +140,14 @@ func CloseConfigDB() error {
 	return nil
 }
 
+// GetDataDir 获取数据目录，即配置数据库所在的目录
+func GetDataDir() string {
+	if dbFilePath != "" {
+		return filepath.Dir(dbFilePath)
+	}
+	return "data"
+}
+
 // LoadConfigFromDB 从数据库加载配置
 func LoadConfigFromDB() (*Config, error) {
 	// 确保数据库连接已经初始化
@@ -211,7 +254,9 @@ func LoadConfigFromDB() (*Config, error) {
 		// }
 	}
 
+	// 旧版本保存的配置中缺少的字段先设置默认值，配置中显式设置的值（包括0）会覆盖默认值
 	var cfg Config
+	cfg.ApiProxy.Retry.JitterRatio = defaultRetryJitterRatio
 	err = json.Unmarshal([]byte(configJSON), &cfg)
 	if err != nil {
 		logger.Error("解析配置JSON失败: %v", err)
@@ -0,0 +1,38 @@
+/**
+  @author: Hanhai
+  @desc: 从数据库加载配置时填充缺省值的测试
+**/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestLoadConfigJitterRatioDefault(t *testing.T) {
+	setupKeyCryptoTest(t)
+	previous := GetConfig()
+	t.Cleanup(func() { UpdateConfig(previous) })
+
+	tests := []struct {
+		name string
+		json string
+		want float64
+	}{
+		{"旧版本配置中没有该项", `{"ApiProxy":{"Retry":{"MaxRetries":2}}}`, defaultRetryJitterRatio},
+		{"显式设置为0表示不抖动", `{"ApiProxy":{"Retry":{"MaxRetries":2,"JitterRatio":0}}}`, 0},
+		{"显式设置的值", `{"ApiProxy":{"Retry":{"JitterRatio":0.5}}}`, 0.5},
+	}
+	for _, tt := range tests {
+		if _, err := db.Exec("INSERT OR REPLACE INTO "+configTableName+" (id, key, value) VALUES (1, 'config', ?)", tt.json); err != nil {
+			t.Fatalf("写入配置失败: %v", err)
+		}
+		cfg, err := LoadConfigFromDB()
+		if err != nil {
+			t.Fatalf("加载配置失败: %v", err)
+		}
+		if got := cfg.ApiProxy.Retry.JitterRatio; got != tt.want {
+			t.Errorf("%s: JitterRatio = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/**
+  @author: Hanhai
+  @desc: 图片生成结果的图库存储，记录归档到本地的图片及其提示词和模型
+**/
+
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"flowsilicon/internal/logger"
+)
+
+// 图库表名
+const imageGalleryTableName = "image_gallery"
+
+// GalleryImage 归档到本地的生成图片
+type GalleryImage struct {
+	ID          string `json:"id"`           // 图片ID，同时用于本地访问地址
+	Model       string `json:"model"`        // 模型名称
+	Prompt      string `json:"prompt"`       // 提示词
+	Size        string `json:"size"`         // 请求的图片尺寸
+	MaskedKey   string `json:"masked_key"`   // 生成图片使用的API密钥，掩码后保存
+	SourceURL   string `json:"source_url"`   // 上游返回的图片地址，通常很快过期
+	LocalFile   string `json:"local_file"`   // 本地图片文件路径
+	ContentType string `json:"content_type"` // 图片类型
+	Bytes       int64  `json:"bytes"`        // 文件大小
+	CreatedAt   int64  `json:"created_at"`   // 生成时间戳
+}
+
+// ErrGalleryImageNotFound 图库中不存在该图片
+var ErrGalleryImageNotFound = errors.New("图片不存在")
+
+// 查询图库的字段
+const galleryImageColumns = `id, model, prompt, size, masked_key, source_url, local_file, content_type, bytes, created_at`
+
+// InitImageGalleryDB 创建图库表
+func InitImageGalleryDB() error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS ` + imageGalleryTableName + ` (
+		id TEXT PRIMARY KEY,
+		model TEXT NOT NULL,
+		prompt TEXT NOT NULL,
+		size TEXT NOT NULL DEFAULT '',
+		masked_key TEXT NOT NULL DEFAULT '',
+		source_url TEXT NOT NULL DEFAULT '',
+		local_file TEXT NOT NULL,
+		content_type TEXT NOT NULL DEFAULT '',
+		bytes INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_image_gallery_created_at ON ` + imageGalleryTableName + ` (created_at)`)
+	return err
+}
+
+// CreateGalleryImage 记录归档的图片
+func CreateGalleryImage(image GalleryImage) error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	_, err := ExecWithRetry("记录归档图片", 3,
+		`INSERT INTO `+imageGalleryTableName+` (`+galleryImageColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		image.ID, image.Model, image.Prompt, image.Size, image.MaskedKey, image.SourceURL, image.LocalFile,
+		image.ContentType, image.Bytes, image.CreatedAt)
+	return err
+}
+
+// scanGalleryImage 读取一行图库记录
+func scanGalleryImage(scanner interface{ Scan(...interface{}) error }) (GalleryImage, error) {
+	var image GalleryImage
+	err := scanner.Scan(&image.ID, &image.Model, &image.Prompt, &image.Size, &image.MaskedKey, &image.SourceURL,
+		&image.LocalFile, &image.ContentType, &image.Bytes, &image.CreatedAt)
+	return image, err
+}
+
+// GetGalleryImage 获取图库中的图片
+func GetGalleryImage(id string) (GalleryImage, error) {
+	if db == nil {
+		return GalleryImage{}, errors.New("数据库连接未初始化")
+	}
+
+	image, err := scanGalleryImage(db.QueryRow(`SELECT `+galleryImageColumns+` FROM `+imageGalleryTableName+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return image, ErrGalleryImageNotFound
+	}
+	return image, err
+}
+
+// ListGalleryImages 分页获取图库中的图片，按生成时间倒序，返回图片列表和总数
+// model不为空时只返回该模型生成的图片
+func ListGalleryImages(model string, limit, offset int) ([]GalleryImage, int, error) {
+	if db == nil {
+		return nil, 0, errors.New("数据库连接未初始化")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	where := ""
+	var args []interface{}
+	if model != "" {
+		where = ` WHERE model = ?`
+		args = append(args, model)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+imageGalleryTableName+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT `+galleryImageColumns+` FROM `+imageGalleryTableName+where+
+		` ORDER BY created_at DESC, rowid DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	images := make([]GalleryImage, 0)
+	for rows.Next() {
+		image, err := scanGalleryImage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		images = append(images, image)
+	}
+	return images, total, rows.Err()
+}
+
+// DeleteGalleryImage 删除图库记录，返回被删除图片的本地文件路径
+func DeleteGalleryImage(id string) (string, error) {
+	image, err := GetGalleryImage(id)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ExecWithRetry("删除归档图片", 3, `DELETE FROM `+imageGalleryTableName+` WHERE id = ?`, id); err != nil {
+		return "", err
+	}
+	return image.LocalFile, nil
+}
+
+// PurgeGalleryImages 删除指定时间之前生成的图片，以及超出保留数量的较早图片，返回这些图片的本地文件路径
+// before为0时不按时间清理，maxImages为0时不限制数量
+func PurgeGalleryImages(before int64, maxImages int) ([]string, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接未初始化")
+	}
+
+	// 超出保留数量的图片：按生成时间倒序跳过最新的maxImages张
+	condition := `created_at < ?`
+	args := []interface{}{before}
+	if maxImages > 0 {
+		condition += ` OR id IN (SELECT id FROM ` + imageGalleryTableName + ` ORDER BY created_at DESC, rowid DESC LIMIT -1 OFFSET ?)`
+		args = append(args, maxImages)
+	}
+
+	rows, err := db.Query(`SELECT local_file FROM `+imageGalleryTableName+` WHERE `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	result, err := ExecWithRetry("清理归档图片", 3, `DELETE FROM `+imageGalleryTableName+` WHERE `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		logger.Info("已清理 %d 张过期或超出数量的归档图片", count)
+	}
+	return files, nil
+}
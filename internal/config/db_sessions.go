@@ -0,0 +1,258 @@
+/**
+  @author: Hanhai
+  @desc: 登录会话存储，认证Cookie中的令牌对应一条会话记录，支持查看和撤销
+**/
+
+package config
+
+import (
+	"errors"
+	"flowsilicon/internal/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 会话表名
+	sessionsTableName = "sessions"
+	// 最后活动时间写入数据库的最小间隔（秒）
+	sessionTouchInterval = 60
+)
+
+// LoginSession 登录会话
+type LoginSession struct {
+	ID        string `json:"id"`         // 会话ID
+	Username  string `json:"username"`   // 用户名，为空表示使用全局密码登录
+	IP        string `json:"ip"`         // 登录IP
+	UserAgent string `json:"user_agent"` // 浏览器标识
+	CreatedAt int64  `json:"created_at"` // 创建时间戳
+	ExpiresAt int64  `json:"expires_at"` // 过期时间戳
+	LastSeen  int64  `json:"last_seen"`  // 最后活动时间戳
+	Revoked   bool   `json:"revoked"`    // 是否已撤销
+	savedSeen int64
+}
+
+var (
+	// 内存中的会话，键为会话ID
+	sessions = make(map[string]*LoginSession)
+	// 互斥锁保护会话
+	sessionsMutex sync.RWMutex
+)
+
+// ErrSessionNotFound 会话不存在
+var ErrSessionNotFound = errors.New("会话不存在")
+
+// InitSessionsDB 创建会话表并加载未过期的会话
+func InitSessionsDB() error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS ` + sessionsTableName + ` (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		revoked BOOLEAN NOT NULL DEFAULT FALSE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// 清理已过期的会话
+	now := time.Now().Unix()
+	if _, err := db.Exec(`DELETE FROM `+sessionsTableName+` WHERE expires_at < ?`, now); err != nil {
+		logger.Error("清理过期会话失败: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT id, username, ip, user_agent, created_at, expires_at, last_seen, revoked
+		FROM ` + sessionsTableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]*LoginSession)
+	for rows.Next() {
+		session := &LoginSession{}
+		if err := rows.Scan(&session.ID, &session.Username, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.ExpiresAt, &session.LastSeen, &session.Revoked); err != nil {
+			logger.Error("扫描会话数据失败: %v", err)
+			continue
+		}
+		session.savedSeen = session.LastSeen
+		loaded[session.ID] = session
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sessionsMutex.Lock()
+	sessions = loaded
+	sessionsMutex.Unlock()
+	return nil
+}
+
+// CreateLoginSession 创建登录会话
+func CreateLoginSession(session LoginSession) error {
+	now := time.Now().Unix()
+	session.CreatedAt = now
+	session.LastSeen = now
+	session.savedSeen = now
+
+	if db != nil {
+		_, err := ExecWithRetry("创建登录会话", 3,
+			`INSERT INTO `+sessionsTableName+` (id, username, ip, user_agent, created_at, expires_at, last_seen, revoked)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			session.ID, session.Username, session.IP, session.UserAgent,
+			session.CreatedAt, session.ExpiresAt, session.LastSeen, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	sessionsMutex.Lock()
+	sessions[session.ID] = &session
+	sessionsMutex.Unlock()
+
+	purgeExpiredSessions()
+	return nil
+}
+
+// GetLoginSession 获取会话，不存在时返回false
+func GetLoginSession(id string) (LoginSession, bool) {
+	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
+
+	session, exists := sessions[id]
+	if !exists {
+		return LoginSession{}, false
+	}
+	return *session, true
+}
+
+// TouchLoginSession 更新会话的最后活动时间，数据库中的时间按间隔批量更新
+func TouchLoginSession(id string) {
+	now := time.Now().Unix()
+
+	sessionsMutex.Lock()
+	session, exists := sessions[id]
+	if !exists {
+		sessionsMutex.Unlock()
+		return
+	}
+	session.LastSeen = now
+	persist := now-session.savedSeen >= sessionTouchInterval
+	if persist {
+		session.savedSeen = now
+	}
+	sessionsMutex.Unlock()
+
+	if persist && db != nil {
+		if _, err := ExecWithRetry("更新会话活动时间", 3,
+			`UPDATE `+sessionsTableName+` SET last_seen = ? WHERE id = ?`, now, id); err != nil {
+			logger.Error("更新会话活动时间失败: %v", err)
+		}
+	}
+}
+
+// GetActiveLoginSessions 获取所有未撤销且未过期的会话，按最后活动时间倒序
+func GetActiveLoginSessions() []LoginSession {
+	now := time.Now().Unix()
+
+	sessionsMutex.RLock()
+	result := make([]LoginSession, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.Revoked && session.ExpiresAt >= now {
+			result = append(result, *session)
+		}
+	}
+	sessionsMutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeen > result[j].LastSeen
+	})
+	return result
+}
+
+// RevokeLoginSession 撤销指定会话
+func RevokeLoginSession(id string) error {
+	sessionsMutex.Lock()
+	session, exists := sessions[id]
+	if exists {
+		session.Revoked = true
+	}
+	sessionsMutex.Unlock()
+
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	if db != nil {
+		if _, err := ExecWithRetry("撤销登录会话", 3,
+			`UPDATE `+sessionsTableName+` SET revoked = ? WHERE id = ?`, true, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUserLoginSessions 撤销指定用户的所有会话，返回撤销的数量
+func RevokeUserLoginSessions(username string) int {
+	var ids []string
+
+	sessionsMutex.RLock()
+	for id, session := range sessions {
+		if session.Username == username && !session.Revoked {
+			ids = append(ids, id)
+		}
+	}
+	sessionsMutex.RUnlock()
+
+	for _, id := range ids {
+		if err := RevokeLoginSession(id); err != nil {
+			logger.Error("撤销会话失败: %v", err)
+		}
+	}
+	return len(ids)
+}
+
+// RevokeAllLoginSessions 撤销所有会话
+func RevokeAllLoginSessions() {
+	sessionsMutex.Lock()
+	for _, session := range sessions {
+		session.Revoked = true
+	}
+	sessionsMutex.Unlock()
+
+	if db != nil {
+		if _, err := ExecWithRetry("撤销所有登录会话", 3,
+			`UPDATE `+sessionsTableName+` SET revoked = ?`, true); err != nil {
+			logger.Error("撤销所有登录会话失败: %v", err)
+		}
+	}
+}
+
+// purgeExpiredSessions 清理已过期的会话
+func purgeExpiredSessions() {
+	now := time.Now().Unix()
+
+	sessionsMutex.Lock()
+	for id, session := range sessions {
+		if session.ExpiresAt < now {
+			delete(sessions, id)
+		}
+	}
+	sessionsMutex.Unlock()
+
+	if db != nil {
+		if _, err := ExecWithRetry("清理过期会话", 3,
+			`DELETE FROM `+sessionsTableName+` WHERE expires_at < ?`, now); err != nil {
+			logger.Error("清理过期会话失败: %v", err)
+		}
+	}
+}
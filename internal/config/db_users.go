@@ -0,0 +1,300 @@
+/**
+  @author: Hanhai
+  @desc: 管理界面用户数据库管理模块，提供用户及角色的存储和读取功能
+**/
+
+package config
+
+import (
+	"errors"
+	"flowsilicon/internal/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 用户表名
+	usersTableName = "users"
+)
+
+// User 管理界面用户
+type User struct {
+	Username          string `json:"username"`               // 用户名
+	PasswordHash      string `json:"-"`                      // 密码哈希，不序列化
+	Role              string `json:"role"`                   // 角色：admin、operator、viewer
+	Disabled          bool   `json:"disabled"`               // 是否禁用
+	CreatedAt         int64  `json:"created_at"`             // 创建时间戳
+	PasswordChangedAt int64  `json:"password_changed_at"`    // 最后修改密码的时间戳，早于该时间签发的令牌失效
+	OIDCIssuer        string `json:"oidc_issuer,omitempty"`  // 单点登录用户的身份提供方发行方，本地用户为空
+	OIDCSubject       string `json:"oidc_subject,omitempty"` // 单点登录用户在身份提供方的唯一标识，本地用户为空
+}
+
+var (
+	// 内存中的用户列表
+	users []User
+	// 互斥锁保护用户列表
+	usersMutex sync.RWMutex
+)
+
+// 用户相关错误
+var (
+	ErrUserNotFound = errors.New("用户不存在")
+	ErrUserExists   = errors.New("用户已存在")
+)
+
+// InitUsersDB 创建用户表并加载用户到内存
+func InitUsersDB() error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS ` + usersTableName + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		disabled BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at INTEGER NOT NULL,
+		password_changed_at INTEGER NOT NULL,
+		oidc_issuer TEXT NOT NULL DEFAULT '',
+		oidc_subject TEXT NOT NULL DEFAULT ''
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// 旧版本的用户表没有单点登录身份字段，需要添加
+	for _, column := range []string{"oidc_issuer", "oidc_subject"} {
+		var columnExists int
+		if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('"+usersTableName+"') WHERE name = ?", column).Scan(&columnExists); err != nil {
+			return err
+		}
+		if columnExists == 0 {
+			if _, err := db.Exec("ALTER TABLE " + usersTableName + " ADD COLUMN " + column + " TEXT NOT NULL DEFAULT ''"); err != nil {
+				logger.Error("添加%s字段失败: %v", column, err)
+				return err
+			}
+			logger.Info("已添加%s字段到用户表", column)
+		}
+	}
+
+	// 同一个身份提供方的用户只能关联一个本地用户
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_identity ON ` + usersTableName +
+		` (oidc_issuer, oidc_subject) WHERE oidc_subject != ''`); err != nil {
+		return err
+	}
+
+	return loadUsersFromDB()
+}
+
+// loadUsersFromDB 从数据库加载所有用户
+func loadUsersFromDB() error {
+	rows, err := db.Query(`SELECT username, password_hash, role, disabled, created_at, password_changed_at,
+		oidc_issuer, oidc_subject FROM ` + usersTableName + ` ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var loadedUsers []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.PasswordHash, &user.Role, &user.Disabled,
+			&user.CreatedAt, &user.PasswordChangedAt, &user.OIDCIssuer, &user.OIDCSubject); err != nil {
+			logger.Error("扫描用户数据失败: %v", err)
+			continue
+		}
+		loadedUsers = append(loadedUsers, user)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	usersMutex.Lock()
+	users = loadedUsers
+	usersMutex.Unlock()
+
+	logger.Info("已从数据库加载 %d 个用户", len(loadedUsers))
+	return nil
+}
+
+// normalizeUsername 规范化用户名，用户名不区分大小写
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// GetUsers 获取所有用户
+func GetUsers() []User {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	result := make([]User, len(users))
+	copy(result, users)
+	return result
+}
+
+// GetUser 根据用户名获取用户
+func GetUser(username string) (User, bool) {
+	username = normalizeUsername(username)
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	for _, user := range users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// GetUserByOIDC 根据身份提供方的发行方和用户标识获取关联的用户
+func GetUserByOIDC(issuer, subject string) (User, bool) {
+	if issuer == "" || subject == "" {
+		return User{}, false
+	}
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	for _, user := range users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// CreateUser 创建用户，passwordHash 为已经哈希过的密码
+func CreateUser(username, passwordHash, role string) error {
+	return createUser(User{Username: username, PasswordHash: passwordHash, Role: role})
+}
+
+// CreateOIDCUser 创建关联到单点登录身份的用户，之后按发行方和用户标识识别该用户
+func CreateOIDCUser(username, passwordHash, role, issuer, subject string) error {
+	if issuer == "" || subject == "" {
+		return errors.New("单点登录身份不能为空")
+	}
+	if _, exists := GetUserByOIDC(issuer, subject); exists {
+		return ErrUserExists
+	}
+	return createUser(User{Username: username, PasswordHash: passwordHash, Role: role, OIDCIssuer: issuer, OIDCSubject: subject})
+}
+
+// createUser 保存新用户到数据库和内存
+func createUser(user User) error {
+	username := normalizeUsername(user.Username)
+	if username == "" {
+		return errors.New("用户名不能为空")
+	}
+	if _, exists := GetUser(username); exists {
+		return ErrUserExists
+	}
+
+	now := time.Now().Unix()
+	user.Username = username
+	user.CreatedAt = now
+	user.PasswordChangedAt = now
+
+	if db != nil {
+		_, err := ExecWithRetry("创建用户", 3,
+			`INSERT INTO `+usersTableName+` (username, password_hash, role, disabled, created_at, password_changed_at, oidc_issuer, oidc_subject)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			user.Username, user.PasswordHash, user.Role, user.Disabled, user.CreatedAt, user.PasswordChangedAt,
+			user.OIDCIssuer, user.OIDCSubject)
+		if err != nil {
+			return err
+		}
+	}
+
+	usersMutex.Lock()
+	users = append(users, user)
+	usersMutex.Unlock()
+
+	logger.Info("已创建用户: %s, 角色=%s", username, user.Role)
+	return nil
+}
+
+// updateUser 更新内存和数据库中的用户
+func updateUser(username string, update func(user *User)) error {
+	username = normalizeUsername(username)
+
+	usersMutex.Lock()
+	index := -1
+	for i := range users {
+		if users[i].Username == username {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		usersMutex.Unlock()
+		return ErrUserNotFound
+	}
+	update(&users[index])
+	user := users[index]
+	usersMutex.Unlock()
+
+	if db != nil {
+		_, err := ExecWithRetry("更新用户", 3,
+			`UPDATE `+usersTableName+` SET password_hash = ?, role = ?, disabled = ?, password_changed_at = ? WHERE username = ?`,
+			user.PasswordHash, user.Role, user.Disabled, user.PasswordChangedAt, user.Username)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUserPassword 设置用户密码哈希
+// resetSessions 为true时使该用户已签发的令牌失效，升级哈希方式时不需要
+func SetUserPassword(username, passwordHash string, resetSessions bool) error {
+	return updateUser(username, func(user *User) {
+		user.PasswordHash = passwordHash
+		if resetSessions {
+			user.PasswordChangedAt = time.Now().Unix()
+		}
+	})
+}
+
+// SetUserRole 设置用户角色
+func SetUserRole(username, role string) error {
+	return updateUser(username, func(user *User) {
+		user.Role = role
+	})
+}
+
+// SetUserDisabled 设置用户是否禁用
+func SetUserDisabled(username string, disabled bool) error {
+	return updateUser(username, func(user *User) {
+		user.Disabled = disabled
+	})
+}
+
+// DeleteUser 删除用户
+func DeleteUser(username string) error {
+	username = normalizeUsername(username)
+	if _, exists := GetUser(username); !exists {
+		return ErrUserNotFound
+	}
+
+	if db != nil {
+		if _, err := ExecWithRetry("删除用户", 3,
+			`DELETE FROM `+usersTableName+` WHERE username = ?`, username); err != nil {
+			return err
+		}
+	}
+
+	usersMutex.Lock()
+	for i := range users {
+		if users[i].Username == username {
+			users = append(users[:i], users[i+1:]...)
+			break
+		}
+	}
+	usersMutex.Unlock()
+
+	logger.Info("已删除用户: %s", username)
+	return nil
+}
@@ -0,0 +1,261 @@
+/**
+  @author: Hanhai
+  @desc: 视频生成任务存储，记录每个任务由哪个API密钥提交，查询状态时使用同一密钥
+**/
+
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"flowsilicon/internal/logger"
+	"time"
+)
+
+// 视频任务表名
+const videoJobsTableName = "video_jobs"
+
+// 视频任务状态，与硅基流动接口返回的状态一致
+const (
+	VideoJobStatusInQueue    = "InQueue"    // 排队中
+	VideoJobStatusInProgress = "InProgress" // 生成中
+	VideoJobStatusSucceed    = "Succeed"    // 已完成
+	VideoJobStatusFailed     = "Failed"     // 失败
+)
+
+// VideoJob 视频生成任务
+type VideoJob struct {
+	RequestID   string `json:"request_id"`   // 上游返回的任务ID
+	KeyLookup   string `json:"-"`            // 提交任务的API密钥的查询标识，与密钥表的key字段对应
+	MaskedKey   string `json:"masked_key"`   // 掩码后的API密钥，用于展示
+	Model       string `json:"model"`        // 模型名称
+	Prompt      string `json:"prompt"`       // 提示词
+	Status      string `json:"status"`       // 任务状态
+	Reason      string `json:"reason"`       // 失败原因
+	VideoURL    string `json:"video_url"`    // 上游返回的视频地址
+	LocalFile   string `json:"local_file"`   // 下载到本地的视频文件路径
+	CreatedAt   int64  `json:"created_at"`   // 提交时间戳
+	UpdatedAt   int64  `json:"updated_at"`   // 最后更新时间戳
+	CompletedAt int64  `json:"completed_at"` // 完成或失败的时间戳
+}
+
+// ErrVideoJobNotFound 视频任务不存在
+var ErrVideoJobNotFound = errors.New("视频任务不存在")
+
+// InitVideoJobsDB 创建视频任务表
+func InitVideoJobsDB() error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS ` + videoJobsTableName + ` (
+		request_id TEXT PRIMARY KEY,
+		key_lookup TEXT NOT NULL,
+		masked_key TEXT NOT NULL,
+		model TEXT NOT NULL,
+		prompt TEXT NOT NULL,
+		status TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		video_url TEXT NOT NULL DEFAULT '',
+		local_file TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		completed_at INTEGER NOT NULL DEFAULT 0
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	return migrateVideoJobsKeyLookup()
+}
+
+// migrateVideoJobsKeyLookup 将旧版本按密钥表ID关联的视频任务改为按密钥查询标识关联
+// 保存密钥时会清空并重建密钥表，ID随之改变，查询标识只由密钥和主密钥决定
+func migrateVideoJobsKeyLookup() error {
+	var keyIDExists int
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('" + videoJobsTableName + "') WHERE name = 'key_id'").Scan(&keyIDExists); err != nil {
+		return err
+	}
+	if keyIDExists == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("ALTER TABLE " + videoJobsTableName + " ADD COLUMN key_lookup TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// 尽量按当前的密钥表补全查询标识，找不到的任务查询状态时会提示密钥已被删除
+	var apikeysExists int
+	if err := tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name = ?", apikeysTableName).Scan(&apikeysExists); err != nil {
+		return err
+	}
+	if apikeysExists > 0 {
+		if _, err := tx.Exec(`UPDATE ` + videoJobsTableName + ` SET key_lookup = COALESCE((SELECT key FROM ` + apikeysTableName +
+			` WHERE ` + apikeysTableName + `.id = ` + videoJobsTableName + `.key_id), '')`); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("ALTER TABLE " + videoJobsTableName + " DROP COLUMN key_id"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("已将视频任务改为按密钥查询标识关联API密钥")
+	return nil
+}
+
+// CreateVideoJob 记录新提交的视频任务
+// 只保存密钥的查询标识，查询状态时再从密钥表读取并解密密钥，重新保存密钥表或轮换主密钥后仍然有效
+func CreateVideoJob(job VideoJob, apiKey string) error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	job.KeyLookup = apiKeyLookupID(apiKey)
+	if job.KeyLookup == "" {
+		return errors.New("计算API密钥查询标识失败")
+	}
+
+	now := time.Now().Unix()
+	_, err := ExecWithRetry("创建视频任务", 3,
+		`INSERT INTO `+videoJobsTableName+` (request_id, key_lookup, masked_key, model, prompt, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.RequestID, job.KeyLookup, MaskKey(apiKey), job.Model, job.Prompt, VideoJobStatusInQueue, now, now)
+	return err
+}
+
+// scanVideoJob 读取一行视频任务
+func scanVideoJob(scanner interface{ Scan(...interface{}) error }) (VideoJob, error) {
+	var job VideoJob
+	err := scanner.Scan(&job.RequestID, &job.KeyLookup, &job.MaskedKey, &job.Model, &job.Prompt, &job.Status,
+		&job.Reason, &job.VideoURL, &job.LocalFile, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt)
+	return job, err
+}
+
+// 查询视频任务的字段
+const videoJobColumns = `request_id, key_lookup, masked_key, model, prompt, status, reason, video_url, local_file,
+	created_at, updated_at, completed_at`
+
+// GetVideoJob 获取视频任务
+func GetVideoJob(requestID string) (VideoJob, error) {
+	if db == nil {
+		return VideoJob{}, errors.New("数据库连接未初始化")
+	}
+
+	job, err := scanVideoJob(db.QueryRow(`SELECT `+videoJobColumns+` FROM `+videoJobsTableName+` WHERE request_id = ?`, requestID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrVideoJobNotFound
+	}
+	return job, err
+}
+
+// GetVideoJobApiKey 获取提交视频任务的API密钥明文
+func GetVideoJobApiKey(job VideoJob) (string, error) {
+	var cipher string
+	if err := db.QueryRow(`SELECT key_cipher FROM `+apikeysTableName+` WHERE key = ?`, job.KeyLookup).Scan(&cipher); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("提交任务的API密钥已被删除")
+		}
+		return "", err
+	}
+	return DecryptApiKey(cipher)
+}
+
+// UpdateVideoJobStatus 更新视频任务的状态，任务完成或失败时记录完成时间
+func UpdateVideoJobStatus(requestID, status, reason, videoURL string) error {
+	now := time.Now().Unix()
+	completedAt := int64(0)
+	if status == VideoJobStatusSucceed || status == VideoJobStatusFailed {
+		completedAt = now
+	}
+
+	_, err := ExecWithRetry("更新视频任务状态", 3,
+		`UPDATE `+videoJobsTableName+` SET status = ?, reason = ?, video_url = ?, updated_at = ?,
+		completed_at = CASE WHEN completed_at = 0 THEN ? ELSE completed_at END WHERE request_id = ?`,
+		status, reason, videoURL, now, completedAt, requestID)
+	return err
+}
+
+// SetVideoJobLocalFile 记录下载到本地的视频文件
+func SetVideoJobLocalFile(requestID, localFile string) error {
+	_, err := ExecWithRetry("更新视频任务文件", 3,
+		`UPDATE `+videoJobsTableName+` SET local_file = ?, updated_at = ? WHERE request_id = ?`,
+		localFile, time.Now().Unix(), requestID)
+	return err
+}
+
+// ListVideoJobs 获取最近的视频任务
+func ListVideoJobs(limit int) ([]VideoJob, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接未初始化")
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := db.Query(`SELECT `+videoJobColumns+` FROM `+videoJobsTableName+` ORDER BY created_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]VideoJob, 0)
+	for rows.Next() {
+		job, err := scanVideoJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// DeleteVideoJob 删除视频任务记录，返回被删除任务的本地文件路径
+func DeleteVideoJob(requestID string) (string, error) {
+	job, err := GetVideoJob(requestID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ExecWithRetry("删除视频任务", 3, `DELETE FROM `+videoJobsTableName+` WHERE request_id = ?`, requestID); err != nil {
+		return "", err
+	}
+	return job.LocalFile, nil
+}
+
+// PurgeVideoJobs 删除指定时间之前创建的视频任务，返回这些任务的本地文件路径
+func PurgeVideoJobs(before int64) ([]string, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接未初始化")
+	}
+
+	rows, err := db.Query(`SELECT local_file FROM `+videoJobsTableName+` WHERE created_at < ? AND local_file != ''`, before)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+
+	result, err := ExecWithRetry("清理视频任务", 3, `DELETE FROM `+videoJobsTableName+` WHERE created_at < ?`, before)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		logger.Info("已清理 %d 个过期的视频任务", count)
+	}
+	return files, nil
+}
@@ -0,0 +1,97 @@
+/**
+  @author: Hanhai
+  @desc: 视频任务关联API密钥的测试，保存密钥表或轮换主密钥后仍能使用提交任务的密钥查询状态
+**/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestVideoJobKeySurvivesSaveAndRotation(t *testing.T) {
+	setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-first-secret", Balance: 1}, {Key: "sk-video-secret", Balance: 2}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+
+	if err := CreateVideoJob(VideoJob{RequestID: "req-1", Model: "Wan-AI/Wan2.1-T2V-14B", Prompt: "a cat"}, "sk-video-secret"); err != nil {
+		t.Fatalf("创建视频任务失败: %v", err)
+	}
+
+	// 提交任务后重新保存密钥表，密钥在表中的ID会改变
+	setApiKeysForTest([]ApiKey{{Key: "sk-video-secret", Balance: 2}, {Key: "sk-added-later", Balance: 3}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	assertVideoJobKey(t, "req-1", "sk-video-secret")
+
+	// 轮换主密钥后查询标识改变，视频任务同步更新
+	if err := RotateMasterKey("the-new-master-key"); err != nil {
+		t.Fatalf("轮换主密钥失败: %v", err)
+	}
+	assertVideoJobKey(t, "req-1", "sk-video-secret")
+
+	// 提交任务的密钥被删除后无法查询
+	setApiKeysForTest([]ApiKey{{Key: "sk-added-later", Balance: 3}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	job, err := GetVideoJob("req-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetVideoJobApiKey(job); err == nil {
+		t.Error("密钥已删除时应返回错误")
+	}
+}
+
+func TestMigrateVideoJobsKeyLookup(t *testing.T) {
+	setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-video-secret", Balance: 2}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	var keyID int64
+	if err := db.QueryRow(`SELECT id FROM ` + apikeysTableName).Scan(&keyID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟旧版本按密钥表ID关联的视频任务表
+	if _, err := db.Exec(`DROP TABLE ` + videoJobsTableName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE ` + videoJobsTableName + ` (
+		request_id TEXT PRIMARY KEY, key_id INTEGER NOT NULL, masked_key TEXT NOT NULL, model TEXT NOT NULL,
+		prompt TEXT NOT NULL, status TEXT NOT NULL, reason TEXT NOT NULL DEFAULT '', video_url TEXT NOT NULL DEFAULT '',
+		local_file TEXT NOT NULL DEFAULT '', created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL,
+		completed_at INTEGER NOT NULL DEFAULT 0)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO `+videoJobsTableName+` (request_id, key_id, masked_key, model, prompt, status, created_at, updated_at)
+		VALUES ('req-old', ?, 'sk-vid******', 'm', 'p', ?, 1, 1)`, keyID, VideoJobStatusInQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitVideoJobsDB(); err != nil {
+		t.Fatalf("迁移视频任务表失败: %v", err)
+	}
+	assertVideoJobKey(t, "req-old", "sk-video-secret")
+	if err := CreateVideoJob(VideoJob{RequestID: "req-new", Model: "m", Prompt: "p"}, "sk-video-secret"); err != nil {
+		t.Fatalf("迁移后创建视频任务失败: %v", err)
+	}
+}
+
+// assertVideoJobKey 检查视频任务关联的API密钥
+func assertVideoJobKey(t *testing.T, requestID, want string) {
+	t.Helper()
+	job, err := GetVideoJob(requestID)
+	if err != nil {
+		t.Fatalf("读取视频任务失败: %v", err)
+	}
+	apiKey, err := GetVideoJobApiKey(job)
+	if err != nil || apiKey != want {
+		t.Fatalf("视频任务的API密钥 = %q, %v, want %q", apiKey, err, want)
+	}
+}
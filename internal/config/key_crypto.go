@@ -0,0 +1,456 @@
+/**
+  @author: Hanhai
+  @desc: API密钥加密存储，使用主密钥对数据库中的上游密钥进行AES-GCM加密，并提供主密钥轮换功能
+**/
+
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flowsilicon/internal/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// MasterKeyEnv 指定主密钥的环境变量
+	MasterKeyEnv = "FLOWSILICON_MASTER_KEY"
+	// MasterKeyFileEnv 指定主密钥文件路径的环境变量
+	MasterKeyFileEnv = "FLOWSILICON_MASTER_KEY_FILE"
+	// NewMasterKeyEnv 轮换主密钥时指定新主密钥的环境变量，未设置时自动生成
+	NewMasterKeyEnv = "FLOWSILICON_NEW_MASTER_KEY"
+	// 默认主密钥文件名，与数据库文件位于同一目录
+	masterKeyFileName = "master.key"
+	// 加密后密钥的前缀
+	encryptedKeyPrefix = "enc:v1:"
+	// 密钥查询标识的前缀
+	lookupIDPrefix = "hmac:"
+)
+
+// ErrApiKeyDecrypt 数据库中存在无法用当前主密钥解密的API密钥，此时不允许加载或覆盖保存密钥，避免丢失数据
+var ErrApiKeyDecrypt = errors.New("无法解密数据库中的API密钥，请检查主密钥是否正确")
+
+var (
+	// 当前使用的主密钥（派生后的32字节）
+	masterKey []byte
+	// 主密钥来源：env 或 file
+	masterKeySource string
+	// 主密钥文件路径
+	masterKeyFilePath string
+	// 互斥锁保护主密钥
+	masterKeyMutex sync.RWMutex
+)
+
+// deriveMasterKey 将主密钥字符串派生为AES-256使用的32字节密钥
+func deriveMasterKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return sum[:]
+}
+
+// generateMasterKeySecret 生成随机的主密钥字符串
+func generateMasterKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// getMasterKeyFilePath 获取主密钥文件路径，优先使用环境变量指定的路径
+func getMasterKeyFilePath() string {
+	if path := os.Getenv(MasterKeyFileEnv); path != "" {
+		return path
+	}
+	return filepath.Join(GetDataDir(), masterKeyFileName)
+}
+
+// InitMasterKey 加载主密钥
+// 优先使用环境变量，其次读取主密钥文件，文件不存在时自动生成
+func InitMasterKey() error {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	return initMasterKeyLocked()
+}
+
+// initMasterKeyLocked 加载主密钥（已加锁）
+func initMasterKeyLocked() error {
+	if secret := strings.TrimSpace(os.Getenv(MasterKeyEnv)); secret != "" {
+		masterKey = deriveMasterKey(secret)
+		masterKeySource = "env"
+		logger.Info("已从环境变量 %s 加载主密钥", MasterKeyEnv)
+		return nil
+	}
+
+	path := getMasterKeyFilePath()
+	data, err := os.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		masterKey = deriveMasterKey(string(data))
+		masterKeySource = "file"
+		masterKeyFilePath = path
+		logger.Info("已从文件加载主密钥: %s", path)
+		warnMasterKeyBesideData(path)
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("读取主密钥文件失败: %w", err)
+	}
+
+	// 数据库中已有加密的密钥时不能生成新主密钥，否则这些密钥将无法解密
+	if hasEncryptedApiKeys() {
+		return fmt.Errorf("%w: 数据库中已有加密的API密钥，但未找到主密钥文件 %s，也未设置环境变量 %s",
+			ErrApiKeyDecrypt, path, MasterKeyEnv)
+	}
+
+	// 主密钥文件不存在，生成新的主密钥
+	secret, err := generateMasterKeySecret()
+	if err != nil {
+		return fmt.Errorf("生成主密钥失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建主密钥目录失败: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("写入主密钥文件失败: %w", err)
+	}
+
+	masterKey = deriveMasterKey(secret)
+	masterKeySource = "file"
+	masterKeyFilePath = path
+	logger.Warn("未找到主密钥，已生成新的主密钥文件: %s，请妥善备份并与数据库分开保存", path)
+	warnMasterKeyBesideData(path)
+	return nil
+}
+
+// warnMasterKeyBesideData 主密钥文件位于数据目录时提示风险，备份数据目录会同时备份主密钥，加密将失去作用
+func warnMasterKeyBesideData(path string) {
+	keyDir, err1 := filepath.Abs(filepath.Dir(path))
+	dataDir, err2 := filepath.Abs(GetDataDir())
+	if err1 == nil && err2 == nil && keyDir == dataDir {
+		logger.Warn("主密钥文件 %s 与数据库位于同一目录，备份数据目录时会一并备份主密钥；建议通过环境变量 %s 或 %s 将主密钥保存在其他位置",
+			path, MasterKeyEnv, MasterKeyFileEnv)
+	}
+}
+
+// hasEncryptedApiKeys 判断数据库中是否已有加密保存的API密钥
+func hasEncryptedApiKeys() bool {
+	if db == nil {
+		return false
+	}
+	var count int
+	err := db.QueryRow(`SELECT count(*) FROM `+apikeysTableName+` WHERE key_cipher LIKE ?`, encryptedKeyPrefix+"%").Scan(&count)
+	return err == nil && count > 0
+}
+
+// verifyStoredApiKeys 检查数据库中保存的所有API密钥都能用当前主密钥解密
+// 保存密钥会先清空表再写入内存中的密钥，存在无法解密的密钥时保存会导致这些密钥永久丢失
+func verifyStoredApiKeys(tx *sql.Tx, key []byte) error {
+	rows, err := tx.Query(`SELECT id, key_cipher FROM ` + apikeysTableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			return err
+		}
+		if _, err := decryptWithKey(key, stored); err != nil {
+			return fmt.Errorf("%w: ID为%d的密钥: %v", ErrApiKeyDecrypt, id, err)
+		}
+	}
+	return rows.Err()
+}
+
+// getMasterKey 获取当前主密钥，尚未加载时自动加载
+func getMasterKey() ([]byte, error) {
+	masterKeyMutex.RLock()
+	current := masterKey
+	masterKeyMutex.RUnlock()
+	if current != nil {
+		return current, nil
+	}
+
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	if masterKey == nil {
+		if err := initMasterKeyLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return masterKey, nil
+}
+
+// encryptWithKey 使用指定主密钥加密API密钥
+func encryptWithKey(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptWithKey 使用指定主密钥解密API密钥，没有加密前缀的值视为明文
+func decryptWithKey(key []byte, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		return stored, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil {
+		return "", fmt.Errorf("解码加密密钥失败: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("加密密钥数据过短")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("解密密钥失败，主密钥可能不正确")
+	}
+	return string(plaintext), nil
+}
+
+// lookupIDWithKey 使用指定主密钥计算API密钥的查询标识
+func lookupIDWithKey(key []byte, plaintext string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plaintext))
+	return lookupIDPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptApiKey 使用当前主密钥加密API密钥
+func EncryptApiKey(plaintext string) (string, error) {
+	key, err := getMasterKey()
+	if err != nil {
+		return "", err
+	}
+	return encryptWithKey(key, plaintext)
+}
+
+// DecryptApiKey 使用当前主密钥解密API密钥
+func DecryptApiKey(stored string) (string, error) {
+	key, err := getMasterKey()
+	if err != nil {
+		return "", err
+	}
+	return decryptWithKey(key, stored)
+}
+
+// apiKeyLookupID 计算API密钥在数据库中的查询标识
+// 数据库的key字段保存该标识而不是明文，加密后的密钥保存在key_cipher字段
+func apiKeyLookupID(plaintext string) string {
+	key, err := getMasterKey()
+	if err != nil {
+		logger.Error("获取主密钥失败: %v", err)
+		return ""
+	}
+	return lookupIDWithKey(key, plaintext)
+}
+
+// migrateApiKeysEncryption 将旧版本以明文保存的API密钥加密
+func migrateApiKeysEncryption() error {
+	key, err := getMasterKey()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT id, key FROM ` + apikeysTableName + ` WHERE key_cipher = ''`)
+	if err != nil {
+		return err
+	}
+
+	type plainRow struct {
+		id  int64
+		key string
+	}
+	var plainRows []plainRow
+	for rows.Next() {
+		var row plainRow
+		if err := rows.Scan(&row.id, &row.key); err != nil {
+			rows.Close()
+			return err
+		}
+		plainRows = append(plainRows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(plainRows) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, row := range plainRows {
+		encrypted, err := encryptWithKey(key, row.key)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE `+apikeysTableName+` SET key = ?, key_cipher = ? WHERE id = ?`,
+			lookupIDWithKey(key, row.key), encrypted, row.id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("已将 %d 个明文API密钥迁移为加密存储", len(plainRows))
+	return nil
+}
+
+// RotateMasterKey 轮换主密钥，使用新主密钥重新加密所有API密钥
+// newSecret为空时自动生成；主密钥来自环境变量时必须显式提供新主密钥
+func RotateMasterKey(newSecret string) error {
+	if db == nil {
+		return errors.New("数据库连接未初始化")
+	}
+
+	oldKey, err := getMasterKey()
+	if err != nil {
+		return err
+	}
+
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+
+	newSecret = strings.TrimSpace(newSecret)
+	if newSecret == "" {
+		if masterKeySource == "env" {
+			return fmt.Errorf("主密钥来自环境变量 %s，请通过 %s 指定新主密钥", MasterKeyEnv, NewMasterKeyEnv)
+		}
+		if newSecret, err = generateMasterKeySecret(); err != nil {
+			return fmt.Errorf("生成主密钥失败: %w", err)
+		}
+	}
+	newKey := deriveMasterKey(newSecret)
+
+	// 主密钥来自文件时，先写入临时文件，数据库提交成功后再替换
+	var pendingFile string
+	if masterKeySource == "file" {
+		pendingFile = masterKeyFilePath + ".new"
+		if err := os.WriteFile(pendingFile, []byte(newSecret+"\n"), 0600); err != nil {
+			return fmt.Errorf("写入新主密钥文件失败: %w", err)
+		}
+	}
+
+	count, err := reencryptApiKeys(oldKey, newKey)
+	if err != nil {
+		if pendingFile != "" {
+			os.Remove(pendingFile)
+		}
+		return err
+	}
+
+	masterKey = newKey
+
+	if pendingFile != "" {
+		if err := os.Rename(pendingFile, masterKeyFilePath); err != nil {
+			return fmt.Errorf("数据库已使用新主密钥加密，但替换主密钥文件失败，新主密钥保存在 %s: %w", pendingFile, err)
+		}
+		logger.Info("已更新主密钥文件: %s", masterKeyFilePath)
+	} else {
+		logger.Warn("数据库已使用新主密钥加密，请将环境变量 %s 更新为新主密钥后再启动程序", MasterKeyEnv)
+	}
+
+	logger.Info("主密钥轮换完成，已重新加密 %d 个API密钥", count)
+	return nil
+}
+
+// reencryptApiKeys 在一个事务中使用新主密钥重新加密所有API密钥
+func reencryptApiKeys(oldKey, newKey []byte) (int, error) {
+	rows, err := db.Query(`SELECT id, key_cipher FROM ` + apikeysTableName)
+	if err != nil {
+		return 0, err
+	}
+
+	type cipherRow struct {
+		id     int64
+		cipher string
+	}
+	var cipherRows []cipherRow
+	for rows.Next() {
+		var row cipherRow
+		if err := rows.Scan(&row.id, &row.cipher); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		cipherRows = append(cipherRows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, row := range cipherRows {
+		plaintext, err := decryptWithKey(oldKey, row.cipher)
+		if err != nil {
+			return 0, fmt.Errorf("解密ID为%d的密钥失败: %w", row.id, err)
+		}
+		encrypted, err := encryptWithKey(newKey, plaintext)
+		if err != nil {
+			return 0, err
+		}
+		newLookupID := lookupIDWithKey(newKey, plaintext)
+		if _, err := tx.Exec(`UPDATE `+apikeysTableName+` SET key = ?, key_cipher = ? WHERE id = ?`,
+			newLookupID, encrypted, row.id); err != nil {
+			return 0, err
+		}
+		// 视频任务按查询标识关联密钥，需要同步更新
+		if _, err := tx.Exec(`UPDATE `+videoJobsTableName+` SET key_lookup = ? WHERE key_lookup = ?`,
+			newLookupID, lookupIDWithKey(oldKey, plaintext)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(cipherRows), nil
+}
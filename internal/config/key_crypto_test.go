@@ -0,0 +1,206 @@
+/**
+  @author: Hanhai
+  @desc: API密钥加密存储的测试，包括主密钥错误或丢失时拒绝加载和覆盖保存，以及主密钥轮换
+**/
+
+package config
+
+import (
+	"errors"
+	"flowsilicon/internal/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupKeyCryptoTest 使用临时目录中的数据库和主密钥文件
+func setupKeyCryptoTest(t *testing.T) string {
+	t.Helper()
+	logger.InitLogger()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secrets", "master.key")
+	t.Setenv(MasterKeyEnv, "")
+	t.Setenv(MasterKeyFileEnv, keyFile)
+	resetMasterKeyForTest()
+
+	if err := InitConfigDB(filepath.Join(dir, "config.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	if err := InitApiKeysDB(); err != nil {
+		t.Fatalf("初始化API密钥表失败: %v", err)
+	}
+	t.Cleanup(func() {
+		CloseConfigDB()
+		db = nil
+		resetMasterKeyForTest()
+		setApiKeysForTest(nil)
+	})
+	return keyFile
+}
+
+func resetMasterKeyForTest() {
+	masterKeyMutex.Lock()
+	masterKey, masterKeySource, masterKeyFilePath = nil, "", ""
+	masterKeyMutex.Unlock()
+}
+
+func useMasterKeyForTest(secret string) {
+	masterKeyMutex.Lock()
+	masterKey = deriveMasterKey(secret)
+	masterKeyMutex.Unlock()
+}
+
+func setApiKeysForTest(keys []ApiKey) {
+	keysMutex.Lock()
+	apiKeys = keys
+	keysMutex.Unlock()
+}
+
+func storedKeyCount(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM ` + apikeysTableName).Scan(&count); err != nil {
+		t.Fatalf("查询API密钥数量失败: %v", err)
+	}
+	return count
+}
+
+func TestApiKeysStoredEncrypted(t *testing.T) {
+	setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-first-secret", Balance: 1}, {Key: "sk-second-secret", Balance: 2}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT key, key_cipher FROM ` + apikeysTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lookupID, cipherText string
+		if err := rows.Scan(&lookupID, &cipherText); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(lookupID+cipherText, "secret") {
+			t.Errorf("数据库中保存了明文密钥: %s %s", lookupID, cipherText)
+		}
+		if !strings.HasPrefix(cipherText, encryptedKeyPrefix) || !strings.HasPrefix(lookupID, lookupIDPrefix) {
+			t.Errorf("密钥未加密保存: %s %s", lookupID, cipherText)
+		}
+	}
+
+	setApiKeysForTest(nil)
+	if err := LoadApiKeysFromDB(); err != nil {
+		t.Fatalf("加载API密钥失败: %v", err)
+	}
+	if keys := GetApiKeys(); len(keys) != 2 || keys[0].Key != "sk-first-secret" {
+		t.Errorf("加载的密钥不正确: %+v", keys)
+	}
+}
+
+func TestWrongMasterKeyRefusesLoadAndSave(t *testing.T) {
+	setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-first-secret", Balance: 1}, {Key: "sk-second-secret", Balance: 2}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	correctKey := masterKey
+
+	// 主密钥不正确时加载失败，内存中的密钥保持不变
+	useMasterKeyForTest("a-different-master-key")
+	if err := LoadApiKeysFromDB(); !errors.Is(err, ErrApiKeyDecrypt) {
+		t.Fatalf("主密钥错误时加载应返回ErrApiKeyDecrypt，实际: %v", err)
+	}
+	if keys := GetApiKeys(); len(keys) != 2 {
+		t.Errorf("加载失败不应修改内存中的密钥: %+v", keys)
+	}
+
+	// 存在无法解密的密钥时拒绝保存，数据库中的密钥不会被清空
+	setApiKeysForTest([]ApiKey{{Key: "sk-added-later", Balance: 3}})
+	if err := SaveApiKeysToDB(); !errors.Is(err, ErrApiKeyDecrypt) {
+		t.Fatalf("存在无法解密的密钥时保存应返回ErrApiKeyDecrypt，实际: %v", err)
+	}
+	if count := storedKeyCount(t); count != 2 {
+		t.Fatalf("拒绝保存后数据库中应仍有2个密钥，实际: %d", count)
+	}
+
+	// 恢复正确的主密钥后可以正常加载原有密钥
+	masterKeyMutex.Lock()
+	masterKey = correctKey
+	masterKeyMutex.Unlock()
+	if err := LoadApiKeysFromDB(); err != nil {
+		t.Fatalf("恢复主密钥后加载失败: %v", err)
+	}
+	if keys := GetApiKeys(); len(keys) != 2 || keys[1].Key != "sk-second-secret" {
+		t.Errorf("恢复主密钥后加载的密钥不正确: %+v", keys)
+	}
+}
+
+func TestMissingMasterKeyFileIsNotRegenerated(t *testing.T) {
+	keyFile := setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-first-secret", Balance: 1}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatalf("应已生成主密钥文件: %v", err)
+	}
+
+	// 主密钥文件丢失后不能生成新主密钥，否则已加密的密钥将无法解密并在下次保存时被删除
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	resetMasterKeyForTest()
+	if err := LoadApiKeysFromDB(); !errors.Is(err, ErrApiKeyDecrypt) {
+		t.Fatalf("主密钥文件丢失时加载应返回ErrApiKeyDecrypt，实际: %v", err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("数据库中已有加密密钥时不应生成新的主密钥文件")
+	}
+
+	setApiKeysForTest(nil)
+	if err := SaveApiKeysToDB(); err == nil {
+		t.Fatal("主密钥丢失时保存应失败")
+	}
+	if count := storedKeyCount(t); count != 1 {
+		t.Fatalf("数据库中的密钥不应被删除，实际数量: %d", count)
+	}
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	keyFile := setupKeyCryptoTest(t)
+	setApiKeysForTest([]ApiKey{{Key: "sk-first-secret", Balance: 1}, {Key: "sk-second-secret", Balance: 2}})
+	if err := SaveApiKeysToDB(); err != nil {
+		t.Fatalf("保存API密钥失败: %v", err)
+	}
+	oldSecret, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RotateMasterKey("the-new-master-key"); err != nil {
+		t.Fatalf("轮换主密钥失败: %v", err)
+	}
+	newSecret, err := os.ReadFile(keyFile)
+	if err != nil || strings.TrimSpace(string(newSecret)) != "the-new-master-key" {
+		t.Fatalf("主密钥文件未更新: %q, %v", newSecret, err)
+	}
+
+	// 重新从文件加载新主密钥后可以解密所有密钥
+	resetMasterKeyForTest()
+	setApiKeysForTest(nil)
+	if err := LoadApiKeysFromDB(); err != nil {
+		t.Fatalf("轮换后加载失败: %v", err)
+	}
+	if keys := GetApiKeys(); len(keys) != 2 {
+		t.Errorf("轮换后加载的密钥数量不正确: %+v", keys)
+	}
+
+	// 旧主密钥不再能解密
+	useMasterKeyForTest(string(oldSecret))
+	if err := LoadApiKeysFromDB(); !errors.Is(err, ErrApiKeyDecrypt) {
+		t.Errorf("轮换后使用旧主密钥加载应失败，实际: %v", err)
+	}
+}
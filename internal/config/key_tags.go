@@ -0,0 +1,107 @@
+/**
+  @author: Hanhai
+  @desc: API密钥标签管理，提供标签解析、设置和按标签查询密钥的功能
+**/
+
+package config
+
+import (
+	"flowsilicon/internal/logger"
+	"sort"
+	"strings"
+)
+
+// ParseTags 解析以逗号分隔的标签字符串，去除空白和重复项并统一为小写
+func ParseTags(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+	return NormalizeTags(strings.Split(value, ","))
+}
+
+// NormalizeTags 规范化标签列表，去除空白和重复项并统一为小写
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || strings.Contains(tag, ",") || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// JoinTags 将标签列表拼接为以逗号分隔的字符串，用于保存到数据库
+func JoinTags(tags []string) string {
+	return strings.Join(NormalizeTags(tags), ",")
+}
+
+// KeyHasAnyTag 判断密钥是否包含任意一个指定标签
+func KeyHasAnyTag(key ApiKey, tags []string) bool {
+	for _, want := range tags {
+		want = strings.ToLower(strings.TrimSpace(want))
+		for _, tag := range key.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetApiKeyTags 设置API密钥的标签
+func SetApiKeyTags(key string, tags []string) bool {
+	normalized := NormalizeTags(tags)
+
+	keysMutex.Lock()
+	keyFound := false
+	for i, k := range apiKeys {
+		if k.Key == key {
+			apiKeys[i].Tags = normalized
+			keyFound = true
+			break
+		}
+	}
+	keysMutex.Unlock()
+
+	if !keyFound {
+		return false
+	}
+
+	// 保存更新到数据库
+	if db != nil {
+		_, err := ExecWithRetry("更新API密钥标签", 3,
+			`UPDATE `+apikeysTableName+` SET tags = ? WHERE key = ?`, JoinTags(normalized), apiKeyLookupID(key))
+		if err != nil {
+			logger.Error("更新API密钥标签到数据库失败: %v", err)
+		} else {
+			logger.Info("已更新API密钥标签: %s, 标签=%v", MaskKey(key), normalized)
+		}
+	}
+
+	return true
+}
+
+// GetApiKeysByTag 获取包含指定标签的所有API密钥（不包括已删除的密钥）
+func GetApiKeysByTag(tag string) []ApiKey {
+	var result []ApiKey
+	for _, key := range GetApiKeys() {
+		if KeyHasAnyTag(key, []string{tag}) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// GetAllKeyTags 获取所有密钥使用过的标签
+func GetAllKeyTags() []string {
+	var tags []string
+	for _, key := range GetApiKeys() {
+		tags = append(tags, key.Tags...)
+	}
+	return NormalizeTags(tags)
+}
@@ -0,0 +1,225 @@
+/**
+  @author: Hanhai
+  @desc: 密钥并发控制，维护每个密钥的进行中请求数并在密钥满载时排队等待
+**/
+
+package key
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"flowsilicon/internal/common"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+)
+
+// 默认的满载排队等待时间（毫秒）
+const defaultConcurrencyWaitTimeoutMs = 30000
+
+var (
+	// 每个密钥当前进行中的请求数
+	inFlightCounts = make(map[string]int)
+	// 互斥锁保护进行中请求数的并发访问
+	inFlightMutex sync.Mutex
+)
+
+// GetInFlightCount 获取指定密钥当前进行中的请求数
+func GetInFlightCount(apiKey string) int {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	return inFlightCounts[apiKey]
+}
+
+// GetAllInFlightCounts 获取所有密钥当前进行中的请求数
+func GetAllInFlightCounts() map[string]int {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+
+	result := make(map[string]int, len(inFlightCounts))
+	for apiKey, count := range inFlightCounts {
+		result[apiKey] = count
+	}
+	return result
+}
+
+// getMaxConcurrencyPerKey 获取每个密钥的最大并发数，0表示不限制
+func getMaxConcurrencyPerKey() int {
+	cfg := config.GetConfig()
+	if cfg.App.MaxConcurrencyPerKey < 0 {
+		return 0
+	}
+	return cfg.App.MaxConcurrencyPerKey
+}
+
+// IsKeySaturated 判断密钥是否已达到最大并发数
+func IsKeySaturated(apiKey string) bool {
+	maxConcurrency := getMaxConcurrencyPerKey()
+	if maxConcurrency == 0 {
+		return false
+	}
+	return GetInFlightCount(apiKey) >= maxConcurrency
+}
+
+// tryAcquireKey 尝试占用密钥的一个并发槽位，超过最大并发数时返回false
+func tryAcquireKey(apiKey string, maxConcurrency int) bool {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+
+	if maxConcurrency > 0 && inFlightCounts[apiKey] >= maxConcurrency {
+		return false
+	}
+	inFlightCounts[apiKey]++
+	return true
+}
+
+// releaseKey 释放密钥的一个并发槽位，并将空出的槽位分派给排队中的请求
+func releaseKey(apiKey string) {
+	inFlightMutex.Lock()
+	if inFlightCounts[apiKey] <= 1 {
+		delete(inFlightCounts, apiKey)
+	} else {
+		inFlightCounts[apiKey]--
+	}
+	inFlightMutex.Unlock()
+
+	queue.dispatch()
+}
+
+// newReleaseFunc 创建只会执行一次的释放函数
+func newReleaseFunc(apiKey string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			releaseKey(apiKey)
+		})
+	}
+}
+
+// AcquireKeyForRequest 选择密钥并占用一个并发槽位，返回的释放函数必须在上游请求结束后调用
+// 所选密钥已满载或处于限流冷却时改用进行中请求最少的密钥；所有密钥都不可用时按上下文中的优先级类别排队等待，
+// 超时返回ErrKeysSaturated，队列已满返回ErrQueueFull。可用密钥都在该模型上冷却时不排队，返回带最短剩余冷却时间的CooldownError；
+// 未限制每个密钥的并发数时也不排队，直接返回ErrKeysSaturated
+func AcquireKeyForRequest(ctx context.Context, requestType string, modelName string, tokenEstimate int) (string, func(), error) {
+	maxConcurrency := getMaxConcurrencyPerKey()
+	req := SelectRequest{
+		RequestType:   requestType,
+		ModelName:     modelName,
+		TokenEstimate: tokenEstimate,
+		ClientKey:     ClientKeyFromContext(ctx),
+	}
+
+	acquire := func() (string, func(), bool, error) {
+		apiKey, err := selectBestKey(req)
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		if !IsKeyCoolingDown(apiKey, modelName) && tryAcquireKey(apiKey, maxConcurrency) {
+			return apiKey, newReleaseFunc(apiKey), true, nil
+		}
+
+		// 所选密钥已满载或处于限流冷却，尝试进行中请求最少的其他密钥
+		if alternative := getLeastConnectionsKey(maxConcurrency, req); alternative != "" && tryAcquireKey(alternative, maxConcurrency) {
+			logger.Info("密钥 %s 并发已满或处于冷却，改用进行中请求最少的密钥 %s",
+				utils.MaskKey(apiKey), utils.MaskKey(alternative))
+			config.UpdateApiKeyLastUsed(alternative, time.Now().Unix())
+			return alternative, newReleaseFunc(alternative), true, nil
+		}
+
+		return "", nil, false, nil
+	}
+
+	// 先直接尝试获取，密钥释放时会先分派给排队中的请求，新请求不必排在不竞争同一组密钥的请求之后
+	apiKey, release, acquired, err := acquire()
+	if err != nil {
+		return "", nil, err
+	}
+	if acquired {
+		return apiKey, release, nil
+	}
+
+	// 可用密钥都在冷却时排队也无法获取，按最短剩余冷却时间提示客户端稍后重试
+	if retryAfter, allCooling := GetShortestCooldown(candidateKeys(req), modelName); allCooling {
+		logger.Warn("所有可用API密钥都处于限流冷却，%v后可重试: 模型=%s", retryAfter, modelName)
+		return "", nil, &common.CooldownError{RetryAfter: retryAfter}
+	}
+
+	// 未限制并发时没有可以等待释放的槽位，不进入队列
+	if maxConcurrency == 0 {
+		return "", nil, common.ErrKeysSaturated
+	}
+
+	cfg := config.GetConfig()
+	waitTimeout := time.Duration(cfg.App.ConcurrencyWaitTimeoutMs) * time.Millisecond
+	if cfg.App.ConcurrencyWaitTimeoutMs <= 0 {
+		waitTimeout = defaultConcurrencyWaitTimeoutMs * time.Millisecond
+	}
+
+	logger.Warn("所有API密钥并发已满(上限%d)或处于冷却，排队等待: 模型=%s", maxConcurrency, modelName)
+	return waitInQueue(ctx, modelName+"|"+req.ClientKey, acquire, waitTimeout)
+}
+
+// getLeastConnectionsKey 获取请求允许使用的、进行中请求最少、未满载且未在该模型上冷却的可用密钥，同数量时轮询
+func getLeastConnectionsKey(maxConcurrency int, req SelectRequest) string {
+	activeKeys := candidateKeys(req)
+	if len(activeKeys) == 0 {
+		return ""
+	}
+
+	minBalance := config.GetConfig().App.MinBalanceThreshold
+	counts := GetAllInFlightCounts()
+
+	// 过滤掉余额不足和处于冷却的密钥
+	var candidates []config.ApiKey
+	for _, k := range activeKeys {
+		if k.Balance >= minBalance && !IsKeyCoolingDown(k.Key, req.ModelName) {
+			candidates = append(candidates, k)
+		}
+	}
+
+	// 找出最少的进行中请求数
+	lowest := -1
+	for _, k := range candidates {
+		count := counts[k.Key]
+		if maxConcurrency > 0 && count >= maxConcurrency {
+			continue
+		}
+		if lowest == -1 || count < lowest {
+			lowest = count
+		}
+	}
+
+	if lowest == -1 {
+		return ""
+	}
+
+	// 收集所有进行中请求数最少的密钥
+	var leastKeys []config.ApiKey
+	for _, k := range candidates {
+		if counts[k.Key] == lowest {
+			leastKeys = append(leastKeys, k)
+		}
+	}
+
+	logger.Info("找到%d个进行中请求数最少(%d)的密钥", len(leastKeys), lowest)
+	return selectKeyByRoundRobin(leastKeys, StrategyLeastConnections)
+}
+
+// getLeastConnectionsKeyForSelector 最少连接策略，没有合适密钥时回退到任意可用密钥
+func getLeastConnectionsKeyForSelector(req SelectRequest) (string, error) {
+	activeKeys := candidateKeys(req)
+	if len(activeKeys) == 0 {
+		return "", common.ErrNoActiveKeys
+	}
+
+	selectedKey := getLeastConnectionsKey(getMaxConcurrencyPerKey(), req)
+	if selectedKey == "" {
+		return getAnyAvailableKey(activeKeys)
+	}
+
+	config.UpdateApiKeyLastUsed(selectedKey, time.Now().Unix())
+	return selectedKey, nil
+}
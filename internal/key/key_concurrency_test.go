@@ -0,0 +1,121 @@
+/**
+  @author: Hanhai
+  @desc: 密钥进行中请求计数和最少连接选择的测试
+**/
+
+package key
+
+import (
+	"sync"
+	"testing"
+
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+)
+
+// addTestKeys 向内存中的密钥列表添加测试密钥，测试结束后标记删除
+func addTestKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	logger.InitLogger()
+	for _, k := range keys {
+		config.AddApiKey(k, 10)
+	}
+	t.Cleanup(func() {
+		for _, k := range keys {
+			config.MarkApiKeyForDeletion(k)
+		}
+	})
+}
+
+func TestAcquireReleaseConcurrent(t *testing.T) {
+	logger.InitLogger()
+	const apiKey = "sk-concurrency-counter"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !tryAcquireKey(apiKey, 0) {
+				t.Error("不限制并发时应总能占用槽位")
+				return
+			}
+			release := newReleaseFunc(apiKey)
+			release()
+			release() // 释放函数重复调用不应重复扣减
+		}()
+	}
+	wg.Wait()
+
+	if got := GetInFlightCount(apiKey); got != 0 {
+		t.Fatalf("全部释放后进行中请求数 = %d, want 0", got)
+	}
+	if _, exists := GetAllInFlightCounts()[apiKey]; exists {
+		t.Error("进行中请求数为0的密钥应从计数表中移除")
+	}
+}
+
+func TestTryAcquireKeyLimit(t *testing.T) {
+	logger.InitLogger()
+	const apiKey = "sk-concurrency-limit"
+	t.Cleanup(func() {
+		for GetInFlightCount(apiKey) > 0 {
+			releaseKey(apiKey)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if !tryAcquireKey(apiKey, 2) {
+			t.Fatalf("第%d次占用应成功", i+1)
+		}
+	}
+	if tryAcquireKey(apiKey, 2) {
+		t.Fatal("达到最大并发数后占用应失败")
+	}
+
+	releaseKey(apiKey)
+	if !tryAcquireKey(apiKey, 2) {
+		t.Fatal("释放一个槽位后占用应成功")
+	}
+	if got := GetInFlightCount(apiKey); got != 2 {
+		t.Errorf("进行中请求数 = %d, want 2", got)
+	}
+}
+
+func TestGetLeastConnectionsKey(t *testing.T) {
+	setTestConfig(t, &config.Config{})
+	addTestKeys(t, "sk-least-a", "sk-least-b", "sk-least-c")
+
+	acquire := func(apiKey string, n int) {
+		for i := 0; i < n; i++ {
+			tryAcquireKey(apiKey, 0)
+		}
+		t.Cleanup(func() {
+			for i := 0; i < n; i++ {
+				releaseKey(apiKey)
+			}
+		})
+	}
+	acquire("sk-least-a", 2)
+	acquire("sk-least-b", 1)
+
+	tests := []struct {
+		name           string
+		maxConcurrency int
+		extra          map[string]int
+		want           string
+	}{
+		{"选择进行中请求最少的密钥", 0, nil, "sk-least-c"},
+		{"负载变化后重新选择", 0, map[string]int{"sk-least-c": 2}, "sk-least-b"},
+		{"跳过满载的密钥", 2, nil, "sk-least-b"},
+		{"全部满载时返回空", 2, map[string]int{"sk-least-b": 1}, ""},
+	}
+	for _, tt := range tests {
+		for apiKey, n := range tt.extra {
+			acquire(apiKey, n)
+		}
+		if got := getLeastConnectionsKey(tt.maxConcurrency, SelectRequest{ModelName: "test/model"}); got != tt.want {
+			t.Errorf("%s: getLeastConnectionsKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
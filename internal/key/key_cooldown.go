@@ -0,0 +1,153 @@
+/**
+  @author: Hanhai
+  @desc: 密钥限流冷却，上游返回限流信息后按模型暂停使用对应密钥
+**/
+
+package key
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+)
+
+// KeyCooldown 密钥冷却信息
+type KeyCooldown struct {
+	Key       string `json:"key"`       // 掩码后的密钥
+	Model     string `json:"model"`     // 冷却的模型，为空表示所有模型
+	Until     int64  `json:"until"`     // 冷却结束时间戳
+	Remaining int64  `json:"remaining"` // 剩余冷却秒数
+	Reason    string `json:"reason"`    // 冷却原因
+	rawKey    string
+}
+
+var (
+	// 密钥冷却记录，键为 密钥|模型
+	keyCooldowns = make(map[string]KeyCooldown)
+	// 互斥锁保护冷却记录的并发访问
+	cooldownMutex sync.Mutex
+)
+
+// cooldownKey 生成冷却记录的键，模型名称不区分大小写
+func cooldownKey(apiKey, modelName string) string {
+	return apiKey + "|" + strings.ToLower(modelName)
+}
+
+// SetKeyCooldown 将密钥在指定模型上置于冷却期，模型为空时对所有模型生效
+// 已有更长的冷却时间时保持不变
+func SetKeyCooldown(apiKey, modelName string, duration time.Duration, reason string) {
+	if apiKey == "" || duration <= 0 {
+		return
+	}
+
+	until := time.Now().Add(duration).Unix()
+
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+
+	k := cooldownKey(apiKey, modelName)
+	if existing, exists := keyCooldowns[k]; exists && existing.Until >= until {
+		return
+	}
+
+	keyCooldowns[k] = KeyCooldown{
+		Key:    utils.MaskKey(apiKey),
+		Model:  modelName,
+		Until:  until,
+		Reason: reason,
+		rawKey: apiKey,
+	}
+
+	logger.Warn("密钥 %s 进入冷却: 模型=%s, 时长=%v, 原因=%s",
+		utils.MaskKey(apiKey), modelName, duration, reason)
+}
+
+// IsKeyCoolingDown 判断密钥在指定模型上是否处于冷却期
+func IsKeyCoolingDown(apiKey, modelName string) bool {
+	now := time.Now().Unix()
+
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+
+	for _, k := range []string{cooldownKey(apiKey, modelName), cooldownKey(apiKey, "")} {
+		if cooldown, exists := keyCooldowns[k]; exists {
+			if cooldown.Until > now {
+				return true
+			}
+			delete(keyCooldowns, k)
+		}
+	}
+	return false
+}
+
+// getCooldownRemaining 获取密钥在指定模型上的剩余冷却时间，未冷却时返回0（已加锁）
+func getCooldownRemainingLocked(apiKey, modelName string, now int64) time.Duration {
+	var remaining time.Duration
+	for _, k := range []string{cooldownKey(apiKey, modelName), cooldownKey(apiKey, "")} {
+		if cooldown, exists := keyCooldowns[k]; exists && cooldown.Until > now {
+			if d := time.Duration(cooldown.Until-now) * time.Second; d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining
+}
+
+// GetShortestCooldown 判断密钥是否都在指定模型上处于冷却期，都在冷却时返回最短的剩余冷却时间
+func GetShortestCooldown(keys []config.ApiKey, modelName string) (time.Duration, bool) {
+	if len(keys) == 0 {
+		return 0, false
+	}
+
+	now := time.Now().Unix()
+
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+
+	var shortest time.Duration
+	for _, k := range keys {
+		remaining := getCooldownRemainingLocked(k.Key, modelName, now)
+		if remaining <= 0 {
+			return 0, false
+		}
+		if shortest == 0 || remaining < shortest {
+			shortest = remaining
+		}
+	}
+	return shortest, true
+}
+
+// ClearKeyCooldown 清除密钥的所有冷却记录
+func ClearKeyCooldown(apiKey string) {
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+
+	for k, cooldown := range keyCooldowns {
+		if cooldown.rawKey == apiKey {
+			delete(keyCooldowns, k)
+		}
+	}
+}
+
+// GetKeyCooldowns 获取当前所有未过期的冷却记录
+func GetKeyCooldowns() []KeyCooldown {
+	now := time.Now().Unix()
+
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+
+	result := make([]KeyCooldown, 0, len(keyCooldowns))
+	for k, cooldown := range keyCooldowns {
+		if cooldown.Until <= now {
+			delete(keyCooldowns, k)
+			continue
+		}
+		cooldown.Remaining = cooldown.Until - now
+		result = append(result, cooldown)
+	}
+	return result
+}
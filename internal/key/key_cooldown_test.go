@@ -0,0 +1,147 @@
+/**
+  @author: Hanhai
+  @desc: 密钥按模型限流冷却的测试
+**/
+
+package key
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"flowsilicon/internal/common"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+)
+
+func TestKeyCooldownPerModel(t *testing.T) {
+	logger.InitLogger()
+	const apiKey = "sk-cooldown-model"
+	t.Cleanup(func() { ClearKeyCooldown(apiKey) })
+
+	SetKeyCooldown(apiKey, "Test/Model", time.Minute, "test")
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"Test/Model", true},
+		{"test/model", true}, // 模型名称不区分大小写
+		{"other/model", false},
+	}
+	for _, tt := range tests {
+		if got := IsKeyCoolingDown(apiKey, tt.model); got != tt.want {
+			t.Errorf("IsKeyCoolingDown(%s) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+
+	// 较短的冷却时间不会覆盖已有的冷却
+	SetKeyCooldown(apiKey, "test/model", time.Second, "test")
+	if remaining := GetKeyCooldowns(); len(remaining) != 1 || remaining[0].Remaining < 50 {
+		t.Errorf("较短的冷却覆盖了已有冷却: %+v", remaining)
+	}
+
+	// 模型为空时对所有模型生效
+	SetKeyCooldown(apiKey, "", time.Minute, "test")
+	if !IsKeyCoolingDown(apiKey, "other/model") {
+		t.Error("对所有模型的冷却未生效")
+	}
+
+	ClearKeyCooldown(apiKey)
+	if IsKeyCoolingDown(apiKey, "test/model") || IsKeyCoolingDown(apiKey, "other/model") {
+		t.Error("清除后密钥仍处于冷却")
+	}
+}
+
+func TestKeyCooldownExpiry(t *testing.T) {
+	logger.InitLogger()
+	const apiKey = "sk-cooldown-expiry"
+	t.Cleanup(func() { ClearKeyCooldown(apiKey) })
+
+	SetKeyCooldown(apiKey, "test/model", time.Minute, "test")
+	SetKeyCooldown(apiKey, "other/model", time.Minute, "test")
+
+	// 将其中一个模型的冷却改为已过期
+	cooldownMutex.Lock()
+	expired := keyCooldowns[cooldownKey(apiKey, "test/model")]
+	expired.Until = time.Now().Unix() - 1
+	keyCooldowns[cooldownKey(apiKey, "test/model")] = expired
+	cooldownMutex.Unlock()
+
+	if IsKeyCoolingDown(apiKey, "test/model") {
+		t.Error("冷却过期后密钥仍处于冷却")
+	}
+	if !IsKeyCoolingDown(apiKey, "other/model") {
+		t.Error("一个模型的冷却过期不应影响其他模型")
+	}
+	for _, cooldown := range GetKeyCooldowns() {
+		if cooldown.rawKey == apiKey && cooldown.Model == "test/model" {
+			t.Error("过期的冷却记录未被清理")
+		}
+	}
+}
+
+func TestGetShortestCooldown(t *testing.T) {
+	logger.InitLogger()
+	keys := []config.ApiKey{{Key: "sk-shortest-a"}, {Key: "sk-shortest-b"}}
+	t.Cleanup(func() {
+		for _, k := range keys {
+			ClearKeyCooldown(k.Key)
+		}
+	})
+
+	if _, allCooling := GetShortestCooldown(nil, "test/model"); allCooling {
+		t.Error("没有密钥时不应视为都在冷却")
+	}
+
+	SetKeyCooldown("sk-shortest-a", "test/model", 2*time.Minute, "test")
+	if _, allCooling := GetShortestCooldown(keys, "test/model"); allCooling {
+		t.Error("部分密钥未冷却时不应视为都在冷却")
+	}
+
+	SetKeyCooldown("sk-shortest-b", "", 30*time.Second, "test")
+	retryAfter, allCooling := GetShortestCooldown(keys, "test/model")
+	if !allCooling || retryAfter < 29*time.Second || retryAfter > 30*time.Second {
+		t.Errorf("GetShortestCooldown = %v, %v, want 30s, true", retryAfter, allCooling)
+	}
+	if _, allCooling := GetShortestCooldown(keys, "other/model"); allCooling {
+		t.Error("只在其他模型上冷却的密钥不应视为冷却")
+	}
+}
+
+func TestAcquireKeyAllKeysCoolingDown(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.MaxConcurrencyPerKey = 2
+	newTestQueue(t, cfg)
+	addTestKeys(t, "sk-all-cooling-a", "sk-all-cooling-b")
+	t.Cleanup(func() {
+		ClearKeyCooldown("sk-all-cooling-a")
+		ClearKeyCooldown("sk-all-cooling-b")
+	})
+
+	SetKeyCooldown("sk-all-cooling-a", "test/model", time.Minute, "test")
+	SetKeyCooldown("sk-all-cooling-b", "test/model", 20*time.Second, "test")
+
+	_, _, err := AcquireKeyForRequest(context.Background(), "chat", "test/model", 10)
+	var cooldownErr *common.CooldownError
+	if !errors.As(err, &cooldownErr) || !errors.Is(err, common.ErrKeysCoolingDown) {
+		t.Fatalf("所有密钥冷却时应返回CooldownError: %v", err)
+	}
+	if cooldownErr.RetryAfter < 19*time.Second || cooldownErr.RetryAfter > 20*time.Second {
+		t.Errorf("RetryAfter = %v, want 最短的剩余冷却时间20s", cooldownErr.RetryAfter)
+	}
+	if got := queue.len(); got != 0 {
+		t.Errorf("所有密钥冷却时不应进入队列，队列长度 = %d", got)
+	}
+
+	// 其他模型不受影响
+	apiKey, release, err := AcquireKeyForRequest(context.Background(), "chat", "other/model", 10)
+	if err != nil {
+		t.Fatalf("其他模型应能获取密钥: %v", err)
+	}
+	release()
+	if apiKey != "sk-all-cooling-a" && apiKey != "sk-all-cooling-b" {
+		t.Errorf("获取到未知密钥 %s", apiKey)
+	}
+}
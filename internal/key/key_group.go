@@ -0,0 +1,143 @@
+/**
+  @author: Hanhai
+  @desc: 密钥分组路由，按模型或客户端限制可使用的密钥标签，并提供分组统计
+**/
+
+package key
+
+import (
+	"context"
+	"strings"
+
+	"flowsilicon/internal/config"
+)
+
+// 上下文中存放客户端API密钥的键
+const clientKeyContextKey = priorityContextKey("flowsilicon_client_key")
+
+// WithClientKey 将客户端API密钥写入上下文，供分组路由使用
+func WithClientKey(ctx context.Context, clientKey string) context.Context {
+	return context.WithValue(ctx, clientKeyContextKey, clientKey)
+}
+
+// ClientKeyFromContext 从上下文中读取客户端API密钥
+func ClientKeyFromContext(ctx context.Context) string {
+	if clientKey, ok := ctx.Value(clientKeyContextKey).(string); ok {
+		return clientKey
+	}
+	return ""
+}
+
+// matchModelGroupRule 查找模型对应的密钥标签规则
+// 优先精确匹配（不区分大小写），其次匹配以*结尾的最长前缀规则，未配置时返回nil
+func matchModelGroupRule(modelName string, rules map[string][]string) []string {
+	if modelName == "" || len(rules) == 0 {
+		return nil
+	}
+
+	lowerModel := strings.ToLower(modelName)
+	var matchedTags []string
+	matchedLength := -1
+	for pattern, tags := range rules {
+		lowerPattern := strings.ToLower(pattern)
+		if lowerPattern == lowerModel {
+			return tags
+		}
+		if strings.HasSuffix(lowerPattern, "*") {
+			prefix := strings.TrimSuffix(lowerPattern, "*")
+			if strings.HasPrefix(lowerModel, prefix) && len(prefix) > matchedLength {
+				matchedTags = tags
+				matchedLength = len(prefix)
+			}
+		}
+	}
+	return matchedTags
+}
+
+// getGroupRulesForRequest 获取请求需要满足的标签规则，每条规则要求密钥包含其中任意一个标签
+func getGroupRulesForRequest(req SelectRequest) [][]string {
+	cfg := config.GetConfig()
+	var rules [][]string
+
+	if tags := matchModelGroupRule(req.ModelName, cfg.KeyGroups.ModelRules); len(tags) > 0 {
+		rules = append(rules, tags)
+	}
+	if req.ClientKey != "" {
+		if tags := cfg.KeyGroups.ClientRules[req.ClientKey]; len(tags) > 0 {
+			rules = append(rules, tags)
+		}
+	}
+	return rules
+}
+
+// filterKeysForRequest 按分组路由规则过滤密钥，没有适用规则时原样返回
+func filterKeysForRequest(keys []config.ApiKey, req SelectRequest) []config.ApiKey {
+	rules := getGroupRulesForRequest(req)
+	if len(rules) == 0 {
+		return keys
+	}
+
+	var result []config.ApiKey
+	for _, k := range keys {
+		allowed := true
+		for _, tags := range rules {
+			if !config.KeyHasAnyTag(k, tags) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// candidateKeys 获取请求可使用的活跃密钥
+func candidateKeys(req SelectRequest) []config.ApiKey {
+	return filterKeysForRequest(config.GetActiveApiKeys(), req)
+}
+
+// KeyGroupStats 密钥分组统计
+type KeyGroupStats struct {
+	Tag          string  `json:"tag"`           // 标签
+	Count        int     `json:"count"`         // 密钥总数
+	Active       int     `json:"active"`        // 可用密钥数
+	Disabled     int     `json:"disabled"`      // 禁用密钥数
+	TotalBalance float64 `json:"total_balance"` // 总余额
+	TotalCalls   int     `json:"total_calls"`   // 总调用次数
+	SuccessCalls int     `json:"success_calls"` // 成功调用次数
+	SuccessRate  float64 `json:"success_rate"`  // 成功率
+	InFlight     int     `json:"in_flight"`     // 当前进行中的请求数
+}
+
+// GetKeyGroupStats 按标签汇总密钥的数量、余额、调用和并发统计
+func GetKeyGroupStats() []KeyGroupStats {
+	keys := config.GetApiKeys()
+	inFlight := GetAllInFlightCounts()
+
+	result := make([]KeyGroupStats, 0)
+	for _, tag := range config.GetAllKeyTags() {
+		stats := KeyGroupStats{Tag: tag}
+		for _, k := range keys {
+			if !config.KeyHasAnyTag(k, []string{tag}) {
+				continue
+			}
+			stats.Count++
+			if k.Disabled {
+				stats.Disabled++
+			} else {
+				stats.Active++
+			}
+			stats.TotalBalance += k.Balance
+			stats.TotalCalls += k.TotalCalls
+			stats.SuccessCalls += k.SuccessCalls
+			stats.InFlight += inFlight[k.Key]
+		}
+		if stats.TotalCalls > 0 {
+			stats.SuccessRate = float64(stats.SuccessCalls) / float64(stats.TotalCalls)
+		}
+		result = append(result, stats)
+	}
+	return result
+}
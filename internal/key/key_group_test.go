@@ -0,0 +1,108 @@
+/**
+  @author: Hanhai
+  @desc: 密钥分组路由的测试，按模型或客户端限制的请求只能使用分组内的密钥
+**/
+
+package key
+
+import (
+	"context"
+	"testing"
+
+	"flowsilicon/internal/config"
+)
+
+func TestMatchModelGroupRule(t *testing.T) {
+	rules := map[string][]string{
+		"deepseek-ai/DeepSeek-R1": {"paid"},
+		"deepseek-ai/*":           {"team-a"},
+		"deepseek-ai/deepseek-v*": {"team-b"},
+	}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"deepseek-ai/DeepSeek-R1", "paid"},
+		{"DEEPSEEK-AI/deepseek-r1", "paid"},   // 不区分大小写
+		{"deepseek-ai/DeepSeek-V3", "team-b"}, // 最长前缀优先
+		{"deepseek-ai/Janus-Pro-7B", "team-a"},
+		{"Qwen/Qwen2.5-7B-Instruct", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		tags := matchModelGroupRule(tt.model, rules)
+		got := ""
+		if len(tags) > 0 {
+			got = tags[0]
+		}
+		if got != tt.want {
+			t.Errorf("matchModelGroupRule(%q) = %v, want %s", tt.model, tags, tt.want)
+		}
+	}
+}
+
+func TestGroupScopedSelectionStaysInGroup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.KeyGroups.ModelRules = map[string][]string{"paid/*": {"paid"}}
+	cfg.KeyGroups.ClientRules = map[string][]string{"sk-client-team-a": {"team-a"}}
+	setTestConfig(t, cfg)
+
+	addTestKeys(t, "sk-group-paid-a", "sk-group-paid-b", "sk-group-free", "sk-group-team")
+	config.SetApiKeyTags("sk-group-paid-a", []string{"paid", "team-a"})
+	config.SetApiKeyTags("sk-group-paid-b", []string{"paid"})
+	config.SetApiKeyTags("sk-group-free", []string{"free-trial"})
+	config.SetApiKeyTags("sk-group-team", []string{"team-a"})
+
+	tests := []struct {
+		name    string
+		req     SelectRequest
+		allowed map[string]bool
+	}{
+		{"模型分组", SelectRequest{RequestType: "chat", ModelName: "paid/model"},
+			map[string]bool{"sk-group-paid-a": true, "sk-group-paid-b": true}},
+		{"客户端分组", SelectRequest{RequestType: "chat", ModelName: "other/model", ClientKey: "sk-client-team-a"},
+			map[string]bool{"sk-group-paid-a": true, "sk-group-team": true}},
+		{"模型和客户端分组同时满足", SelectRequest{RequestType: "chat", ModelName: "paid/model", ClientKey: "sk-client-team-a"},
+			map[string]bool{"sk-group-paid-a": true}},
+	}
+
+	// 每个内置策略都只能从分组内选择密钥
+	for _, strategy := range ListKeySelectors() {
+		cfg.App.RequestTypeStrategies = map[string]string{"default": strategy}
+		for _, tt := range tests {
+			for i := 0; i < 4; i++ {
+				got, err := selectBestKey(tt.req)
+				if err != nil {
+					t.Fatalf("%s/%s: selectBestKey失败: %v", strategy, tt.name, err)
+				}
+				if !tt.allowed[got] {
+					t.Fatalf("%s/%s: 选择了分组外的密钥 %s", strategy, tt.name, got)
+				}
+			}
+		}
+	}
+}
+
+func TestGroupScopedAcquireWithSaturatedGroup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.MaxConcurrencyPerKey = 1
+	cfg.KeyGroups.ModelRules = map[string][]string{"paid/*": {"paid"}}
+	newTestQueue(t, cfg)
+
+	addTestKeys(t, "sk-saturated-paid", "sk-saturated-free")
+	config.SetApiKeyTags("sk-saturated-paid", []string{"paid"})
+	config.SetApiKeyTags("sk-saturated-free", []string{"free-trial"})
+
+	apiKey, release, err := AcquireKeyForRequest(context.Background(), "chat", "paid/model", 10)
+	if err != nil || apiKey != "sk-saturated-paid" {
+		t.Fatalf("AcquireKeyForRequest = %s, %v, want sk-saturated-paid", apiKey, err)
+	}
+	defer release()
+
+	// 分组内的密钥满载时，即使分组外还有空闲密钥也只能排队等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got, _, err := AcquireKeyForRequest(ctx, "chat", "paid/model", 10); err == nil {
+		t.Fatalf("分组满载时不应获取到分组外的密钥: %s", got)
+	}
+}
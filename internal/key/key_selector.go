@@ -35,8 +35,7 @@ func GetOptimalApiKey() (string, error) {
 type RequestType string
 
 // 获取任意可用密钥
-func getAnyAvailableKey() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getAnyAvailableKey(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -44,13 +43,12 @@ func getAnyAvailableKey() (string, error) {
 }
 
 // 获取余额最高的密钥
-func getHighestBalanceKey() (string, error) {
-	return getHighestBalanceKeyWithRoundRobin()
+func getHighestBalanceKey(activeKeys []config.ApiKey) (string, error) {
+	return getHighestBalanceKeyWithRoundRobin(activeKeys)
 }
 
 // 获取余额最高的密钥（支持轮询）
-func getHighestBalanceKeyWithRoundRobin() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getHighestBalanceKeyWithRoundRobin(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -114,8 +112,7 @@ func getHighestBalanceKeyWithRoundRobin() (string, error) {
 }
 
 // 获取历史成功率高的密钥
-func getHighSuccessRateKey(modelName string) (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getHighSuccessRateKey(modelName string, activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -141,7 +138,7 @@ func getHighSuccessRateKey(modelName string) (string, error) {
 	}
 
 	if len(highSuccessKeys) == 0 {
-		return getAnyAvailableKey()
+		return getAnyAvailableKey(activeKeys)
 	}
 
 	// 增加详细日志
@@ -188,14 +185,13 @@ func getHighSuccessRateKey(modelName string) (string, error) {
 }
 
 // 获取响应速度快的密钥
-func getFastResponseKey() (string, error) {
+func getFastResponseKey(activeKeys []config.ApiKey) (string, error) {
 	// 使用低RPM策略
-	return getLowRPMKey()
+	return getLowRPMKey(activeKeys)
 }
 
 // getLowRPMKey 获取RPM最低的密钥
-func getLowRPMKey() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getLowRPMKey(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -221,7 +217,7 @@ func getLowRPMKey() (string, error) {
 	}
 
 	if len(lowestRPMKeys) == 0 {
-		return getAnyAvailableKey()
+		return getAnyAvailableKey(activeKeys)
 	}
 
 	// 增加详细日志
@@ -263,8 +259,7 @@ func getLowRPMKey() (string, error) {
 }
 
 // getLowTPMKey 获取TPM最低的密钥
-func getLowTPMKey() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getLowTPMKey(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -290,7 +285,7 @@ func getLowTPMKey() (string, error) {
 	}
 
 	if len(lowestTPMKeys) == 0 {
-		return getAnyAvailableKey()
+		return getAnyAvailableKey(activeKeys)
 	}
 
 	// 增加详细日志
@@ -333,12 +328,22 @@ func getLowTPMKey() (string, error) {
 
 // GetBestKeyForRequest 根据请求类型选择最佳密钥
 func GetBestKeyForRequest(requestType string, modelName string, tokenEstimate int) (string, error) {
+	return selectBestKey(SelectRequest{
+		RequestType:   requestType,
+		ModelName:     modelName,
+		TokenEstimate: tokenEstimate,
+	})
+}
+
+// selectBestKey 根据请求上下文选择最佳密钥
+func selectBestKey(req SelectRequest) (string, error) {
+	modelName := req.ModelName
 
 	// 添加调试日志
-	logger.Info("GetBestKeyForRequest被调用: 模型=%s, 请求类型=%s, 预估token=%d", modelName, requestType, tokenEstimate)
+	logger.Info("GetBestKeyForRequest被调用: 模型=%s, 请求类型=%s, 预估token=%d", modelName, req.RequestType, req.TokenEstimate)
 
 	// 检查是否有针对该模型的特定策略配置
-	key, found, err := GetModelSpecificKey(modelName)
+	key, found, err := selectModelSpecificKey(req)
 	logger.Info("模型特定策略查找结果: 模型=%s, 找到策略=%v", modelName, found)
 
 	if found {
@@ -346,18 +351,8 @@ func GetBestKeyForRequest(requestType string, modelName string, tokenEstimate in
 		return key, err
 	}
 
-	// 对于大型请求，选择余额高的密钥
-	if tokenEstimate > 5000 {
-		return getHighestBalanceKey()
-	}
-
-	// 对于流式请求，选择响应速度快的密钥
-	if requestType == "streaming" {
-		return getFastResponseKey()
-	}
-
-	// 默认使用普通轮询策略（而不是智能负载均衡策略）
-	return getRoundRobinKey()
+	// 根据请求类型选择策略，默认大型请求使用高余额、流式请求使用低RPM、其余使用普通轮询
+	return selectWithStrategy(resolveRequestTypeStrategy(req), req)
 }
 
 // selectKeyByRoundRobin 使用轮询方式从密钥列表中选择一个
@@ -406,7 +401,11 @@ func selectKeyByRoundRobin(keys []config.ApiKey, strategyName string) string {
 
 // GetOptimalApiKeyWithRoundRobin 获取得分最高的API密钥，带轮询功能
 func GetOptimalApiKeyWithRoundRobin() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+	return getHighScoreKey(config.GetActiveApiKeys())
+}
+
+// getHighScoreKey 从给定密钥中获取得分最高的密钥，带轮询功能
+func getHighScoreKey(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -472,8 +471,7 @@ func GetOptimalApiKeyWithRoundRobin() (string, error) {
 }
 
 // getRoundRobinKey 实现普通轮询策略，轮询所有可用的API密钥
-func getRoundRobinKey() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getRoundRobinKey(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -521,8 +519,7 @@ func getRoundRobinKey() (string, error) {
 }
 
 // 获取余额最低的密钥（支持轮询）
-func getLowestBalanceKeyWithRoundRobin() (string, error) {
-	activeKeys := config.GetActiveApiKeys()
+func getLowestBalanceKeyWithRoundRobin(activeKeys []config.ApiKey) (string, error) {
 	if len(activeKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -586,15 +583,15 @@ func getLowestBalanceKeyWithRoundRobin() (string, error) {
 }
 
 // getLowestBalanceKey 获取余额最低的密钥
-func getLowestBalanceKey() (string, error) {
-	return getLowestBalanceKeyWithRoundRobin()
+func getLowestBalanceKey(activeKeys []config.ApiKey) (string, error) {
+	return getLowestBalanceKeyWithRoundRobin(activeKeys)
 }
 
 // getFreeModelKey 实现免费模型的策略
 // 先轮询is_delete为1的密钥，再轮询disabled为1的密钥，再轮询is_used为0的密钥，最后使用低余额策略
-func getFreeModelKey() (string, error) {
-	// 获取所有API密钥（包括禁用的，但不包括已标记为删除的）
-	allKeys := config.GetApiKeys()
+func getFreeModelKey(req SelectRequest) (string, error) {
+	// 获取请求允许使用的所有API密钥（包括禁用的，但不包括已标记为删除的）
+	allKeys := filterKeysForRequest(config.GetApiKeys(), req)
 	if len(allKeys) == 0 {
 		return "", common.ErrNoActiveKeys
 	}
@@ -603,7 +600,7 @@ func getFreeModelKey() (string, error) {
 	deletedKeys, err := getDeletedApiKeys()
 	if err != nil {
 		logger.Error("获取已删除密钥失败: %v", err)
-	} else if len(deletedKeys) > 0 {
+	} else if deletedKeys = filterKeysForRequest(deletedKeys, req); len(deletedKeys) > 0 {
 		logger.Info("找到%d个已删除的密钥，尝试使用", len(deletedKeys))
 
 		// 使用轮询选择器
@@ -654,7 +651,7 @@ func getFreeModelKey() (string, error) {
 
 	// 4. 最后尝试使用低余额策略
 	logger.Info("尝试使用低余额策略选择密钥")
-	return getLowestBalanceKey()
+	return getLowestBalanceKey(candidateKeys(req))
 }
 
 // getDeletedApiKeys 获取所有标记为已删除的API密钥
@@ -665,8 +662,8 @@ func getDeletedApiKeys() ([]config.ApiKey, error) {
 	}
 
 	rows, err := config.DB().Query(`SELECT 
-		key, balance, last_used, total_calls, success_calls, success_rate, 
-		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used 
+		key_cipher, balance, last_used, total_calls, success_calls, success_rate, 
+		consecutive_failures, disabled, disabled_at, last_tested, rpm, tpm, score, is_delete, is_used, tags 
 		FROM apikeys WHERE is_delete = 1`)
 	if err != nil {
 		return nil, err
@@ -676,8 +673,9 @@ func getDeletedApiKeys() ([]config.ApiKey, error) {
 	var deletedKeys []config.ApiKey
 	for rows.Next() {
 		var key config.ApiKey
+		var tags, keyCipher string
 		if err := rows.Scan(
-			&key.Key,
+			&keyCipher,
 			&key.Balance,
 			&key.LastUsed,
 			&key.TotalCalls,
@@ -692,9 +690,14 @@ func getDeletedApiKeys() ([]config.ApiKey, error) {
 			&key.Score,
 			&key.Delete,
 			&key.IsUsed,
+			&tags,
 		); err != nil {
 			return nil, err
 		}
+		if key.Key, err = config.DecryptApiKey(keyCipher); err != nil {
+			return nil, err
+		}
+		key.Tags = config.ParseTags(tags)
 
 		deletedKeys = append(deletedKeys, key)
 	}
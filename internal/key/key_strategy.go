@@ -0,0 +1,250 @@
+/**
+  @author: Hanhai
+  @desc: 可插拔的密钥选择策略接口与注册表
+**/
+
+package key
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+)
+
+// 内置策略名称
+const (
+	StrategyHighSuccessRate  = "high_success_rate" // 高成功率策略
+	StrategyHighScore        = "high_score"        // 高分数策略
+	StrategyLowRPM           = "low_rpm"           // 低RPM策略
+	StrategyLowTPM           = "low_tpm"           // 低TPM策略
+	StrategyHighBalance      = "high_balance"      // 高余额策略
+	StrategyRoundRobin       = "round_robin"       // 普通轮询策略
+	StrategyLowBalance       = "low_balance"       // 低余额策略
+	StrategyFree             = "free"              // 免费模型策略
+	StrategyWeighted         = "weighted"          // 加权组合策略
+	StrategyLeastConnections = "least_connections" // 最少连接策略
+)
+
+// 默认的大型请求token阈值
+const largeRequestTokenThreshold = 5000
+
+// SelectRequest 密钥选择请求的上下文信息
+type SelectRequest struct {
+	RequestType   string // 请求类型，如 chat、streaming、embeddings
+	ModelName     string // 模型名称
+	TokenEstimate int    // 预估token数量
+	ClientKey     string // 客户端使用的API密钥，用于分组路由
+}
+
+// KeySelector 密钥选择策略接口
+type KeySelector interface {
+	// Name 返回策略名称，作为注册表中的唯一标识
+	Name() string
+	// Select 根据请求上下文选择一个可用的密钥
+	Select(req SelectRequest) (string, error)
+}
+
+// selectorFunc 将普通函数包装为KeySelector
+type selectorFunc struct {
+	name string
+	fn   func(req SelectRequest) (string, error)
+}
+
+func (s *selectorFunc) Name() string {
+	return s.name
+}
+
+func (s *selectorFunc) Select(req SelectRequest) (string, error) {
+	return s.fn(req)
+}
+
+// NewKeySelector 使用名称和选择函数创建一个密钥选择策略
+func NewKeySelector(name string, fn func(req SelectRequest) (string, error)) KeySelector {
+	return &selectorFunc{name: name, fn: fn}
+}
+
+var (
+	// 已注册的密钥选择策略
+	selectorRegistry = make(map[string]KeySelector)
+	// 互斥锁保护注册表的并发访问
+	selectorMutex sync.RWMutex
+)
+
+// 策略ID到策略名称的映射，用于兼容models表和配置中的整数策略ID
+var strategyIDNames = map[int]string{
+	1:  StrategyHighSuccessRate,
+	2:  StrategyHighScore,
+	3:  StrategyLowRPM,
+	4:  StrategyLowTPM,
+	5:  StrategyHighBalance,
+	6:  StrategyRoundRobin,
+	7:  StrategyLowBalance,
+	8:  StrategyFree,
+	9:  StrategyWeighted,
+	10: StrategyLeastConnections,
+}
+
+// 策略名称到中文显示名的映射
+var strategyDisplayNames = map[string]string{
+	StrategyHighSuccessRate:  "高成功率",
+	StrategyHighScore:        "高分数",
+	StrategyLowRPM:           "低RPM",
+	StrategyLowTPM:           "低TPM",
+	StrategyHighBalance:      "高余额",
+	StrategyRoundRobin:       "普通",
+	StrategyLowBalance:       "低余额",
+	StrategyFree:             "免费",
+	StrategyWeighted:         "加权组合",
+	StrategyLeastConnections: "最少连接",
+}
+
+// 未配置时各请求类型使用的默认策略
+var defaultRequestTypeStrategies = map[string]string{
+	"large_completion": StrategyHighBalance,
+	"streaming":        StrategyLowRPM,
+}
+
+func init() {
+	builtinSelectors := []KeySelector{
+		NewKeySelector(StrategyHighSuccessRate, func(req SelectRequest) (string, error) {
+			return getHighSuccessRateKey(req.ModelName, candidateKeys(req))
+		}),
+		NewKeySelector(StrategyHighScore, func(req SelectRequest) (string, error) {
+			return getHighScoreKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyLowRPM, func(req SelectRequest) (string, error) {
+			return getLowRPMKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyLowTPM, func(req SelectRequest) (string, error) {
+			return getLowTPMKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyHighBalance, func(req SelectRequest) (string, error) {
+			return getHighestBalanceKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyRoundRobin, func(req SelectRequest) (string, error) {
+			return getRoundRobinKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyLowBalance, func(req SelectRequest) (string, error) {
+			return getLowestBalanceKey(candidateKeys(req))
+		}),
+		NewKeySelector(StrategyFree, func(req SelectRequest) (string, error) {
+			return getFreeModelKey(req)
+		}),
+		NewKeySelector(StrategyLeastConnections, func(req SelectRequest) (string, error) {
+			return getLeastConnectionsKeyForSelector(req)
+		}),
+		&WeightedSelector{},
+	}
+
+	for _, selector := range builtinSelectors {
+		if err := RegisterKeySelector(selector); err != nil {
+			logger.Error("注册内置密钥选择策略失败: %v", err)
+		}
+	}
+}
+
+// RegisterKeySelector 注册一个密钥选择策略，名称不能为空且不能重复
+func RegisterKeySelector(selector KeySelector) error {
+	if selector == nil {
+		return fmt.Errorf("密钥选择策略不能为空")
+	}
+
+	name := strings.ToLower(strings.TrimSpace(selector.Name()))
+	if name == "" {
+		return fmt.Errorf("密钥选择策略名称不能为空")
+	}
+
+	selectorMutex.Lock()
+	defer selectorMutex.Unlock()
+
+	if _, exists := selectorRegistry[name]; exists {
+		return fmt.Errorf("密钥选择策略已存在: %s", name)
+	}
+
+	selectorRegistry[name] = selector
+	return nil
+}
+
+// GetKeySelector 根据名称获取已注册的密钥选择策略
+func GetKeySelector(name string) (KeySelector, bool) {
+	selectorMutex.RLock()
+	defer selectorMutex.RUnlock()
+
+	selector, exists := selectorRegistry[strings.ToLower(strings.TrimSpace(name))]
+	return selector, exists
+}
+
+// ListKeySelectors 返回所有已注册策略的名称，按字母顺序排列
+func ListKeySelectors() []string {
+	selectorMutex.RLock()
+	defer selectorMutex.RUnlock()
+
+	names := make([]string, 0, len(selectorRegistry))
+	for name := range selectorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StrategyNameByID 将整数策略ID转换为策略名称，未知ID返回普通轮询策略
+func StrategyNameByID(strategyID int) string {
+	if name, exists := strategyIDNames[strategyID]; exists {
+		return name
+	}
+	return StrategyRoundRobin
+}
+
+// StrategyDisplayName 获取策略ID对应的中文显示名
+func StrategyDisplayName(strategyID int) string {
+	return strategyDisplayNames[StrategyNameByID(strategyID)]
+}
+
+// selectWithStrategy 使用指定名称的策略选择密钥，策略不存在时回退到普通轮询
+func selectWithStrategy(name string, req SelectRequest) (string, error) {
+	selector, exists := GetKeySelector(name)
+	if !exists {
+		logger.Warn("未找到密钥选择策略 %s，回退到普通轮询策略", name)
+		selector, _ = GetKeySelector(StrategyRoundRobin)
+	}
+
+	logger.Info("使用%s策略选择密钥: 模型=%s, 请求类型=%s", selector.Name(), req.ModelName, req.RequestType)
+	return selector.Select(req)
+}
+
+// resolveRequestTypeStrategy 根据请求类型确定使用的策略名称
+// 优先使用配置中的请求类型策略，其次是配置的default策略，然后是大型请求与内置默认值，最后为普通轮询
+func resolveRequestTypeStrategy(req SelectRequest) string {
+	cfg := config.GetConfig()
+	configured := cfg.App.RequestTypeStrategies
+	isLarge := req.TokenEstimate > largeRequestTokenThreshold
+
+	if name, exists := configured[req.RequestType]; exists && name != "" {
+		return name
+	}
+
+	// 对于大型请求，按large_completion类型处理
+	if isLarge {
+		if name, exists := configured["large_completion"]; exists && name != "" {
+			return name
+		}
+	}
+
+	if name, exists := configured["default"]; exists && name != "" {
+		return name
+	}
+
+	if isLarge {
+		return defaultRequestTypeStrategies["large_completion"]
+	}
+
+	if name, exists := defaultRequestTypeStrategies[req.RequestType]; exists {
+		return name
+	}
+
+	return StrategyRoundRobin
+}
@@ -0,0 +1,243 @@
+/**
+  @author: Hanhai
+  @desc: 密钥选择策略注册表、策略分配和加权组合评分的测试
+**/
+
+package key
+
+import (
+	"testing"
+
+	"flowsilicon/internal/config"
+)
+
+// setTestConfig 设置测试使用的全局配置
+func setTestConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := config.GetConfig()
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+}
+
+// registerTestSelector 注册一个返回固定密钥的策略，并记录收到的请求
+func registerTestSelector(t *testing.T, name string, result string, received *[]SelectRequest) {
+	t.Helper()
+	err := RegisterKeySelector(NewKeySelector(name, func(req SelectRequest) (string, error) {
+		*received = append(*received, req)
+		return result, nil
+	}))
+	if err != nil {
+		t.Fatalf("注册策略失败: %v", err)
+	}
+	t.Cleanup(func() {
+		selectorMutex.Lock()
+		delete(selectorRegistry, name)
+		selectorMutex.Unlock()
+	})
+}
+
+func TestRegisterKeySelector(t *testing.T) {
+	var received []SelectRequest
+	registerTestSelector(t, "test_fixed", "sk-fixed", &received)
+
+	if err := RegisterKeySelector(NewKeySelector("Test_Fixed", nil)); err == nil {
+		t.Error("重复的策略名称（忽略大小写）应注册失败")
+	}
+	if err := RegisterKeySelector(NewKeySelector("  ", nil)); err == nil {
+		t.Error("空的策略名称应注册失败")
+	}
+	if err := RegisterKeySelector(nil); err == nil {
+		t.Error("空策略应注册失败")
+	}
+
+	selector, exists := GetKeySelector(" TEST_FIXED ")
+	if !exists || selector.Name() != "test_fixed" {
+		t.Fatalf("按名称获取策略失败: exists=%v", exists)
+	}
+
+	names := ListKeySelectors()
+	for _, want := range []string{"test_fixed", StrategyRoundRobin, StrategyWeighted, StrategyLeastConnections} {
+		found := false
+		for _, name := range names {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("策略列表中缺少 %s: %v", want, names)
+		}
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] > names[i] {
+			t.Errorf("策略列表未按字母顺序排列: %v", names)
+			break
+		}
+	}
+}
+
+func TestStrategyNameByID(t *testing.T) {
+	tests := map[int]string{
+		1:  StrategyHighSuccessRate,
+		6:  StrategyRoundRobin,
+		9:  StrategyWeighted,
+		10: StrategyLeastConnections,
+		0:  StrategyRoundRobin,
+		99: StrategyRoundRobin,
+	}
+	for id, want := range tests {
+		if got := StrategyNameByID(id); got != want {
+			t.Errorf("StrategyNameByID(%d) = %s, want %s", id, got, want)
+		}
+	}
+	if got := StrategyDisplayName(9); got != "加权组合" {
+		t.Errorf("StrategyDisplayName(9) = %s", got)
+	}
+}
+
+func TestResolveRequestTypeStrategy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.RequestTypeStrategies = map[string]string{
+		"embeddings": StrategyHighBalance,
+		"default":    StrategyWeighted,
+	}
+	setTestConfig(t, cfg)
+
+	tests := []struct {
+		req  SelectRequest
+		want string
+	}{
+		{SelectRequest{RequestType: "embeddings"}, StrategyHighBalance},
+		{SelectRequest{RequestType: "streaming"}, StrategyWeighted},
+		{SelectRequest{RequestType: "chat", TokenEstimate: largeRequestTokenThreshold + 1}, StrategyWeighted},
+		{SelectRequest{RequestType: "chat"}, StrategyWeighted},
+	}
+	for _, tt := range tests {
+		if got := resolveRequestTypeStrategy(tt.req); got != tt.want {
+			t.Errorf("resolveRequestTypeStrategy(%+v) = %s, want %s", tt.req, got, tt.want)
+		}
+	}
+
+	// 配置的请求类型策略优先于内置默认值
+	cfg.App.RequestTypeStrategies["streaming"] = StrategyLowTPM
+	if got := resolveRequestTypeStrategy(SelectRequest{RequestType: "streaming"}); got != StrategyLowTPM {
+		t.Errorf("配置的流式请求策略未生效: %s", got)
+	}
+
+	// 未配置default时使用内置默认值，其余请求使用普通轮询
+	cfg.App.RequestTypeStrategies = map[string]string{"large_completion": StrategyLowTPM}
+	fallbacks := []struct {
+		req  SelectRequest
+		want string
+	}{
+		{SelectRequest{RequestType: "streaming"}, StrategyLowRPM},
+		{SelectRequest{RequestType: "chat", TokenEstimate: largeRequestTokenThreshold + 1}, StrategyLowTPM},
+		{SelectRequest{RequestType: "chat"}, StrategyRoundRobin},
+	}
+	for _, tt := range fallbacks {
+		if got := resolveRequestTypeStrategy(tt.req); got != tt.want {
+			t.Errorf("resolveRequestTypeStrategy(%+v) = %s, want %s", tt.req, got, tt.want)
+		}
+	}
+
+	cfg.App.RequestTypeStrategies = nil
+	if got := resolveRequestTypeStrategy(SelectRequest{RequestType: "chat", TokenEstimate: largeRequestTokenThreshold + 1}); got != StrategyHighBalance {
+		t.Errorf("未配置时大型请求应使用高余额策略: %s", got)
+	}
+}
+
+func TestSelectBestKeyUsesAssignedSelector(t *testing.T) {
+	var modelRequests, typeRequests []SelectRequest
+	registerTestSelector(t, "test_model_selector", "sk-model", &modelRequests)
+	registerTestSelector(t, "test_type_selector", "sk-type", &typeRequests)
+
+	cfg := &config.Config{}
+	cfg.App.ModelKeySelectors = map[string]string{"Test/Model": "test_model_selector"}
+	cfg.App.RequestTypeStrategies = map[string]string{"rerank": "test_type_selector"}
+	setTestConfig(t, cfg)
+
+	// 模型指定的策略优先于请求类型策略，模型名不区分大小写
+	key, err := GetBestKeyForRequest("rerank", "test/model", 10)
+	if err != nil || key != "sk-model" {
+		t.Fatalf("selectBestKey = %s, %v, want sk-model", key, err)
+	}
+	if len(modelRequests) != 1 || modelRequests[0].TokenEstimate != 10 || len(typeRequests) != 0 {
+		t.Fatalf("策略收到的请求不正确: model=%v type=%v", modelRequests, typeRequests)
+	}
+
+	key, err = selectWithStrategy(resolveRequestTypeStrategy(SelectRequest{RequestType: "rerank"}), SelectRequest{RequestType: "rerank"})
+	if err != nil || key != "sk-type" {
+		t.Fatalf("请求类型策略 = %s, %v, want sk-type", key, err)
+	}
+}
+
+func TestScoreKeysWeighted(t *testing.T) {
+	now := int64(10000)
+	keys := []config.ApiKey{
+		{Key: "sk-rich", Balance: 100, RequestsPerMinute: 50, TotalCalls: 10, SuccessRate: 0.5, LastUsed: now},
+		{Key: "sk-idle", Balance: 10, RequestsPerMinute: 0, TotalCalls: 10, SuccessRate: 1, LastUsed: now - 600},
+		{Key: "sk-new", Balance: 50, RequestsPerMinute: 10, LastUsed: now - 60},
+	}
+
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		want    string
+	}{
+		{"余额优先", map[string]float64{FactorBalance: 1}, "sk-rich"},
+		{"低RPM优先", map[string]float64{FactorRPM: 1}, "sk-idle"},
+		{"空闲时间优先", map[string]float64{FactorIdle: 1}, "sk-idle"},
+		{"负权重表示反向偏好", map[string]float64{FactorBalance: -1}, "sk-idle"},
+		{"没有调用记录视为成功率100%", map[string]float64{FactorSuccessRate: 1, FactorBalance: 0.1}, "sk-new"},
+	}
+	for _, tt := range tests {
+		scores := ScoreKeysWeighted(keys, tt.weights, now)
+		if len(scores) != len(keys) {
+			t.Fatalf("%s: 得分数量 %d", tt.name, len(scores))
+		}
+		if scores[0].Key.Key != tt.want {
+			t.Errorf("%s: 最高分密钥 = %s, want %s", tt.name, scores[0].Key.Key, tt.want)
+		}
+		for i := 1; i < len(scores); i++ {
+			if scores[i-1].Score < scores[i].Score {
+				t.Errorf("%s: 得分未按从高到低排序", tt.name)
+			}
+		}
+	}
+
+	// 未知因子被忽略，所有密钥同分时保持原有顺序
+	scores := ScoreKeysWeighted(keys, map[string]float64{"unknown": 5}, now)
+	for i, score := range scores {
+		if score.Score != 0 || score.Key.Key != keys[i].Key {
+			t.Errorf("未知因子应被忽略: %+v", scores)
+			break
+		}
+	}
+
+	if scores := ScoreKeysWeighted(nil, map[string]float64{FactorBalance: 1}, now); len(scores) != 0 {
+		t.Errorf("没有密钥时应返回空列表: %v", scores)
+	}
+}
+
+func TestGetCompositeWeights(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.BalanceWeight = 0.4
+	cfg.App.SuccessRateWeight = 0.3
+	cfg.App.RPMWeight = 0.2
+	cfg.App.TPMWeight = 0.1
+	setTestConfig(t, cfg)
+
+	weights := GetCompositeWeights()
+	if weights[FactorBalance] != 0.4 || weights[FactorTPM] != 0.1 || len(weights) != 4 {
+		t.Errorf("未配置组合权重时应沿用评分权重: %v", weights)
+	}
+
+	cfg.App.CompositeWeights = map[string]float64{FactorIdle: 1, FactorFailures: 0.5}
+	weights = GetCompositeWeights()
+	if len(weights) != 2 || weights[FactorIdle] != 1 || weights[FactorFailures] != 0.5 {
+		t.Errorf("配置的组合权重未生效: %v", weights)
+	}
+
+	// 返回的是副本，修改不影响配置
+	weights[FactorIdle] = 3
+	if cfg.App.CompositeWeights[FactorIdle] != 1 {
+		t.Error("修改返回的权重不应影响配置")
+	}
+}
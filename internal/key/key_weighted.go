@@ -0,0 +1,175 @@
+/**
+  @author: Hanhai
+  @desc: 加权组合密钥选择策略，各因子权重来自配置
+**/
+
+package key
+
+import (
+	"time"
+
+	"flowsilicon/internal/common"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+)
+
+// 加权组合策略支持的评分因子
+const (
+	FactorBalance     = "balance"      // 余额，越高越好
+	FactorSuccessRate = "success_rate" // 成功率，越高越好
+	FactorRPM         = "rpm"          // 每分钟请求数，越低越好
+	FactorTPM         = "tpm"          // 每分钟令牌数，越低越好
+	FactorIdle        = "idle"         // 距上次使用的时间，越久越好
+	FactorFailures    = "failures"     // 连续失败次数，越少越好
+)
+
+// WeightedSelector 加权组合策略
+// 对每个密钥按各因子归一化到0~1后乘以权重求和，选择得分最高的密钥，同分时轮询
+type WeightedSelector struct{}
+
+// Name 返回策略名称
+func (s *WeightedSelector) Name() string {
+	return StrategyWeighted
+}
+
+// Select 使用配置中的权重选择密钥
+func (s *WeightedSelector) Select(req SelectRequest) (string, error) {
+	activeKeys := candidateKeys(req)
+	if len(activeKeys) == 0 {
+		return "", common.ErrNoActiveKeys
+	}
+
+	weights := GetCompositeWeights()
+	keysWithScores := ScoreKeysWeighted(activeKeys, weights, time.Now().Unix())
+	if len(keysWithScores) == 0 {
+		return "", common.ErrNoActiveKeys
+	}
+
+	// 收集所有具有最高分数的密钥
+	highestScore := keysWithScores[0].Score
+	var highestScoreKeys []config.ApiKey
+	for _, keyWithScore := range keysWithScores {
+		if keyWithScore.Score == highestScore {
+			highestScoreKeys = append(highestScoreKeys, keyWithScore.Key)
+		}
+	}
+
+	logger.Info("加权组合策略: 权重=%v, 找到%d个具有相同最高分数(%.4f)的密钥",
+		weights, len(highestScoreKeys), highestScore)
+
+	selectedKey := selectKeyByRoundRobin(highestScoreKeys, StrategyWeighted)
+	if selectedKey == "" {
+		return "", common.ErrNoActiveKeys
+	}
+
+	logger.Info("加权组合策略选择密钥: %s", utils.MaskKey(selectedKey))
+
+	// 更新最后使用时间
+	config.UpdateApiKeyLastUsed(selectedKey, time.Now().Unix())
+	return selectedKey, nil
+}
+
+// GetCompositeWeights 获取加权组合策略的权重
+// 未配置CompositeWeights时沿用余额、成功率、RPM、TPM的评分权重
+func GetCompositeWeights() map[string]float64 {
+	cfg := config.GetConfig()
+
+	weights := make(map[string]float64)
+	if len(cfg.App.CompositeWeights) > 0 {
+		for factor, weight := range cfg.App.CompositeWeights {
+			weights[factor] = weight
+		}
+		return weights
+	}
+
+	weights[FactorBalance] = cfg.App.BalanceWeight
+	weights[FactorSuccessRate] = cfg.App.SuccessRateWeight
+	weights[FactorRPM] = cfg.App.RPMWeight
+	weights[FactorTPM] = cfg.App.TPMWeight
+	return weights
+}
+
+// ScoreKeysWeighted 按给定权重计算密钥得分，返回按得分从高到低排序的列表
+// 权重可以为负数，表示反向偏好；未知因子会被忽略。该函数不依赖全局状态，便于单独测试
+func ScoreKeysWeighted(keys []config.ApiKey, weights map[string]float64, now int64) []KeyWithScore {
+	if len(keys) == 0 {
+		return []KeyWithScore{}
+	}
+
+	// 找出各维度的最大值，用于归一化
+	var maxBalance float64
+	var maxRPM, maxTPM, maxFailures int
+	var maxIdle int64
+	for _, k := range keys {
+		if k.Balance > maxBalance {
+			maxBalance = k.Balance
+		}
+		if k.RequestsPerMinute > maxRPM {
+			maxRPM = k.RequestsPerMinute
+		}
+		if k.TokensPerMinute > maxTPM {
+			maxTPM = k.TokensPerMinute
+		}
+		if k.ConsecutiveFailures > maxFailures {
+			maxFailures = k.ConsecutiveFailures
+		}
+		if idle := now - k.LastUsed; idle > maxIdle {
+			maxIdle = idle
+		}
+	}
+
+	keysWithScores := make([]KeyWithScore, 0, len(keys))
+	for _, k := range keys {
+		factors := map[string]float64{
+			FactorBalance:     normalizeHigher(k.Balance, maxBalance),
+			FactorSuccessRate: k.SuccessRate,
+			FactorRPM:         normalizeLower(float64(k.RequestsPerMinute), float64(maxRPM)),
+			FactorTPM:         normalizeLower(float64(k.TokensPerMinute), float64(maxTPM)),
+			FactorIdle:        normalizeHigher(float64(now-k.LastUsed), float64(maxIdle)),
+			FactorFailures:    normalizeLower(float64(k.ConsecutiveFailures), float64(maxFailures)),
+		}
+
+		// 没有调用记录时假设成功率为100%
+		if k.TotalCalls == 0 {
+			factors[FactorSuccessRate] = 1
+		}
+
+		score := 0.0
+		for factor, weight := range weights {
+			if value, ok := factors[factor]; ok {
+				score += value * weight
+			}
+		}
+
+		keysWithScores = append(keysWithScores, KeyWithScore{Key: k, Score: score})
+	}
+
+	// 按得分从高到低排序，保持同分密钥的原有顺序
+	for i := 1; i < len(keysWithScores); i++ {
+		for j := i; j > 0 && keysWithScores[j].Score > keysWithScores[j-1].Score; j-- {
+			keysWithScores[j], keysWithScores[j-1] = keysWithScores[j-1], keysWithScores[j]
+		}
+	}
+
+	return keysWithScores
+}
+
+// normalizeHigher 将数值归一化到0~1，数值越大得分越高
+func normalizeHigher(value, max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	if value <= 0 {
+		return 0
+	}
+	return value / max
+}
+
+// normalizeLower 将数值归一化到0~1，数值越小得分越高
+func normalizeLower(value, max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	return 1 - value/max
+}
@@ -12,34 +12,68 @@ import (
 	"strings"
 )
 
-// KeySelectionStrategy 定义密钥选择策略类型
-type KeySelectionStrategy int
-
 // GetModelSpecificKey 根据模型名称获取特定的密钥
 func GetModelSpecificKey(modelName string) (string, bool, error) {
+	return selectModelSpecificKey(SelectRequest{ModelName: modelName})
+}
+
+// selectModelSpecificKey 根据请求中的模型查找特定策略并选择密钥
+func selectModelSpecificKey(req SelectRequest) (string, bool, error) {
+	modelName := req.ModelName
 	logger.Info("检查模型特定策略: 模型=%s", modelName)
 
-	// 首先从models表中获取模型的策略
+	// 优先使用配置中按名称指定的策略
+	if strategyName, exists := getModelSelectorFromConfig(modelName); exists {
+		logger.Info("从配置找到模型策略名称: 模型=%s, 策略=%s", modelName, strategyName)
+		key, err := selectWithStrategy(strategyName, req)
+		return key, true, err
+	}
+
+	// 其次从models表中获取模型的策略
 	strategyID, err := model.GetModelStrategy(modelName)
 	if err != nil {
 		logger.Error("从数据库获取模型策略失败: %v", err)
 		// 如果获取失败，回退到配置文件中查找
-		return getModelStrategyFromConfig(modelName)
+		return getModelStrategyFromConfig(req)
 	}
 
 	// 如果找到策略（strategyID > 0），应用它
 	if strategyID > 0 {
 		logger.Info("从数据库找到模型特定策略: 模型=%s, 策略ID=%d", modelName, strategyID)
-		return applyModelStrategy(modelName, strategyID)
+		return applyModelStrategy(req, strategyID)
 	}
 
 	// 如果数据库中没有指定策略，回退到配置文件中查找
 	logger.Info("数据库中没有模型策略，回退到配置查找: 模型=%s", modelName)
-	return getModelStrategyFromConfig(modelName)
+	return getModelStrategyFromConfig(req)
+}
+
+// getModelSelectorFromConfig 从配置中查找按名称指定的模型策略
+func getModelSelectorFromConfig(modelName string) (string, bool) {
+	cfg := config.GetConfig()
+	if len(cfg.App.ModelKeySelectors) == 0 || modelName == "" {
+		return "", false
+	}
+
+	if strategyName, exists := cfg.App.ModelKeySelectors[modelName]; exists && strategyName != "" {
+		return strategyName, true
+	}
+
+	// 尝试不区分大小写的匹配
+	modelNameLower := strings.ToLower(modelName)
+	for configModel, strategyName := range cfg.App.ModelKeySelectors {
+		if strings.ToLower(configModel) == modelNameLower && strategyName != "" {
+			return strategyName, true
+		}
+	}
+
+	return "", false
 }
 
 // getModelStrategyFromConfig 从配置文件中获取模型策略（为了向后兼容）
-func getModelStrategyFromConfig(modelName string) (string, bool, error) {
+func getModelStrategyFromConfig(req SelectRequest) (string, bool, error) {
+	modelName := req.ModelName
+
 	// 检查是否有针对该模型的特定策略配置
 	cfg := config.GetConfig()
 
@@ -57,7 +91,7 @@ func getModelStrategyFromConfig(modelName string) (string, bool, error) {
 			logger.Error("更新模型策略到数据库失败: %v", err)
 		}
 
-		return applyModelStrategy(modelName, strategyID)
+		return applyModelStrategy(req, strategyID)
 	}
 
 	// 如果精确匹配失败，尝试不区分大小写的匹配
@@ -73,7 +107,7 @@ func getModelStrategyFromConfig(modelName string) (string, bool, error) {
 				logger.Error("更新模型策略到数据库失败: %v", err)
 			}
 
-			return applyModelStrategy(modelName, strategyID)
+			return applyModelStrategy(req, strategyID)
 		}
 	}
 
@@ -82,44 +116,8 @@ func getModelStrategyFromConfig(modelName string) (string, bool, error) {
 	return "", false, nil
 }
 
-// applyModelStrategy 应用模型特定策略
-func applyModelStrategy(modelName string, strategyID int) (string, bool, error) {
-	switch strategyID {
-	case 1: // 高成功率策略
-		logger.Info("使用高成功率策略选择密钥: 模型=%s", modelName)
-		key, err := getHighSuccessRateKey(modelName)
-		return key, true, err
-	case 2: // 高分数策略
-		logger.Info("使用高分数策略选择密钥: 模型=%s", modelName)
-		key, err := GetOptimalApiKeyWithRoundRobin()
-		return key, true, err
-	case 3: // 低RPM策略
-		logger.Info("使用低RPM策略选择密钥: 模型=%s", modelName)
-		key, err := getLowRPMKey()
-		return key, true, err
-	case 4: // 低TPM策略
-		logger.Info("使用低TPM策略选择密钥: 模型=%s", modelName)
-		key, err := getLowTPMKey()
-		return key, true, err
-	case 5: // 高余额策略
-		logger.Info("使用高余额策略选择密钥: 模型=%s", modelName)
-		key, err := getHighestBalanceKey()
-		return key, true, err
-	case 6: // 普通轮询策略
-		logger.Info("使用普通轮询策略选择密钥: 模型=%s", modelName)
-		key, err := getRoundRobinKey()
-		return key, true, err
-	case 7: // 低余额策略
-		logger.Info("使用低余额策略选择密钥: 模型=%s", modelName)
-		key, err := getLowestBalanceKey()
-		return key, true, err
-	case 8: // 免费模型策略
-		logger.Info("使用免费模型策略选择密钥: 模型=%s", modelName)
-		key, err := getFreeModelKey()
-		return key, true, err
-	default:
-		logger.Info("使用默认策略(普通轮询)选择密钥: 模型=%s", modelName)
-		key, err := getRoundRobinKey()
-		return key, true, err
-	}
+// applyModelStrategy 应用模型特定策略，整数策略ID通过注册表映射到具体策略
+func applyModelStrategy(req SelectRequest, strategyID int) (string, bool, error) {
+	key, err := selectWithStrategy(StrategyNameByID(strategyID), req)
+	return key, true, err
 }
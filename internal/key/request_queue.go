@@ -0,0 +1,422 @@
+/**
+  @author: Hanhai
+  @desc: 带优先级的请求队列，所有密钥满载时按优先级类别排队等待可用密钥
+**/
+
+package key
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"flowsilicon/internal/common"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+)
+
+// 请求队列默认配置
+const (
+	defaultQueueMaxLength   = 100
+	defaultPriorityClass    = "interactive"
+	queueRecheckInterval    = time.Second
+	priorityClassContextKey = priorityContextKey("flowsilicon_priority_class")
+)
+
+// 未配置时使用的优先级类别，数值越大越优先
+var defaultPriorityClasses = map[string]int{
+	"interactive": 10,
+	"batch":       1,
+}
+
+// priorityContextKey 上下文中存放优先级类别的键类型
+type priorityContextKey string
+
+// WithPriorityClass 将优先级类别写入上下文，供排队时使用
+func WithPriorityClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, priorityClassContextKey, class)
+}
+
+// PriorityClassFromContext 从上下文中读取优先级类别，未设置时返回默认类别
+func PriorityClassFromContext(ctx context.Context) string {
+	if class, ok := ctx.Value(priorityClassContextKey).(string); ok && class != "" {
+		return class
+	}
+	return GetDefaultPriorityClass()
+}
+
+// GetPriorityClasses 获取配置的优先级类别，未配置时使用默认类别
+func GetPriorityClasses() map[string]int {
+	cfg := config.GetConfig()
+	if len(cfg.RequestQueue.Classes) > 0 {
+		return cfg.RequestQueue.Classes
+	}
+	return defaultPriorityClasses
+}
+
+// GetDefaultPriorityClass 获取默认优先级类别
+func GetDefaultPriorityClass() string {
+	cfg := config.GetConfig()
+	if cfg.RequestQueue.DefaultClass != "" {
+		return cfg.RequestQueue.DefaultClass
+	}
+	return defaultPriorityClass
+}
+
+// ResolvePriorityClass 校验优先级类别，未知类别回退到默认类别
+func ResolvePriorityClass(class string) string {
+	if _, exists := GetPriorityClasses()[class]; exists {
+		return class
+	}
+	return GetDefaultPriorityClass()
+}
+
+// acquireFunc 尝试为请求获取密钥，密钥都不可用时返回false
+type acquireFunc func() (string, func(), bool, error)
+
+// queueResult 分派给排队请求的获取结果
+type queueResult struct {
+	apiKey  string
+	release func()
+	err     error
+}
+
+// queueWaiter 队列中等待的请求
+type queueWaiter struct {
+	class    string
+	priority int
+	seq      uint64
+	scope    string // 可用密钥范围，同一范围的请求竞争同一组密钥
+	enqueued time.Time
+	acquire  acquireFunc
+	result   chan queueResult
+	index    int
+}
+
+// waiterHeap 按优先级从高到低、同优先级先进先出排列的堆
+type waiterHeap []*queueWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x interface{}) {
+	waiter := x.(*queueWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	waiter := old[n-1]
+	old[n-1] = nil
+	waiter.index = -1
+	*h = old[:n-1]
+	return waiter
+}
+
+// QueueClassStats 单个优先级类别的队列统计
+type QueueClassStats struct {
+	Priority      int     `json:"priority"`    // 优先级
+	Depth         int     `json:"depth"`       // 当前排队数
+	Enqueued      int64   `json:"enqueued"`    // 累计入队数
+	Dequeued      int64   `json:"dequeued"`    // 累计成功出队数
+	Timeouts      int64   `json:"timeouts"`    // 累计等待超时数
+	Rejected      int64   `json:"rejected"`    // 累计因队列已满被拒绝数
+	Canceled      int64   `json:"canceled"`    // 累计客户端取消数
+	AvgWaitMs     float64 `json:"avg_wait_ms"` // 平均等待时间（毫秒）
+	MaxWaitMs     int64   `json:"max_wait_ms"` // 最长等待时间（毫秒）
+	totalWaitTime int64
+}
+
+// QueueStats 请求队列统计
+type QueueStats struct {
+	Depth     int                         `json:"depth"`      // 当前排队总数
+	MaxDepth  int                         `json:"max_depth"`  // 历史最大排队数
+	MaxLength int                         `json:"max_length"` // 队列最大长度
+	Classes   map[string]*QueueClassStats `json:"classes"`    // 各优先级类别统计
+}
+
+// requestQueue 请求队列
+type requestQueue struct {
+	mutex       sync.Mutex
+	waiters     waiterHeap
+	seq         uint64
+	maxDepth    int
+	stats       map[string]*QueueClassStats
+	dispatching bool // 是否正在分派
+	redispatch  bool // 分派期间有新的释放通知，需要再分派一轮
+}
+
+var queue = &requestQueue{
+	stats: make(map[string]*QueueClassStats),
+}
+
+// getQueueMaxLength 获取队列最大长度
+func getQueueMaxLength() int {
+	cfg := config.GetConfig()
+	if cfg.RequestQueue.MaxLength <= 0 {
+		return defaultQueueMaxLength
+	}
+	return cfg.RequestQueue.MaxLength
+}
+
+// classStatsLocked 获取类别统计，不存在时创建（已加锁）
+func (q *requestQueue) classStatsLocked(class string) *QueueClassStats {
+	stats, exists := q.stats[class]
+	if !exists {
+		stats = &QueueClassStats{}
+		q.stats[class] = stats
+	}
+	return stats
+}
+
+// len 获取当前排队数
+func (q *requestQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.waiters.Len()
+}
+
+// push 将请求加入队列，队列已满时返回ErrQueueFull
+func (q *requestQueue) push(class string, scope string, acquire acquireFunc) (*queueWaiter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	stats := q.classStatsLocked(class)
+	if q.waiters.Len() >= getQueueMaxLength() {
+		stats.Rejected++
+		return nil, common.ErrQueueFull
+	}
+
+	q.seq++
+	waiter := &queueWaiter{
+		class:    class,
+		priority: GetPriorityClasses()[class],
+		seq:      q.seq,
+		scope:    scope,
+		enqueued: time.Now(),
+		acquire:  acquire,
+		result:   make(chan queueResult, 1),
+	}
+	heap.Push(&q.waiters, waiter)
+
+	stats.Enqueued++
+	stats.Depth++
+	if depth := q.waiters.Len(); depth > q.maxDepth {
+		q.maxDepth = depth
+	}
+	return waiter, nil
+}
+
+// remove 将请求移出队列，请求已被分派结果时返回false
+func (q *requestQueue) remove(waiter *queueWaiter) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.removeLocked(waiter)
+}
+
+// removeLocked 将请求移出队列（已加锁）
+func (q *requestQueue) removeLocked(waiter *queueWaiter) bool {
+	if waiter.index < 0 {
+		return false
+	}
+	heap.Remove(&q.waiters, waiter.index)
+	q.classStatsLocked(waiter.class).Depth--
+	return true
+}
+
+// deliver 将获取结果交给仍在排队的请求并移出队列，请求已离开队列时返回false
+func (q *requestQueue) deliver(waiter *queueWaiter, result queueResult) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.removeLocked(waiter) {
+		return false
+	}
+	waiter.result <- result
+	return true
+}
+
+// isHead 判断请求是否位于队首
+func (q *requestQueue) isHead(waiter *queueWaiter) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.waiters.Len() > 0 && q.waiters[0] == waiter
+}
+
+// sortedWaitersLocked 按优先级从高到低、同优先级先进先出的顺序返回排队中的请求（已加锁）
+func (q *requestQueue) sortedWaitersLocked() []*queueWaiter {
+	waiters := make(waiterHeap, len(q.waiters))
+	copy(waiters, q.waiters)
+	sort.Slice(waiters, func(i, j int) bool {
+		if waiters[i].priority != waiters[j].priority {
+			return waiters[i].priority > waiters[j].priority
+		}
+		return waiters[i].seq < waiters[j].seq
+	})
+	return waiters
+}
+
+// dispatch 按优先级依次让排队中的请求尝试获取密钥
+// 某个请求获取失败时继续尝试后面的请求，避免被冷却或分组满载的队首阻塞其他请求；
+// 同一范围内已经失败的请求本轮不再重复尝试。分派期间收到的新通知会合并为下一轮分派
+func (q *requestQueue) dispatch() {
+	q.mutex.Lock()
+	if q.dispatching {
+		q.redispatch = true
+		q.mutex.Unlock()
+		return
+	}
+	q.dispatching = true
+
+	for {
+		q.redispatch = false
+		waiters := q.sortedWaitersLocked()
+		q.mutex.Unlock()
+
+		failedScopes := make(map[string]bool)
+		for _, waiter := range waiters {
+			if failedScopes[waiter.scope] {
+				continue
+			}
+
+			apiKey, release, acquired, err := waiter.acquire()
+			if err == nil && !acquired {
+				failedScopes[waiter.scope] = true
+				continue
+			}
+			if !q.deliver(waiter, queueResult{apiKey: apiKey, release: release, err: err}) && release != nil {
+				// 请求已超时或取消，归还占用的槽位
+				release()
+			}
+		}
+
+		q.mutex.Lock()
+		if !q.redispatch {
+			q.dispatching = false
+			q.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// record 记录请求的出队结果
+func (q *requestQueue) record(waiter *queueWaiter, outcome string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	stats := q.classStatsLocked(waiter.class)
+	switch outcome {
+	case "dequeued":
+		waitMs := time.Since(waiter.enqueued).Milliseconds()
+		stats.Dequeued++
+		stats.totalWaitTime += waitMs
+		stats.AvgWaitMs = float64(stats.totalWaitTime) / float64(stats.Dequeued)
+		if waitMs > stats.MaxWaitMs {
+			stats.MaxWaitMs = waitMs
+		}
+	case "timeout":
+		stats.Timeouts++
+	case "canceled":
+		stats.Canceled++
+	}
+}
+
+// GetQueueStats 获取请求队列的深度和统计信息
+func GetQueueStats() QueueStats {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	classes := GetPriorityClasses()
+	result := QueueStats{
+		Depth:     queue.waiters.Len(),
+		MaxDepth:  queue.maxDepth,
+		MaxLength: getQueueMaxLength(),
+		Classes:   make(map[string]*QueueClassStats),
+	}
+
+	// 复制已有统计，并确保所有配置的类别都出现在结果中
+	for name, stats := range queue.stats {
+		statsCopy := *stats
+		statsCopy.Priority = classes[name]
+		result.Classes[name] = &statsCopy
+	}
+	for name, priority := range classes {
+		if _, exists := result.Classes[name]; !exists {
+			result.Classes[name] = &QueueClassStats{Priority: priority}
+		}
+	}
+	return result
+}
+
+// waitInQueue 在队列中等待可用密钥，密钥释放或定期检查时按优先级分派
+// scope 为请求可用密钥的范围，同一范围的请求在分派时按顺序竞争
+func waitInQueue(ctx context.Context, scope string, acquire acquireFunc, waitTimeout time.Duration) (string, func(), error) {
+	class := ResolvePriorityClass(PriorityClassFromContext(ctx))
+
+	waiter, err := queue.push(class, scope, acquire)
+	if err != nil {
+		logger.Warn("请求队列已满(%d)，拒绝请求: 优先级=%s", getQueueMaxLength(), class)
+		return "", nil, err
+	}
+
+	logger.Info("所有API密钥满载，请求进入队列: 优先级=%s, 当前排队数=%d", class, queue.len())
+
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(queueRecheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case result := <-waiter.result:
+			return finishWaiting(waiter, result)
+		case <-ticker.C:
+			// 定期检查，避免配置变化或冷却结束时没有释放通知
+			if queue.isHead(waiter) {
+				queue.dispatch()
+			}
+		case <-timer.C:
+			if !queue.remove(waiter) {
+				// 超时的同时已被分派结果
+				return finishWaiting(waiter, <-waiter.result)
+			}
+			queue.record(waiter, "timeout")
+			logger.Warn("请求排队等待超时(%v): 优先级=%s", waitTimeout, class)
+			return "", nil, common.ErrKeysSaturated
+		case <-ctx.Done():
+			if !queue.remove(waiter) {
+				if result := <-waiter.result; result.release != nil {
+					result.release()
+				}
+			}
+			queue.record(waiter, "canceled")
+			return "", nil, ctx.Err()
+		}
+	}
+}
+
+// finishWaiting 处理分派给请求的获取结果
+func finishWaiting(waiter *queueWaiter, result queueResult) (string, func(), error) {
+	if result.err != nil {
+		return "", nil, result.err
+	}
+	queue.record(waiter, "dequeued")
+	logger.Info("请求出队: 优先级=%s, 等待%v", waiter.class, time.Since(waiter.enqueued))
+	return result.apiKey, result.release, nil
+}
@@ -0,0 +1,232 @@
+/**
+  @author: Hanhai
+  @desc: 请求队列优先级分派、超时和取消的测试
+**/
+
+package key
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"flowsilicon/internal/common"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+)
+
+// newTestQueue 使用全新的请求队列，测试结束后恢复
+func newTestQueue(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	logger.InitLogger()
+	setTestConfig(t, cfg)
+
+	previous := queue
+	queue = &requestQueue{stats: make(map[string]*QueueClassStats)}
+	t.Cleanup(func() { queue = previous })
+}
+
+// waitForQueueLen 等待队列达到指定长度
+func waitForQueueLen(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for queue.len() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("队列长度 = %d, want %d", queue.len(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// neverAcquire 始终获取不到密钥
+func neverAcquire() (string, func(), bool, error) {
+	return "", nil, false, nil
+}
+
+func TestQueueDispatchOrder(t *testing.T) {
+	newTestQueue(t, &config.Config{})
+
+	var mutex sync.Mutex
+	available := 0
+	var order []string
+
+	acquireAs := func(id string) acquireFunc {
+		return func() (string, func(), bool, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if available == 0 {
+				return "", nil, false, nil
+			}
+			available--
+			order = append(order, id)
+			return "sk-" + id, func() {}, true, nil
+		}
+	}
+
+	waiters := []struct{ id, class string }{
+		{"batch-1", "batch"},
+		{"interactive-1", "interactive"},
+		{"batch-2", "batch"},
+		{"interactive-2", "interactive"},
+	}
+	var wg sync.WaitGroup
+	for i, w := range waiters {
+		wg.Add(1)
+		go func(id, class string) {
+			defer wg.Done()
+			ctx := WithPriorityClass(context.Background(), class)
+			apiKey, _, err := waitInQueue(ctx, "test/model|", acquireAs(id), time.Minute)
+			if err != nil || apiKey != "sk-"+id {
+				t.Errorf("%s: waitInQueue = %s, %v", id, apiKey, err)
+			}
+		}(w.id, w.class)
+		waitForQueueLen(t, i+1)
+	}
+
+	mutex.Lock()
+	available = len(waiters)
+	mutex.Unlock()
+	queue.dispatch()
+	wg.Wait()
+
+	want := []string{"interactive-1", "interactive-2", "batch-1", "batch-2"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("出队顺序 = %v, want %v", order, want)
+		}
+	}
+	stats := GetQueueStats()
+	if stats.Depth != 0 || stats.Classes["interactive"].Dequeued != 2 || stats.Classes["batch"].Dequeued != 2 {
+		t.Errorf("队列统计不正确: depth=%d interactive=%+v batch=%+v",
+			stats.Depth, stats.Classes["interactive"], stats.Classes["batch"])
+	}
+}
+
+func TestQueueBlockedHeadDoesNotStallOthers(t *testing.T) {
+	newTestQueue(t, &config.Config{})
+
+	headCtx, cancelHead := context.WithCancel(WithPriorityClass(context.Background(), "interactive"))
+	headDone := make(chan error, 1)
+	go func() {
+		_, _, err := waitInQueue(headCtx, "cooling/model|", neverAcquire, time.Minute)
+		headDone <- err
+	}()
+	waitForQueueLen(t, 1)
+
+	otherDone := make(chan string, 1)
+	go func() {
+		ctx := WithPriorityClass(context.Background(), "batch")
+		apiKey, _, _ := waitInQueue(ctx, "other/model|", func() (string, func(), bool, error) {
+			return "sk-other", func() {}, true, nil
+		}, time.Minute)
+		otherDone <- apiKey
+	}()
+	waitForQueueLen(t, 2)
+
+	queue.dispatch()
+	select {
+	case apiKey := <-otherDone:
+		if apiKey != "sk-other" {
+			t.Errorf("后面的请求获取到 %q, want sk-other", apiKey)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("队首无法获取密钥时阻塞了后面的请求")
+	}
+	if got := queue.len(); got != 1 {
+		t.Errorf("无法获取密钥的队首应继续排队，队列长度 = %d", got)
+	}
+
+	cancelHead()
+	<-headDone
+}
+
+func TestQueueTimeout(t *testing.T) {
+	newTestQueue(t, &config.Config{})
+
+	start := time.Now()
+	_, _, err := waitInQueue(context.Background(), "test/model|", neverAcquire, 50*time.Millisecond)
+	if !errors.Is(err, common.ErrKeysSaturated) {
+		t.Fatalf("等待超时应返回ErrKeysSaturated: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("未等到超时就返回: %v", elapsed)
+	}
+	if got := queue.len(); got != 0 {
+		t.Errorf("超时的请求应移出队列，队列长度 = %d", got)
+	}
+	if got := GetQueueStats().Classes[defaultPriorityClass].Timeouts; got != 1 {
+		t.Errorf("超时统计 = %d, want 1", got)
+	}
+}
+
+func TestQueueCancelRemovesWaiter(t *testing.T) {
+	newTestQueue(t, &config.Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := waitInQueue(ctx, "test/model|", neverAcquire, time.Minute)
+		done <- err
+	}()
+	waitForQueueLen(t, 1)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("取消后应返回context.Canceled: %v", err)
+	}
+	if got := queue.len(); got != 0 {
+		t.Errorf("取消的请求应移出队列，队列长度 = %d", got)
+	}
+	if got := GetQueueStats().Classes[defaultPriorityClass].Canceled; got != 1 {
+		t.Errorf("取消统计 = %d, want 1", got)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RequestQueue.MaxLength = 1
+	newTestQueue(t, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		waitInQueue(ctx, "test/model|", neverAcquire, time.Minute)
+		close(done)
+	}()
+	waitForQueueLen(t, 1)
+
+	if _, _, err := waitInQueue(context.Background(), "test/model|", neverAcquire, time.Minute); !errors.Is(err, common.ErrQueueFull) {
+		t.Errorf("队列已满时应返回ErrQueueFull: %v", err)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestAcquireKeyWithoutConcurrencyLimitSkipsQueue(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.RequestTypeStrategies = map[string]string{"default": "test_unlimited"}
+	newTestQueue(t, cfg)
+
+	// 策略选中的密钥处于冷却且没有其他密钥可用
+	var received []SelectRequest
+	registerTestSelector(t, "test_unlimited", "sk-unlimited-cooling", &received)
+	SetKeyCooldown("sk-unlimited-cooling", "test/model", time.Minute, "test")
+	t.Cleanup(func() { ClearKeyCooldown("sk-unlimited-cooling") })
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := AcquireKeyForRequest(context.Background(), "chat", "test/model", 10)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("唯一的密钥处于冷却时不应获取成功")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未限制并发时不应进入队列等待")
+	}
+}
@@ -18,6 +18,10 @@ import (
 // 认证中间件常量
 const (
 	AuthCookieName = "flowsilicon_auth"
+	// 上下文中存放当前用户名、角色和会话ID的键
+	ContextUsernameKey = "auth_username"
+	ContextRoleKey     = "auth_role"
+	ContextSessionKey  = "auth_session"
 )
 
 // AuthMiddleware 检查请求是否包含有效的认证标记
@@ -37,7 +41,8 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// 检查是否启用了密码保护
 		if !cfg.Security.PasswordEnabled {
-			// 未启用密码保护，直接放行
+			// 未启用密码保护，视为管理员直接放行
+			c.Set(ContextRoleKey, auth.RoleAdmin)
 			c.Next()
 			return
 		}
@@ -74,9 +79,9 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 验证令牌
-		valid, err := auth.ParseCookie(cookie)
-		if err != nil || !valid {
+		// 验证令牌并获取用户角色
+		session, err := auth.ResolveSession(cookie)
+		if err != nil {
 			logger.Info("无效的认证令牌: %v", err)
 
 			// 清除无效的Cookie
@@ -102,7 +107,27 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 认证通过，继续处理请求
+		// 认证通过，记录用户和角色后继续处理请求
+		c.Set(ContextUsernameKey, session.Username)
+		c.Set(ContextRoleKey, session.Role)
+		c.Set(ContextSessionKey, session.ID)
+		c.Next()
+	}
+}
+
+// RequireRole 要求当前用户至少拥有指定角色，需在AuthMiddleware之后使用
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.RoleAllows(c.GetString(ContextRoleKey), role) {
+			logger.Warn("用户 %s 权限不足，拒绝访问: %s %s",
+				c.GetString(ContextUsernameKey), c.Request.Method, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "权限不足",
+			})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -114,6 +139,7 @@ func isWhitelistPath(path string) bool {
 		"/login",       // 登录页面
 		"/auth/login",  // 登录API
 		"/auth/check",  // 认证检查API
+		"/auth/oidc/",  // 单点登录
 		"/static/",     // 静态资源
 		"/static-fs/",  // 嵌入式静态资源
 		"/favicon.ico", // 网站图标
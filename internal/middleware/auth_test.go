@@ -0,0 +1,86 @@
+/**
+  @author: Hanhai
+  @desc: 认证中间件和角色权限检查的测试
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/auth"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireRole(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		role     string
+		required string
+		want     int
+	}{
+		{auth.RoleAdmin, auth.RoleAdmin, http.StatusOK},
+		{auth.RoleOperator, auth.RoleAdmin, http.StatusForbidden},
+		{auth.RoleViewer, auth.RoleAdmin, http.StatusForbidden},
+		{auth.RoleAdmin, auth.RoleOperator, http.StatusOK},
+		{auth.RoleOperator, auth.RoleOperator, http.StatusOK},
+		{auth.RoleViewer, auth.RoleOperator, http.StatusForbidden},
+		{auth.RoleViewer, auth.RoleViewer, http.StatusOK},
+		{"", auth.RoleViewer, http.StatusForbidden}, // 未经过认证中间件
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if tt.role != "" {
+				c.Set(ContextRoleKey, tt.role)
+			}
+		})
+		router.GET("/settings/x", RequireRole(tt.required), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/settings/x", nil))
+		if w.Code != tt.want {
+			t.Errorf("角色 %q 访问需要 %q 的接口: 状态码 = %d, want %d", tt.role, tt.required, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuthMiddlewareRoles(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func() *gin.Engine {
+		router := gin.New()
+		router.Use(AuthMiddleware())
+		router.GET("/settings/x", RequireRole(auth.RoleAdmin), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+		return router
+	}
+
+	// 未启用密码保护时视为管理员
+	setTestConfig(t, func(cfg *config.Config) {})
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/settings/x", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("未启用密码保护时状态码 = %d, want 200", w.Code)
+	}
+
+	// 启用密码保护后未登录的接口请求返回401，无效的Cookie同样返回401
+	setTestConfig(t, func(cfg *config.Config) { cfg.Security.PasswordEnabled = true })
+	for _, cookie := range []string{"", "invalid"} {
+		req := httptest.NewRequest(http.MethodGet, "/settings/x", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: AuthCookieName, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Cookie %q: 状态码 = %d, want 401", cookie, w.Code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/**
+  @author: Hanhai
+  @desc: 客户端证书验证中间件，启用双向TLS时要求代理接口的请求提供有效的客户端证书
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/logger"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientCertRequired 当前运行的HTTPS监听是否要求客户端证书
+// 监听的TLS设置在启动时确定，配置修改后要重启才生效，所以不能直接读取配置
+var clientCertRequired atomic.Bool
+
+// SetClientCertRequired 由HTTPS监听启动时设置，只有监听已加载客户端CA证书时才能要求客户端证书
+func SetClientCertRequired(required bool) {
+	clientCertRequired.Store(required)
+}
+
+// ClientCertMiddleware 检查请求是否提供了通过CA验证的客户端证书
+// 只在运行中的HTTPS监听要求客户端证书时生效，证书链由TLS握手时验证
+func ClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !clientCertRequired.Load() {
+			c.Next()
+			return
+		}
+
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			logger.Warn("请求未提供有效的客户端证书: %s %s", c.Request.Method, c.Request.URL.Path)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": gin.H{
+					"message": "请提供有效的客户端证书",
+					"type":    "unauthorized",
+					"code":    401,
+				},
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
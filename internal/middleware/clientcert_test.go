@@ -0,0 +1,56 @@
+/**
+  @author: Hanhai
+  @desc: 客户端证书验证中间件的测试
+**/
+
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientCertMiddlewareFollowsRunningListener(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	t.Cleanup(func() { SetClientCertRequired(false) })
+
+	router := gin.New()
+	router.Use(ClientCertMiddleware())
+	router.GET("/v1/x", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	request := func(verified bool) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/x", nil)
+		if verified {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 配置中开启了要求客户端证书，但运行中的监听未启用时不检查，避免未重启前拒绝所有请求
+	setTestConfig(t, func(cfg *config.Config) {
+		cfg.Server.TLS.Enabled = true
+		cfg.Server.TLS.RequireClientCert = true
+	})
+	if code := request(false); code != http.StatusOK {
+		t.Errorf("监听未要求客户端证书时状态码 = %d, want 200", code)
+	}
+
+	// 运行中的监听要求客户端证书时，即使配置已关闭也要检查，直到重启
+	setTestConfig(t, func(cfg *config.Config) {})
+	SetClientCertRequired(true)
+	if code := request(false); code != http.StatusUnauthorized {
+		t.Errorf("未提供客户端证书时状态码 = %d, want 401", code)
+	}
+	if code := request(true); code != http.StatusOK {
+		t.Errorf("提供了有效客户端证书时状态码 = %d, want 200", code)
+	}
+}
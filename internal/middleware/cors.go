@@ -6,78 +6,153 @@
 package middleware
 
 import (
+	"flowsilicon/internal/config"
+	"net/http"
+	"strconv"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 )
 
-// CorsMiddleware 创建一个处理跨域请求的中间件
-func CorsMiddleware() gin.HandlerFunc {
+// defaultProxyCorsPolicy 旧配置中没有跨域设置时代理接口使用的默认策略
+var defaultProxyCorsPolicy = config.CorsPolicy{
+	Enabled:        true,
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "Accept", "X-Priority-Class", "X-Requested-With"},
+	ExposedHeaders: []string{
+		"X-Request-ID", "Retry-After",
+		"X-RateLimit-Limit-Requests", "X-RateLimit-Remaining-Requests", "X-RateLimit-Reset-Requests",
+		"X-RateLimit-Limit-Tokens", "X-RateLimit-Remaining-Tokens", "X-RateLimit-Reset-Tokens",
+	},
+	MaxAge: 600,
+}
+
+// ProxyCorsMiddleware 代理接口的跨域中间件，需放在API密钥验证之前以便预检请求通过
+func ProxyCorsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 允许的域名
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		// 允许的HTTP方法
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		// 允许的HTTP头
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		// 允许暴露的头信息
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
-		// 允许凭证，如cookie
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// 处理预检请求
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+		policy := defaultProxyCorsPolicy
+		if cfg := config.GetConfig(); cfg != nil && !isEmptyCorsPolicy(cfg.Cors.Proxy) {
+			policy = cfg.Cors.Proxy
 		}
-
-		c.Next()
+		applyCorsPolicy(c, policy)
 	}
 }
 
-// BalanceCorsMiddleware 专门为Balance相关URL路径创建的跨域中间件
-func BalanceCorsMiddleware() gin.HandlerFunc {
+// AdminCorsMiddleware 管理界面和管理接口的跨域中间件，默认不允许跨域访问
+func AdminCorsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 判断是否是Balance相关路径
-		if isBalanceRelatedPath(c.Request.URL.Path) {
-			// 允许的域名
-			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-			// 允许的HTTP方法
-			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-			// 允许的HTTP头
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-			// 允许暴露的头信息
-			c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
-			// 允许凭证，如cookie
-			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-
-			// 处理预检请求
-			if c.Request.Method == "OPTIONS" {
-				c.AbortWithStatus(204)
-				return
-			}
+		var policy config.CorsPolicy
+		if cfg := config.GetConfig(); cfg != nil {
+			policy = cfg.Cors.Admin
 		}
+		applyCorsPolicy(c, policy)
+	}
+}
 
+// isEmptyCorsPolicy 判断是否为未配置的跨域策略
+func isEmptyCorsPolicy(policy config.CorsPolicy) bool {
+	return !policy.Enabled && len(policy.AllowedOrigins) == 0 && len(policy.AllowedMethods) == 0 &&
+		len(policy.AllowedHeaders) == 0 && len(policy.ExposedHeaders) == 0
+}
+
+// applyCorsPolicy 按策略设置跨域响应头，并直接响应预检请求
+func applyCorsPolicy(c *gin.Context, policy config.CorsPolicy) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		// 非跨域请求
 		c.Next()
+		return
 	}
+
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+
+	isPreflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	wildcard, allowed := matchCorsOrigin(policy.AllowedOrigins, origin)
+	if !policy.Enabled || !allowed {
+		if isPreflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		// 不设置跨域头，由浏览器拦截响应
+		c.Next()
+		return
+	}
+
+	// 允许凭证时必须返回具体来源，且只对明确列出的来源允许凭证
+	if wildcard {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		if policy.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if isPreflight {
+		if len(policy.AllowedMethods) > 0 {
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+		}
+		if containsCorsWildcard(policy.AllowedHeaders) {
+			if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if len(policy.AllowedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+		}
+		if policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	if len(policy.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+	}
+	c.Next()
 }
 
-// isBalanceRelatedPath 判断是否是Balance相关的路径
-func isBalanceRelatedPath(path string) bool {
-	// 这里列出所有与Balance查询相关的URL路径
-	balancePaths := []string{
-		"/keys/refresh",
-		"/keys/balance",
-		"/keys/status",
-		"/api/key/balance",
-		"/v1/user/info",
-		"/api/keys",
-		// 添加其他相关路径
+// matchCorsOrigin 检查来源是否被允许，返回是否通过*匹配以及是否允许
+// 支持精确匹配、*匹配所有来源和*.example.com匹配子域名
+func matchCorsOrigin(allowedOrigins []string, origin string) (bool, bool) {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	exact := false
+	wildcard := false
+
+	for _, allowed := range allowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(allowed), "/"))
+		switch {
+		case allowed == "*":
+			wildcard = true
+		case allowed == origin:
+			exact = true
+		case strings.Contains(allowed, "*."):
+			// 形如 https://*.example.com 的子域名通配
+			index := strings.Index(allowed, "*.")
+			prefix, suffix := allowed[:index], allowed[index+1:]
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) {
+				exact = true
+			}
+		}
+	}
+
+	if exact {
+		return false, true
 	}
+	return wildcard, wildcard
+}
 
-	for _, balancePath := range balancePaths {
-		if path == balancePath || path == balancePath+"/" {
+// containsCorsWildcard 检查列表中是否包含*
+func containsCorsWildcard(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) == "*" {
 			return true
 		}
 	}
-
 	return false
 }
@@ -0,0 +1,116 @@
+/**
+  @author: Hanhai
+  @desc: 跨域策略来源匹配和跨域中间件的测试
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchCorsOrigin(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowed      []string
+		origin       string
+		wantWildcard bool
+		wantAllowed  bool
+	}{
+		{"精确匹配", []string{"https://app.example.com"}, "https://app.example.com", false, true},
+		{"不区分大小写和结尾斜杠", []string{"https://App.Example.com/"}, "https://app.example.com", false, true},
+		{"协议不同", []string{"https://app.example.com"}, "http://app.example.com", false, false},
+		{"子域名通配", []string{"https://*.example.com"}, "https://a.b.example.com", false, true},
+		{"子域名通配不匹配根域名", []string{"https://*.example.com"}, "https://example.com", false, false},
+		{"子域名通配不匹配相似域名", []string{"https://*.example.com"}, "https://evil-example.com", false, false},
+		{"星号匹配所有来源", []string{"*"}, "https://any.site", true, true},
+		{"同时列出时优先精确匹配", []string{"*", "https://app.example.com"}, "https://app.example.com", false, true},
+		{"未列出的来源", []string{"https://app.example.com"}, "https://other.example.com", false, false},
+		{"空列表", nil, "https://app.example.com", false, false},
+	}
+	for _, tt := range tests {
+		wildcard, allowed := matchCorsOrigin(tt.allowed, tt.origin)
+		if wildcard != tt.wantWildcard || allowed != tt.wantAllowed {
+			t.Errorf("%s: matchCorsOrigin = %v, %v, want %v, %v", tt.name, wildcard, allowed, tt.wantWildcard, tt.wantAllowed)
+		}
+	}
+}
+
+func TestCorsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	policy := config.CorsPolicy{
+		Enabled:          true,
+		AllowedOrigins:   []string{"https://app.example.com", "*"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}
+
+	tests := []struct {
+		name        string
+		update      func(cfg *config.Config)
+		admin       bool
+		method      string
+		origin      string
+		wantStatus  int
+		wantOrigin  string
+		wantCreds   string
+		wantHeaders string
+	}{
+		{"未配置时代理接口允许所有来源", func(cfg *config.Config) {}, false, http.MethodGet, "https://any.site", http.StatusOK, "*", "", ""},
+		{"未配置时管理接口拒绝预检", func(cfg *config.Config) {}, true, http.MethodOptions, "https://any.site", http.StatusForbidden, "", "", ""},
+		{"未配置时管理接口不返回跨域头", func(cfg *config.Config) {}, true, http.MethodGet, "https://any.site", http.StatusOK, "", "", ""},
+		{"非跨域请求", func(cfg *config.Config) { cfg.Cors.Proxy = policy }, false, http.MethodGet, "", http.StatusOK, "", "", ""},
+		{"明确列出的来源允许凭证", func(cfg *config.Config) { cfg.Cors.Proxy = policy }, false, http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com", "true", ""},
+		{"通配来源不允许凭证", func(cfg *config.Config) { cfg.Cors.Proxy = policy }, false, http.MethodGet, "https://any.site", http.StatusOK, "*", "", ""},
+		{"预检请求回显请求头", func(cfg *config.Config) { cfg.Cors.Admin = policy }, true, http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com", "true", "X-Custom"},
+		{"禁用的策略拒绝预检", func(cfg *config.Config) {
+			cfg.Cors.Proxy = policy
+			cfg.Cors.Proxy.Enabled = false
+			cfg.Cors.Proxy.AllowedOrigins = []string{"https://app.example.com"}
+		}, false, http.MethodOptions, "https://app.example.com", http.StatusForbidden, "", "", ""},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, tt.update)
+
+		router := gin.New()
+		if tt.admin {
+			router.Use(AdminCorsMiddleware())
+		} else {
+			router.Use(ProxyCorsMiddleware())
+		}
+		router.Any("/x", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+		req := httptest.NewRequest(tt.method, "/x", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: 状态码 = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, tt.wantOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", tt.name, got, tt.wantCreds)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+			t.Errorf("%s: Access-Control-Allow-Headers = %q, want %q", tt.name, got, tt.wantHeaders)
+		}
+	}
+}
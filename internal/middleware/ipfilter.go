@@ -0,0 +1,184 @@
+/**
+  @author: Hanhai
+  @desc: IP访问控制中间件，按代理接口、/api接口和管理接口分别检查客户端IP的允许和拒绝列表
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IP访问控制的作用范围
+const (
+	IPScopeProxy = "proxy" // /v1等OpenAI兼容代理接口
+	IPScopeApi   = "api"   // /api代理接口
+	IPScopeAdmin = "admin" // 管理界面和管理接口
+)
+
+// DefaultTrustedProxies 未配置时信任的反向代理地址
+var DefaultTrustedProxies = []string{"127.0.0.1", "::1"}
+
+// IPFilterMiddleware 创建指定范围的IP访问控制中间件
+// 先检查拒绝列表，允许列表不为空时客户端IP必须在允许列表中
+func IPFilterMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.GetConfig()
+		if cfg == nil {
+			c.Next()
+			return
+		}
+
+		var rule config.IPFilterRule
+		switch scope {
+		case IPScopeProxy:
+			rule = cfg.IPFilter.Proxy
+		case IPScopeApi:
+			rule = cfg.IPFilter.Api
+		case IPScopeAdmin:
+			rule = cfg.IPFilter.Admin
+		}
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			c.Next()
+			return
+		}
+
+		clientIP := ClientIP(c)
+		if !IPAllowed(clientIP, rule) {
+			logger.Warn("IP %s 不允许访问%s接口: %s %s", clientIP, scope, c.Request.Method, c.Request.URL.Path)
+			if scope == IPScopeAdmin {
+				c.JSON(http.StatusForbidden, gin.H{
+					"code":    403,
+					"message": "当前IP不允许访问",
+				})
+			} else {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": gin.H{
+						"message": "当前IP不允许访问",
+						"type":    "forbidden",
+						"code":    403,
+					},
+				})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IPAllowed 检查IP是否被规则允许
+func IPAllowed(ipStr string, rule config.IPFilterRule) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		// 无法识别的地址只有在没有允许列表时放行
+		return len(rule.Allow) == 0
+	}
+	if matchIPList(ip, rule.Deny) {
+		return false
+	}
+	if len(rule.Allow) > 0 {
+		return matchIPList(ip, rule.Allow)
+	}
+	return true
+}
+
+// getTrustedProxies 获取受信任的反向代理列表
+func getTrustedProxies() []string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.IPFilter.TrustedProxies != nil {
+		return cfg.IPFilter.TrustedProxies
+	}
+	return DefaultTrustedProxies
+}
+
+// remoteAddrIP 获取直接连接的地址
+func remoteAddrIP(c *gin.Context) string {
+	remoteIP, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		remoteIP = strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	return remoteIP
+}
+
+// FromTrustedProxy 检查请求是否直接来自受信任的反向代理，只有这时X-Forwarded-*请求头才可信
+func FromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(remoteAddrIP(c))
+	return ip != nil && matchIPList(ip, getTrustedProxies())
+}
+
+// ClientIP 获取客户端真实IP
+// 只有直接连接的地址属于受信任的反向代理时才使用X-Forwarded-For，
+// 从右向左跳过受信任的代理，取第一个不受信任的地址
+func ClientIP(c *gin.Context) string {
+	remoteIP := remoteAddrIP(c)
+	if !FromTrustedProxy(c) {
+		return remoteIP
+	}
+	trustedProxies := getTrustedProxies()
+
+	forwarded := c.Request.Header.Values("X-Forwarded-For")
+	var hops []string
+	for _, value := range forwarded {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIP := net.ParseIP(hops[i])
+		if hopIP == nil {
+			break
+		}
+		if i == 0 || !matchIPList(hopIP, trustedProxies) {
+			return hopIP.String()
+		}
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(c.GetHeader("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return remoteIP
+}
+
+// matchIPList 检查IP是否匹配列表中的任一地址或CIDR网段
+func matchIPList(ip net.IP, list []string) bool {
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateIPList 检查列表中的地址和CIDR网段格式，返回第一个无效的条目
+func ValidateIPList(list []string) (string, bool) {
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return entry, false
+			}
+		} else if net.ParseIP(entry) == nil {
+			return entry, false
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,134 @@
+/**
+  @author: Hanhai
+  @desc: IP访问控制中间件和客户端IP识别的测试
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setTestConfig 设置测试使用的全局配置
+func setTestConfig(t *testing.T, update func(cfg *config.Config)) {
+	t.Helper()
+	cfg := &config.Config{}
+	update(cfg)
+	previous := config.GetConfig()
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+}
+
+// newIPTestContext 创建指定直接连接地址和请求头的请求上下文
+func newIPTestContext(remoteAddr string, headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/x", nil)
+	c.Request.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return c
+}
+
+func TestIPAllowed(t *testing.T) {
+	rule := config.IPFilterRule{
+		Allow: []string{"10.0.0.0/8", "192.168.1.10"},
+		Deny:  []string{"10.0.0.5"},
+	}
+	tests := map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"10.0.0.5":     false, // 拒绝列表优先
+		"8.8.8.8":      false, // 不在允许列表中
+		"not-an-ip":    false,
+	}
+	for ip, want := range tests {
+		if got := IPAllowed(ip, rule); got != want {
+			t.Errorf("IPAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	denyOnly := config.IPFilterRule{Deny: []string{"1.1.1.0/24"}}
+	if IPAllowed("1.1.1.1", denyOnly) || !IPAllowed("8.8.8.8", denyOnly) || !IPAllowed("not-an-ip", denyOnly) {
+		t.Error("只有拒绝列表时应放行其他地址")
+	}
+}
+
+func TestClientIPTrustsForwardedOnlyFromTrustedProxies(t *testing.T) {
+	setTestConfig(t, func(cfg *config.Config) {
+		cfg.IPFilter.TrustedProxies = []string{"127.0.0.1", "172.16.0.0/12"}
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"直接连接忽略转发头", "8.8.8.8:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "8.8.8.8"},
+		{"受信任代理转发", "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"跳过受信任的代理链", "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 172.16.0.2"}, "1.2.3.4"},
+		{"客户端伪造的最左地址不被采用", "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.2.3.4"}, "1.2.3.4"},
+		{"没有X-Forwarded-For时使用X-Real-IP", "127.0.0.1:1234", map[string]string{"X-Real-IP": "5.6.7.8"}, "5.6.7.8"},
+		{"不受信任的地址忽略X-Real-IP", "8.8.8.8:1234", map[string]string{"X-Real-IP": "5.6.7.8"}, "8.8.8.8"},
+	}
+	for _, tt := range tests {
+		c := newIPTestContext(tt.remoteAddr, tt.headers)
+		if got := ClientIP(c); got != tt.want {
+			t.Errorf("%s: ClientIP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if FromTrustedProxy(newIPTestContext("8.8.8.8:1234", nil)) || !FromTrustedProxy(newIPTestContext("172.20.0.1:1234", nil)) {
+		t.Error("FromTrustedProxy 判断不正确")
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	setTestConfig(t, func(cfg *config.Config) {
+		cfg.IPFilter.TrustedProxies = []string{"127.0.0.1"}
+		cfg.IPFilter.Proxy = config.IPFilterRule{Allow: []string{"10.0.0.0/8", "1.1.1.1"}, Deny: []string{"10.0.0.5"}}
+	})
+
+	router := gin.New()
+	router.Use(IPFilterMiddleware(IPScopeProxy))
+	router.GET("/v1/x", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	adminRouter := gin.New()
+	adminRouter.Use(IPFilterMiddleware(IPScopeAdmin))
+	adminRouter.GET("/v1/x", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	tests := []struct {
+		name       string
+		router     *gin.Engine
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"允许列表中的地址", router, "10.1.2.3:1234", "", http.StatusOK},
+		{"拒绝列表中的地址", router, "10.0.0.5:1234", "", http.StatusForbidden},
+		{"不在允许列表中的地址", router, "8.8.8.8:1234", "", http.StatusForbidden},
+		{"通过受信任代理转发的允许地址", router, "127.0.0.1:1234", "1.1.1.1", http.StatusOK},
+		{"伪造X-Forwarded-For无效", router, "8.8.8.8:1234", "1.1.1.1", http.StatusForbidden},
+		{"未配置规则的范围不限制", adminRouter, "8.8.8.8:1234", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/x", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: 状态码 = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/**
+  @author: Hanhai
+  @desc: 请求优先级中间件，根据请求头或客户端API密钥确定排队时的优先级类别，并记录客户端密钥用于分组路由
+**/
+
+package middleware
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 默认的优先级请求头
+const defaultPriorityHeader = "X-Priority-Class"
+
+// PriorityMiddleware 为代理请求确定优先级类别并写入请求上下文
+// 优先使用请求头指定的类别，其次是客户端API密钥对应的类别，最后为默认类别
+func PriorityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.GetConfig()
+		if cfg == nil {
+			c.Next()
+			return
+		}
+
+		headerName := cfg.RequestQueue.PriorityHeader
+		if headerName == "" {
+			headerName = defaultPriorityHeader
+		}
+
+		clientKey := extractAPIKey(c)
+		class := strings.ToLower(strings.TrimSpace(c.GetHeader(headerName)))
+		if class == "" && clientKey != "" {
+			class = cfg.RequestQueue.ClientClasses[clientKey]
+		}
+
+		class = key.ResolvePriorityClass(class)
+		ctx := key.WithPriorityClass(c.Request.Context(), class)
+		// 记录客户端API密钥，用于按密钥分组路由
+		if clientKey != "" {
+			ctx = key.WithClientKey(ctx, clientKey)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
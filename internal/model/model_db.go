@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -57,6 +58,8 @@ func InitModelDB(dbPath string) error {
 		strategy_id INTEGER DEFAULT 0 NOT NULL,
 		type INTEGER DEFAULT 1 NOT NULL,
 		call_count INTEGER DEFAULT 0 NOT NULL,
+		tool_emulation BOOLEAN DEFAULT 0 NOT NULL,
+		vision BOOLEAN DEFAULT 0 NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		deleted_at TIMESTAMP
@@ -104,6 +107,22 @@ func InitModelDB(dbPath string) error {
 		return err
 	}
 
+	// 检查tool_emulation字段是否存在
+	var toolEmulationColumnExists int
+	err = modelDB.QueryRow("SELECT count(*) FROM pragma_table_info('models') WHERE name='tool_emulation'").Scan(&toolEmulationColumnExists)
+	if err != nil {
+		logger.Error("检查tool_emulation字段存在失败: %v", err)
+		return err
+	}
+
+	// 检查vision字段是否存在
+	var visionColumnExists int
+	err = modelDB.QueryRow("SELECT count(*) FROM pragma_table_info('models') WHERE name='vision'").Scan(&visionColumnExists)
+	if err != nil {
+		logger.Error("检查vision字段存在失败: %v", err)
+		return err
+	}
+
 	// 如果列不存在，添加它
 	if strategyColumnExists == 0 {
 		_, err = modelDB.Exec("ALTER TABLE models ADD COLUMN strategy_id INTEGER DEFAULT 0 NOT NULL")
@@ -134,6 +153,26 @@ func InitModelDB(dbPath string) error {
 		logger.Info("成功添加call_count字段到models表")
 	}
 
+	// 如果tool_emulation列不存在，添加它
+	if toolEmulationColumnExists == 0 {
+		_, err = modelDB.Exec("ALTER TABLE models ADD COLUMN tool_emulation BOOLEAN DEFAULT 0 NOT NULL")
+		if err != nil {
+			logger.Error("添加tool_emulation字段失败: %v", err)
+			return err
+		}
+		logger.Info("成功添加tool_emulation字段到models表")
+	}
+
+	// 如果vision列不存在，添加它
+	if visionColumnExists == 0 {
+		_, err = modelDB.Exec("ALTER TABLE models ADD COLUMN vision BOOLEAN DEFAULT 0 NOT NULL")
+		if err != nil {
+			logger.Error("添加vision字段失败: %v", err)
+			return err
+		}
+		logger.Info("成功添加vision字段到models表")
+	}
+
 	// 更新所有免费模型的策略为8（免费策略），默认策略为6（普通策略）
 	_, err = modelDB.Exec(`UPDATE models SET 
 							strategy_id = CASE 
@@ -150,6 +189,18 @@ func InitModelDB(dbPath string) error {
 		logger.Info("已更新模型默认策略：免费模型使用策略8，其他模型使用策略6")
 	}
 
+	// 创建请求转换规则表
+	if err := initTransformRulesTable(); err != nil {
+		logger.Error("初始化请求转换规则失败: %v", err)
+		return err
+	}
+
+	// 创建提示词模板表
+	if err := initPromptTemplatesTable(); err != nil {
+		logger.Error("初始化提示词模板失败: %v", err)
+		return err
+	}
+
 	logger.Info("模型表初始化成功")
 	return nil
 }
@@ -194,7 +245,7 @@ func GetAllModels() ([]Model, error) {
 	}
 
 	// 查询所有未删除的模型
-	query := `SELECT id, is_free, is_giftable, strategy_id, type, call_count FROM models WHERE deleted_at IS NULL`
+	query := `SELECT id, is_free, is_giftable, strategy_id, type, call_count, tool_emulation, vision FROM models WHERE deleted_at IS NULL`
 	rows, err := modelDB.Query(query)
 	if err != nil {
 		return nil, err
@@ -204,7 +255,7 @@ func GetAllModels() ([]Model, error) {
 	var models []Model
 	for rows.Next() {
 		var model Model
-		if err := rows.Scan(&model.ID, &model.IsFree, &model.IsGiftable, &model.StrategyID, &model.Type, &model.CallCount); err != nil {
+		if err := rows.Scan(&model.ID, &model.IsFree, &model.IsGiftable, &model.StrategyID, &model.Type, &model.CallCount, &model.ToolEmulation, &model.Vision); err != nil {
 			return nil, err
 		}
 		models = append(models, model)
@@ -484,6 +535,95 @@ func GetModelType(modelId string) (int, error) {
 	return modelType, nil
 }
 
+// UpdateModelToolEmulation 设置模型是否模拟工具调用
+func UpdateModelToolEmulation(modelId string, enabled bool) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+
+	result, err := ModelDBExecWithRetry("更新模型工具调用模拟", 3,
+		"UPDATE models SET tool_emulation = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL",
+		enabled, modelId)
+	if err != nil {
+		logger.Error("更新模型工具调用模拟失败: %v", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("模型 %s 不存在", modelId)
+	}
+
+	logger.Info("已将模型 %s 的工具调用模拟设置为 %v", modelId, enabled)
+	return nil
+}
+
+// IsToolEmulationEnabled 判断模型是否需要模拟工具调用
+func IsToolEmulationEnabled(modelId string) bool {
+	if modelDB == nil || modelId == "" {
+		return false
+	}
+
+	var enabled bool
+	err := modelDB.QueryRow(
+		"SELECT tool_emulation FROM models WHERE id = ? AND deleted_at IS NULL",
+		modelId).Scan(&enabled)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Error("获取模型工具调用模拟设置失败: %v", err)
+		}
+		return false
+	}
+	return enabled
+}
+
+// UpdateModelVision 设置模型是否支持图片输入
+func UpdateModelVision(modelId string, enabled bool) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+
+	result, err := ModelDBExecWithRetry("更新模型图片输入支持", 3,
+		"UPDATE models SET vision = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL",
+		enabled, modelId)
+	if err != nil {
+		logger.Error("更新模型图片输入支持失败: %v", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("模型 %s 不存在", modelId)
+	}
+
+	logger.Info("已将模型 %s 的图片输入支持设置为 %v", modelId, enabled)
+	return nil
+}
+
+// 按名称识别的视觉模型，如Qwen2.5-VL、DeepSeek-VL2、QVQ和GLM-4.1V
+var visionModelPattern = regexp.MustCompile(`(?i)(vl|vision|qvq|omni|glm-4(\.\d+)?v|minicpm-v)`)
+
+// IsVisionModel 判断模型是否支持图片输入，数据库中标记为支持或名称符合视觉模型命名时返回true
+func IsVisionModel(modelId string) bool {
+	if modelId == "" {
+		return false
+	}
+	if visionModelPattern.MatchString(modelId) {
+		return true
+	}
+	if modelDB == nil {
+		return false
+	}
+
+	var enabled bool
+	err := modelDB.QueryRow(
+		"SELECT vision FROM models WHERE id = ? AND deleted_at IS NULL",
+		modelId).Scan(&enabled)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Error("获取模型图片输入支持设置失败: %v", err)
+		}
+		return false
+	}
+	return enabled
+}
+
 // BeginTransaction 开始一个数据库事务
 func BeginTransaction() (*sql.Tx, error) {
 	if modelDB == nil {
@@ -11,15 +11,17 @@ import (
 
 // Model 模型信息
 type Model struct {
-	ID         string     `json:"id"`          // 模型ID
-	IsFree     bool       `json:"is_free"`     // 是否免费
-	IsGiftable bool       `json:"is_giftable"` // 是否可用赠费
-	StrategyID int        `json:"strategy_id"` // 模型使用的策略ID
-	Type       int        `json:"type"`        // 模型类型：1-对话，2-生图，3-视频，4-语音，5-嵌入，6-重排序，7-推理
-	CallCount  int        `json:"call_count"`  // 调用次数
-	CreatedAt  time.Time  `json:"created_at"`  // 创建时间
-	UpdatedAt  time.Time  `json:"updated_at"`  // 更新时间
-	DeletedAt  *time.Time `json:"deleted_at"`  // 删除时间（软删除）
+	ID            string     `json:"id"`             // 模型ID
+	IsFree        bool       `json:"is_free"`        // 是否免费
+	IsGiftable    bool       `json:"is_giftable"`    // 是否可用赠费
+	StrategyID    int        `json:"strategy_id"`    // 模型使用的策略ID
+	Type          int        `json:"type"`           // 模型类型：1-对话，2-生图，3-视频，4-语音，5-嵌入，6-重排序，7-推理
+	CallCount     int        `json:"call_count"`     // 调用次数
+	ToolEmulation bool       `json:"tool_emulation"` // 是否模拟工具调用，用于不支持原生工具调用的模型
+	Vision        bool       `json:"vision"`         // 是否支持图片输入，名称符合视觉模型命名的模型无需标记
+	CreatedAt     time.Time  `json:"created_at"`     // 创建时间
+	UpdatedAt     time.Time  `json:"updated_at"`     // 更新时间
+	DeletedAt     *time.Time `json:"deleted_at"`     // 删除时间（软删除）
 }
 
 // TableName 指定表名
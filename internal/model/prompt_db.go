@@ -0,0 +1,248 @@
+/**
+  @author: Hanhai
+  @desc: 提示词模板的存储，模板可绑定到模型或客户端API密钥，在转发请求时注入到系统消息中
+**/
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/logger"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PromptTemplate 提示词模板
+type PromptTemplate struct {
+	ID          int64    `json:"id"`          // 模板ID
+	Name        string   `json:"name"`        // 模板名称，唯一
+	Content     string   `json:"content"`     // 模板内容，支持{{date}}、{{time}}、{{datetime}}、{{weekday}}、{{model}}、{{client_name}}变量
+	Position    string   `json:"position"`    // 注入位置：prepend添加到系统消息之前，append添加到系统消息之后
+	Models      []string `json:"models"`      // 绑定的模型，写法与转换规则相同：*匹配所有，以*结尾为前缀匹配，type:N按模型类型匹配
+	ClientKeys  []string `json:"client_keys"` // 绑定的客户端API密钥
+	Enabled     bool     `json:"enabled"`     // 是否启用
+	Description string   `json:"description"` // 模板说明
+}
+
+var (
+	// 内存中的提示词模板，按ID排序
+	promptTemplates []PromptTemplate
+	// 互斥锁保护提示词模板
+	promptTemplatesMutex sync.RWMutex
+)
+
+// ErrPromptTemplateNotFound 提示词模板不存在
+var ErrPromptTemplateNotFound = errors.New("提示词模板不存在")
+
+// initPromptTemplatesTable 创建提示词模板表并加载模板
+func initPromptTemplatesTable() error {
+	query := `CREATE TABLE IF NOT EXISTS prompt_templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		content TEXT NOT NULL,
+		position TEXT NOT NULL DEFAULT 'prepend',
+		models TEXT NOT NULL DEFAULT '[]',
+		client_keys TEXT NOT NULL DEFAULT '[]',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := modelDB.Exec(query); err != nil {
+		return err
+	}
+
+	return loadPromptTemplates()
+}
+
+// loadPromptTemplates 从数据库加载提示词模板到内存
+func loadPromptTemplates() error {
+	rows, err := modelDB.Query(`SELECT id, name, content, position, models, client_keys, enabled, description
+		FROM prompt_templates ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var templates []PromptTemplate
+	for rows.Next() {
+		var template PromptTemplate
+		var modelsJSON, clientKeysJSON string
+		if err := rows.Scan(&template.ID, &template.Name, &template.Content, &template.Position,
+			&modelsJSON, &clientKeysJSON, &template.Enabled, &template.Description); err != nil {
+			logger.Error("扫描提示词模板失败: %v", err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(modelsJSON), &template.Models); err != nil {
+			logger.Error("解析提示词模板 %s 的模型列表失败: %v", template.Name, err)
+		}
+		if err := json.Unmarshal([]byte(clientKeysJSON), &template.ClientKeys); err != nil {
+			logger.Error("解析提示词模板 %s 的客户端密钥列表失败: %v", template.Name, err)
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	promptTemplatesMutex.Lock()
+	promptTemplates = templates
+	promptTemplatesMutex.Unlock()
+	return nil
+}
+
+// GetPromptTemplates 获取所有提示词模板
+func GetPromptTemplates() []PromptTemplate {
+	promptTemplatesMutex.RLock()
+	defer promptTemplatesMutex.RUnlock()
+
+	result := make([]PromptTemplate, len(promptTemplates))
+	copy(result, promptTemplates)
+	return result
+}
+
+// ValidatePromptTemplate 检查提示词模板是否有效
+func ValidatePromptTemplate(template PromptTemplate) error {
+	if strings.TrimSpace(template.Name) == "" {
+		return errors.New("模板名称不能为空")
+	}
+	if strings.TrimSpace(template.Content) == "" {
+		return errors.New("模板内容不能为空")
+	}
+	switch template.Position {
+	case SystemPromptModePrepend, SystemPromptModeAppend:
+	default:
+		return fmt.Errorf("无效的注入位置: %s，只支持prepend和append", template.Position)
+	}
+	return nil
+}
+
+// promptTemplateNameExists 检查模板名称是否已被其他模板使用
+func promptTemplateNameExists(name string, excludeID int64) bool {
+	promptTemplatesMutex.RLock()
+	defer promptTemplatesMutex.RUnlock()
+
+	for _, template := range promptTemplates {
+		if template.Name == name && template.ID != excludeID {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalPromptTemplate 序列化模板绑定的模型和客户端密钥列表
+func marshalPromptTemplate(template PromptTemplate) (string, string, error) {
+	if template.Models == nil {
+		template.Models = []string{}
+	}
+	if template.ClientKeys == nil {
+		template.ClientKeys = []string{}
+	}
+	modelsJSON, err := json.Marshal(template.Models)
+	if err != nil {
+		return "", "", err
+	}
+	clientKeysJSON, err := json.Marshal(template.ClientKeys)
+	if err != nil {
+		return "", "", err
+	}
+	return string(modelsJSON), string(clientKeysJSON), nil
+}
+
+// CreatePromptTemplate 创建提示词模板
+func CreatePromptTemplate(template PromptTemplate) (PromptTemplate, error) {
+	if modelDB == nil {
+		return template, fmt.Errorf("数据库连接未初始化")
+	}
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Position == "" {
+		template.Position = SystemPromptModePrepend
+	}
+	if err := ValidatePromptTemplate(template); err != nil {
+		return template, err
+	}
+	if promptTemplateNameExists(template.Name, 0) {
+		return template, fmt.Errorf("模板名称 %s 已存在", template.Name)
+	}
+
+	modelsJSON, clientKeysJSON, err := marshalPromptTemplate(template)
+	if err != nil {
+		return template, err
+	}
+	result, err := ModelDBExecWithRetry("创建提示词模板", 3,
+		`INSERT INTO prompt_templates (name, content, position, models, client_keys, enabled, description) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		template.Name, template.Content, template.Position, modelsJSON, clientKeysJSON, template.Enabled, template.Description)
+	if err != nil {
+		return template, err
+	}
+	if template.ID, err = result.LastInsertId(); err != nil {
+		return template, err
+	}
+
+	if err := loadPromptTemplates(); err != nil {
+		logger.Error("重新加载提示词模板失败: %v", err)
+	}
+	logger.Info("已创建提示词模板 %s", template.Name)
+	return template, nil
+}
+
+// UpdatePromptTemplate 更新提示词模板
+func UpdatePromptTemplate(template PromptTemplate) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Position == "" {
+		template.Position = SystemPromptModePrepend
+	}
+	if err := ValidatePromptTemplate(template); err != nil {
+		return err
+	}
+	if promptTemplateNameExists(template.Name, template.ID) {
+		return fmt.Errorf("模板名称 %s 已存在", template.Name)
+	}
+
+	modelsJSON, clientKeysJSON, err := marshalPromptTemplate(template)
+	if err != nil {
+		return err
+	}
+	result, err := ModelDBExecWithRetry("更新提示词模板", 3,
+		`UPDATE prompt_templates SET name = ?, content = ?, position = ?, models = ?, client_keys = ?, enabled = ?, description = ?,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		template.Name, template.Content, template.Position, modelsJSON, clientKeysJSON, template.Enabled, template.Description, template.ID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrPromptTemplateNotFound
+	}
+
+	if err := loadPromptTemplates(); err != nil {
+		logger.Error("重新加载提示词模板失败: %v", err)
+	}
+	logger.Info("已更新提示词模板 %s", template.Name)
+	return nil
+}
+
+// DeletePromptTemplate 删除提示词模板
+func DeletePromptTemplate(id int64) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+
+	result, err := ModelDBExecWithRetry("删除提示词模板", 3, `DELETE FROM prompt_templates WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrPromptTemplateNotFound
+	}
+
+	if err := loadPromptTemplates(); err != nil {
+		logger.Error("重新加载提示词模板失败: %v", err)
+	}
+	logger.Info("已删除提示词模板 %d", id)
+	return nil
+}
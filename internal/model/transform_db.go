@@ -0,0 +1,364 @@
+/**
+  @author: Hanhai
+  @desc: 请求体转换规则的存储，按模型声明设置、默认值、范围限制、删除字段和注入系统提示词等操作
+**/
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/logger"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 转换操作类型
+const (
+	TransformOpSet          = "set"            // 设置字段值
+	TransformOpDefault      = "default"        // 字段不存在、为null或类型与默认值不同时设置默认值
+	TransformOpClamp        = "clamp"          // 限制数值字段的范围
+	TransformOpRemove       = "remove"         // 删除字段
+	TransformOpSystemPrompt = "system_prompt"  // 注入系统提示词
+	TransformOpCapMaxTokens = "cap_max_tokens" // 限制max_tokens的上限
+)
+
+// 系统提示词的注入方式
+const (
+	SystemPromptModeDefault = "default" // 没有系统消息时添加
+	SystemPromptModePrepend = "prepend" // 添加到已有系统消息之前
+	SystemPromptModeAppend  = "append"  // 添加到已有系统消息之后
+	SystemPromptModeReplace = "replace" // 替换已有的系统消息
+)
+
+// TransformAction 转换操作
+type TransformAction struct {
+	Op    string      `json:"op"`              // 操作类型
+	Field string      `json:"field,omitempty"` // 字段名，支持用.分隔的嵌套字段
+	Value interface{} `json:"value,omitempty"` // 设置的值；clamp时指定该值表示超出范围时改为该值
+	Min   *float64    `json:"min,omitempty"`   // clamp的下限
+	Max   *float64    `json:"max,omitempty"`   // clamp和cap_max_tokens的上限
+	Mode  string      `json:"mode,omitempty"`  // system_prompt的注入方式
+}
+
+// TransformRule 请求体转换规则
+type TransformRule struct {
+	ID          int64             `json:"id"`          // 规则ID
+	Match       string            `json:"match"`       // 匹配的模型：*匹配所有，以*结尾为前缀匹配，type:N按模型类型匹配，其余为精确匹配
+	Endpoints   []string          `json:"endpoints"`   // 生效的接口，如chat/completions、completions、embeddings，为空表示所有接口
+	Priority    int               `json:"priority"`    // 执行顺序，数值小的先执行
+	Enabled     bool              `json:"enabled"`     // 是否启用
+	Description string            `json:"description"` // 规则说明
+	Actions     []TransformAction `json:"actions"`     // 转换操作
+}
+
+var (
+	// 内存中的转换规则，按优先级排序
+	transformRules []TransformRule
+	// 互斥锁保护转换规则
+	transformRulesMutex sync.RWMutex
+)
+
+// ErrTransformRuleNotFound 转换规则不存在
+var ErrTransformRuleNotFound = errors.New("转换规则不存在")
+
+// defaultTransformRules 首次创建规则表时写入的默认规则，与之前内置的转换行为一致
+func defaultTransformRules() []TransformRule {
+	minTokens := 1000.0
+	return []TransformRule{
+		{
+			Match:       "*",
+			Endpoints:   []string{"chat/completions", "completions"},
+			Priority:    0,
+			Enabled:     true,
+			Description: "未指定模型时使用默认对话模型",
+			Actions:     []TransformAction{{Op: TransformOpDefault, Field: "model", Value: "GLM-4"}},
+		},
+		{
+			Match:       "type:7",
+			Endpoints:   []string{"chat/completions", "completions"},
+			Priority:    100,
+			Enabled:     true,
+			Description: "推理模型默认使用较大的max_tokens、强制流式输出并延长超时时间",
+			Actions: []TransformAction{
+				{Op: TransformOpDefault, Field: "max_tokens", Value: 16000},
+				{Op: TransformOpClamp, Field: "max_tokens", Min: &minTokens, Value: 16000},
+				{Op: TransformOpSet, Field: "stream", Value: true},
+				{Op: TransformOpSet, Field: "timeout", Value: 3600},
+			},
+		},
+		{
+			Match:       "*",
+			Endpoints:   []string{"rerank"},
+			Priority:    0,
+			Enabled:     true,
+			Description: "重排序请求的默认参数",
+			Actions: []TransformAction{
+				{Op: TransformOpDefault, Field: "model", Value: "BAAI/bge-reranker-v2-m3"},
+				{Op: TransformOpDefault, Field: "top_n", Value: 10},
+				{Op: TransformOpDefault, Field: "return_documents", Value: true},
+			},
+		},
+		{
+			Match:       "*",
+			Endpoints:   []string{"images/generations"},
+			Priority:    0,
+			Enabled:     true,
+			Description: "图片生成请求的默认参数，图片生成不支持流式响应",
+			Actions: []TransformAction{
+				{Op: TransformOpDefault, Field: "model", Value: "stabilityai/stable-diffusion-xl-base-1.0"},
+				{Op: TransformOpDefault, Field: "n", Value: 1},
+				{Op: TransformOpDefault, Field: "size", Value: "1024x1024"},
+				{Op: TransformOpDefault, Field: "guidance_scale", Value: 7.5},
+				{Op: TransformOpRemove, Field: "stream"},
+			},
+		},
+		{
+			Match:       "*",
+			Endpoints:   []string{"embeddings"},
+			Priority:    0,
+			Enabled:     true,
+			Description: "未指定模型时使用默认嵌入模型",
+			Actions:     []TransformAction{{Op: TransformOpDefault, Field: "model", Value: "BAAI/bge-m3"}},
+		},
+	}
+}
+
+// initTransformRulesTable 创建转换规则表，首次创建时写入默认规则
+func initTransformRulesTable() error {
+	var tableExists int
+	if err := modelDB.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='model_transforms'").Scan(&tableExists); err != nil {
+		return err
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS model_transforms (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		model_match TEXT NOT NULL,
+		endpoints TEXT NOT NULL DEFAULT '[]',
+		priority INTEGER NOT NULL DEFAULT 100,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT NOT NULL DEFAULT '',
+		actions TEXT NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := modelDB.Exec(query); err != nil {
+		return err
+	}
+
+	if tableExists == 0 {
+		for _, rule := range defaultTransformRules() {
+			if _, err := insertTransformRule(rule); err != nil {
+				return err
+			}
+		}
+		logger.Info("已写入默认的请求转换规则")
+	}
+
+	return loadTransformRules()
+}
+
+// loadTransformRules 从数据库加载转换规则到内存
+func loadTransformRules() error {
+	rows, err := modelDB.Query(`SELECT id, model_match, endpoints, priority, enabled, description, actions
+		FROM model_transforms ORDER BY priority, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []TransformRule
+	for rows.Next() {
+		var rule TransformRule
+		var endpointsJSON, actionsJSON string
+		if err := rows.Scan(&rule.ID, &rule.Match, &endpointsJSON, &rule.Priority, &rule.Enabled,
+			&rule.Description, &actionsJSON); err != nil {
+			logger.Error("扫描转换规则失败: %v", err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(endpointsJSON), &rule.Endpoints); err != nil {
+			logger.Error("解析转换规则 %d 的接口列表失败: %v", rule.ID, err)
+		}
+		if err := json.Unmarshal([]byte(actionsJSON), &rule.Actions); err != nil {
+			logger.Error("解析转换规则 %d 的操作失败: %v", rule.ID, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	transformRulesMutex.Lock()
+	transformRules = rules
+	transformRulesMutex.Unlock()
+	return nil
+}
+
+// GetTransformRules 获取所有转换规则，按执行顺序排列
+func GetTransformRules() []TransformRule {
+	transformRulesMutex.RLock()
+	defer transformRulesMutex.RUnlock()
+
+	result := make([]TransformRule, len(transformRules))
+	copy(result, transformRules)
+	return result
+}
+
+// ValidateTransformRule 检查转换规则是否有效
+func ValidateTransformRule(rule TransformRule) error {
+	if strings.TrimSpace(rule.Match) == "" {
+		return errors.New("匹配的模型不能为空")
+	}
+	if strings.HasPrefix(rule.Match, "type:") {
+		var modelType int
+		if _, err := fmt.Sscanf(rule.Match, "type:%d", &modelType); err != nil || modelType < 1 || modelType > 7 {
+			return errors.New("模型类型必须在1-7之间")
+		}
+	}
+	if len(rule.Actions) == 0 {
+		return errors.New("至少需要一个转换操作")
+	}
+
+	for i, action := range rule.Actions {
+		switch action.Op {
+		case TransformOpSet, TransformOpDefault, TransformOpRemove:
+			if action.Field == "" {
+				return fmt.Errorf("第%d个操作缺少字段名", i+1)
+			}
+		case TransformOpClamp:
+			if action.Field == "" {
+				return fmt.Errorf("第%d个操作缺少字段名", i+1)
+			}
+			if action.Min == nil && action.Max == nil {
+				return fmt.Errorf("第%d个操作需要指定min或max", i+1)
+			}
+			if action.Min != nil && action.Max != nil && *action.Min > *action.Max {
+				return fmt.Errorf("第%d个操作的min不能大于max", i+1)
+			}
+		case TransformOpCapMaxTokens:
+			if action.Max == nil || *action.Max <= 0 {
+				return fmt.Errorf("第%d个操作需要指定大于0的max", i+1)
+			}
+		case TransformOpSystemPrompt:
+			if prompt, ok := action.Value.(string); !ok || prompt == "" {
+				return fmt.Errorf("第%d个操作需要在value中指定系统提示词", i+1)
+			}
+			switch action.Mode {
+			case "", SystemPromptModeDefault, SystemPromptModePrepend, SystemPromptModeAppend, SystemPromptModeReplace:
+			default:
+				return fmt.Errorf("第%d个操作的注入方式无效: %s", i+1, action.Mode)
+			}
+		default:
+			return fmt.Errorf("第%d个操作的类型无效: %s", i+1, action.Op)
+		}
+	}
+	return nil
+}
+
+// insertTransformRule 插入转换规则，返回新规则ID
+func insertTransformRule(rule TransformRule) (int64, error) {
+	endpointsJSON, actionsJSON, err := marshalTransformRule(rule)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := ModelDBExecWithRetry("创建转换规则", 3,
+		`INSERT INTO model_transforms (model_match, endpoints, priority, enabled, description, actions) VALUES (?, ?, ?, ?, ?, ?)`,
+		rule.Match, endpointsJSON, rule.Priority, rule.Enabled, rule.Description, actionsJSON)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// marshalTransformRule 序列化规则的接口列表和操作
+func marshalTransformRule(rule TransformRule) (string, string, error) {
+	if rule.Endpoints == nil {
+		rule.Endpoints = []string{}
+	}
+	endpointsJSON, err := json.Marshal(rule.Endpoints)
+	if err != nil {
+		return "", "", err
+	}
+	actionsJSON, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return "", "", err
+	}
+	return string(endpointsJSON), string(actionsJSON), nil
+}
+
+// CreateTransformRule 创建转换规则
+func CreateTransformRule(rule TransformRule) (TransformRule, error) {
+	if modelDB == nil {
+		return rule, fmt.Errorf("数据库连接未初始化")
+	}
+	if err := ValidateTransformRule(rule); err != nil {
+		return rule, err
+	}
+
+	id, err := insertTransformRule(rule)
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = id
+
+	if err := loadTransformRules(); err != nil {
+		logger.Error("重新加载转换规则失败: %v", err)
+	}
+	logger.Info("已创建转换规则 %d: 模型=%s", id, rule.Match)
+	return rule, nil
+}
+
+// UpdateTransformRule 更新转换规则
+func UpdateTransformRule(rule TransformRule) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+	if err := ValidateTransformRule(rule); err != nil {
+		return err
+	}
+
+	endpointsJSON, actionsJSON, err := marshalTransformRule(rule)
+	if err != nil {
+		return err
+	}
+	result, err := ModelDBExecWithRetry("更新转换规则", 3,
+		`UPDATE model_transforms SET model_match = ?, endpoints = ?, priority = ?, enabled = ?, description = ?, actions = ?,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		rule.Match, endpointsJSON, rule.Priority, rule.Enabled, rule.Description, actionsJSON, rule.ID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTransformRuleNotFound
+	}
+
+	if err := loadTransformRules(); err != nil {
+		logger.Error("重新加载转换规则失败: %v", err)
+	}
+	logger.Info("已更新转换规则 %d: 模型=%s", rule.ID, rule.Match)
+	return nil
+}
+
+// DeleteTransformRule 删除转换规则
+func DeleteTransformRule(id int64) error {
+	if modelDB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+
+	result, err := ModelDBExecWithRetry("删除转换规则", 3, `DELETE FROM model_transforms WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTransformRuleNotFound
+	}
+
+	if err := loadTransformRules(); err != nil {
+		logger.Error("重新加载转换规则失败: %v", err)
+	}
+	logger.Info("已删除转换规则 %d", id)
+	return nil
+}
@@ -46,10 +46,8 @@ func AnalyzeRequest(path string, bodyBytes []byte) (string, string, int) {
 				// 更精确估计：计算消息内容的长度
 				for _, msg := range messages {
 					if msgObj, ok := msg.(map[string]interface{}); ok {
-						if content, ok := msgObj["content"].(string); ok {
-							// 使用更精确的token估算
-							tokenEstimate += utils.EstimateStringTokens(content)
-						}
+						// 内容可以是字符串或包含文本和图片的内容数组
+						tokenEstimate += estimateContentTokens(msgObj["content"])
 					}
 				}
 			}
@@ -126,10 +124,8 @@ func AnalyzeOpenAIRequest(path string, bodyBytes []byte) (string, string, int) {
 				// 估计所有消息的token数量
 				for _, msg := range messages {
 					if msgMap, ok := msg.(map[string]interface{}); ok {
-						if content, ok := msgMap["content"].(string); ok {
-							// 使用更精确的token估算
-							tokenEstimate += utils.EstimateStringTokens(content)
-						}
+						// 内容可以是字符串或包含文本和图片的内容数组
+						tokenEstimate += estimateContentTokens(msgMap["content"])
 					}
 				}
 			}
@@ -0,0 +1,436 @@
+/**
+  @author: Hanhai
+  @desc: 语音接口处理，语音转文字流式转发上传的音频文件，文字转语音流式返回合成的音频，并按音频时长和字符数统计用量
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 语音请求的超时时间，较长的音频转写需要更多时间
+	audioRequestTimeout = 10 * time.Minute
+	// 语音转文字请求的token估计值，与请求分析中音频请求的默认值一致
+	audioTranscriptionTokenEstimate = 5000
+	// 非文件表单字段的最大长度
+	maxAudioFieldSize = 1 << 20
+	// 文字转语音请求体的最大长度
+	maxSpeechBodySize = 1 << 20
+	// 语音转文字响应的最大读取长度
+	maxTranscriptionResponseSize = 16 << 20
+	// 无法识别音频格式时估算时长使用的码率（字节/秒），约为128kbps
+	defaultAudioBytesPerSecond = 16000
+	// 记录的音频文件头长度，用于识别WAV格式
+	audioHeaderSize = 44
+)
+
+// isAudioTranscriptionPath 判断是否为语音转文字或语音翻译请求
+func isAudioTranscriptionPath(path string) bool {
+	return strings.Contains(path, "/audio/transcriptions") || strings.Contains(path, "/audio/translations")
+}
+
+// isAudioSpeechPath 判断是否为文字转语音请求
+func isAudioSpeechPath(path string) bool {
+	return strings.Contains(path, "/audio/speech")
+}
+
+// audioFormField 音频文件之前的表单字段
+type audioFormField struct {
+	header textproto.MIMEHeader
+	value  []byte
+}
+
+// audioUpload 记录转发的音频文件大小和文件头，用于估算音频时长
+type audioUpload struct {
+	size      int64
+	header    []byte
+	lateModel string // 位于音频文件之后的model字段
+}
+
+// Write 统计写入的音频数据
+func (u *audioUpload) Write(p []byte) (int, error) {
+	if remaining := audioHeaderSize - len(u.header); remaining > 0 {
+		if remaining > len(p) {
+			remaining = len(p)
+		}
+		u.header = append(u.header, p[:remaining]...)
+	}
+	u.size += int64(len(p))
+	return len(p), nil
+}
+
+// duration 估算音频时长（秒），WAV格式按文件头中的码率计算，其余格式按默认码率估算
+func (u *audioUpload) duration() float64 {
+	if u.size == 0 {
+		return 0
+	}
+	if len(u.header) >= audioHeaderSize && string(u.header[0:4]) == "RIFF" && string(u.header[8:12]) == "WAVE" {
+		if byteRate := binary.LittleEndian.Uint32(u.header[28:32]); byteRate > 0 {
+			return float64(u.size-audioHeaderSize) / float64(byteRate)
+		}
+	}
+	return float64(u.size) / defaultAudioBytesPerSecond
+}
+
+// copyTo 将表单按原顺序写入转发的请求体，音频文件直接流式复制，不在内存中缓存
+func (u *audioUpload) copyTo(writer *multipart.Writer, fields []audioFormField, filePart *multipart.Part, reader *multipart.Reader) error {
+	for _, field := range fields {
+		partWriter, err := writer.CreatePart(field.header)
+		if err != nil {
+			return err
+		}
+		if _, err := partWriter.Write(field.value); err != nil {
+			return err
+		}
+	}
+
+	part := filePart
+	for part != nil {
+		partWriter, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if part.FileName() != "" {
+			if _, err := io.Copy(io.MultiWriter(partWriter, u), part); err != nil {
+				return err
+			}
+		} else {
+			value, err := readAudioField(part)
+			if err != nil {
+				return err
+			}
+			if part.FormName() == "model" && u.lateModel == "" {
+				u.lateModel = string(value)
+			}
+			if _, err := partWriter.Write(value); err != nil {
+				return err
+			}
+		}
+
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// readAudioField 读取非文件表单字段的值
+func readAudioField(part *multipart.Part) ([]byte, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxAudioFieldSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > maxAudioFieldSize {
+		return nil, fmt.Errorf("表单字段 %s 过长", part.FormName())
+	}
+	return value, nil
+}
+
+// createAudioClient 创建语音请求使用的HTTP客户端，延长等待响应头的时间
+func createAudioClient() *http.Client {
+	client := utils.CreateClientWithTimeout(audioRequestTimeout)
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.ResponseHeaderTimeout = audioRequestTimeout
+	}
+	return client
+}
+
+// writeProxyError 返回OpenAI格式的错误响应
+func writeProxyError(c *gin.Context, statusCode int, errorType string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errorType,
+			"code":    statusCode,
+		},
+	})
+}
+
+// handleAudioTranscription 处理语音转文字和语音翻译请求
+// 音频文件边接收边转发，只读取文件之前的表单字段用于选择密钥；model字段位于文件之后时按未指定模型选择密钥
+// 请求体无法重放，因此不进行重试
+func handleAudioTranscription(c *gin.Context, targetURL string) {
+	mediaType, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "语音转文字请求需要使用multipart/form-data格式上传音频文件")
+		return
+	}
+
+	// 读取音频文件之前的表单字段
+	reader := multipart.NewReader(c.Request.Body, params["boundary"])
+	var fields []audioFormField
+	var filePart *multipart.Part
+	modelName := ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeProxyError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("解析上传内容失败: %v", err))
+			return
+		}
+		if part.FileName() != "" {
+			filePart = part
+			break
+		}
+		value, err := readAudioField(part)
+		if err != nil {
+			writeProxyError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+		if part.FormName() == "model" {
+			modelName = string(value)
+		}
+		fields = append(fields, audioFormField{header: part.Header, value: value})
+	}
+	if filePart == nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "请求中缺少音频文件字段file")
+		return
+	}
+
+	if modelName != "" && isModelDisabled(modelName) {
+		writeProxyError(c, http.StatusForbidden, "invalid_request_error", fmt.Sprintf("模型 %s 已被禁用", modelName))
+		return
+	}
+
+	apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), "audio", modelName, audioTranscriptionTokenEstimate)
+	if err != nil {
+		writeNoKeyError(c, err, "No suitable API keys available")
+		return
+	}
+	defer release()
+
+	// 通过管道边读取客户端上传的内容边发送给上游
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	upload := &audioUpload{}
+	uploadDone := make(chan struct{})
+	go func() {
+		defer close(uploadDone)
+		pipeWriter.CloseWithError(upload.copyTo(writer, fields, filePart, reader))
+	}()
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, targetURL, pipeReader)
+	if err != nil {
+		pipeReader.Close()
+		writeProxyError(c, http.StatusInternalServerError, "server_error", fmt.Sprintf("创建请求失败: %v", err))
+		return
+	}
+	utils.SetCommonHeaders(req, apiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	maskedKey := utils.MaskKey(apiKey)
+	logger.InfoWithKey(maskedKey, "语音转文字请求: %s %s, 模型: %s", c.Request.Method, c.Request.URL.Path, modelName)
+
+	resp, err := createAudioClient().Do(req)
+	if err != nil {
+		pipeReader.CloseWithError(err)
+		<-uploadDone
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyAudioStat(apiKey, modelName, 0, 0, false)
+		logger.Error("语音转文字请求失败: %v", err)
+		writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("发送请求失败: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscriptionResponseSize))
+	// 上游提前返回时停止转发剩余的上传内容
+	pipeReader.Close()
+	<-uploadDone
+	if modelName == "" {
+		modelName = upload.lateModel
+	}
+	if err != nil {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyAudioStat(apiKey, modelName, 0, 0, false)
+		writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("读取响应失败: %v", err))
+		return
+	}
+
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	key.UpdateApiKeyStatus(apiKey, success)
+	config.AddKeyRequestStat(apiKey, 1, 0)
+
+	// 优先使用上游返回的音频时长（verbose_json格式），否则按上传的音频估算
+	seconds := 0.0
+	if success {
+		var result struct {
+			Duration float64 `json:"duration"`
+		}
+		if json.Unmarshal(respBody, &result) == nil && result.Duration > 0 {
+			seconds = result.Duration
+		} else {
+			seconds = upload.duration()
+		}
+		go updateModelCallCount(modelName)
+	} else {
+		logger.Error("语音转文字请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+	}
+	config.AddDailyAudioStat(apiKey, modelName, seconds, 0, success)
+
+	copyResponseHeaders(c, resp.Header)
+	c.Status(resp.StatusCode)
+	c.Writer.Write(respBody)
+}
+
+// upstreamFailure 上游返回的错误响应
+type upstreamFailure struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// handleAudioSpeech 处理文字转语音请求，合成的音频边接收边返回给客户端
+func handleAudioSpeech(c *gin.Context, targetURL string) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSpeechBodySize+1))
+	if err != nil || len(bodyBytes) > maxSpeechBodySize {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "读取请求体失败或请求体过大")
+		return
+	}
+
+	var requestData struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Request body is empty or invalid JSON")
+		return
+	}
+	if requestData.Input == "" {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Input field is required for speech requests")
+		return
+	}
+	modelName := requestData.Model
+	if modelName != "" && isModelDisabled(modelName) {
+		writeProxyError(c, http.StatusForbidden, "invalid_request_error", fmt.Sprintf("模型 %s 已被禁用", modelName))
+		return
+	}
+
+	characters := utf8.RuneCountInString(requestData.Input)
+	tokenEstimate := utils.EstimateStringTokens(requestData.Input)
+	retryConfig := config.GetConfig().ApiProxy.Retry
+
+	var lastFailure *upstreamFailure
+	var lastErr error
+	for attempt := 0; attempt <= retryConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoffDelay(attempt-1, retryConfig))
+			logger.Warn("文字转语音请求第%d次重试: %s, 错误: %v", attempt, targetURL, lastErr)
+		}
+
+		apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), "audio", modelName, tokenEstimate)
+		if err != nil {
+			writeNoKeyError(c, err, "No suitable API keys available")
+			return
+		}
+		failure, err := sendAudioSpeech(c, targetURL, bodyBytes, apiKey, modelName, characters)
+		release()
+		if err == nil {
+			go updateModelCallCount(modelName)
+			return
+		}
+
+		lastFailure, lastErr = failure, err
+		if c.Request.Context().Err() != nil || !shouldRetry(err, retryConfig) {
+			break
+		}
+	}
+
+	if c.Request.Context().Err() != nil {
+		logger.Info("客户端已断开，取消文字转语音请求")
+		return
+	}
+	if lastFailure != nil {
+		copyResponseHeaders(c, lastFailure.header)
+		c.Status(lastFailure.statusCode)
+		c.Writer.Write(lastFailure.body)
+		return
+	}
+	writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("发送请求失败: %v", lastErr))
+}
+
+// sendAudioSpeech 使用指定密钥发送文字转语音请求，成功时将音频流式写回客户端
+// 上游返回错误时不写入响应，返回错误响应内容，由调用方决定重试或返回给客户端
+func sendAudioSpeech(c *gin.Context, targetURL string, body []byte, apiKey string, modelName string, characters int) (*upstreamFailure, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	utils.SetCommonHeaders(req, apiKey)
+
+	maskedKey := utils.MaskKey(apiKey)
+	logger.InfoWithKey(maskedKey, "文字转语音请求: %s %s, 模型: %s, 字符数: %d", c.Request.Method, c.Request.URL.Path, modelName, characters)
+
+	resp, err := createAudioClient().Do(req)
+	if err != nil {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyAudioStat(apiKey, modelName, 0, 0, false)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyAudioStat(apiKey, modelName, 0, 0, false)
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxSpeechBodySize))
+		logger.Error("文字转语音请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+		return &upstreamFailure{statusCode: resp.StatusCode, header: resp.Header, body: respBody},
+			newUpstreamStatusError(fmt.Sprintf("文字转语音请求失败: %s", string(respBody)), resp.StatusCode)
+	}
+
+	key.UpdateApiKeyStatus(apiKey, true)
+	config.AddKeyRequestStat(apiKey, 1, 0)
+	config.AddDailyAudioStat(apiKey, modelName, 0, characters, true)
+
+	// 开始返回音频后不再重试，客户端断开时停止读取上游响应
+	copyResponseHeaders(c, resp.Header)
+	c.Status(resp.StatusCode)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, err := c.Writer.Write(buffer[:n]); err != nil {
+				logger.Info("客户端已断开，停止返回合成的音频: %v", err)
+				return nil, nil
+			}
+			c.Writer.Flush()
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			logger.Error("读取合成的音频失败: %v", readErr)
+			break
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,377 @@
+/**
+  @author: Hanhai
+  @desc: embeddings请求的拆分与合并，输入过多时按上游批量上限拆分后并行发送，并发的小请求可合并为一次上游请求
+**/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 未配置时单次上游请求的最大输入数量
+	defaultEmbeddingBatchSize = 32
+	// 未配置时拆分后同时发送的最大请求数
+	defaultEmbeddingMaxParallel = 4
+	// 未配置时合并请求的等待时间
+	defaultEmbeddingCoalesceWindow = 20 * time.Millisecond
+)
+
+var (
+	// 正在等待合并的embeddings请求，按接口地址、模型和客户端分组
+	embeddingBatches = make(map[string]*embeddingBatch)
+	// 互斥锁保护等待合并的请求
+	embeddingBatchesMutex sync.Mutex
+)
+
+// embeddingResult 一次上游embeddings请求的结果
+type embeddingResult struct {
+	Data         []map[string]interface{} // 按输入顺序排列的向量
+	Model        string                   // 上游返回的模型名称
+	PromptTokens int                      // 输入token数
+	TotalTokens  int                      // 总token数
+}
+
+// embeddingOutcome 合并请求中单个请求的结果
+type embeddingOutcome struct {
+	result  *embeddingResult
+	failure *upstreamFailure
+	err     error
+}
+
+// embeddingWaiter 等待合并发送的请求
+type embeddingWaiter struct {
+	inputs []interface{}
+	done   chan embeddingOutcome
+}
+
+// embeddingBatch 同一分组中等待合并发送的请求
+type embeddingBatch struct {
+	ctx         context.Context // 第一个请求的上下文，不随其断开而取消，用于选择密钥
+	targetURL   string
+	requestType string
+	modelName   string
+	waiters     []*embeddingWaiter
+	inputCount  int
+	timer       *time.Timer
+}
+
+// handleEmbeddingsRequest 处理embeddings请求，输入数量超过上游批量上限时拆分后并行发送，
+// 开启合并时将同一模型的并发小请求合并发送；不需要拆分或合并时返回false，由调用方按普通请求处理
+func handleEmbeddingsRequest(c *gin.Context, targetURL string, transformedBody []byte, requestType string) bool {
+	var requestData struct {
+		Model string      `json:"model"`
+		Input interface{} `json:"input"`
+	}
+	if err := json.Unmarshal(transformedBody, &requestData); err != nil {
+		return false
+	}
+	// 被禁用的模型按普通请求处理并返回错误
+	if isModelDisabled(requestData.Model) {
+		return false
+	}
+
+	// 只处理字符串数组，token数组作为单个输入不拆分
+	inputs, ok := requestData.Input.([]interface{})
+	if !ok || len(inputs) == 0 {
+		return false
+	}
+	if _, isString := inputs[0].(string); !isString {
+		return false
+	}
+
+	cfg := config.GetConfig().Embeddings
+	batchSize, split := embeddingBatchSize(cfg.BatchSize)
+
+	var result *embeddingResult
+	var failure *upstreamFailure
+	var err error
+	switch {
+	case split && len(inputs) > batchSize:
+		result, failure, err = sendSplitEmbeddings(c.Request.Context(), targetURL, requestType, requestData.Model, inputs, batchSize, cfg.MaxParallel)
+	case cfg.Coalesce && len(inputs) < batchSize:
+		result, failure, err = coalesceEmbeddings(c, targetURL, requestType, requestData.Model, inputs, batchSize, cfg.CoalesceWindowMs)
+	default:
+		return false
+	}
+
+	if err != nil {
+		writeUpstreamError(c, failure, err)
+		return true
+	}
+	writeEmbeddingResult(c, result)
+	go updateModelCallCount(requestData.Model)
+	return true
+}
+
+// embeddingBatchSize 获取单次上游请求的最大输入数量以及是否拆分超过上限的请求
+// 未配置时（旧版本的配置中为0）使用默认值，负数表示不拆分，此时合并请求仍以默认值为上限
+func embeddingBatchSize(configured int) (int, bool) {
+	if configured < 0 {
+		return defaultEmbeddingBatchSize, false
+	}
+	if configured == 0 {
+		return defaultEmbeddingBatchSize, true
+	}
+	return configured, true
+}
+
+// embeddingTokenEstimate 估计输入的token数量
+func embeddingTokenEstimate(inputs []interface{}) int {
+	tokens := 0
+	for _, input := range inputs {
+		if str, ok := input.(string); ok {
+			tokens += utils.EstimateStringTokens(str)
+		}
+	}
+	return tokens
+}
+
+// sendEmbeddings 发送一次上游embeddings请求，返回按输入顺序排列的向量
+func sendEmbeddings(ctx context.Context, targetURL string, requestType string, modelName string, inputs []interface{}) (*embeddingResult, *upstreamFailure, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": modelName,
+		"input": inputs,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result embeddingResult
+	_, failure, err := sendUpstreamJSON(ctx, targetURL, body, requestType, modelName, embeddingTokenEstimate(inputs), func(respBody []byte) (int, int, error) {
+		var response struct {
+			Data  []map[string]interface{} `json:"data"`
+			Model string                   `json:"model"`
+			Usage struct {
+				PromptTokens int `json:"prompt_tokens"`
+				TotalTokens  int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(respBody, &response); err != nil || len(response.Data) != len(inputs) {
+			return 0, 0, fmt.Errorf("上游返回的向量数量与输入数量不一致: %d/%d", len(response.Data), len(inputs))
+		}
+
+		// 按上游返回的index排序，保证与输入顺序一致
+		sort.SliceStable(response.Data, func(i, j int) bool {
+			indexI, _ := response.Data[i]["index"].(float64)
+			indexJ, _ := response.Data[j]["index"].(float64)
+			return indexI < indexJ
+		})
+		if response.Usage.TotalTokens == 0 {
+			response.Usage.TotalTokens = response.Usage.PromptTokens
+		}
+
+		result = embeddingResult{
+			Data:         response.Data,
+			Model:        response.Model,
+			PromptTokens: response.Usage.PromptTokens,
+			TotalTokens:  response.Usage.TotalTokens,
+		}
+		return response.Usage.PromptTokens, response.Usage.TotalTokens - response.Usage.PromptTokens, nil
+	})
+	if err != nil {
+		return nil, failure, err
+	}
+	return &result, nil, nil
+}
+
+// sendSplitEmbeddings 将输入按批量上限拆分，并行发送后按原顺序合并结果
+// 任一批次失败时取消其余批次，返回该批次的错误
+func sendSplitEmbeddings(ctx context.Context, targetURL string, requestType string, modelName string, inputs []interface{}, batchSize int, maxParallel int) (*embeddingResult, *upstreamFailure, error) {
+	if maxParallel <= 0 {
+		maxParallel = defaultEmbeddingMaxParallel
+	}
+	batchCount := (len(inputs) + batchSize - 1) / batchSize
+	logger.Info("embeddings请求包含 %d 个输入，拆分为 %d 个请求发送，模型: %s", len(inputs), batchCount, modelName)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*embeddingResult, batchCount)
+	var firstFailure *upstreamFailure
+	var firstErr error
+	var errMutex sync.Mutex
+	semaphore := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i := 0; i < batchCount; i++ {
+		end := (i + 1) * batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		wg.Add(1)
+		go func(i int, batch []interface{}) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			result, failure, err := sendEmbeddings(ctx, targetURL, requestType, modelName, batch)
+			if err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstFailure, firstErr = failure, err
+					cancel()
+				}
+				errMutex.Unlock()
+				return
+			}
+			results[i] = result
+		}(i, inputs[i*batchSize:end])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstFailure, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	merged := &embeddingResult{Data: make([]map[string]interface{}, 0, len(inputs))}
+	for _, result := range results {
+		if merged.Model == "" {
+			merged.Model = result.Model
+		}
+		merged.Data = append(merged.Data, result.Data...)
+		merged.PromptTokens += result.PromptTokens
+		merged.TotalTokens += result.TotalTokens
+	}
+	return merged, nil, nil
+}
+
+// coalesceEmbeddings 将请求加入同一模型和客户端的等待队列，在等待时间结束或输入数量达到批量上限时合并发送
+// 不同客户端的请求不合并，避免绕过按客户端分配密钥的规则
+func coalesceEmbeddings(c *gin.Context, targetURL string, requestType string, modelName string, inputs []interface{}, batchSize int, windowMs int) (*embeddingResult, *upstreamFailure, error) {
+	window := time.Duration(windowMs) * time.Millisecond
+	if window <= 0 {
+		window = defaultEmbeddingCoalesceWindow
+	}
+	groupKey := targetURL + "\x00" + modelName + "\x00" + key.ClientKeyFromContext(c.Request.Context())
+	waiter := &embeddingWaiter{inputs: inputs, done: make(chan embeddingOutcome, 1)}
+
+	embeddingBatchesMutex.Lock()
+	batch := embeddingBatches[groupKey]
+	if batch != nil && batch.inputCount+len(inputs) > batchSize {
+		// 加入后会超过批量上限，先发送已等待的请求
+		delete(embeddingBatches, groupKey)
+		batch.timer.Stop()
+		go batch.flush()
+		batch = nil
+	}
+	if batch == nil {
+		batch = &embeddingBatch{
+			ctx:         context.WithoutCancel(c.Request.Context()),
+			targetURL:   targetURL,
+			requestType: requestType,
+			modelName:   modelName,
+		}
+		embeddingBatches[groupKey] = batch
+		current := batch
+		batch.timer = time.AfterFunc(window, func() {
+			embeddingBatchesMutex.Lock()
+			if embeddingBatches[groupKey] != current {
+				embeddingBatchesMutex.Unlock()
+				return
+			}
+			delete(embeddingBatches, groupKey)
+			embeddingBatchesMutex.Unlock()
+			current.flush()
+		})
+	}
+	batch.waiters = append(batch.waiters, waiter)
+	batch.inputCount += len(inputs)
+	if batch.inputCount >= batchSize {
+		delete(embeddingBatches, groupKey)
+		batch.timer.Stop()
+		go batch.flush()
+	}
+	embeddingBatchesMutex.Unlock()
+
+	select {
+	case outcome := <-waiter.done:
+		return outcome.result, outcome.failure, outcome.err
+	case <-c.Request.Context().Done():
+		return nil, nil, c.Request.Context().Err()
+	}
+}
+
+// flush 合并发送等待中的请求，并将结果按各请求的输入拆分返回
+// token用量按输入数量比例分摊到各请求
+func (b *embeddingBatch) flush() {
+	inputs := make([]interface{}, 0, b.inputCount)
+	for _, waiter := range b.waiters {
+		inputs = append(inputs, waiter.inputs...)
+	}
+	if len(b.waiters) > 1 {
+		logger.Info("合并 %d 个embeddings请求为一次上游请求，共 %d 个输入，模型: %s", len(b.waiters), len(inputs), b.modelName)
+	}
+
+	result, failure, err := sendEmbeddings(b.ctx, b.targetURL, b.requestType, b.modelName, inputs)
+	if err != nil {
+		for _, waiter := range b.waiters {
+			waiter.done <- embeddingOutcome{failure: failure, err: err}
+		}
+		return
+	}
+
+	offset, promptTokensLeft, totalTokensLeft := 0, result.PromptTokens, result.TotalTokens
+	for i, waiter := range b.waiters {
+		count := len(waiter.inputs)
+		promptTokens := result.PromptTokens * count / len(inputs)
+		totalTokens := result.TotalTokens * count / len(inputs)
+		if i == len(b.waiters)-1 {
+			promptTokens, totalTokens = promptTokensLeft, totalTokensLeft
+		}
+		promptTokensLeft -= promptTokens
+		totalTokensLeft -= totalTokens
+
+		waiter.done <- embeddingOutcome{result: &embeddingResult{
+			Data:         result.Data[offset : offset+count],
+			Model:        result.Model,
+			PromptTokens: promptTokens,
+			TotalTokens:  totalTokens,
+		}}
+		offset += count
+	}
+}
+
+// writeEmbeddingResult 以OpenAI格式返回embeddings结果，向量的index按本次请求的输入重新编号
+func writeEmbeddingResult(c *gin.Context, result *embeddingResult) {
+	data := make([]map[string]interface{}, 0, len(result.Data))
+	for i, item := range result.Data {
+		entry := make(map[string]interface{}, len(item))
+		for field, value := range item {
+			entry[field] = value
+		}
+		entry["index"] = i
+		if _, ok := entry["object"]; !ok {
+			entry["object"] = "embedding"
+		}
+		data = append(data, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+		"model":  result.Model,
+		"usage": gin.H{
+			"prompt_tokens": result.PromptTokens,
+			"total_tokens":  result.TotalTokens,
+		},
+	})
+}
@@ -0,0 +1,26 @@
+/**
+  @author: Hanhai
+  @desc: embeddings请求拆分设置的测试
+**/
+
+package proxy
+
+import "testing"
+
+func TestEmbeddingBatchSize(t *testing.T) {
+	tests := []struct {
+		configured int
+		wantSize   int
+		wantSplit  bool
+	}{
+		{0, defaultEmbeddingBatchSize, true}, // 旧版本的配置中没有该项
+		{16, 16, true},
+		{-1, defaultEmbeddingBatchSize, false},
+	}
+	for _, tt := range tests {
+		size, split := embeddingBatchSize(tt.configured)
+		if size != tt.wantSize || split != tt.wantSplit {
+			t.Errorf("embeddingBatchSize(%d) = %d, %v, want %d, %v", tt.configured, size, split, tt.wantSize, tt.wantSplit)
+		}
+	}
+}
@@ -17,3 +17,22 @@ type ApiError struct {
 func (e *ApiError) Error() string {
 	return fmt.Sprintf("%s (code: %d)", e.Message, e.Code)
 }
+
+// UpstreamStatusError 上游返回非成功状态码的错误
+type UpstreamStatusError struct {
+	Message    string
+	StatusCode int
+}
+
+// Error 实现error接口，保留 "status code: N" 格式以便按状态码判断重试
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("%s (status code: %d)", e.Message, e.StatusCode)
+}
+
+// newUpstreamStatusError 创建上游状态码错误
+func newUpstreamStatusError(message string, statusCode int) error {
+	return &UpstreamStatusError{
+		Message:    message,
+		StatusCode: statusCode,
+	}
+}
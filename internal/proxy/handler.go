@@ -10,6 +10,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flowsilicon/internal/common"
 	"flowsilicon/internal/config"
 	"flowsilicon/internal/key"
 	"flowsilicon/internal/logger"
@@ -17,10 +19,11 @@ import (
 	"flowsilicon/pkg/utils"
 	"fmt"
 	"io"
-	"net"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -120,6 +123,49 @@ func isModelDisabled(modelName string) bool {
 	return false
 }
 
+// writeNoKeyError 返回无可用密钥的错误响应，所有密钥并发已满或队列已满时返回503，
+// 所有密钥都处于限流冷却时返回429并通过Retry-After告知剩余冷却时间
+func writeNoKeyError(c *gin.Context, err error, message string) {
+	var cooldownErr *common.CooldownError
+	if errors.As(err, &cooldownErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(cooldownErr.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": gin.H{
+				"message": "所有API密钥都处于限流冷却，请稍后重试",
+				"type":    "rate_limit_error",
+				"code":    "keys_cooling_down",
+			},
+		})
+		return
+	}
+
+	if errors.Is(err, common.ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": gin.H{
+				"message": "请求队列已满，请稍后重试",
+				"type":    "server_busy_error",
+				"code":    "queue_full",
+			},
+		})
+		return
+	}
+
+	if errors.Is(err, common.ErrKeysSaturated) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": gin.H{
+				"message": "所有API密钥并发已满，请稍后重试",
+				"type":    "server_busy_error",
+				"code":    "keys_saturated",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": message,
+	})
+}
+
 // 添加带重试逻辑的API代理处理函数
 func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte, requestType string, modelName string, tokenEstimate int) bool {
 	// 获取配置
@@ -146,20 +192,16 @@ func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte,
 
 	// 进行重试
 	for i := 0; i < retryConfig.MaxRetries; i++ {
-		// 等待重试间隔
-		if i > 0 {
-			time.Sleep(time.Duration(retryConfig.RetryDelayMs) * time.Millisecond)
-		}
+		// 按指数退避等待重试间隔
+		time.Sleep(retryBackoffDelay(i, retryConfig))
 
 		// 记录重试信息
 		logger.Warn("API请求第%d次重试: %s, 错误: %v", i+1, targetURL, err)
 
 		// 获取另一个API密钥进行重试
-		apiKey, err := key.GetBestKeyForRequest(requestType, modelName, tokenEstimate)
+		apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), requestType, modelName, tokenEstimate)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "No suitable API keys available for retry",
-			})
+			writeNoKeyError(c, err, "No suitable API keys available for retry")
 			return false
 		}
 
@@ -170,6 +212,7 @@ func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte,
 		// 创建新的请求
 		req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(bodyBytes))
 		if err != nil {
+			release()
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("Failed to create request for retry: %v", err),
 			})
@@ -196,6 +239,7 @@ func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte,
 		// 发送请求
 		resp, err := client.Do(req)
 		if err != nil {
+			release()
 			// 更新密钥失败记录
 			key.UpdateApiKeyStatus(apiKey, false)
 
@@ -205,11 +249,15 @@ func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte,
 		}
 		defer resp.Body.Close()
 
+		// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+		applyRateLimitCooldown(apiKey, modelName, resp)
+
 		// 记录请求信息
 		logger.InfoWithKey(maskedKey, "API请求重试: %s %s", c.Request.Method, c.Request.URL.Path)
 
 		// 读取响应体
 		respBody, err := io.ReadAll(resp.Body)
+		release()
 		if err != nil {
 			// 更新密钥失败记录
 			key.UpdateApiKeyStatus(apiKey, false)
@@ -236,11 +284,7 @@ func handleApiProxyWithRetry(c *gin.Context, targetURL string, bodyBytes []byte,
 		config.AddDailyRequestStat(apiKey, modelNameForStats, 1, promptTokensCount, completionTokensCount, success)
 
 		// 复制响应 headers
-		for name, values := range resp.Header {
-			for _, value := range values {
-				c.Header(name, value)
-			}
-		}
+		copyResponseHeaders(c, resp.Header)
 
 		// 设置响应状态码
 		c.Status(resp.StatusCode)
@@ -272,13 +316,12 @@ func processApiRequest(c *gin.Context, targetURL string, bodyBytes []byte, reque
 	}
 
 	// 根据请求类型选择最佳的API密钥
-	apiKey, err := key.GetBestKeyForRequest(requestType, modelName, tokenEstimate)
+	apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), requestType, modelName, tokenEstimate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "No suitable API keys available",
-		})
+		writeNoKeyError(c, err, "No suitable API keys available")
 		return false, err
 	}
+	defer release()
 
 	// 创建新的请求
 	req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(bodyBytes))
@@ -316,6 +359,9 @@ func processApiRequest(c *gin.Context, targetURL string, bodyBytes []byte, reque
 	}
 	defer resp.Body.Close()
 
+	// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
 	// 记录请求信息
 	maskedKey := utils.MaskKey(apiKey)
 	logger.InfoWithKey(maskedKey, "API请求: %s %s", c.Request.Method, c.Request.URL.Path)
@@ -339,7 +385,7 @@ func processApiRequest(c *gin.Context, targetURL string, bodyBytes []byte, reque
 	if !success {
 		// 更新密钥失败记录
 		key.UpdateApiKeyStatus(apiKey, false)
-		return false, fmt.Errorf("API请求失败，状态码: %d", resp.StatusCode)
+		return false, newUpstreamStatusError("API请求失败", resp.StatusCode)
 	}
 
 	// 更新密钥状态
@@ -363,11 +409,7 @@ func processApiRequest(c *gin.Context, targetURL string, bodyBytes []byte, reque
 	config.AddDailyRequestStat(apiKey, modelNameForStats, 1, promptTokensCount, completionTokensCount, success)
 
 	// 复制响应 headers
-	for name, values := range resp.Header {
-		for _, value := range values {
-			c.Header(name, value)
-		}
-	}
+	copyResponseHeaders(c, resp.Header)
 
 	// 设置响应状态码
 	c.Status(resp.StatusCode)
@@ -410,25 +452,7 @@ func HandleOpenAIProxy(c *gin.Context) {
 					c.Writer.Header().Set("Content-Type", "text/event-stream")
 					c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
 
-					// 使用background上下文并设置更长的超时
-					ctx := context.Background()
-					// 创建一个新的上下文，超时时间为180分钟(3小时)
-					ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
-					defer cancel()
-					c.Request = c.Request.WithContext(ctx)
-
-					// 设置更长的读写超时
-					if h, ok := c.Writer.(http.Hijacker); ok {
-						conn, _, err := h.Hijack()
-						if err == nil {
-							if tc, ok := conn.(*net.TCPConn); ok {
-								tc.SetKeepAlive(true)
-								tc.SetKeepAlivePeriod(30 * time.Second)
-								tc.SetReadBuffer(65536)  // 64KB
-								tc.SetWriteBuffer(65536) // 64KB
-							}
-						}
-					}
+					// 超时由handleOpenAIStreamRequest基于请求上下文设置，客户端断开时上游请求随之取消
 				}
 			}
 
@@ -475,6 +499,36 @@ func HandleOpenAIProxy(c *gin.Context) {
 		logger.Info("检测到标准版本号路径请求: %s，转发到: %s", "/v1"+path, targetURL)
 	}
 
+	// 语音接口使用二进制上传和响应，单独处理
+	if isAudioTranscriptionPath(fullPath) {
+		handleAudioTranscription(c, targetURL)
+		return
+	}
+	if isAudioSpeechPath(fullPath) {
+		handleAudioSpeech(c, targetURL)
+		return
+	}
+
+	// 视频生成任务需要记录提交任务的密钥，查询状态时使用同一密钥
+	if isVideoFilePath(fullPath) {
+		handleVideoFile(c)
+		return
+	}
+	if isVideoSubmitPath(fullPath) {
+		handleVideoSubmit(c, targetURL)
+		return
+	}
+	if isVideoStatusPath(fullPath) {
+		handleVideoStatus(c, targetURL)
+		return
+	}
+
+	// 重排序请求兼容Cohere和Jina格式，Cohere格式的 /v2/rerank 同样转发到 /v1/rerank
+	if isRerankPath(fullPath) {
+		handleRerank(c, fmt.Sprintf("%s/v1/rerank", baseURL))
+		return
+	}
+
 	// 如果是 /models 请求，使用特殊处理
 	if strings.HasSuffix(fullPath, "/models") {
 		logger.Info("检测到模型列表请求: %s", fullPath)
@@ -599,35 +653,6 @@ func HandleOpenAIProxy(c *gin.Context) {
 		}
 	}
 
-	// 检查rerank请求中是否缺少必要字段
-	if strings.Contains(fullPath, "/rerank") {
-		var requestData map[string]interface{}
-		if err := json.Unmarshal(bodyBytes, &requestData); err == nil {
-			// 检查是否存在query字段
-			if _, hasQuery := requestData["query"]; !hasQuery {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": map[string]interface{}{
-						"message": "Query field is required for rerank requests",
-						"type":    "invalid_request_error",
-						"code":    400,
-					},
-				})
-				return
-			}
-			// 检查是否存在documents字段
-			if _, hasDocuments := requestData["documents"]; !hasDocuments {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": map[string]interface{}{
-						"message": "Documents field is required for rerank requests",
-						"type":    "invalid_request_error",
-						"code":    400,
-					},
-				})
-				return
-			}
-		}
-	}
-
 	// 检查images/generations请求中是否缺少必要字段
 	if strings.Contains(fullPath, "/images/generations") {
 		var requestData map[string]interface{}
@@ -656,8 +681,13 @@ func HandleOpenAIProxy(c *gin.Context) {
 	}
 	requestType, modelName, tokenEstimate := AnalyzeOpenAIRequest(requestPath, bodyBytes)
 
+	// 确定推理内容的返回方式，流式和非流式响应按同一方式处理
+	if requestEndpoint(requestPath) == "chat/completions" {
+		resolveReasoningMode(c, bodyBytes)
+	}
+
 	// 转换请求体为硅基流动格式
-	transformedBody, err := TransformRequestBody(bodyBytes, requestPath)
+	transformedBody, err := TransformRequestBody(bodyBytes, requestPath, key.ClientKeyFromContext(c.Request.Context()))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("Failed to transform request body: %v", err),
@@ -665,6 +695,32 @@ func HandleOpenAIProxy(c *gin.Context) {
 		return
 	}
 
+	// 检查对话请求中的图片，按设置将远程图片转为data URL
+	if requestEndpoint(requestPath) == "chat/completions" {
+		var ok bool
+		if transformedBody, ok = prepareVisionInput(c, transformedBody); !ok {
+			return
+		}
+	}
+
+	// 输入过多的embeddings请求拆分发送，开启合并时合并并发的小请求
+	if requestEndpoint(requestPath) == "embeddings" {
+		if handleEmbeddingsRequest(c, targetURL, transformedBody, requestType) {
+			return
+		}
+	}
+
+	// 开启了工具调用模拟的模型，由本服务将工具定义注入提示词并解析工具调用
+	if requestEndpoint(requestPath) == "chat/completions" {
+		if handleToolEmulation(c, targetURL, bodyBytes, transformedBody, requestType, tokenEstimate) {
+			return
+		}
+		// 指定了json_schema响应格式的请求，校验响应内容并在不符合时要求模型修正
+		if handleStructuredOutput(c, targetURL, transformedBody, requestType, tokenEstimate) {
+			return
+		}
+	}
+
 	// 调用带重试逻辑的函数处理OpenAI格式请求
 	success := processOpenAIRequestWithRetry(c, targetURL, transformedBody, bodyBytes, requestType, modelName, tokenEstimate, requestPath)
 
@@ -724,20 +780,16 @@ func processOpenAIRequestWithRetry(c *gin.Context, targetURL string, transformed
 
 	// 进行重试
 	for i := 0; i < retryConfig.MaxRetries; i++ {
-		// 等待重试间隔
-		if i > 0 {
-			time.Sleep(time.Duration(retryConfig.RetryDelayMs) * time.Millisecond)
-		}
+		// 按指数退避等待重试间隔
+		time.Sleep(retryBackoffDelay(i, retryConfig))
 
 		// 记录重试信息
 		logger.Warn("OpenAI格式API请求第%d次重试: %s, 错误: %v", i+1, targetURL, err)
 
 		// 获取另一个API密钥进行重试
-		apiKey, err := key.GetBestKeyForRequest(requestType, modelName, tokenEstimate)
+		apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), requestType, modelName, tokenEstimate)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "No suitable API keys available for retry",
-			})
+			writeNoKeyError(c, err, "No suitable API keys available for retry")
 			return false
 		}
 
@@ -748,6 +800,7 @@ func processOpenAIRequestWithRetry(c *gin.Context, targetURL string, transformed
 		// 创建新的请求
 		req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(transformedBody))
 		if err != nil {
+			release()
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("Failed to create request for retry: %v", err),
 			})
@@ -774,6 +827,7 @@ func processOpenAIRequestWithRetry(c *gin.Context, targetURL string, transformed
 		// 发送请求
 		resp, err := client.Do(req)
 		if err != nil {
+			release()
 			// 区分连接错误和其他错误类型
 			if strings.Contains(err.Error(), "context deadline exceeded") ||
 				strings.Contains(err.Error(), "timeout") {
@@ -801,11 +855,15 @@ func processOpenAIRequestWithRetry(c *gin.Context, targetURL string, transformed
 		}
 		defer resp.Body.Close()
 
+		// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+		applyRateLimitCooldown(apiKey, modelName, resp)
+
 		// 记录请求信息
 		logger.InfoWithKey(maskedKey, "OpenAI格式API请求重试: %s %s", c.Request.Method, c.Request.URL.Path)
 
 		// 读取响应体
 		respBody, err := io.ReadAll(resp.Body)
+		release()
 		if err != nil {
 			// 更新密钥失败记录
 			key.UpdateApiKeyStatus(apiKey, false)
@@ -858,19 +916,40 @@ func processOpenAIRequestWithRetry(c *gin.Context, targetURL string, transformed
 
 // shouldRetry 判断是否需要重试
 func shouldRetry(err error, retryConfig config.RetryConfig) bool {
+	if err == nil {
+		return false
+	}
+
+	// 密钥满载或队列已满时已经排过队，所有密钥冷却时重试也无法获取，不再重试
+	if errors.Is(err, common.ErrKeysSaturated) || errors.Is(err, common.ErrQueueFull) || errors.Is(err, common.ErrKeysCoolingDown) {
+		return false
+	}
+
+	// 上游返回了非成功状态码，按状态码判断是否需要重试
+	var statusErr *UpstreamStatusError
+	if errors.As(err, &statusErr) {
+		// 被限流的密钥已进入冷却，换用其他密钥重试
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			return true
+		}
+		for _, code := range retryConfig.RetryOnStatusCodes {
+			if statusErr.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+
 	// 如果是网络错误且配置允许重试网络错误
-	if err != nil && retryConfig.RetryOnNetworkErrors {
+	if retryConfig.RetryOnNetworkErrors {
 		return true
 	}
 
-	// 如果是HTTP响应错误，检查状态码是否需要重试
-	if err != nil {
-		// 尝试从错误信息中提取状态码
-		if strings.Contains(err.Error(), "status code:") {
-			for _, code := range retryConfig.RetryOnStatusCodes {
-				if strings.Contains(err.Error(), fmt.Sprintf("status code: %d", code)) {
-					return true
-				}
+	// 尝试从错误信息中提取状态码
+	if strings.Contains(err.Error(), "status code:") {
+		for _, code := range retryConfig.RetryOnStatusCodes {
+			if strings.Contains(err.Error(), fmt.Sprintf("status code: %d", code)) {
+				return true
 			}
 		}
 	}
@@ -920,13 +999,13 @@ func handleOpenAIStreamRequest(c *gin.Context, targetURL string, transformedBody
 	}
 
 	// 根据请求类型选择最佳的API密钥
-	apiKey, err := key.GetBestKeyForRequest(requestType, modelName, tokenEstimate)
+	apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), requestType, modelName, tokenEstimate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "No suitable API keys available",
-		})
+		writeNoKeyError(c, err, "No suitable API keys available")
 		return
 	}
+	// 流式响应结束前一直占用该密钥的并发槽位
+	defer release()
 
 	// 检查是否是推理模型（类型为7）
 	isReasonModelType := false
@@ -949,8 +1028,8 @@ func handleOpenAIStreamRequest(c *gin.Context, targetURL string, transformedBody
 		logger.Info("为普通模型设置10分钟的请求超时")
 	}
 
-	// 创建带超时的上下文
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	// 基于客户端请求创建带超时的上下文，客户端断开时上游请求随之取消
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
 	defer cancel() // 确保函数结束时取消上下文
 
 	// 创建新的请求，使用我们的超时上下文
@@ -1002,17 +1081,8 @@ func handleOpenAIStreamRequest(c *gin.Context, targetURL string, transformedBody
 	logger.Info("跳过重复的响应头设置")
 	// utils.SetStreamResponseHeaders(c.Writer)
 
-	// 监听客户端连接关闭
-	clientCtx, clientCancel := context.WithCancel(ctx)
-	go func() {
-		<-c.Request.Context().Done()
-		logger.Info("检测到客户端已断开连接，取消流式请求")
-		clientCancel() // 取消请求
-	}()
-	defer clientCancel()
-
-	// 发送请求，使用上下文控制超时
-	resp, err := client.Do(req.WithContext(clientCtx))
+	// 发送请求，使用上下文控制超时和客户端断开
+	resp, err := client.Do(req)
 	if err != nil {
 		// 区分连接错误和其他错误类型
 		if strings.Contains(err.Error(), "context deadline exceeded") ||
@@ -1040,6 +1110,9 @@ func handleOpenAIStreamRequest(c *gin.Context, targetURL string, transformedBody
 		return
 	}
 
+	// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
 	// 检查状态码
 	if resp.StatusCode != http.StatusOK {
 		// 更新密钥失败记录
@@ -1117,8 +1190,8 @@ func handleOpenAIStreamRequest(c *gin.Context, targetURL string, transformedBody
 	// 记录成功启动流式响应
 	logger.Info("成功启动流式响应，正在处理响应流...")
 
-	// 处理流式响应，传递与当前请求相同的超时上下文
-	HandleStreamResponse(c, resp.Body, apiKey, originalBody)
+	// 处理流式响应，客户端断开时关闭响应体停止上游生成，请求估算的token作为未返回usage时的prompt用量
+	HandleStreamResponse(c, resp.Body, apiKey, originalBody, tokenEstimate)
 }
 
 // 处理非流式OpenAI请求，返回是否成功处理和可能的错误
@@ -1144,13 +1217,12 @@ func processOpenAIRequest(c *gin.Context, targetURL string, transformedBody []by
 	}
 
 	// 根据请求类型选择最佳的API密钥
-	apiKey, err := key.GetBestKeyForRequest(requestType, modelName, tokenEstimate)
+	apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), requestType, modelName, tokenEstimate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "No suitable API keys available",
-		})
+		writeNoKeyError(c, err, "No suitable API keys available")
 		return false, err
 	}
+	defer release()
 
 	// 创建新的请求
 	req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(transformedBody))
@@ -1188,6 +1260,9 @@ func processOpenAIRequest(c *gin.Context, targetURL string, transformedBody []by
 	}
 	defer resp.Body.Close()
 
+	// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
 	// 记录请求信息
 	maskedKey := utils.MaskKey(apiKey)
 	logger.InfoWithKey(maskedKey, "OpenAI格式API请求: %s %s", c.Request.Method, c.Request.URL.Path)
@@ -1260,7 +1335,7 @@ func processOpenAIRequest(c *gin.Context, targetURL string, transformedBody []by
 			},
 		})
 
-		return false, fmt.Errorf("OpenAI格式API请求失败: %s", errorMessage)
+		return false, newUpstreamStatusError(fmt.Sprintf("OpenAI格式API请求失败: %s", errorMessage), resp.StatusCode)
 	}
 
 	// 更新密钥状态
@@ -1290,6 +1365,16 @@ func processOpenAIRequest(c *gin.Context, targetURL string, transformedBody []by
 		return false, err
 	}
 
+	// 归档生成的图片，或按请求返回base64编码的图片
+	if requestEndpoint(path) == "images/generations" {
+		openAIResponse = archiveGeneratedImages(c, openAIResponse, transformedBody, originalBody, apiKey)
+	}
+
+	// 按请求的方式返回推理内容
+	if requestEndpoint(path) == "chat/completions" {
+		openAIResponse = applyReasoningModeToBody(openAIResponse, reasoningModeFromContext(c))
+	}
+
 	// 返回转换后的响应
 	c.Header("Content-Type", "application/json")
 	c.Status(resp.StatusCode)
@@ -1369,11 +1454,7 @@ func HandleModelsRequest(c *gin.Context, apiKey string) {
 	}
 
 	// 设置响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
-		}
-	}
+	copyResponseHeaders(c, resp.Header)
 
 	// 过滤掉被禁用的模型
 	var modelsResponse map[string]interface{}
@@ -1414,11 +1495,12 @@ func HandleModelsRequest(c *gin.Context, apiKey string) {
 }
 
 // 处理流式响应
-func HandleStreamResponse(c *gin.Context, responseBody io.ReadCloser, apiKey string, requestBody []byte) {
+// 上游长时间没有数据时定期发送SSE注释保持连接；客户端断开后立即关闭上游响应停止生成，
+// 并按已收到的内容记录本次请求实际消耗的token
+func HandleStreamResponse(c *gin.Context, responseBody io.ReadCloser, apiKey string, requestBody []byte, promptEstimate int) {
 	logger.Info("开始处理流式响应")
-
-	// 创建缓冲读取器，增加缓冲区大小以处理大型响应
-	reader := bufio.NewReaderSize(responseBody, 65536) // 增加到64KB的缓冲区
+	// 关闭响应体会断开与上游的连接，客户端提前断开时上游随之停止生成，不再继续消耗token
+	defer responseBody.Close()
 
 	// 创建刷新写入器，确保数据立即发送
 	flusher, ok := c.Writer.(http.Flusher)
@@ -1430,584 +1512,162 @@ func HandleStreamResponse(c *gin.Context, responseBody io.ReadCloser, apiKey str
 		return
 	}
 
-	// 创建带超时的上下文，而不是使用无限期的background上下文
-	// 检查请求体中是否包含Deepseek R1模型
-	var isDeepseekR1 bool
+	// 从请求体中提取模型名称用于统计
+	modelNameForStats := "unknown"
 	var requestData map[string]interface{}
 	if err := json.Unmarshal(requestBody, &requestData); err == nil {
-		if model, ok := requestData["model"].(string); ok {
-			if strings.Contains(strings.ToLower(model), "deepseek") && strings.Contains(model, "r1") {
-				isDeepseekR1 = true
-				logger.Info("检测到Deepseek R1模型请求，启用特殊处理模式")
-			}
+		if model, ok := requestData["model"].(string); ok && model != "" {
+			modelNameForStats = model
 		}
 	}
 
-	// 设置合理的超时时间，根据模型类型调整
-	var streamTimeout time.Duration
-	if isDeepseekR1 {
-		streamTimeout = 60 * time.Minute // Deepseek R1 模型设置60分钟超时
-		logger.Info("为Deepseek R1流式响应设置60分钟超时")
-	} else {
-		streamTimeout = 10 * time.Minute // 普通模型设置10分钟超时
-		logger.Info("为普通模型流式响应设置10分钟超时")
-	}
-
-	// 使用带超时的上下文，确保有明确的超时控制
-	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
-	defer cancel()
-
-	// 对于R1模型，立即发送一个初始响应，保持连接活跃
-	if isDeepseekR1 {
-		initialComment := ": 已连接到Deepseek R1服务，正在生成回答，请稍候...\n\n"
-		c.Writer.Write([]byte(initialComment))
+	// 推理内容按请求的方式返回，think方式需要跨事件记录标签状态
+	reasoningState := newReasoningStreamState(reasoningModeFromContext(c))
+	usage := &streamUsage{}
+
+	// 立即发送响应头，客户端不必等到第一个事件才确认连接已建立
+	c.Writer.WriteHeaderNow()
+	flusher.Flush()
+
+	// 在单独的协程中读取上游，等待上游数据时仍能发送保活注释和响应客户端断开
+	lines := make(chan streamLine)
+	stop := make(chan struct{})
+	defer close(stop)
+	go readStreamLines(bufio.NewReaderSize(responseBody, 65536), lines, stop)
+
+	// 每次写出数据后重新计时，只有持续没有数据时才发送保活注释
+	keepaliveInterval := streamKeepaliveInterval()
+	var keepaliveTimer *time.Timer
+	var keepaliveC <-chan time.Time
+	if keepaliveInterval > 0 {
+		keepaliveTimer = time.NewTimer(keepaliveInterval)
+		defer keepaliveTimer.Stop()
+		keepaliveC = keepaliveTimer.C
+	}
+
+	clientGone := false
+	// write 写出数据并立即刷新，写入失败说明客户端已断开
+	write := func(data []byte) bool {
+		if _, err := c.Writer.Write(data); err != nil {
+			logger.Info("向客户端写入流式数据失败: %v", err)
+			clientGone = true
+			return false
+		}
 		flusher.Flush()
+		if keepaliveTimer != nil {
+			keepaliveTimer.Reset(keepaliveInterval)
+		}
+		return true
 	}
 
-	// 初始化计数器
-	var totalTokens int
-	var eventCount int
-	var lastProgressTime = time.Now() // 上次进度更新时间
-
-	// 心跳间隔 - 对Deepseek R1更频繁
-	var heartbeatInterval time.Duration = 10 * time.Second // 从5秒改为10秒
-	if isDeepseekR1 {
-		heartbeatInterval = 3 * time.Second // 从500毫秒改为3秒
-	}
-
-	// 异常处理通道
-	errorChan := make(chan error, 1)
-	doneChan := make(chan bool, 1)
-
-	// 创建缓冲区用于批量发送
-	var buffer bytes.Buffer
-	// 对于Deepseek R1，降低缓冲区阈值，确保更频繁发送数据
-	bufferThreshold := 1024 // 1KB
-	if isDeepseekR1 {
-		bufferThreshold = 256 // 从64字节改为256字节，减少过于频繁的发送
-	}
-
-	// 上次刷新时间
-	lastFlushTime := time.Now()
-	// 最大刷新间隔 (毫秒)，对Deepseek R1使用更短的间隔
-	maxFlushInterval := 500 * time.Millisecond // 从200毫秒改为500毫秒
-	if isDeepseekR1 {
-		maxFlushInterval = 200 * time.Millisecond // 从30毫秒改为200毫秒
-	}
-
-	// 进度报告间隔
-	progressInterval := 10 * time.Second // 从5秒改为10秒
-
-	// 连接已断开标志
-	var connectionClosed atomic.Bool
-
-	// 监听客户端连接关闭
-	go func() {
-		<-c.Request.Context().Done()
-		connectionClosed.Store(true)
-		cancel() // 取消我们的上下文
-		logger.Info("检测到客户端连接已关闭")
-	}()
-
-	// 监听我们自己的上下文超时
-	go func() {
-		<-ctx.Done()
-		if ctx.Err() == context.DeadlineExceeded {
-			logger.Warn("流式响应处理超时（%v）：已达到最大处理时间限制", streamTimeout)
-			if !connectionClosed.Load() {
-				// 向客户端发送超时通知
-				timeoutMsg := "data: {\"error\":{\"message\":\"处理超时，已达到最大响应时间限制\",\"type\":\"timeout_error\",\"code\":\"context_deadline_exceeded\"}}\n\n"
-				c.Writer.Write([]byte(timeoutMsg))
-				flusher.Flush()
+	var eventCount, keepaliveCount int
+	var streamErr error
+	completed := false
+loop:
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			clientGone = true
+			break loop
+		case <-keepaliveC:
+			keepaliveCount++
+			if !write([]byte(": keepalive\n\n")) {
+				break loop
 			}
-		}
-	}()
-
-	// 启动心跳协程，定期发送注释保持连接活跃
-	go func() {
-		heartbeatTicker := time.NewTicker(heartbeatInterval)
-		defer heartbeatTicker.Stop()
-
-		heartbeatCount := 0
-		dataSentCount := 0 // 跟踪发送的数据心跳数量
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-heartbeatTicker.C:
-				if connectionClosed.Load() {
-					return
-				}
-
-				heartbeatCount++
-
-				// 对于Deepseek R1，每隔几次心跳发送一个额外的数据包
-				if isDeepseekR1 && heartbeatCount%3 == 0 {
-					dataSentCount++
-					keepaliveData := []byte(fmt.Sprintf("data: {\"id\":\"chatcmpl-hb%d\",\"object\":\"chat.completion.chunk\",\"created\":%d,\"model\":\"deepseek-r1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"\"},\"finish_reason\":null}]}\n\n",
-						dataSentCount, time.Now().Unix()))
-					_, err := c.Writer.Write(keepaliveData)
-					if err != nil {
-						if !connectionClosed.Load() {
-							logger.Error("数据包心跳发送失败: %v", err)
-						}
-					} else {
-						flusher.Flush()
-					}
-				}
-
-				// 发送心跳注释（作为SSE注释，客户端会忽略）
-				heartbeatMsg := fmt.Sprintf(": heartbeat-%d\n\n", heartbeatCount)
-				_, err := c.Writer.Write([]byte(heartbeatMsg))
-				if err != nil {
-					if !connectionClosed.Load() {
-						logger.Error("心跳发送失败: %v", err)
-						errorChan <- fmt.Errorf("心跳发送失败: %v", err)
-					}
-					return
-				}
-				flusher.Flush()
-
-				// 仅在Debug级别记录心跳
-				if heartbeatCount%5 == 0 {
-					logger.Info("已发送%d次心跳以保持连接活跃, 数据包心跳: %d", heartbeatCount, dataSentCount)
-				}
+			if keepaliveCount%10 == 0 {
+				logger.Info("上游已较长时间没有数据，已发送%d次保活注释", keepaliveCount)
 			}
-		}
-	}()
-
-	// 读取并处理每一行SSE事件
-	go func() {
-		defer func() {
-			doneChan <- true
-			close(doneChan)
-		}()
-
-		readTimeoutChan := make(chan error, 1)
-
-		for {
-			// 首先检查上下文是否已取消
-			select {
-			case <-ctx.Done():
-				if ctx.Err() == context.DeadlineExceeded {
-					errorChan <- fmt.Errorf("流式响应处理超时: %v", ctx.Err())
+		case line := <-lines:
+			if line.err != nil {
+				if line.err == io.EOF {
+					completed = true
 				} else {
-					errorChan <- ctx.Err()
+					streamErr = line.err
 				}
-				return
-			default:
-				// 继续处理
+				break loop
 			}
 
-			if connectionClosed.Load() {
-				return
-			}
-
-			// 定期报告进度，避免客户端认为连接已断开
-			if time.Since(lastProgressTime) > progressInterval {
-				logger.Info("流式响应处理中，已处理 %d 个事件，约 %d tokens", eventCount, totalTokens)
-				lastProgressTime = time.Now()
+			trimmed := bytes.TrimSpace(line.data)
+			if len(trimmed) == 0 {
+				// 空行不处理，每个事件写出时会补上事件分隔
+				continue
 			}
-
-			// 使用带超时的上下文创建一个读取操作
-			readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
-
-			// 使用goroutine包装读取操作
-			go func() {
-				line, err := reader.ReadBytes('\n')
-				if err != nil {
-					readTimeoutChan <- err
-					return
-				}
-
-				// 处理接收到的行
-				if len(bytes.TrimSpace(line)) == 0 {
-					// 空行不处理
-					readTimeoutChan <- nil
-					return
-				}
-
-				// 处理SSE事件行
-				if bytes.HasPrefix(line, []byte("data: ")) {
-					eventCount++
-
-					// 解析事件数据
-					data := bytes.TrimPrefix(line, []byte("data: "))
-
-					// 检查是否是[DONE]事件
-					if bytes.Equal(bytes.TrimSpace(data), []byte("[DONE]")) {
-						// 发送[DONE]事件
-						buffer.WriteString("data: [DONE]\n\n")
-						if !connectionClosed.Load() {
-							_, err := c.Writer.Write(buffer.Bytes())
-							if err == nil {
-								buffer.Reset()
-								flusher.Flush()
-							}
-						}
-						readTimeoutChan <- io.EOF // 使用EOF表示正常结束
-						return
-					}
-
-					// 转换事件数据，确保与OpenAI API格式兼容
-					transformedData, err := TransformStreamEvent(bytes.TrimSpace(data))
-					if err != nil {
-						logger.Error("转换流式事件失败: %v", err)
-						// 使用原始数据
-						transformedData = bytes.TrimSpace(data)
-					}
-
-					// 更新token估算
-					var jsonData map[string]interface{}
-					if err := json.Unmarshal(transformedData, &jsonData); err == nil {
-						// 首先尝试从usage中获取total_tokens
-						if usage, ok := jsonData["usage"].(map[string]interface{}); ok {
-							if tt, ok := usage["total_tokens"].(float64); ok {
-								// 更新总tokens数量为API返回的值
-								if eventCount <= 3 || eventCount%50 == 0 {
-									logger.Info("事件#%d: 从API返回的usage中读取total_tokens=%d", eventCount, int(tt))
-								}
-								// 使用API返回的token数量
-								totalTokens = int(tt)
-								// 继续处理，但不再进行token估算
-							}
-						} else if choices, ok := jsonData["choices"].([]interface{}); ok && len(choices) > 0 {
-							// 如果没有usage字段，继续使用原来的估算方法
-							if choice, ok := choices[0].(map[string]interface{}); ok {
-								if delta, ok := choice["delta"].(map[string]interface{}); ok {
-									if content, ok := delta["content"].(string); ok {
-										// 简单估算：每个字符约为0.25个token
-										tokenEstimate := int(float64(len(content)) * 0.2)
-										if tokenEstimate == 0 && len(content) > 0 {
-											tokenEstimate = 1 // 确保至少有1个token
-										}
-										totalTokens += tokenEstimate
-
-										// 每100个事件记录一次token统计情况
-										if eventCount%100 == 0 || eventCount <= 3 {
-											logger.Info("事件#%d: 内容长度=%d字符, 估计tokens=%d, 累计tokens=%d",
-												eventCount, len(content), tokenEstimate, totalTokens)
-										}
-									} else {
-										// 如果无法提取content但delta不为空，尝试其他方式估算
-										deltaJSON, _ := json.Marshal(delta)
-										deltaStr := string(deltaJSON)
-										if len(deltaStr) > 0 {
-											// 记录无法直接提取content的情况
-											if eventCount <= 10 || eventCount%100 == 0 {
-												logger.Info("事件#%d: 无法提取content，delta=%s", eventCount, deltaStr)
-											}
-
-											// 仍然尝试估算token
-											tokenEstimate := int(float64(len(deltaStr)) * 0.1) // 对JSON格式的内容降低估算比例
-											if tokenEstimate == 0 && len(deltaStr) > 0 {
-												tokenEstimate = 1
-											}
-											totalTokens += tokenEstimate
-										}
-									}
-								} else {
-									// 如果无法提取delta但choice不为空，记录问题
-									if eventCount <= 10 || eventCount%100 == 0 {
-										choiceJSON, _ := json.Marshal(choice)
-										logger.Info("事件#%d: 无法提取delta，choice=%s", eventCount, string(choiceJSON))
-									}
-
-									// 确保每个事件至少计算一些token
-									if eventCount%5 == 0 { // 每5个事件增加1个token（保守估计）
-										totalTokens += 1
-									}
-								}
-							} else {
-								// 如果无法正确解析choice，记录问题
-								if eventCount <= 10 || eventCount%100 == 0 {
-									if len(choices) > 0 {
-										choiceData, _ := json.Marshal(choices[0])
-										logger.Info("事件#%d: choice格式异常，原始数据=%s", eventCount, string(choiceData))
-									}
-								}
-
-								// 确保计数不为零
-								if eventCount%5 == 0 {
-									totalTokens += 1
-								}
-							}
-						} else {
-							// 如果无法提取choices，尝试直接从原始数据估算
-							jsonString := string(transformedData)
-							// 对Deepseek R1特殊处理
-							if isDeepseekR1 && strings.Contains(jsonString, "\"choices\"") {
-								// 从原始JSON字符串中查找content
-								contentIndex := strings.Index(jsonString, "\"content\":")
-								if contentIndex > 0 {
-									// 粗略提取content内容
-									contentStart := contentIndex + 11 // "content":"
-									contentEnd := strings.Index(jsonString[contentStart:], "\"")
-									if contentEnd > 0 {
-										content := jsonString[contentStart : contentStart+contentEnd]
-										if len(content) > 0 {
-											tokenEstimate := int(float64(len(content)) * 0.25)
-											if tokenEstimate == 0 {
-												tokenEstimate = 1
-											}
-											totalTokens += tokenEstimate
-
-											if eventCount%50 == 0 || eventCount <= 3 {
-												logger.Info("事件#%d(字符串解析): 内容长度=%d字符, 估计tokens=%d, 累计tokens=%d",
-													eventCount, len(content), tokenEstimate, totalTokens)
-											}
-										}
-									}
-								} else {
-									// 没有找到content但仍是有效事件
-									if eventCount%10 == 0 {
-										totalTokens += 1 // 每10个无内容事件算1个token
-									}
-								}
-							} else {
-								// 保守估计，每10个事件至少计1个token
-								if eventCount%10 == 0 {
-									totalTokens += 1
-
-									if eventCount <= 10 || eventCount%100 == 0 {
-										logger.Info("事件#%d: 无法提取choices，使用保守估计", eventCount)
-									}
-								}
-							}
-						}
-					} else {
-						// JSON解析失败，使用基于事件的保守估计
-						if eventCount%10 == 0 {
-							totalTokens += 1 // 每10个事件至少计1个token
-
-							if eventCount <= 10 || eventCount%100 == 0 {
-								logger.Info("事件#%d: JSON解析失败: %v", eventCount, err)
-							}
-						}
-					}
-
-					// 添加到缓冲区
-					buffer.WriteString("data: ")
-					buffer.Write(transformedData)
-					buffer.WriteString("\n\n")
-
-					// 对于Deepseek R1，几乎总是立即刷新
-					timeToFlush := buffer.Len() >= bufferThreshold ||
-						eventCount <= 3 ||
-						time.Since(lastFlushTime) >= maxFlushInterval ||
-						isDeepseekR1
-
-					if timeToFlush && !connectionClosed.Load() {
-						_, writeErr := c.Writer.Write(buffer.Bytes())
-						if writeErr != nil {
-							readTimeoutChan <- writeErr
-							return
-						}
-						buffer.Reset()
-						flusher.Flush()
-						lastFlushTime = time.Now()
-					}
-				} else {
-					// 处理其他SSE事件(注释等)
-					buffer.Write(line)
-					buffer.WriteString("\n")
-
-					// 定期刷新
-					if (buffer.Len() >= bufferThreshold || time.Since(lastFlushTime) >= maxFlushInterval*2) && !connectionClosed.Load() {
-						c.Writer.Write(buffer.Bytes())
-						buffer.Reset()
-						flusher.Flush()
-						lastFlushTime = time.Now()
-					}
+			if !bytes.HasPrefix(trimmed, []byte("data:")) {
+				// 其他SSE行（注释等）原样转发
+				if !write(append(append([]byte{}, trimmed...), '\n', '\n')) {
+					break loop
 				}
+				continue
+			}
 
-				readTimeoutChan <- nil
-			}()
+			eventCount++
+			data := bytes.TrimSpace(bytes.TrimPrefix(trimmed, []byte("data:")))
+			if bytes.Equal(data, []byte("[DONE]")) {
+				completed = true
+				break loop
+			}
 
-			// 等待读取结果或超时
-			select {
-			case err := <-readTimeoutChan:
-				readCancel() // 取消读取上下文
+			// 转换事件数据，确保与OpenAI API格式兼容
+			transformedData, err := TransformStreamEvent(data)
+			if err != nil {
+				logger.Error("转换流式事件失败: %v", err)
+				transformedData = data
+			}
 
-				if err != nil {
-					if err == io.EOF {
-						// 正常结束
-						errorChan <- nil
-						return
-					}
+			// 在移除推理内容之前统计用量，推理内容同样计入输出token
+			usage.observe(transformedData)
 
-					// 对于Deepseek R1，特殊处理超时和上下文取消
-					if isDeepseekR1 {
-						if err == context.Canceled ||
-							strings.Contains(err.Error(), "context canceled") ||
-							strings.Contains(err.Error(), "deadline exceeded") ||
-							strings.Contains(err.Error(), "timeout") {
-							// 记录为信息而不是错误
-							logger.Info("Deepseek R1读取超时或取消，继续处理: %v", err)
-							// 发送一个空的delta事件保持连接活跃
-							if !connectionClosed.Load() {
-								keepaliveData := []byte("data: {\"id\":\"chatcmpl-keep-alive\",\"object\":\"chat.completion.chunk\",\"created\":" +
-									fmt.Sprintf("%d", time.Now().Unix()) +
-									",\"model\":\"deepseek-r1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"\"},\"finish_reason\":null}]}\n\n")
-								c.Writer.Write(keepaliveData)
-								flusher.Flush()
-							}
-							// 继续处理，不中断
-							continue
-						}
-					}
+			// 按请求的方式返回推理内容
+			transformedData = reasoningState.transformEvent(transformedData)
 
-					errorChan <- err
-					return
-				}
-			case <-readCtx.Done():
-				readCancel() // 确保取消读取上下文
-
-				// 读取超时处理
-				if isDeepseekR1 {
-					logger.Info("Deepseek R1读取操作超时，发送保持活动包")
-					// 发送一个空的delta事件
-					if !connectionClosed.Load() {
-						keepaliveData := []byte("data: {\"id\":\"chatcmpl-keep-alive\",\"object\":\"chat.completion.chunk\",\"created\":" +
-							fmt.Sprintf("%d", time.Now().Unix()) +
-							",\"model\":\"deepseek-r1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"\"},\"finish_reason\":null}]}\n\n")
-						c.Writer.Write(keepaliveData)
-						flusher.Flush()
-					}
-					// 继续处理，不中断
-					continue
-				} else {
-					// 非Deepseek模型，读取超时视为错误
-					errorChan <- fmt.Errorf("读取操作超时: %v", readCtx.Err())
-					return
-				}
-			case <-ctx.Done():
-				readCancel() // 确保取消读取上下文
-
-				// 主上下文被取消
-				if isDeepseekR1 {
-					logger.Info("Deepseek R1上下文已取消，可能是正常完成")
-					// 发送最后一个事件
-					if !connectionClosed.Load() {
-						finalData := []byte("data: {\"id\":\"chatcmpl-final\",\"object\":\"chat.completion.chunk\",\"created\":" +
-							fmt.Sprintf("%d", time.Now().Unix()) +
-							",\"model\":\"deepseek-r1\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
-						c.Writer.Write(finalData)
-						flusher.Flush()
-					}
-				}
-
-				if ctx.Err() == context.DeadlineExceeded {
-					errorChan <- fmt.Errorf("流式响应处理总时间超出限制: %v", ctx.Err())
-				} else {
-					errorChan <- ctx.Err()
-				}
-				return
+			event := make([]byte, 0, len(transformedData)+8)
+			event = append(event, "data: "...)
+			event = append(event, transformedData...)
+			event = append(event, '\n', '\n')
+			if !write(event) {
+				break loop
 			}
 		}
-	}()
-
-	// 等待处理完成
-	var err error
-	select {
-	case err = <-errorChan:
-		// 处理结束或出错
-	case <-doneChan:
-		// 正常完成
-		err = nil
-	case <-ctx.Done():
-		// 上下文取消
-		if ctx.Err() == context.DeadlineExceeded {
-			err = fmt.Errorf("流式响应处理超时: %v", ctx.Err())
-		} else {
-			err = ctx.Err()
-		}
 	}
 
-	// 发送剩余的缓冲数据
-	if buffer.Len() > 0 && !connectionClosed.Load() {
-		c.Writer.Write(buffer.Bytes())
-		flusher.Flush()
+	// 客户端断开会取消上游请求，读取上游可能先于请求上下文返回错误
+	if c.Request.Context().Err() != nil {
+		clientGone = true
 	}
 
-	// 处理错误信息
-	if err == nil || err == io.EOF {
-		logger.Info("流式响应正常完成")
-	} else if err == context.Canceled || connectionClosed.Load() {
-		logger.Info("客户端取消了连接")
-	} else if strings.Contains(err.Error(), "deadline exceeded") {
-		if isDeepseekR1 {
-			// 对于Deepseek R1，超时结束也视为正常
-			logger.Info("Deepseek R1流式响应由于超时而结束: %v", err)
-		} else {
-			// 对于其他模型，记录为警告
-			logger.Warn("流式响应由于上下文超时而结束: %v", err)
-		}
-
-		// 尝试向客户端发送超时通知（如果连接仍然有效）
-		if !connectionClosed.Load() {
-			timeoutNotice := "data: {\"id\":\"timeout-notice\",\"object\":\"chat.completion.chunk\",\"created\":" +
-				fmt.Sprintf("%d", time.Now().Unix()) +
-				",\"model\":\"generic\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"\\n\\n[系统通知: 响应生成已达到最大时间限制]\"},\"finish_reason\":\"timeout\"}]}\n\n"
-			c.Writer.Write([]byte(timeoutNotice))
-			flusher.Flush()
-
-			// 发送[DONE]事件标记结束
-			c.Writer.Write([]byte("data: [DONE]\n\n"))
-			flusher.Flush()
-		}
-	} else {
-		logger.Error("流式响应错误: %v", err)
+	switch {
+	case clientGone:
+		logger.Info("客户端断开，已关闭上游流式响应，共转发 %d 个事件", eventCount)
+	case completed:
+		logger.Info("流式响应正常完成，共转发 %d 个事件", eventCount)
+	case errors.Is(streamErr, context.DeadlineExceeded) || os.IsTimeout(streamErr):
+		logger.Warn("流式响应由于超时而结束: %v", streamErr)
+		// 向客户端发送超时通知
+		timeoutNotice := "data: {\"id\":\"timeout-notice\",\"object\":\"chat.completion.chunk\",\"created\":" +
+			fmt.Sprintf("%d", time.Now().Unix()) +
+			",\"model\":\"generic\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"\\n\\n[系统通知: 响应生成已达到最大时间限制]\"},\"finish_reason\":\"timeout\"}]}\n\n"
+		write([]byte(timeoutNotice))
+	default:
+		logger.Error("流式响应错误: %v", streamErr)
 	}
 
-	// 统计请求数据
-	// 确保token统计至少有一个合理的最小值
-	if totalTokens < eventCount/4 {
-		// 如果计算的token异常少，使用事件数作为保底估计
-		minTokens := eventCount / 4 // 保守估计每4个事件至少1个token
-		logger.Info("Token估计值(%d)过低，调整为基于事件数的保底估计: %d", totalTokens, minTokens)
-		totalTokens = minTokens
-	}
-
-	// 记录最终使用的token数
-	var tokenSource string
-	if totalTokens > 0 {
-		tokenSource = "API返回或有效估算"
-	} else {
-		tokenSource = "基于事件的保底估算"
+	// 发送最终的[DONE]事件，确保客户端知道流已结束
+	if !clientGone {
+		write([]byte("data: [DONE]\n\n"))
 	}
-	logger.Info("流式响应最终统计: total_tokens=%d (来源: %s)",
-		totalTokens, tokenSource)
 
+	// 统计请求数据，客户端中途断开时按已生成的部分记录token，但只有完整结束的响应计为成功
+	promptTokens, completionTokens, tokenSource := usage.result(promptEstimate, completed)
+	totalTokens := promptTokens + completionTokens
 	config.AddKeyRequestStat(apiKey, 1, totalTokens)
+	config.AddDailyRequestStat(apiKey, modelNameForStats, 1, promptTokens, completionTokens, completed)
 
-	// 更新每日统计数据
-	modelNameForStats := "unknown"
-	// 尝试从请求体中提取模型名称
-	if requestData != nil {
-		if model, ok := requestData["model"].(string); ok && model != "" {
-			modelNameForStats = model
-		}
-	}
-
-	// 计算prompt和completion的分配比例
-	promptTokensCount := totalTokens / 3                     // 估计输入占1/3
-	completionTokensCount := totalTokens - promptTokensCount // 估计输出占2/3
-
-	// 添加到每日统计
-	config.AddDailyRequestStat(apiKey, modelNameForStats, 1, promptTokensCount, completionTokensCount, true)
-
-	logger.Info("流式响应完成，总tokens=%d (prompt=%d, completion=%d)，处理了 %d 个事件",
-		totalTokens, promptTokensCount, completionTokensCount, eventCount)
-
-	// 确保响应已经完成并标记为结束
-	// 检查是否已经发送了[DONE]事件，如果没有，发送一个
-	if !bytes.Contains(buffer.Bytes(), []byte("data: [DONE]")) && !connectionClosed.Load() {
-		// 发送最终的[DONE]事件
-		logger.Info("发送最终的[DONE]事件以确保客户端知道流已结束")
-		c.Writer.Write([]byte("data: [DONE]\n\n"))
-		flusher.Flush()
+	if clientGone && !completed {
+		logger.Info("客户端断开，按已生成的部分记录token: 总tokens=%d (prompt=%d, completion=%d，来源: %s)",
+			totalTokens, promptTokens, completionTokens, tokenSource)
+	} else {
+		logger.Info("流式响应完成，总tokens=%d (prompt=%d, completion=%d，来源: %s)",
+			totalTokens, promptTokens, completionTokens, tokenSource)
 	}
 
 	// 设置响应完成标志，防止后续请求误判为403
@@ -2015,6 +1675,18 @@ func HandleStreamResponse(c *gin.Context, responseBody io.ReadCloser, apiKey str
 	c.Set("stream_completed", true)
 }
 
+// copyResponseHeaders 复制上游响应头，跳过跨域相关的头，跨域策略由本地中间件决定
+func copyResponseHeaders(c *gin.Context, header http.Header) {
+	for name, values := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), "Access-Control-") {
+			continue
+		}
+		for _, value := range values {
+			c.Header(name, value)
+		}
+	}
+}
+
 // extractModelName 从请求和响应中提取模型名称
 func extractModelName(req *http.Request, respBody []byte) string {
 	// 尝试从请求路径中提取模型名称
@@ -2084,13 +1756,12 @@ func extractTokenCounts(respBody []byte) (int, int) {
 // forwardUserInfoRequest 处理用户信息请求
 func forwardUserInfoRequest(c *gin.Context, targetURL string) {
 	// 获取最佳API密钥
-	apiKey, err := key.GetBestKeyForRequest("user_info", "", 0)
+	apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), "user_info", "", 0)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "No suitable API keys available",
-		})
+		writeNoKeyError(c, err, "No suitable API keys available")
 		return
 	}
+	defer release()
 
 	// 创建新的请求
 	req, err := http.NewRequest(c.Request.Method, targetURL, nil)
@@ -2130,6 +1801,9 @@ func forwardUserInfoRequest(c *gin.Context, targetURL string) {
 	}
 	defer resp.Body.Close()
 
+	// 检查上游限流信息，被限流的密钥在该模型上进入冷却
+	applyRateLimitCooldown(apiKey, "", resp)
+
 	// 记录请求信息
 	maskedKey := utils.MaskKey(apiKey)
 	logger.InfoWithKey(maskedKey, "用户信息请求: %s %s", c.Request.Method, c.Request.URL.Path)
@@ -2162,11 +1836,7 @@ func forwardUserInfoRequest(c *gin.Context, targetURL string) {
 	key.UpdateApiKeyStatus(apiKey, success)
 
 	// 复制响应 headers
-	for name, values := range resp.Header {
-		for _, value := range values {
-			c.Header(name, value)
-		}
-	}
+	copyResponseHeaders(c, resp.Header)
 
 	// 设置响应状态码
 	c.Status(resp.StatusCode)
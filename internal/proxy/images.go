@@ -0,0 +1,311 @@
+/**
+  @author: Hanhai
+  @desc: 图片生成结果的归档，将上游返回的临时图片地址下载到本地并改写为本服务的访问地址，或按请求返回base64编码的图片
+**/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 下载单张图片的超时时间
+	imageDownloadTimeout = 2 * time.Minute
+	// 单张图片的最大大小
+	maxImageSize = 32 << 20
+	// 清理归档图片的间隔
+	imageCleanupInterval = time.Hour
+	// 归档图片的访问路径前缀
+	ImageFilesPath = "/files/images/"
+)
+
+var (
+	// 确保清理任务只启动一次
+	imageCleanerOnce sync.Once
+	// 避免多个清理同时执行
+	imageCleanupMutex sync.Mutex
+)
+
+// generatedImage 下载后的图片
+type generatedImage struct {
+	id          string // 归档后的图片ID，未归档时为空
+	data        []byte // 图片内容
+	contentType string // 图片类型
+}
+
+// archiveGeneratedImages 处理图片生成的响应，开启归档时将图片保存到本地并记录到图库，
+// 请求的response_format为b64_json时将图片内容编码后返回，否则将图片地址改写为本服务的访问地址
+// 处理失败的图片保留上游地址，不影响整个响应
+func archiveGeneratedImages(c *gin.Context, respBody []byte, transformedBody []byte, originalBody []byte, apiKey string) []byte {
+	cfg := config.GetConfig()
+	var originalRequest struct {
+		ResponseFormat string `json:"response_format"`
+	}
+	json.Unmarshal(originalBody, &originalRequest)
+	wantBase64 := originalRequest.ResponseFormat == "b64_json"
+	if !cfg.Images.Archive && !wantBase64 {
+		return respBody
+	}
+
+	var responseData map[string]interface{}
+	if err := json.Unmarshal(respBody, &responseData); err != nil {
+		return respBody
+	}
+	var requestData struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+		Size   string `json:"image_size"`
+	}
+	json.Unmarshal(transformedBody, &requestData)
+	if requestData.Size == "" {
+		var sizeData struct {
+			Size string `json:"size"`
+		}
+		json.Unmarshal(transformedBody, &sizeData)
+		requestData.Size = sizeData.Size
+	}
+
+	// 硅基流动的响应同时包含images和data字段，两者的图片地址相同，每个地址只下载一次
+	downloaded := make(map[string]*generatedImage)
+	changed := false
+	for _, field := range []string{"images", "data"} {
+		entries, ok := responseData[field].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, entry := range entries {
+			entryMap, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			sourceURL, _ := entryMap["url"].(string)
+			if !strings.HasPrefix(sourceURL, "http://") && !strings.HasPrefix(sourceURL, "https://") {
+				continue
+			}
+
+			image, exists := downloaded[sourceURL]
+			if !exists {
+				var err error
+				image, err = fetchGeneratedImage(sourceURL)
+				if err != nil {
+					logger.Error("下载生成的图片失败: %v", err)
+				} else if cfg.Images.Archive {
+					image.id, err = saveGeneratedImage(image, config.GalleryImage{
+						Model:     requestData.Model,
+						Prompt:    requestData.Prompt,
+						Size:      requestData.Size,
+						MaskedKey: utils.MaskKey(apiKey),
+						SourceURL: sourceURL,
+					})
+					if err != nil {
+						logger.Error("归档生成的图片失败: %v", err)
+					}
+				}
+				downloaded[sourceURL] = image
+			}
+			if image == nil {
+				continue
+			}
+
+			if wantBase64 {
+				delete(entryMap, "url")
+				entryMap["b64_json"] = base64.StdEncoding.EncodeToString(image.data)
+				changed = true
+			} else if image.id != "" {
+				entryMap["url"] = imagePublicURL(c, image.id)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return respBody
+	}
+
+	if cfg.Images.Archive && cfg.Images.MaxImages > 0 {
+		go cleanupGalleryImages()
+	}
+
+	data, err := json.Marshal(responseData)
+	if err != nil {
+		logger.Error("序列化图片生成响应失败: %v", err)
+		return respBody
+	}
+	return data
+}
+
+// fetchGeneratedImage 下载上游生成的图片
+func fetchGeneratedImage(sourceURL string) (*generatedImage, error) {
+	resp, err := utils.CreateClientWithTimeout(imageDownloadTimeout).Get(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片返回状态码 %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("图片超过 %d MB", maxImageSize>>20)
+	}
+
+	// 上游返回的类型不是图片时按内容判断
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("下载的内容不是图片: %s", contentType)
+	}
+	return &generatedImage{data: data, contentType: contentType}, nil
+}
+
+// saveGeneratedImage 将图片写入保存目录并记录到图库，返回图片ID
+func saveGeneratedImage(image *generatedImage, record config.GalleryImage) (string, error) {
+	dir := getImageStorageDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// 图片ID用作公开的访问地址，使用随机值避免被猜测
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	localFile := filepath.Join(dir, id+imageExtension(image.contentType))
+
+	if err := os.WriteFile(localFile, image.data, 0644); err != nil {
+		return "", err
+	}
+
+	record.ID = id
+	record.LocalFile = localFile
+	record.ContentType = image.contentType
+	record.Bytes = int64(len(image.data))
+	record.CreatedAt = time.Now().Unix()
+	if err := config.CreateGalleryImage(record); err != nil {
+		os.Remove(localFile)
+		return "", err
+	}
+	logger.Info("已归档模型 %s 生成的图片: %s", record.Model, localFile)
+	return id, nil
+}
+
+// imageExtension 根据图片类型获取文件扩展名
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+// imagePublicURL 获取归档图片的访问地址
+func imagePublicURL(c *gin.Context, id string) string {
+	if base := config.GetConfig().Images.PublicBaseURL; base != "" {
+		return base + ImageFilesPath + id
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + ImageFilesPath + id
+}
+
+// getImageStorageDir 获取图片保存目录
+func getImageStorageDir() string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Images.StorageDir != "" {
+		return cfg.Images.StorageDir
+	}
+	return filepath.Join(config.GetDataDir(), "images")
+}
+
+// HandleImageFile 返回归档的图片
+// 图片地址会被客户端直接展示，无法携带API密钥，依靠随机的图片ID防止被遍历
+func HandleImageFile(c *gin.Context) {
+	image, err := config.GetGalleryImage(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrGalleryImageNotFound) {
+			status = http.StatusNotFound
+		}
+		writeProxyError(c, status, "invalid_request_error", "图片不存在")
+		return
+	}
+	if _, err := os.Stat(image.LocalFile); err != nil {
+		writeProxyError(c, http.StatusNotFound, "invalid_request_error", "图片不存在或已被清理")
+		return
+	}
+
+	c.Header("Content-Type", image.ContentType)
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(image.LocalFile)
+}
+
+// StartImageGalleryCleaner 启动后台任务，定期清理超过保留天数或保留数量的归档图片
+func StartImageGalleryCleaner() {
+	imageCleanerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(imageCleanupInterval)
+			defer ticker.Stop()
+			for {
+				cleanupGalleryImages()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// cleanupGalleryImages 清理超过保留天数或保留数量的归档图片
+func cleanupGalleryImages() {
+	cfg := config.GetConfig()
+	if cfg == nil || (cfg.Images.RetentionDays <= 0 && cfg.Images.MaxImages <= 0) {
+		return
+	}
+
+	imageCleanupMutex.Lock()
+	defer imageCleanupMutex.Unlock()
+
+	var before int64
+	if cfg.Images.RetentionDays > 0 {
+		before = time.Now().AddDate(0, 0, -cfg.Images.RetentionDays).Unix()
+	}
+	files, err := config.PurgeGalleryImages(before, cfg.Images.MaxImages)
+	if err != nil {
+		logger.Error("清理归档图片失败: %v", err)
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Error("删除归档图片文件失败: %v", err)
+		}
+	}
+}
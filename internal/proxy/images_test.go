@@ -0,0 +1,179 @@
+/**
+  @author: Hanhai
+  @desc: 生成图片的归档、地址改写和b64_json返回的测试
+**/
+
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testPNG 最小的PNG文件头，足以被识别为图片
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestArchiveGeneratedImages(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	if err := config.InitConfigDB(filepath.Join(dir, "config.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	previous := config.GetConfig()
+	t.Cleanup(func() {
+		config.CloseConfigDB()
+		config.UpdateConfig(previous)
+	})
+
+	var downloads int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		switch r.URL.Path {
+		case "/image.png":
+			// 上游返回的类型不准确时按内容判断
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(testPNG)
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("not an image"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	imageURL := upstream.URL + "/image.png"
+	tests := []struct {
+		name          string
+		archive       bool
+		request       string
+		sourceURL     string
+		wantURLPrefix string // 为空时图片地址应被移除
+		wantBase64    bool
+		wantArchived  bool
+	}{
+		{"未开启归档时保持上游地址", false, `{}`, imageURL, imageURL, false, false},
+		{"未开启归档但请求b64_json", false, `{"response_format": "b64_json"}`, imageURL, "", true, false},
+		{"开启归档时改写为本服务地址", true, `{}`, imageURL, "https://img.example.com" + ImageFilesPath, false, true},
+		{"开启归档且请求b64_json", true, `{"response_format": "b64_json"}`, imageURL, "", true, true},
+		{"下载失败时保留上游地址", true, `{}`, upstream.URL + "/missing.png", upstream.URL + "/missing.png", false, false},
+		{"下载的内容不是图片时保留上游地址", true, `{}`, upstream.URL + "/text", upstream.URL + "/text", false, false},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Images.Archive = tt.archive
+		cfg.Images.StorageDir = filepath.Join(dir, "images")
+		cfg.Images.PublicBaseURL = "https://img.example.com"
+		config.UpdateConfig(cfg)
+		atomic.StoreInt32(&downloads, 0)
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+		respBody := `{"images": [{"url": "` + tt.sourceURL + `"}], "data": [{"url": "` + tt.sourceURL + `"}]}`
+		transformed := `{"model": "black-forest-labs/FLUX.1-schnell", "prompt": "a cat", "image_size": "1024x1024"}`
+
+		result := archiveGeneratedImages(c, []byte(respBody), []byte(transformed), []byte(tt.request), "sk-images-test")
+
+		var resp struct {
+			Images []map[string]string `json:"images"`
+			Data   []map[string]string `json:"data"`
+		}
+		if err := json.Unmarshal(result, &resp); err != nil {
+			t.Fatalf("%s: 解析响应失败: %v", tt.name, err)
+		}
+		if tt.archive || tt.wantBase64 {
+			// images和data中的相同地址只下载一次
+			if got := atomic.LoadInt32(&downloads); got != 1 {
+				t.Errorf("%s: 下载次数 = %d, want 1", tt.name, got)
+			}
+		} else if got := atomic.LoadInt32(&downloads); got != 0 {
+			t.Errorf("%s: 未开启归档时不应下载图片，下载次数 = %d", tt.name, got)
+		}
+
+		for _, entry := range []map[string]string{resp.Images[0], resp.Data[0]} {
+			if tt.wantURLPrefix == "" {
+				if _, ok := entry["url"]; ok {
+					t.Errorf("%s: 返回b64_json时不应包含url: %v", tt.name, entry)
+				}
+			} else if !strings.HasPrefix(entry["url"], tt.wantURLPrefix) {
+				t.Errorf("%s: url = %s, want 前缀 %s", tt.name, entry["url"], tt.wantURLPrefix)
+			}
+			if tt.wantBase64 {
+				data, err := base64.StdEncoding.DecodeString(entry["b64_json"])
+				if err != nil || string(data) != string(testPNG) {
+					t.Errorf("%s: b64_json内容不正确: %v", tt.name, err)
+				}
+			} else if _, ok := entry["b64_json"]; ok {
+				t.Errorf("%s: 未请求b64_json时不应返回", tt.name)
+			}
+		}
+
+		images, total, err := config.ListGalleryImages("", 10, 0)
+		if err != nil {
+			t.Fatalf("%s: 查询图库失败: %v", tt.name, err)
+		}
+		if (total == 1) != tt.wantArchived {
+			t.Errorf("%s: 图库记录数 = %d, want 归档 %v", tt.name, total, tt.wantArchived)
+		}
+		for _, image := range images {
+			if image.Model != "black-forest-labs/FLUX.1-schnell" || image.Prompt != "a cat" || image.Size != "1024x1024" || image.SourceURL != tt.sourceURL {
+				t.Errorf("%s: 图库记录不正确: %+v", tt.name, image)
+			}
+			if strings.Contains(image.MaskedKey, "sk-images-test") {
+				t.Errorf("%s: 图库中记录了完整的API密钥", tt.name)
+			}
+			if tt.wantURLPrefix != "" && resp.Data[0]["url"] != "https://img.example.com"+ImageFilesPath+image.ID {
+				t.Errorf("%s: 改写的地址与图库记录不一致: %s", tt.name, resp.Data[0]["url"])
+			}
+			if data, err := os.ReadFile(image.LocalFile); err != nil || string(data) != string(testPNG) {
+				t.Errorf("%s: 归档的图片文件不正确: %v", tt.name, err)
+			}
+			if filepath.Ext(image.LocalFile) != ".png" {
+				t.Errorf("%s: 归档文件扩展名 = %s", tt.name, filepath.Ext(image.LocalFile))
+			}
+			if _, err := config.DeleteGalleryImage(image.ID); err != nil {
+				t.Fatalf("%s: 删除图库记录失败: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestImagePublicURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+
+	tests := []struct {
+		name    string
+		baseURL string
+		host    string
+		want    string
+	}{
+		{"使用配置的外部访问地址", "https://img.example.com", "127.0.0.1:3016", "https://img.example.com" + ImageFilesPath + "abc"},
+		{"未配置时根据请求地址生成", "", "127.0.0.1:3016", "http://127.0.0.1:3016" + ImageFilesPath + "abc"},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Images.PublicBaseURL = tt.baseURL
+		config.UpdateConfig(cfg)
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+		c.Request.Host = tt.host
+		if got := imagePublicURL(c, "abc"); got != tt.want {
+			t.Errorf("%s: imagePublicURL = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+/**
+  @author: Hanhai
+  @desc: 简单的JSON Schema校验，支持工具参数和结构化输出中常用的关键字
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// 校验时最多报告的错误数量
+const maxSchemaErrors = 10
+
+// validateJSONSchema 按JSON Schema校验数据，返回发现的错误，没有错误时返回nil
+// 错误信息会放入要求模型修正的英文提示中，因此使用英文
+// 支持type、enum、const、properties、required、additionalProperties、items、
+// minItems、maxItems、minLength、maxLength、pattern、minimum、maximum、anyOf、oneOf、allOf
+// 以及指向 #/$defs 和 #/definitions 的$ref，不支持的关键字会被忽略
+func validateJSONSchema(value interface{}, schema map[string]interface{}) []string {
+	validator := &schemaValidator{root: schema}
+	validator.validate(value, schema, "$", 0)
+	return validator.errors
+}
+
+// schemaValidator 保存校验过程中的根Schema和错误
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors []string
+}
+
+// addError 记录一条错误，超过上限后不再记录
+func (v *schemaValidator) addError(path string, format string, args ...interface{}) {
+	if len(v.errors) < maxSchemaErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// validate 递归校验，depth用于防止循环引用
+func (v *schemaValidator) validate(value interface{}, schema map[string]interface{}, path string, depth int) {
+	if schema == nil || depth > 32 {
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if resolved := v.resolveRef(ref); resolved != nil {
+			v.validate(value, resolved, path, depth+1)
+		}
+		return
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesAnySchemaType(value, types) {
+		v.addError(path, "expected type %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsJSONValue(enum, value) {
+		v.addError(path, "value must be one of %s", compactJSON(enum))
+	}
+	if constValue, ok := schema["const"]; ok && !jsonValuesEqual(constValue, value) {
+		v.addError(path, "value must be %s", compactJSON(constValue))
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(typed, schema, path, depth)
+	case []interface{}:
+		v.validateArray(typed, schema, path, depth)
+	case string:
+		length := utf8.RuneCountInString(typed)
+		if minLength, ok := schemaNumber(schema["minLength"]); ok && float64(length) < minLength {
+			v.addError(path, "length must be at least %v", minLength)
+		}
+		if maxLength, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > maxLength {
+			v.addError(path, "length must be at most %v", maxLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(typed) {
+				v.addError(path, "does not match pattern %s", pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schemaNumber(schema["minimum"]); ok && typed < minimum {
+			v.addError(path, "must be at least %v", minimum)
+		}
+		if maximum, ok := schemaNumber(schema["maximum"]); ok && typed > maximum {
+			v.addError(path, "must be at most %v", maximum)
+		}
+	}
+
+	v.validateCombinators(value, schema, path, depth)
+}
+
+// validateObject 校验对象的属性
+func (v *schemaValidator) validateObject(object map[string]interface{}, schema map[string]interface{}, path string, depth int) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, item := range required {
+			if name, ok := item.(string); ok {
+				if _, exists := object[name]; !exists {
+					v.addError(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	for name, fieldValue := range object {
+		fieldPath := path + "." + name
+		if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+			v.validate(fieldValue, propertySchema, fieldPath, depth+1)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.addError(path, "additional property %q is not allowed", name)
+			}
+		case map[string]interface{}:
+			v.validate(fieldValue, additional, fieldPath, depth+1)
+		}
+	}
+}
+
+// validateArray 校验数组的元素和长度
+func (v *schemaValidator) validateArray(array []interface{}, schema map[string]interface{}, path string, depth int) {
+	if minItems, ok := schemaNumber(schema["minItems"]); ok && float64(len(array)) < minItems {
+		v.addError(path, "must have at least %v items", minItems)
+	}
+	if maxItems, ok := schemaNumber(schema["maxItems"]); ok && float64(len(array)) > maxItems {
+		v.addError(path, "must have at most %v items", maxItems)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range array {
+			v.validate(item, items, fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+	}
+}
+
+// validateCombinators 校验anyOf、oneOf和allOf
+func (v *schemaValidator) validateCombinators(value interface{}, schema map[string]interface{}, path string, depth int) {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, item := range allOf {
+			if sub, ok := item.(map[string]interface{}); ok {
+				v.validate(value, sub, path, depth+1)
+			}
+		}
+	}
+
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		options, ok := schema[keyword].([]interface{})
+		if !ok || len(options) == 0 {
+			continue
+		}
+		matched := 0
+		for _, item := range options {
+			sub, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			probe := &schemaValidator{root: v.root}
+			probe.validate(value, sub, path, depth+1)
+			if len(probe.errors) == 0 {
+				matched++
+			}
+		}
+		if matched == 0 || (keyword == "oneOf" && matched > 1) {
+			v.addError(path, "does not match %s", keyword)
+		}
+	}
+}
+
+// resolveRef 解析指向根Schema内部定义的引用
+func (v *schemaValidator) resolveRef(ref string) map[string]interface{} {
+	if ref == "#" {
+		return v.root
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var current interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		current = object[part]
+	}
+	resolved, _ := current.(map[string]interface{})
+	return resolved
+}
+
+// schemaTypes 获取Schema中声明的类型，type可以是字符串或字符串数组
+func schemaTypes(value interface{}) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []interface{}:
+		var types []string
+		for _, item := range typed {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// matchesAnySchemaType 判断值是否符合任一类型
+func matchesAnySchemaType(value interface{}, types []string) bool {
+	for _, name := range types {
+		switch name {
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if number, ok := value.(float64); ok && number == math.Trunc(number) {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonTypeName 获取值的JSON类型名称
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// schemaNumber 获取Schema中的数值关键字
+func schemaNumber(value interface{}) (float64, bool) {
+	number, ok := value.(float64)
+	return number, ok
+}
+
+// containsJSONValue 判断列表中是否包含指定的值
+func containsJSONValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if jsonValuesEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValuesEqual 按JSON序列化结果比较两个值
+func jsonValuesEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+// compactJSON 将值序列化为紧凑的JSON字符串
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
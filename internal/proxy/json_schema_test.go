@@ -0,0 +1,49 @@
+/**
+  @author: Hanhai
+  @desc: JSON Schema校验的测试
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	var schema map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["name", "tags"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 2},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "maxItems": 2, "items": {"enum": ["a", "b"]}}
+		}
+	}`), &schema)
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"合法数据", `{"name": "Bob", "age": 3, "tags": ["a"]}`, nil},
+		{"缺少必需字段", `{"name": "Bob"}`, []string{`$: missing required property "tags"`}},
+		{"类型错误", `{"name": 1, "tags": []}`, []string{"$.name: expected type string, got number"}},
+		{"整数和范围", `{"name": "Bob", "age": -1.5, "tags": []}`, []string{"$.age: expected type integer, got number"}},
+		{"多余字段", `{"name": "Bob", "tags": [], "extra": 1}`, []string{`$: additional property "extra" is not allowed`}},
+		{"数组元素", `{"name": "Bob", "tags": ["a", "c", "b"]}`, []string{"$.tags: must have at most 2 items", `$.tags[1]: value must be one of ["a","b"]`}},
+	}
+	for _, tt := range tests {
+		var data interface{}
+		if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+			t.Fatal(err)
+		}
+		got := validateJSONSchema(data, schema)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: 错误 = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/**
+  @author: Hanhai
+  @desc: 提示词模板的注入，按模型和客户端API密钥查找绑定的模板，替换变量后加入系统消息
+**/
+
+package proxy
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/model"
+	"strings"
+	"time"
+)
+
+// ApplyPromptTemplates 将绑定到当前模型或客户端的提示词模板注入对话消息，返回已应用的模板名称
+// 多个模板按ID顺序拼接，prepend模板放在已有系统消息之前，append模板放在之后
+func ApplyPromptTemplates(requestData map[string]interface{}, clientKey string) []string {
+	if _, ok := requestData["messages"].([]interface{}); !ok {
+		return nil
+	}
+
+	modelName, _ := requestData["model"].(string)
+	replacer := promptVariableReplacer(modelName, clientKey)
+
+	var applied, prepends, appends []string
+	for _, template := range model.GetPromptTemplates() {
+		if !template.Enabled || !promptTemplateMatches(template, modelName, clientKey) {
+			continue
+		}
+
+		content := replacer.Replace(template.Content)
+		if template.Position == model.SystemPromptModeAppend {
+			appends = append(appends, content)
+		} else {
+			prepends = append(prepends, content)
+		}
+		applied = append(applied, template.Name)
+	}
+
+	if len(prepends) > 0 {
+		injectSystemPrompt(requestData, strings.Join(prepends, "\n\n"), model.SystemPromptModePrepend)
+	}
+	if len(appends) > 0 {
+		injectSystemPrompt(requestData, strings.Join(appends, "\n\n"), model.SystemPromptModeAppend)
+	}
+	if len(applied) > 0 {
+		logger.Info("为模型 %s 注入提示词模板: %s", modelName, strings.Join(applied, ", "))
+	}
+	return applied
+}
+
+// promptTemplateMatches 检查模板是否绑定到指定模型或客户端API密钥
+func promptTemplateMatches(template model.PromptTemplate, modelName, clientKey string) bool {
+	if clientKey != "" {
+		for _, boundKey := range template.ClientKeys {
+			if boundKey == clientKey {
+				return true
+			}
+		}
+	}
+	for _, pattern := range template.Models {
+		if matchModelPattern(pattern, modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// promptVariableReplacer 创建模板变量的替换器
+func promptVariableReplacer(modelName, clientKey string) *strings.Replacer {
+	now := time.Now()
+	return strings.NewReplacer(
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04"),
+		"{{datetime}}", now.Format("2006-01-02 15:04"),
+		"{{weekday}}", now.Weekday().String(),
+		"{{model}}", modelName,
+		"{{client_name}}", promptClientName(clientKey),
+	)
+}
+
+// promptClientName 获取客户端名称，未配置名称时返回空字符串，避免在提示词中暴露API密钥
+func promptClientName(clientKey string) string {
+	if clientKey == "" {
+		return ""
+	}
+	if cfg := config.GetConfig(); cfg != nil {
+		return cfg.Prompts.ClientNames[clientKey]
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+/**
+  @author: Hanhai
+  @desc: 提示词模板变量替换和按模型、客户端注入的测试
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/model"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptVariableReplacer(t *testing.T) {
+	previous := config.GetConfig()
+	cfg := &config.Config{}
+	cfg.Prompts.ClientNames = map[string]string{"sk-client-a": "团队A"}
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+
+	now := time.Now()
+	tests := []struct {
+		content   string
+		clientKey string
+		want      string
+	}{
+		{"模型: {{model}}", "", "模型: deepseek-ai/DeepSeek-V3"},
+		{"客户端: {{client_name}}", "sk-client-a", "客户端: 团队A"},
+		{"客户端: {{client_name}}", "sk-unnamed", "客户端: "}, // 未配置名称时不暴露API密钥
+		{"日期: {{date}}", "", "日期: " + now.Format("2006-01-02")},
+		{"星期: {{weekday}}", "", "星期: " + now.Weekday().String()},
+		{"未知变量 {{unknown}} 保持不变", "", "未知变量 {{unknown}} 保持不变"},
+		{"{{model}}/{{model}}", "", "deepseek-ai/DeepSeek-V3/deepseek-ai/DeepSeek-V3"},
+	}
+	for _, tt := range tests {
+		replacer := promptVariableReplacer("deepseek-ai/DeepSeek-V3", tt.clientKey)
+		if got := replacer.Replace(tt.content); got != tt.want {
+			t.Errorf("Replace(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPromptTemplates(t *testing.T) {
+	logger.InitLogger()
+	if err := model.InitModelDB(filepath.Join(t.TempDir(), "models.db")); err != nil {
+		t.Fatalf("初始化模型数据库失败: %v", err)
+	}
+	previous := config.GetConfig()
+	cfg := &config.Config{}
+	cfg.Prompts.ClientNames = map[string]string{"sk-client-a": "团队A"}
+	config.UpdateConfig(cfg)
+
+	templates := []model.PromptTemplate{
+		{Name: "模型模板", Content: "你是{{model}}", Position: model.SystemPromptModePrepend, Models: []string{"deepseek-ai/*"}, Enabled: true},
+		{Name: "客户端模板", Content: "用户来自{{client_name}}", Position: model.SystemPromptModeAppend, ClientKeys: []string{"sk-client-a"}, Enabled: true},
+		{Name: "禁用的模板", Content: "不应出现", Models: []string{"*"}, Enabled: false},
+	}
+	var created []int64
+	for _, template := range templates {
+		result, err := model.CreatePromptTemplate(template)
+		if err != nil {
+			t.Fatalf("创建模板失败: %v", err)
+		}
+		created = append(created, result.ID)
+	}
+	t.Cleanup(func() {
+		for _, id := range created {
+			model.DeletePromptTemplate(id)
+		}
+		model.CloseModelDB()
+		config.UpdateConfig(previous)
+	})
+
+	tests := []struct {
+		name        string
+		body        string
+		clientKey   string
+		wantApplied []string
+		wantSystem  string
+	}{
+		{"按模型前缀匹配并新建系统消息", `{"model": "deepseek-ai/DeepSeek-V3", "messages": [{"role": "user", "content": "hi"}]}`, "",
+			[]string{"模型模板"}, "你是deepseek-ai/DeepSeek-V3"},
+		{"按客户端匹配并追加到已有系统消息", `{"model": "Qwen/QwQ-32B", "messages": [{"role": "system", "content": "原有"}, {"role": "user", "content": "hi"}]}`, "sk-client-a",
+			[]string{"客户端模板"}, "原有\n\n用户来自团队A"},
+		{"同时匹配时prepend在前append在后", `{"model": "deepseek-ai/DeepSeek-R1", "messages": [{"role": "system", "content": "原有"}]}`, "sk-client-a",
+			[]string{"模型模板", "客户端模板"}, "你是deepseek-ai/DeepSeek-R1\n\n原有\n\n用户来自团队A"},
+		{"没有匹配的模板", `{"model": "Qwen/QwQ-32B", "messages": [{"role": "user", "content": "hi"}]}`, "sk-other",
+			nil, ""},
+		{"没有messages时不处理", `{"model": "deepseek-ai/DeepSeek-V3", "prompt": "hi"}`, "",
+			nil, ""},
+	}
+	for _, tt := range tests {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+			t.Fatal(err)
+		}
+		applied := ApplyPromptTemplates(data, tt.clientKey)
+		if !reflect.DeepEqual(applied, tt.wantApplied) {
+			t.Errorf("%s: 应用的模板 = %v, want %v", tt.name, applied, tt.wantApplied)
+		}
+
+		system := ""
+		if messages, ok := data["messages"].([]interface{}); ok {
+			if first, ok := messages[0].(map[string]interface{}); ok && first["role"] == "system" {
+				system, _ = first["content"].(string)
+			}
+		}
+		if tt.wantSystem != "" && system != tt.wantSystem {
+			t.Errorf("%s: 系统消息 = %q, want %q", tt.name, system, tt.wantSystem)
+		}
+		if strings.Contains(system, "不应出现") {
+			t.Errorf("%s: 注入了禁用的模板", tt.name)
+		}
+	}
+}
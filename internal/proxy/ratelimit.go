@@ -0,0 +1,147 @@
+/**
+  @author: Hanhai
+  @desc: 上游限流响应头解析与指数退避重试间隔计算
+**/
+
+package proxy
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 限流与退避的默认参数
+const (
+	defaultRateLimitCooldown = 60 * time.Second
+	defaultMaxCooldown       = 10 * time.Minute
+	defaultBackoffMultiplier = 2.0
+	defaultMaxRetryDelay     = 30 * time.Second
+)
+
+// parseRateLimitWait 从上游响应头中解析需要等待的时间
+// 支持 Retry-After（秒数或HTTP日期）以及 x-ratelimit-remaining-* 为0时对应的 x-ratelimit-reset-*
+func parseRateLimitWait(header http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); retryAfter != "" {
+		if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil && seconds > 0 {
+			wait = time.Duration(seconds * float64(time.Second))
+		} else if t, err := http.ParseTime(retryAfter); err == nil && t.After(now) {
+			wait = t.Sub(now)
+		}
+	}
+
+	// 检查请求数和令牌数的限流头，只有剩余额度耗尽时才使用重置时间
+	for _, kind := range []string{"requests", "tokens"} {
+		remaining := strings.TrimSpace(header.Get("X-Ratelimit-Remaining-" + kind))
+		if remaining == "" {
+			continue
+		}
+		if value, err := strconv.ParseFloat(remaining, 64); err != nil || value > 0 {
+			continue
+		}
+		if reset := parseRateLimitReset(header.Get("X-Ratelimit-Reset-"+kind), now); reset > wait {
+			wait = reset
+		}
+	}
+
+	return wait
+}
+
+// parseRateLimitReset 解析重置时间，支持 "1s"、"6m0s"、"20ms" 等时长、秒数以及Unix时间戳
+func parseRateLimitReset(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil && number > 0 {
+		// 数值大于一年的秒数时视为Unix时间戳
+		if number > 365*24*3600 {
+			resetAt := time.Unix(int64(number), 0)
+			if resetAt.After(now) {
+				return resetAt.Sub(now)
+			}
+			return 0
+		}
+		return time.Duration(number * float64(time.Second))
+	}
+
+	return 0
+}
+
+// applyRateLimitCooldown 根据上游响应判断是否被限流，被限流时将密钥在该模型上置于冷却期
+// 返回冷却时长，未被限流时返回0
+func applyRateLimitCooldown(apiKey string, modelName string, resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	retryConfig := config.GetConfig().ApiProxy.Retry
+	wait := parseRateLimitWait(resp.Header, time.Now())
+
+	var reason string
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		reason = "上游返回429"
+		if wait <= 0 {
+			wait = time.Duration(retryConfig.RateLimitCooldownMs) * time.Millisecond
+			if wait <= 0 {
+				wait = defaultRateLimitCooldown
+			}
+		}
+	case wait > 0:
+		// 非429响应但限流头显示额度已耗尽，提前冷却
+		reason = "上游限流额度已耗尽"
+	default:
+		return 0
+	}
+
+	maxCooldown := time.Duration(retryConfig.MaxCooldownMs) * time.Millisecond
+	if maxCooldown <= 0 {
+		maxCooldown = defaultMaxCooldown
+	}
+	if wait > maxCooldown {
+		wait = maxCooldown
+	}
+
+	key.SetKeyCooldown(apiKey, modelName, wait, reason)
+	return wait
+}
+
+// retryBackoffDelay 计算第attempt次重试（从0开始）前的等待时间，使用带抖动的指数退避
+func retryBackoffDelay(attempt int, retryConfig config.RetryConfig) time.Duration {
+	base := float64(retryConfig.RetryDelayMs) * float64(time.Millisecond)
+	if base <= 0 {
+		return 0
+	}
+
+	multiplier := retryConfig.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	maxDelay := float64(retryConfig.MaxRetryDelayMs) * float64(time.Millisecond)
+	if maxDelay <= 0 {
+		maxDelay = float64(defaultMaxRetryDelay)
+	}
+
+	delay := math.Min(base*math.Pow(multiplier, float64(attempt)), maxDelay)
+
+	// 抖动比例为0时不抖动，未设置时的默认值在加载配置时填充
+	jitterRatio := math.Min(math.Max(retryConfig.JitterRatio, 0), 1)
+
+	// 在 [delay*(1-ratio), delay*(1+ratio)] 区间内随机取值
+	jitter := (rand.Float64()*2 - 1) * jitterRatio * delay
+	return time.Duration(delay + jitter)
+}
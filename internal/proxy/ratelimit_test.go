@@ -0,0 +1,135 @@
+/**
+  @author: Hanhai
+  @desc: 上游限流响应头解析、密钥冷却和退避间隔的测试
+**/
+
+package proxy
+
+import (
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitWait(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"没有限流头", nil, 0},
+		{"Retry-After秒数", map[string]string{"Retry-After": "30"}, 30 * time.Second},
+		{"Retry-After小数秒", map[string]string{"Retry-After": "1.5"}, 1500 * time.Millisecond},
+		{"Retry-After HTTP日期", map[string]string{"Retry-After": now.Add(2 * time.Minute).Format(http.TimeFormat)}, 2 * time.Minute},
+		{"Retry-After 过去的日期", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0},
+		{"Retry-After 无法解析", map[string]string{"Retry-After": "soon"}, 0},
+		{"请求数耗尽 6m0s", map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "6m0s"}, 6 * time.Minute},
+		{"令牌数耗尽 20ms", map[string]string{"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "20ms"}, 20 * time.Millisecond},
+		{"重置时间为秒数", map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "2"}, 2 * time.Second},
+		{"重置时间为Unix时间戳", map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "1735787135"}, 90 * time.Second},
+		{"额度未耗尽时忽略重置时间", map[string]string{"x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "1s"}, 0},
+		{"取最长的等待时间", map[string]string{
+			"Retry-After":                    "10",
+			"x-ratelimit-remaining-requests": "0",
+			"x-ratelimit-reset-requests":     "1m",
+			"x-ratelimit-remaining-tokens":   "0",
+			"x-ratelimit-reset-tokens":       "30s",
+		}, time.Minute},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.headers {
+			header.Set(k, v)
+		}
+		if got := parseRateLimitWait(header, now); got != tt.want {
+			t.Errorf("%s: parseRateLimitWait = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRateLimitCooldown(t *testing.T) {
+	logger.InitLogger()
+	previous := config.GetConfig()
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+
+	tests := []struct {
+		name       string
+		status     int
+		headers    map[string]string
+		cooldownMs int
+		maxMs      int
+		want       time.Duration
+	}{
+		{"429使用Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "20"}, 0, 0, 20 * time.Second},
+		{"429没有限流头时使用配置的冷却时间", http.StatusTooManyRequests, nil, 5000, 0, 5 * time.Second},
+		{"429没有限流头且未配置", http.StatusTooManyRequests, nil, 0, 0, defaultRateLimitCooldown},
+		{"冷却时间不超过上限", http.StatusTooManyRequests, map[string]string{"Retry-After": "3600"}, 0, 120000, 2 * time.Minute},
+		{"未配置上限时使用默认上限", http.StatusTooManyRequests, map[string]string{"Retry-After": "86400"}, 0, 0, defaultMaxCooldown},
+		{"成功响应但额度已耗尽", http.StatusOK, map[string]string{"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "30s"}, 0, 0, 30 * time.Second},
+		{"未被限流", http.StatusOK, nil, 0, 0, 0},
+		{"其他错误状态码", http.StatusInternalServerError, nil, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.ApiProxy.Retry.RateLimitCooldownMs = tt.cooldownMs
+		cfg.ApiProxy.Retry.MaxCooldownMs = tt.maxMs
+		config.UpdateConfig(cfg)
+
+		const apiKey = "sk-ratelimit-test"
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		for k, v := range tt.headers {
+			resp.Header.Set(k, v)
+		}
+
+		got := applyRateLimitCooldown(apiKey, "test/model", resp)
+		if got != tt.want {
+			t.Errorf("%s: applyRateLimitCooldown = %v, want %v", tt.name, got, tt.want)
+		}
+		if cooling := key.IsKeyCoolingDown(apiKey, "test/model"); cooling != (tt.want > 0) {
+			t.Errorf("%s: 密钥冷却状态 = %v", tt.name, cooling)
+		}
+		if key.IsKeyCoolingDown(apiKey, "other/model") {
+			t.Errorf("%s: 冷却不应影响其他模型", tt.name)
+		}
+		key.ClearKeyCooldown(apiKey)
+	}
+
+	if got := applyRateLimitCooldown("sk-ratelimit-test", "test/model", nil); got != 0 {
+		t.Errorf("没有响应时不应冷却: %v", got)
+	}
+}
+
+func TestRetryBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   config.RetryConfig
+		attempt int
+		want    time.Duration
+	}{
+		{"未设置重试间隔", config.RetryConfig{}, 3, 0},
+		{"首次重试", config.RetryConfig{RetryDelayMs: 1000, BackoffMultiplier: 2}, 0, time.Second},
+		{"指数增长", config.RetryConfig{RetryDelayMs: 1000, BackoffMultiplier: 2}, 3, 8 * time.Second},
+		{"倍数小于1时使用默认倍数", config.RetryConfig{RetryDelayMs: 500, BackoffMultiplier: 0.5}, 2, 2 * time.Second},
+		{"不超过配置的上限", config.RetryConfig{RetryDelayMs: 1000, BackoffMultiplier: 3, MaxRetryDelayMs: 5000}, 4, 5 * time.Second},
+		{"未配置上限时使用默认上限", config.RetryConfig{RetryDelayMs: 1000, BackoffMultiplier: 2}, 10, defaultMaxRetryDelay},
+	}
+	for _, tt := range tests {
+		// 抖动比例为0时不抖动，结果是确定的
+		if got := retryBackoffDelay(tt.attempt, tt.retry); got != tt.want {
+			t.Errorf("%s: retryBackoffDelay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	retry := config.RetryConfig{RetryDelayMs: 1000, BackoffMultiplier: 2, JitterRatio: 0.2}
+	for i := 0; i < 100; i++ {
+		got := retryBackoffDelay(1, retry)
+		if got < 1600*time.Millisecond || got > 2400*time.Millisecond {
+			t.Fatalf("带抖动的退避间隔超出范围: %v", got)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/**
+  @author: Hanhai
+  @desc: 推理内容的返回方式，按请求或客户端配置原样返回、移除或以<think>标签合并到content，流式和非流式响应处理一致
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 推理内容的返回方式
+const (
+	ReasoningModePassthrough = "passthrough" // 原样返回reasoning_content
+	ReasoningModeStrip       = "strip"       // 移除推理内容
+	ReasoningModeThink       = "think"       // 以<think>标签包裹后合并到content之前
+)
+
+// 请求中指定推理内容返回方式的请求头，优先于请求体中的reasoning_format字段
+const reasoningFormatHeader = "X-Reasoning-Format"
+
+// 保存在请求上下文中的推理内容返回方式
+const reasoningModeContextKey = "reasoning_mode"
+
+// IsValidReasoningMode 判断推理内容返回方式是否有效
+func IsValidReasoningMode(mode string) bool {
+	return mode == ReasoningModePassthrough || mode == ReasoningModeStrip || mode == ReasoningModeThink
+}
+
+// normalizeReasoningMode 规范化请求指定的返回方式，兼容Groq的parsed、hidden和raw，无法识别时返回空字符串
+func normalizeReasoningMode(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "parsed":
+		return ReasoningModePassthrough
+	case "hidden":
+		return ReasoningModeStrip
+	case "raw":
+		return ReasoningModeThink
+	}
+	if IsValidReasoningMode(mode) {
+		return mode
+	}
+	return ""
+}
+
+// resolveReasoningMode 确定请求的推理内容返回方式并保存到请求上下文
+// 优先级依次为请求头、请求体中的reasoning_format、客户端配置和默认配置
+func resolveReasoningMode(c *gin.Context, bodyBytes []byte) string {
+	mode := normalizeReasoningMode(c.GetHeader(reasoningFormatHeader))
+	if mode == "" {
+		var requestData struct {
+			ReasoningFormat string `json:"reasoning_format"`
+		}
+		if json.Unmarshal(bodyBytes, &requestData) == nil {
+			mode = normalizeReasoningMode(requestData.ReasoningFormat)
+		}
+	}
+	if mode == "" {
+		if cfg := config.GetConfig(); cfg != nil {
+			mode = cfg.Reasoning.ClientModes[key.ClientKeyFromContext(c.Request.Context())]
+			if mode == "" {
+				mode = cfg.Reasoning.Mode
+			}
+		}
+	}
+	if !IsValidReasoningMode(mode) {
+		mode = ReasoningModePassthrough
+	}
+	c.Set(reasoningModeContextKey, mode)
+	return mode
+}
+
+// reasoningModeFromContext 获取请求上下文中的推理内容返回方式
+func reasoningModeFromContext(c *gin.Context) string {
+	if mode := c.GetString(reasoningModeContextKey); mode != "" {
+		return mode
+	}
+	return ReasoningModePassthrough
+}
+
+// takeReasoningContent 取出消息或增量中的推理内容并删除对应字段，兼容reasoning_content和reasoning两种字段名
+func takeReasoningContent(message map[string]interface{}) (string, bool) {
+	var reasoning string
+	found := false
+	for _, field := range []string{"reasoning_content", "reasoning"} {
+		if value, ok := message[field]; ok {
+			found = true
+			if text, ok := value.(string); ok && reasoning == "" {
+				reasoning = text
+			}
+			delete(message, field)
+		}
+	}
+	return reasoning, found
+}
+
+// applyReasoningModeToResponse 按返回方式处理非流式对话响应中每个选项的推理内容
+func applyReasoningModeToResponse(response map[string]interface{}, mode string) bool {
+	if mode == ReasoningModePassthrough {
+		return false
+	}
+	changed := false
+	choices, _ := response["choices"].([]interface{})
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		message, ok := choice["message"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		reasoning, found := takeReasoningContent(message)
+		if !found {
+			continue
+		}
+		changed = true
+		if mode == ReasoningModeThink && reasoning != "" {
+			content, _ := message["content"].(string)
+			message["content"] = "<think>\n" + reasoning + "\n</think>\n\n" + content
+		}
+	}
+	return changed
+}
+
+// applyReasoningModeToBody 处理非流式对话响应体中的推理内容，无需处理时返回原响应体
+func applyReasoningModeToBody(body []byte, mode string) []byte {
+	if mode == ReasoningModePassthrough {
+		return body
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil || !applyReasoningModeToResponse(response, mode) {
+		return body
+	}
+	if modified, err := marshalReasoningJSON(response); err == nil {
+		return modified
+	}
+	return body
+}
+
+// reasoningStreamState 流式响应中推理内容的处理状态，记录每个选项的<think>标签是否已打开
+type reasoningStreamState struct {
+	mode     string
+	thinking map[float64]bool
+}
+
+// newReasoningStreamState 创建流式响应的推理内容处理状态
+func newReasoningStreamState(mode string) *reasoningStreamState {
+	return &reasoningStreamState{mode: mode, thinking: make(map[float64]bool)}
+}
+
+// transformEvent 按返回方式处理一个流式事件，think方式在推理内容开始时打开标签，在正文开始或结束时关闭标签
+func (s *reasoningStreamState) transformEvent(data []byte) []byte {
+	if s.mode == ReasoningModePassthrough {
+		return data
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return data
+	}
+	choices, _ := event["choices"].([]interface{})
+	changed := false
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		delta, ok := choice["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		reasoning, found := takeReasoningContent(delta)
+		changed = changed || found
+		if s.mode != ReasoningModeThink {
+			continue
+		}
+
+		index, _ := choice["index"].(float64)
+		content, _ := delta["content"].(string)
+		var text strings.Builder
+		if reasoning != "" {
+			if !s.thinking[index] {
+				text.WriteString("<think>\n")
+				s.thinking[index] = true
+			}
+			text.WriteString(reasoning)
+		}
+		if s.thinking[index] && (content != "" || choice["finish_reason"] != nil) {
+			text.WriteString("\n</think>\n\n")
+			s.thinking[index] = false
+		}
+		if text.Len() > 0 {
+			delta["content"] = text.String() + content
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	if modified, err := marshalReasoningJSON(event); err == nil {
+		return modified
+	}
+	return data
+}
+
+// marshalReasoningJSON 序列化处理后的响应，不转义<think>标签中的尖括号
+func marshalReasoningJSON(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
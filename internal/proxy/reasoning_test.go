@@ -0,0 +1,177 @@
+/**
+  @author: Hanhai
+  @desc: 推理内容返回方式的测试，覆盖流式和非流式响应
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeReasoningMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"passthrough", ReasoningModePassthrough},
+		{" Strip ", ReasoningModeStrip},
+		{"THINK", ReasoningModeThink},
+		{"parsed", ReasoningModePassthrough}, // 兼容Groq的reasoning_format
+		{"hidden", ReasoningModeStrip},
+		{"raw", ReasoningModeThink},
+		{"", ""},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeReasoningMode(tt.mode); got != tt.want {
+			t.Errorf("normalizeReasoningMode(%q) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestResolveReasoningMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	cfg := &config.Config{}
+	cfg.Reasoning.Mode = ReasoningModeStrip
+	cfg.Reasoning.ClientModes = map[string]string{"sk-client-think": ReasoningModeThink}
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+
+	tests := []struct {
+		name      string
+		header    string
+		body      string
+		clientKey string
+		want      string
+	}{
+		{"请求头优先", "raw", `{"reasoning_format": "hidden"}`, "sk-client-think", ReasoningModeThink},
+		{"请求体中的reasoning_format", "", `{"reasoning_format": "parsed"}`, "sk-client-think", ReasoningModePassthrough},
+		{"无效的请求头使用请求体", "invalid", `{"reasoning_format": "hidden"}`, "", ReasoningModeStrip},
+		{"客户端配置", "", `{}`, "sk-client-think", ReasoningModeThink},
+		{"默认配置", "", `{}`, "sk-other", ReasoningModeStrip},
+		{"请求体无法解析时使用默认配置", "", `{`, "", ReasoningModeStrip},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Request = c.Request.WithContext(key.WithClientKey(c.Request.Context(), tt.clientKey))
+		if tt.header != "" {
+			c.Request.Header.Set(reasoningFormatHeader, tt.header)
+		}
+		if got := resolveReasoningMode(c, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s: resolveReasoningMode = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := reasoningModeFromContext(c); got != tt.want {
+			t.Errorf("%s: 上下文中的返回方式 = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 配置无效时原样返回
+	cfg.Reasoning.Mode = "invalid"
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	if got := resolveReasoningMode(c, []byte(`{}`)); got != ReasoningModePassthrough {
+		t.Errorf("配置无效时 resolveReasoningMode = %q, want passthrough", got)
+	}
+}
+
+func TestApplyReasoningModeToBody(t *testing.T) {
+	body := `{"choices": [{"index": 0, "message": {"role": "assistant", "reasoning_content": "先想一想", "content": "答案"}},` +
+		` {"index": 1, "message": {"role": "assistant", "reasoning": "另一种", "content": "答案2"}}]}`
+
+	tests := []struct {
+		name string
+		body string
+		mode string
+		want string
+	}{
+		{"原样返回", body, ReasoningModePassthrough, body},
+		{"移除推理内容", body, ReasoningModeStrip,
+			`{"choices":[{"index":0,"message":{"content":"答案","role":"assistant"}},{"index":1,"message":{"content":"答案2","role":"assistant"}}]}`},
+		{"以think标签合并", body, ReasoningModeThink,
+			`{"choices":[{"index":0,"message":{"content":"<think>\n先想一想\n</think>\n\n答案","role":"assistant"}},{"index":1,"message":{"content":"<think>\n另一种\n</think>\n\n答案2","role":"assistant"}}]}`},
+		{"没有推理内容时保持不变", `{"choices": [{"message": {"content": "答案"}}]}`, ReasoningModeThink, `{"choices": [{"message": {"content": "答案"}}]}`},
+		{"空的推理内容只移除字段", `{"choices":[{"message":{"content":"答案","reasoning_content":""}}]}`, ReasoningModeThink, `{"choices":[{"message":{"content":"答案"}}]}`},
+		{"无效的JSON保持不变", `not json`, ReasoningModeStrip, `not json`},
+	}
+	for _, tt := range tests {
+		if got := string(applyReasoningModeToBody([]byte(tt.body), tt.mode)); got != tt.want {
+			t.Errorf("%s: applyReasoningModeToBody = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReasoningStreamState(t *testing.T) {
+	events := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"","reasoning_content":"先"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"","reasoning_content":"想一想"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"答案","reasoning_content":null}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"。"}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"total_tokens":10}}`,
+	}
+
+	tests := []struct {
+		mode string
+		want string // 所有事件中content的拼接
+	}{
+		{ReasoningModePassthrough, "答案。"},
+		{ReasoningModeStrip, "答案。"},
+		{ReasoningModeThink, "<think>\n先想一想\n</think>\n\n答案。"},
+	}
+	for _, tt := range tests {
+		state := newReasoningStreamState(tt.mode)
+		var content strings.Builder
+		for _, event := range events {
+			data := string(state.transformEvent([]byte(event)))
+			if tt.mode != ReasoningModePassthrough && strings.Contains(data, "reasoning_content") {
+				t.Errorf("%s: 事件中仍包含推理内容: %s", tt.mode, data)
+			}
+			if tt.mode == ReasoningModePassthrough && data != event {
+				t.Errorf("%s: 事件被修改: %s", tt.mode, data)
+			}
+			content.WriteString(streamDeltaContent(t, data))
+		}
+		if got := content.String(); got != tt.want {
+			t.Errorf("%s: 拼接的content = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+
+	// 推理内容一直持续到结束时，在finish_reason处关闭标签
+	state := newReasoningStreamState(ReasoningModeThink)
+	var content strings.Builder
+	for _, event := range []string{events[0], events[1], events[4]} {
+		content.WriteString(streamDeltaContent(t, string(state.transformEvent([]byte(event)))))
+	}
+	if got := content.String(); got != "<think>\n先想一想\n</think>\n\n" {
+		t.Errorf("没有正文时 content = %q", got)
+	}
+}
+
+// streamDeltaContent 获取流式事件中第一个选项的增量内容
+func streamDeltaContent(t *testing.T, data string) string {
+	t.Helper()
+	var event struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("解析事件失败: %v: %s", err, data)
+	}
+	if len(event.Choices) == 0 {
+		return ""
+	}
+	return event.Choices[0].Delta.Content
+}
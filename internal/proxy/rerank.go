@@ -0,0 +1,270 @@
+/**
+  @author: Hanhai
+  @desc: 重排序请求的格式转换，接受Cohere和Jina格式的请求，转换为硅基流动格式后发送，并返回兼容这些格式的响应
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 重排序请求体的最大读取长度
+const maxRerankBodySize = 16 << 20
+
+// rerankResponse 硅基流动重排序接口的响应
+type rerankResponse struct {
+	ID      string `json:"id"`
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+	Tokens struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"tokens"`
+}
+
+// isRerankPath 判断是否为重排序请求，包括Cohere格式的 /v2/rerank
+func isRerankPath(path string) bool {
+	return strings.HasSuffix(path, "/rerank")
+}
+
+// isCohereV2RerankPath 判断是否为Cohere v2格式的重排序请求
+func isCohereV2RerankPath(path string) bool {
+	return strings.HasPrefix(path, "/v2/")
+}
+
+// handleRerank 处理重排序请求
+// 请求中的文档可以是字符串或对象（Cohere的rank_fields和Jina的{"text": ...}），查询可以是字符串或{"text": ...}，
+// 转换为硅基流动的字符串格式后发送；响应同时包含Cohere的meta、Jina的model和usage以及硅基流动的tokens字段，
+// return_documents时按索引返回原始文档，Cohere v2接口默认不返回文档
+func handleRerank(c *gin.Context, targetURL string) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRerankBodySize+1))
+	if err != nil || len(bodyBytes) > maxRerankBodySize {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "读取请求体失败或请求体过大")
+		return
+	}
+
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Request body is empty or invalid JSON")
+		return
+	}
+
+	query, err := rerankQueryText(requestData["query"])
+	if err != nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	documents, ok := requestData["documents"].([]interface{})
+	if !ok || len(documents) == 0 {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Documents field is required for rerank requests")
+		return
+	}
+	rankFields := rerankRankFields(requestData["rank_fields"])
+	texts := make([]string, len(documents))
+	for i, document := range documents {
+		text, err := rerankDocumentText(document, rankFields)
+		if err != nil {
+			writeProxyError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("documents[%d]: %v", i, err))
+			return
+		}
+		texts[i] = text
+	}
+
+	upstreamRequest := map[string]interface{}{
+		"query":     query,
+		"documents": texts,
+	}
+	for _, field := range []string{"model", "top_n", "return_documents", "max_chunks_per_doc", "overlap_tokens"} {
+		if value, ok := requestData[field]; ok {
+			upstreamRequest[field] = value
+		}
+	}
+	if _, ok := upstreamRequest["return_documents"]; !ok && isCohereV2RerankPath(c.Request.URL.Path) {
+		upstreamRequest["return_documents"] = false
+	}
+
+	// 应用转换规则，填充默认模型和参数
+	upstreamBody, err := json.Marshal(upstreamRequest)
+	if err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	transformedBody, err := TransformRequestBody(upstreamBody, "/rerank", key.ClientKeyFromContext(c.Request.Context()))
+	if err != nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var transformed map[string]interface{}
+	if err := json.Unmarshal(transformedBody, &transformed); err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	modelName, _ := transformed["model"].(string)
+	if modelName != "" && isModelDisabled(modelName) {
+		writeProxyError(c, http.StatusForbidden, "invalid_request_error", fmt.Sprintf("模型 %s 已被禁用", modelName))
+		return
+	}
+
+	// 文档由本服务按索引返回，上游不必返回文档内容
+	returnDocuments, _ := transformed["return_documents"].(bool)
+	transformed["return_documents"] = false
+	if topN, ok := transformed["top_n"].(float64); ok && int(topN) > len(texts) {
+		transformed["top_n"] = len(texts)
+	}
+	if transformedBody, err = json.Marshal(transformed); err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	tokenEstimate := utils.EstimateStringTokens(query)
+	for _, text := range texts {
+		tokenEstimate += utils.EstimateStringTokens(text)
+	}
+
+	var response rerankResponse
+	_, failure, err := sendUpstreamJSON(c.Request.Context(), targetURL, transformedBody, "rerank", modelName, tokenEstimate, func(respBody []byte) (int, int, error) {
+		if err := json.Unmarshal(respBody, &response); err != nil {
+			return 0, 0, fmt.Errorf("解析重排序响应失败: %v", err)
+		}
+		for _, result := range response.Results {
+			if result.Index < 0 || result.Index >= len(documents) {
+				return 0, 0, fmt.Errorf("重排序响应中的文档索引无效: %d", result.Index)
+			}
+		}
+		if response.Tokens.InputTokens == 0 && response.Tokens.OutputTokens == 0 {
+			response.Tokens.InputTokens = tokenEstimate
+		}
+		return response.Tokens.InputTokens, response.Tokens.OutputTokens, nil
+	})
+	if err != nil {
+		writeUpstreamError(c, failure, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, buildRerankResponse(response, modelName, documents, returnDocuments))
+	if modelName != "" {
+		go updateModelCallCount(modelName)
+	}
+}
+
+// rerankQueryText 获取查询文本，支持字符串和Jina的{"text": ...}格式
+func rerankQueryText(query interface{}) (string, error) {
+	switch value := query.(type) {
+	case string:
+		if value != "" {
+			return value, nil
+		}
+	case map[string]interface{}:
+		if text, ok := value["text"].(string); ok && text != "" {
+			return text, nil
+		}
+		if _, ok := value["image"]; ok {
+			return "", errors.New("不支持图片查询")
+		}
+	}
+	return "", errors.New("Query field is required for rerank requests")
+}
+
+// rerankRankFields 获取Cohere格式中参与排序的文档字段
+func rerankRankFields(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	var fields []string
+	for _, item := range items {
+		if field, ok := item.(string); ok && field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// rerankDocumentText 将文档转换为文本
+// 对象文档指定了rank_fields时按"字段: 值"逐行拼接，否则使用text字段，没有text字段时使用对象的JSON
+func rerankDocumentText(document interface{}, rankFields []string) (string, error) {
+	switch value := document.(type) {
+	case string:
+		return value, nil
+	case map[string]interface{}:
+		if len(rankFields) > 0 {
+			lines := make([]string, 0, len(rankFields))
+			for _, field := range rankFields {
+				if fieldValue, ok := value[field]; ok {
+					lines = append(lines, fmt.Sprintf("%s: %v", field, fieldValue))
+				}
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		if text, ok := value["text"].(string); ok {
+			return text, nil
+		}
+		if _, ok := value["image"]; ok {
+			return "", errors.New("不支持图片文档")
+		}
+		data, err := json.Marshal(value)
+		return string(data), err
+	}
+	return "", errors.New("文档必须是字符串或对象")
+}
+
+// buildRerankResponse 构建同时兼容Cohere、Jina和硅基流动格式的响应，结果按相关性从高到低排序
+func buildRerankResponse(response rerankResponse, modelName string, documents []interface{}, returnDocuments bool) gin.H {
+	sort.SliceStable(response.Results, func(i, j int) bool {
+		return response.Results[i].RelevanceScore > response.Results[j].RelevanceScore
+	})
+
+	results := make([]gin.H, 0, len(response.Results))
+	for _, result := range response.Results {
+		item := gin.H{
+			"index":           result.Index,
+			"relevance_score": result.RelevanceScore,
+		}
+		if returnDocuments {
+			// 字符串文档按Cohere和Jina的格式包装为{"text": ...}，对象文档原样返回
+			if text, ok := documents[result.Index].(string); ok {
+				item["document"] = gin.H{"text": text}
+			} else {
+				item["document"] = documents[result.Index]
+			}
+		}
+		results = append(results, item)
+	}
+
+	totalTokens := response.Tokens.InputTokens + response.Tokens.OutputTokens
+	logger.Info("重排序完成: 模型: %s, 文档数: %d, 返回结果数: %d", modelName, len(documents), len(results))
+	return gin.H{
+		"id":      response.ID,
+		"model":   modelName,
+		"results": results,
+		"meta": gin.H{
+			"api_version": gin.H{"version": "2"},
+			"billed_units": gin.H{
+				"search_units": 1,
+				"input_tokens": response.Tokens.InputTokens,
+			},
+		},
+		"usage": gin.H{
+			"prompt_tokens": response.Tokens.InputTokens,
+			"total_tokens":  totalTokens,
+		},
+		"tokens": gin.H{
+			"input_tokens":  response.Tokens.InputTokens,
+			"output_tokens": response.Tokens.OutputTokens,
+		},
+	}
+}
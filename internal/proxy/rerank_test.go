@@ -0,0 +1,177 @@
+/**
+  @author: Hanhai
+  @desc: Cohere和Jina格式重排序请求与响应转换的测试
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRerankQueryText(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"字符串查询", `"什么是大模型"`, "什么是大模型", false},
+		{"Jina格式的text对象", `{"text": "什么是大模型"}`, "什么是大模型", false},
+		{"Jina格式的图片查询", `{"image": "https://example.com/a.png"}`, "", true},
+		{"空字符串", `""`, "", true},
+		{"缺少查询", `null`, "", true},
+		{"数字查询", `42`, "", true},
+	}
+	for _, tt := range tests {
+		var query interface{}
+		json.Unmarshal([]byte(tt.query), &query)
+		got, err := rerankQueryText(query)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: rerankQueryText = %q, %v, want %q, 错误 %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRerankDocumentText(t *testing.T) {
+	tests := []struct {
+		name       string
+		document   string
+		rankFields string
+		want       string
+		wantErr    bool
+	}{
+		{"字符串文档", `"文档内容"`, `null`, "文档内容", false},
+		{"Jina格式的text对象", `{"text": "文档内容"}`, `null`, "文档内容", false},
+		{"Cohere的rank_fields按顺序拼接", `{"title": "标题", "body": "正文", "id": 1}`, `["title", "body"]`, "title: 标题\nbody: 正文", false},
+		{"rank_fields中缺少的字段被跳过", `{"title": "标题"}`, `["title", "body"]`, "title: 标题", false},
+		{"rank_fields中的非字符串项被忽略", `{"title": "标题", "text": "正文"}`, `[1, ""]`, "正文", false},
+		{"没有text字段时使用JSON", `{"id": 1, "title": "标题"}`, `null`, `{"id":1,"title":"标题"}`, false},
+		{"Jina格式的图片文档", `{"image": "https://example.com/a.png"}`, `null`, "", true},
+		{"数字文档", `42`, `null`, "", true},
+	}
+	for _, tt := range tests {
+		var document, rankFields interface{}
+		json.Unmarshal([]byte(tt.document), &document)
+		json.Unmarshal([]byte(tt.rankFields), &rankFields)
+		got, err := rerankDocumentText(document, rerankRankFields(rankFields))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: rerankDocumentText = %q, %v, want %q, 错误 %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildRerankResponse(t *testing.T) {
+	logger.InitLogger()
+	var response rerankResponse
+	json.Unmarshal([]byte(`{
+		"id": "rerank-1",
+		"results": [{"index": 0, "relevance_score": 0.1}, {"index": 2, "relevance_score": 0.9}, {"index": 1, "relevance_score": 0.5}],
+		"tokens": {"input_tokens": 30, "output_tokens": 0}
+	}`), &response)
+	var documents []interface{}
+	json.Unmarshal([]byte(`["文档A", {"title": "文档B"}, {"text": "文档C"}]`), &documents)
+
+	tests := []struct {
+		name            string
+		returnDocuments bool
+		wantDocuments   []interface{}
+	}{
+		{"不返回文档", false, []interface{}{nil, nil, nil}},
+		// 字符串文档包装为{"text": ...}，对象文档原样返回
+		{"按索引返回原始文档", true, []interface{}{
+			map[string]interface{}{"text": "文档C"},
+			map[string]interface{}{"title": "文档B"},
+			map[string]interface{}{"text": "文档A"},
+		}},
+	}
+	for _, tt := range tests {
+		data, _ := json.Marshal(buildRerankResponse(response, "BAAI/bge-reranker-v2-m3", documents, tt.returnDocuments))
+		var got struct {
+			ID      string `json:"id"`
+			Model   string `json:"model"`
+			Results []struct {
+				Index          int         `json:"index"`
+				RelevanceScore float64     `json:"relevance_score"`
+				Document       interface{} `json:"document"`
+			} `json:"results"`
+			Meta struct {
+				BilledUnits struct {
+					SearchUnits int `json:"search_units"`
+					InputTokens int `json:"input_tokens"`
+				} `json:"billed_units"`
+			} `json:"meta"`
+			Usage struct {
+				PromptTokens int `json:"prompt_tokens"`
+				TotalTokens  int `json:"total_tokens"`
+			} `json:"usage"`
+			Tokens struct {
+				InputTokens int `json:"input_tokens"`
+			} `json:"tokens"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: 解析响应失败: %v", tt.name, err)
+		}
+
+		// 结果按相关性从高到低排序
+		var indexes []int
+		for i, result := range got.Results {
+			indexes = append(indexes, result.Index)
+			if !reflect.DeepEqual(result.Document, tt.wantDocuments[i]) {
+				t.Errorf("%s: results[%d].document = %v, want %v", tt.name, i, result.Document, tt.wantDocuments[i])
+			}
+		}
+		if !reflect.DeepEqual(indexes, []int{2, 1, 0}) {
+			t.Errorf("%s: 结果顺序 = %v, want [2 1 0]", tt.name, indexes)
+		}
+
+		// 同时包含Cohere、Jina和硅基流动格式的用量字段
+		if got.ID != "rerank-1" || got.Model != "BAAI/bge-reranker-v2-m3" {
+			t.Errorf("%s: id = %s, model = %s", tt.name, got.ID, got.Model)
+		}
+		if got.Meta.BilledUnits.SearchUnits != 1 || got.Meta.BilledUnits.InputTokens != 30 {
+			t.Errorf("%s: Cohere的meta不正确: %+v", tt.name, got.Meta)
+		}
+		if got.Usage.PromptTokens != 30 || got.Usage.TotalTokens != 30 {
+			t.Errorf("%s: Jina的usage不正确: %+v", tt.name, got.Usage)
+		}
+		if got.Tokens.InputTokens != 30 {
+			t.Errorf("%s: tokens不正确: %+v", tt.name, got.Tokens)
+		}
+	}
+}
+
+func TestHandleRerankInvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"无效的JSON", `{`, "invalid JSON"},
+		{"缺少查询", `{"documents": ["a"]}`, "Query field is required"},
+		{"缺少文档", `{"query": "q"}`, "Documents field is required"},
+		{"空文档列表", `{"query": "q", "documents": []}`, "Documents field is required"},
+		{"不支持的文档类型", `{"query": "q", "documents": ["a", 1]}`, "documents[1]"},
+		{"图片查询", `{"query": {"image": "https://example.com/a.png"}, "documents": ["a"]}`, "不支持图片查询"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v2/rerank", strings.NewReader(tt.body))
+
+		handleRerank(c, "http://127.0.0.1:0/v1/rerank")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.wantErr) {
+			t.Errorf("%s: 响应 = %d %s, want 400 包含 %q", tt.name, w.Code, w.Body.String(), tt.wantErr)
+		}
+	}
+}
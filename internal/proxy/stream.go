@@ -0,0 +1,127 @@
+/**
+  @author: Hanhai
+  @desc: 流式响应的辅助处理，包括逐行读取上游事件、保活间隔配置和按已收到内容统计token用量
+**/
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/pkg/utils"
+	"time"
+)
+
+// 未配置时的保活注释间隔
+const defaultStreamKeepaliveInterval = 15 * time.Second
+
+// streamLine 从上游读取的一行SSE数据，读取结束或出错时err不为空
+type streamLine struct {
+	data []byte
+	err  error
+}
+
+// readStreamLines 逐行读取上游响应并发送到lines，stop关闭后停止发送
+// 读取在单独的协程中进行，等待上游数据时不会阻塞保活注释和客户端断开的处理
+func readStreamLines(reader *bufio.Reader, lines chan<- streamLine, stop <-chan struct{}) {
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			select {
+			case lines <- streamLine{data: data}:
+			case <-stop:
+				return
+			}
+		}
+		if err != nil {
+			select {
+			case lines <- streamLine{err: err}:
+			case <-stop:
+			}
+			return
+		}
+	}
+}
+
+// streamKeepaliveInterval 获取流式响应的保活注释间隔，返回0表示不发送
+func streamKeepaliveInterval() time.Duration {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return defaultStreamKeepaliveInterval
+	}
+	if cfg.Streaming.KeepaliveInterval < 0 {
+		return 0
+	}
+	if cfg.Streaming.KeepaliveInterval == 0 {
+		return defaultStreamKeepaliveInterval
+	}
+	return time.Duration(cfg.Streaming.KeepaliveInterval) * time.Second
+}
+
+// streamUsage 流式响应的token用量，优先使用上游返回的usage，没有时按已收到的内容估算
+type streamUsage struct {
+	promptTokens        int // 上游usage中的prompt_tokens
+	completionTokens    int // 上游usage中的completion_tokens
+	hasUsage            bool
+	estimatedCompletion int // 按已收到的正文、推理内容和工具调用参数估算的输出token
+}
+
+// observe 统计一个流式事件，需要在移除推理内容之前调用，使推理内容也计入输出token
+func (u *streamUsage) observe(data []byte) {
+	var event struct {
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Choices []struct {
+			Text  string `json:"text"`
+			Delta struct {
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"`
+				Reasoning        string `json:"reasoning"`
+				ToolCalls        []struct {
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+
+	// 部分上游在每个事件中返回累计的usage，以最后一次为准
+	if event.Usage != nil && (event.Usage.PromptTokens > 0 || event.Usage.CompletionTokens > 0) {
+		u.promptTokens = event.Usage.PromptTokens
+		u.completionTokens = event.Usage.CompletionTokens
+		u.hasUsage = true
+	}
+	for _, choice := range event.Choices {
+		text := choice.Text + choice.Delta.Content + choice.Delta.ReasoningContent + choice.Delta.Reasoning
+		for _, toolCall := range choice.Delta.ToolCalls {
+			text += toolCall.Function.Name + toolCall.Function.Arguments
+		}
+		if text != "" {
+			u.estimatedCompletion += utils.EstimateStringTokens(text)
+		}
+	}
+}
+
+// result 返回最终计入统计的prompt和completion token数以及数据来源
+// 流未完整结束时上游的usage可能只统计到中途，输出token取其与估算值中较大的一个
+func (u *streamUsage) result(promptEstimate int, completed bool) (int, int, string) {
+	if !u.hasUsage {
+		return promptEstimate, u.estimatedCompletion, "估算"
+	}
+	promptTokens := u.promptTokens
+	if promptTokens == 0 {
+		promptTokens = promptEstimate
+	}
+	if !completed && u.estimatedCompletion > u.completionTokens {
+		return promptTokens, u.estimatedCompletion, "上游usage和估算"
+	}
+	return promptTokens, u.completionTokens, "上游usage"
+}
@@ -0,0 +1,222 @@
+/**
+  @author: Hanhai
+  @desc: 流式响应的保活注释、客户端断开时取消上游和按已收到内容统计用量的测试
+**/
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dailyStreamStats 获取今天的成功、失败请求数和指定模型的token数
+func dailyStreamStats(t *testing.T, model string) (success, failed, tokens int) {
+	t.Helper()
+	stats, err := config.GetDailyStats("")
+	if err != nil {
+		t.Fatalf("获取每日统计失败: %v", err)
+	}
+	if stats == nil {
+		return 0, 0, 0
+	}
+	return stats.Requests.Success, stats.Requests.Failed, stats.Models[model].Tokens
+}
+
+// setStreamTestConfig 使用指定的保活间隔（秒）
+func setStreamTestConfig(t *testing.T, keepaliveInterval int) {
+	t.Helper()
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	cfg := &config.Config{}
+	cfg.Streaming.KeepaliveInterval = keepaliveInterval
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+}
+
+func TestStreamKeepaliveInterval(t *testing.T) {
+	tests := []struct {
+		configured int
+		want       time.Duration
+	}{
+		{0, defaultStreamKeepaliveInterval}, // 旧版本的配置中没有该项
+		{5, 5 * time.Second},
+		{-1, 0},
+	}
+	for _, tt := range tests {
+		setStreamTestConfig(t, tt.configured)
+		if got := streamKeepaliveInterval(); got != tt.want {
+			t.Errorf("streamKeepaliveInterval(%d) = %v, want %v", tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestStreamUsageResult(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         []string
+		completed      bool
+		wantPrompt     int
+		wantCompletion int
+	}{
+		{"没有usage时按内容估算", []string{`{"choices":[{"delta":{"content":"hello world"}}]}`}, true, 7, utils.EstimateStringTokens("hello world")},
+		{"以最后一次usage为准", []string{
+			`{"choices":[{"delta":{"content":"a"}}],"usage":{"prompt_tokens":12,"completion_tokens":1}}`,
+			`{"choices":[{"delta":{"content":"b"}}],"usage":{"prompt_tokens":12,"completion_tokens":30}}`,
+		}, true, 12, 30},
+		{"usage中没有prompt_tokens时使用估算", []string{`{"usage":{"completion_tokens":30}}`}, true, 7, 30},
+		{"未完成时取usage和估算中较大的输出token", []string{
+			`{"choices":[{"delta":{"content":"a"}}],"usage":{"prompt_tokens":12,"completion_tokens":0}}`,
+			`{"choices":[{"delta":{"reasoning_content":"` + strings.Repeat("think ", 20) + `"}}]}`,
+		}, false, 12, utils.EstimateStringTokens("a") + utils.EstimateStringTokens(strings.Repeat("think ", 20))},
+	}
+	for _, tt := range tests {
+		usage := &streamUsage{}
+		for _, event := range tt.events {
+			usage.observe([]byte(event))
+		}
+		prompt, completion, _ := usage.result(7, tt.completed)
+		if prompt != tt.wantPrompt || completion != tt.wantCompletion {
+			t.Errorf("%s: result = %d, %d, want %d, %d", tt.name, prompt, completion, tt.wantPrompt, tt.wantCompletion)
+		}
+	}
+}
+
+func TestHandleStreamResponseKeepalive(t *testing.T) {
+	setStreamTestConfig(t, 1)
+	const model = "test/stream-keepalive"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		// 上游沉默超过保活间隔
+		time.Sleep(1500 * time.Millisecond)
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	resp, err := http.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("请求上游失败: %v", err)
+	}
+	successBefore, failedBefore, tokensBefore := dailyStreamStats(t, model)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	HandleStreamResponse(c, resp.Body, "sk-stream-keepalive", []byte(`{"model": "`+model+`", "stream": true}`), 10)
+
+	body := w.Body.String()
+	first := strings.Index(body, "你好")
+	keepalive := strings.Index(body, ": keepalive\n\n")
+	finish := strings.Index(body, "finish_reason")
+	if first < 0 || keepalive < first || finish < keepalive {
+		t.Errorf("沉默期间应在两个事件之间发送保活注释: %q", body)
+	}
+	if strings.Count(body, "data: [DONE]") != 1 {
+		t.Errorf("[DONE]应只发送一次: %q", body)
+	}
+
+	success, failed, tokens := dailyStreamStats(t, model)
+	if success-successBefore != 1 || failed != failedBefore {
+		t.Errorf("完整结束的响应应计为成功: 成功 +%d, 失败 +%d", success-successBefore, failed-failedBefore)
+	}
+	if tokens-tokensBefore != 7 {
+		t.Errorf("token数 = %d, want 上游usage的7", tokens-tokensBefore)
+	}
+}
+
+func TestHandleStreamResponseClientDisconnect(t *testing.T) {
+	setStreamTestConfig(t, -1)
+	const model = "test/stream-disconnect"
+
+	upstreamCancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你\"}}],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":1}}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"好\"}}],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":40}}\n\n")
+		w.(http.Flusher).Flush()
+		// 一直生成，直到请求被取消
+		select {
+		case <-r.Context().Done():
+			close(upstreamCancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	// 与handleOpenAIStreamRequest一样，上游请求的上下文基于客户端请求
+	handlerDone := make(chan struct{})
+	router := gin.New()
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		defer close(handlerDone)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("请求上游失败: %v", err)
+			return
+		}
+		HandleStreamResponse(c, resp.Body, "sk-stream-disconnect", []byte(`{"model": "`+model+`", "stream": true}`), 10)
+	})
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	successBefore, failedBefore, tokensBefore := dailyStreamStats(t, model)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/v1/chat/completions", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求代理失败: %v", err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	for events := 0; events < 2; {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("读取流式响应失败: %v", err)
+		}
+		if strings.HasPrefix(line, "data:") {
+			events++
+		}
+	}
+
+	// 收到两个事件后客户端断开
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端断开后上游请求未被取消")
+	}
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端断开后流式处理未结束")
+	}
+
+	// 按最后一次usage记录已生成的部分，但不计为成功
+	success, failed, tokens := dailyStreamStats(t, model)
+	if success != successBefore || failed-failedBefore != 1 {
+		t.Errorf("客户端断开的响应应计为失败: 成功 +%d, 失败 +%d", success-successBefore, failed-failedBefore)
+	}
+	if tokens-tokensBefore != 52 {
+		t.Errorf("token数 = %d, want 最后一次usage的52", tokens-tokensBefore)
+	}
+}
@@ -0,0 +1,168 @@
+/**
+  @author: Hanhai
+  @desc: 结构化输出校验，按请求的json_schema校验非流式对话响应，不符合时要求模型修正
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleStructuredOutput 处理指定了json_schema响应格式的非流式对话请求
+// 响应内容不是符合Schema的JSON时，将错误反馈给模型要求修正，超过修正次数后返回错误
+// 未开启校验、流式请求或没有Schema时返回false，由调用方按普通请求处理
+func handleStructuredOutput(c *gin.Context, targetURL string, transformedBody []byte, requestType string, tokenEstimate int) bool {
+	cfg := config.GetConfig()
+	if !cfg.StructuredOutput.Enforce {
+		return false
+	}
+
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(transformedBody, &requestData); err != nil {
+		return false
+	}
+	schema := requestJSONSchema(requestData)
+	if schema == nil {
+		return false
+	}
+	if stream, _ := requestData["stream"].(bool); stream {
+		return false
+	}
+	if n, ok := requestData["n"].(float64); ok && n > 1 {
+		return false
+	}
+	modelName, _ := requestData["model"].(string)
+	messages, _ := requestData["messages"].([]interface{})
+
+	var response map[string]interface{}
+	var problems []string
+	promptTokens, completionTokens := 0, 0
+	repairs := 0
+	for {
+		body, err := json.Marshal(requestData)
+		if err != nil {
+			writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return true
+		}
+
+		_, failure, err := sendUpstreamJSON(c.Request.Context(), targetURL, body, requestType, modelName, tokenEstimate, func(respBody []byte) (int, int, error) {
+			response = nil
+			if err := json.Unmarshal(respBody, &response); err != nil {
+				return 0, 0, fmt.Errorf("解析对话响应失败: %s", string(respBody))
+			}
+			prompt, completion := extractTokenCounts(respBody)
+			promptTokens += prompt
+			completionTokens += completion
+			return prompt, completion, nil
+		})
+		if err != nil {
+			writeUpstreamError(c, failure, err)
+			return true
+		}
+
+		message := firstChoiceMessage(response)
+		if message == nil || message["tool_calls"] != nil {
+			// 模型选择调用工具时没有需要校验的内容
+			writeStructuredOutputResponse(c, response, promptTokens, completionTokens, modelName)
+			return true
+		}
+
+		content, _ := message["content"].(string)
+		var cleaned string
+		cleaned, problems = checkStructuredOutput(content, schema)
+		if len(problems) == 0 {
+			message["content"] = cleaned
+			break
+		}
+		if repairs >= cfg.StructuredOutput.MaxRepairAttempts {
+			break
+		}
+
+		repairs++
+		logger.Info("模型 %s 的响应不符合json_schema，第%d次要求修正: %s", modelName, repairs, strings.Join(problems, "; "))
+		messages = append(messages,
+			map[string]interface{}{"role": "assistant", "content": content},
+			map[string]interface{}{"role": "user", "content": "Your previous reply does not match the required JSON schema:\n- " + strings.Join(problems, "\n- ") +
+				"\nReply again with ONLY the corrected JSON, without any other text or code fences."},
+		)
+		requestData["messages"] = messages
+	}
+
+	config.AddDailySchemaStat(modelName, repairs, len(problems) == 0)
+	if len(problems) > 0 {
+		logger.Warn("模型 %s 的响应在%d次修正后仍不符合json_schema: %s", modelName, repairs, strings.Join(problems, "; "))
+		writeProxyError(c, http.StatusUnprocessableEntity, "invalid_response_format",
+			fmt.Sprintf("模型响应在%d次修正后仍不符合json_schema: %s", repairs, strings.Join(problems, "; ")))
+		return true
+	}
+
+	writeStructuredOutputResponse(c, response, promptTokens, completionTokens, modelName)
+	return true
+}
+
+// requestJSONSchema 获取请求中response_format指定的JSON Schema
+func requestJSONSchema(requestData map[string]interface{}) map[string]interface{} {
+	responseFormat, _ := requestData["response_format"].(map[string]interface{})
+	if formatType, _ := responseFormat["type"].(string); formatType != "json_schema" {
+		return nil
+	}
+	jsonSchema, _ := responseFormat["json_schema"].(map[string]interface{})
+	schema, _ := jsonSchema["schema"].(map[string]interface{})
+	return schema
+}
+
+// firstChoiceMessage 获取响应中第一个选项的消息
+func firstChoiceMessage(response map[string]interface{}) map[string]interface{} {
+	choices, _ := response["choices"].([]interface{})
+	if len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	return message
+}
+
+// checkStructuredOutput 校验响应内容，返回去除代码块标记后的JSON文本和发现的问题
+func checkStructuredOutput(content string, schema map[string]interface{}) (string, []string) {
+	cleaned := stripCodeFence(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(cleaned), &value); err != nil {
+		return cleaned, []string{fmt.Sprintf("reply is not valid JSON: %v", err)}
+	}
+	return cleaned, validateJSONSchema(value, schema)
+}
+
+// stripCodeFence 去除模型输出中包裹内容的Markdown代码块标记
+func stripCodeFence(content string) string {
+	text := strings.TrimSpace(content)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// writeStructuredOutputResponse 返回最终响应，token用量为包括修正请求在内的合计
+func writeStructuredOutputResponse(c *gin.Context, response map[string]interface{}, promptTokens, completionTokens int, modelName string) {
+	if promptTokens > 0 || completionTokens > 0 {
+		response["usage"] = gin.H{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		}
+	}
+	applyReasoningModeToResponse(response, reasoningModeFromContext(c))
+	c.JSON(http.StatusOK, response)
+	go updateModelCallCount(modelName)
+}
@@ -0,0 +1,203 @@
+/**
+  @author: Hanhai
+  @desc: 结构化输出校验和要求模型修正的测试
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStripCodeFence(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"  {\"a\": 1}\n", `{"a": 1}`},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"```\n{\"a\": 1}\n```  ", `{"a": 1}`},
+		{"说明 ```json\n{}\n```", "说明 ```json\n{}\n```"}, // 代码块之前有其他文本时不处理
+	}
+	for _, tt := range tests {
+		if got := stripCodeFence(tt.content); got != tt.want {
+			t.Errorf("stripCodeFence(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRequestJSONSchema(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantSchema bool
+	}{
+		{"json_schema格式", `{"response_format": {"type": "json_schema", "json_schema": {"name": "x", "schema": {"type": "object"}}}}`, true},
+		{"json_object格式", `{"response_format": {"type": "json_object"}}`, false},
+		{"缺少schema", `{"response_format": {"type": "json_schema", "json_schema": {"name": "x"}}}`, false},
+		{"没有response_format", `{}`, false},
+	}
+	for _, tt := range tests {
+		var data map[string]interface{}
+		json.Unmarshal([]byte(tt.body), &data)
+		if got := requestJSONSchema(data); (got != nil) != tt.wantSchema {
+			t.Errorf("%s: requestJSONSchema = %v, want schema %v", tt.name, got, tt.wantSchema)
+		}
+	}
+}
+
+func TestHandleStructuredOutputRepair(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	config.UpdateConfig(&config.Config{})
+	const apiKey = "sk-structured-output-test"
+	config.AddApiKey(apiKey, 10)
+	t.Cleanup(func() {
+		config.MarkApiKeyForDeletion(apiKey)
+		config.UpdateConfig(previous)
+	})
+
+	tests := []struct {
+		name         string
+		maxRepairs   int
+		replies      []string
+		wantStatus   int
+		wantRequests int
+		wantContent  string
+	}{
+		{"首次响应即符合Schema", 2, []string{`{"name": "Bob"}`}, http.StatusOK, 1, `{"name": "Bob"}`},
+		{"去除代码块标记", 2, []string{"```json\n{\"name\": \"Bob\"}\n```"}, http.StatusOK, 1, `{"name": "Bob"}`},
+		{"修正一次后符合", 2, []string{`not json`, `{"name": "Bob"}`}, http.StatusOK, 2, `{"name": "Bob"}`},
+		{"修正两次后符合", 2, []string{`{}`, `{"name": 1}`, `{"name": "Bob"}`}, http.StatusOK, 3, `{"name": "Bob"}`},
+		{"超过修正次数后返回错误", 1, []string{`{}`, `{}`, `{"name": "Bob"}`}, http.StatusUnprocessableEntity, 2, ""},
+		{"不修正时直接返回错误", 0, []string{`{}`}, http.StatusUnprocessableEntity, 1, ""},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.StructuredOutput.Enforce = true
+		cfg.StructuredOutput.MaxRepairAttempts = tt.maxRepairs
+		config.UpdateConfig(cfg)
+
+		var mu sync.Mutex
+		var requests []map[string]interface{}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var request map[string]interface{}
+			json.Unmarshal(body, &request)
+			mu.Lock()
+			reply := tt.replies[len(requests)]
+			requests = append(requests, request)
+			mu.Unlock()
+
+			content, _ := json.Marshal(reply)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": "chatcmpl-1", "choices": [{"index": 0, "message": {"role": "assistant", "content": ` + string(content) +
+				`}}], "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`))
+		}))
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		body := `{"model": "test/model", "messages": [{"role": "user", "content": "hi"}], "response_format": {"type": "json_schema",
+			"json_schema": {"name": "person", "schema": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}}}}`
+
+		if !handleStructuredOutput(c, upstream.URL, []byte(body), "chat", 10) {
+			t.Fatalf("%s: 指定了json_schema的请求应由结构化输出处理", tt.name)
+		}
+		upstream.Close()
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: 状态码 = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body.String())
+		}
+		if len(requests) != tt.wantRequests {
+			t.Errorf("%s: 上游请求次数 = %d, want %d", tt.name, len(requests), tt.wantRequests)
+		}
+
+		// 每次修正请求都带上之前的回复和问题说明
+		for i, request := range requests {
+			messages, _ := request["messages"].([]interface{})
+			if len(messages) != 1+2*i {
+				t.Errorf("%s: 第%d次请求的消息数 = %d, want %d", tt.name, i+1, len(messages), 1+2*i)
+				continue
+			}
+			if i > 0 {
+				assistant, _ := messages[len(messages)-2].(map[string]interface{})
+				feedback, _ := messages[len(messages)-1].(map[string]interface{})
+				if assistant["role"] != "assistant" || assistant["content"] != tt.replies[i-1] {
+					t.Errorf("%s: 第%d次请求未带上之前的回复: %v", tt.name, i+1, assistant)
+				}
+				if content, _ := feedback["content"].(string); feedback["role"] != "user" || !strings.Contains(content, "does not match the required JSON schema") {
+					t.Errorf("%s: 第%d次请求的修正说明不正确: %v", tt.name, i+1, feedback)
+				}
+			}
+		}
+
+		if tt.wantStatus != http.StatusOK {
+			if !strings.Contains(w.Body.String(), "invalid_response_format") {
+				t.Errorf("%s: 错误类型不正确: %s", tt.name, w.Body.String())
+			}
+			continue
+		}
+		var response struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: 解析响应失败: %v", tt.name, err)
+		}
+		if response.Choices[0].Message.Content != tt.wantContent {
+			t.Errorf("%s: content = %s, want %s", tt.name, response.Choices[0].Message.Content, tt.wantContent)
+		}
+		// token用量为包括修正请求在内的合计
+		if response.Usage.PromptTokens != 10*tt.wantRequests || response.Usage.CompletionTokens != 5*tt.wantRequests {
+			t.Errorf("%s: usage = %+v, want %d次请求的合计", tt.name, response.Usage, tt.wantRequests)
+		}
+	}
+}
+
+func TestHandleStructuredOutputSkipped(t *testing.T) {
+	previous := config.GetConfig()
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+	schema := `"response_format": {"type": "json_schema", "json_schema": {"schema": {"type": "object"}}}`
+
+	tests := []struct {
+		name    string
+		enforce bool
+		body    string
+	}{
+		{"未开启校验", false, `{"model": "m", ` + schema + `}`},
+		{"没有Schema", true, `{"model": "m"}`},
+		{"流式请求", true, `{"model": "m", "stream": true, ` + schema + `}`},
+		{"多个选项", true, `{"model": "m", "n": 2, ` + schema + `}`},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.StructuredOutput.Enforce = tt.enforce
+		config.UpdateConfig(cfg)
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if handleStructuredOutput(c, "http://127.0.0.1:0", []byte(tt.body), "chat", 10) {
+			t.Errorf("%s: 应按普通请求处理", tt.name)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+/**
+  @author: Hanhai
+  @desc: 为不支持原生工具调用的模型模拟工具调用，将工具定义注入提示词，解析模型输出的JSON并转换为tool_calls
+**/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/model"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 工具调用参数校验失败时，要求模型修正的最大次数
+const toolEmulationRepairAttempts = 1
+
+// 模型输出中工具调用JSON的起始位置
+var toolCallsJSONPattern = regexp.MustCompile(`\{\s*"tool_calls"\s*:`)
+
+// emulatedTool 请求中定义的工具
+type emulatedTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// emulatedToolCall 从模型输出中解析出的工具调用
+type emulatedToolCall struct {
+	ID        string
+	Name      string
+	Arguments interface{}
+}
+
+// toolChoice 请求的工具选择方式
+type toolChoice struct {
+	Mode string // none、auto或required
+	Name string // 指定调用的工具名称
+}
+
+// emulatedCompletion 模拟工具调用后的最终结果
+type emulatedCompletion struct {
+	ID               string
+	Created          int64
+	Model            string
+	Content          string
+	ToolCalls        []emulatedToolCall
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// handleToolEmulation 对开启了工具调用模拟的模型处理带工具的对话请求
+// 上游始终使用非流式请求，客户端请求流式输出时将最终结果转换为流式的增量返回
+// 不需要模拟时返回false，由调用方按普通请求处理
+func handleToolEmulation(c *gin.Context, targetURL string, originalBody []byte, transformedBody []byte, requestType string, tokenEstimate int) bool {
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(transformedBody, &requestData); err != nil {
+		return false
+	}
+	modelName, _ := requestData["model"].(string)
+	messages, _ := requestData["messages"].([]interface{})
+	if !model.IsToolEmulationEnabled(modelName) || (requestData["tools"] == nil && !hasToolMessages(messages)) {
+		return false
+	}
+
+	var clientRequest struct {
+		Stream        bool `json:"stream"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	json.Unmarshal(originalBody, &clientRequest)
+
+	tools := parseEmulatedTools(requestData["tools"])
+	choice := parseToolChoice(requestData["tool_choice"])
+	allowParallel := true
+	if parallel, ok := requestData["parallel_tool_calls"].(bool); ok {
+		allowParallel = parallel
+	}
+	if choice.Name != "" && findEmulatedTool(tools, choice.Name) == nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("tool_choice指定的工具 %s 不存在", choice.Name))
+		return true
+	}
+
+	// 移除上游不支持的工具字段，将历史中的工具调用和结果转换为普通消息
+	for _, field := range []string{"tools", "tool_choice", "parallel_tool_calls", "functions", "function_call", "stream_options"} {
+		delete(requestData, field)
+	}
+	requestData["stream"] = false
+	requestData["messages"] = convertToolMessages(messages)
+	if len(tools) > 0 && choice.Mode != "none" {
+		injectSystemPrompt(requestData, buildToolPrompt(tools, choice, allowParallel), model.SystemPromptModeAppend)
+	}
+	logger.Info("为模型 %s 模拟工具调用，工具数量: %d", modelName, len(tools))
+
+	result := &emulatedCompletion{Model: modelName}
+	for attempt := 0; ; attempt++ {
+		content, err := sendEmulatedCompletion(c, targetURL, requestData, requestType, modelName, tokenEstimate, result)
+		if err != nil {
+			return true
+		}
+
+		calls, isToolCall := parseEmulatedToolCalls(content)
+		problems := validateEmulatedToolCalls(calls, isToolCall, tools, choice, allowParallel)
+		if len(problems) == 0 {
+			if isToolCall {
+				result.ToolCalls = calls
+			} else {
+				result.Content = content
+			}
+			break
+		}
+
+		if attempt >= toolEmulationRepairAttempts {
+			logger.Warn("模型 %s 的工具调用不符合要求，按普通回复返回: %s", modelName, strings.Join(problems, "; "))
+			result.Content = content
+			break
+		}
+		logger.Info("模型 %s 的工具调用不符合要求，要求模型修正: %s", modelName, strings.Join(problems, "; "))
+		requestData["messages"] = append(requestData["messages"].([]interface{}),
+			map[string]interface{}{"role": "assistant", "content": content},
+			map[string]interface{}{"role": "user", "content": "Your tool call was invalid:\n- " + strings.Join(problems, "\n- ") +
+				"\nReply again with ONLY the corrected JSON object in the required format."},
+		)
+	}
+
+	if clientRequest.Stream {
+		writeEmulatedStream(c, result, clientRequest.StreamOptions.IncludeUsage)
+	} else {
+		writeEmulatedCompletion(c, result)
+	}
+	go updateModelCallCount(modelName)
+	return true
+}
+
+// sendEmulatedCompletion 发送非流式对话请求，返回模型输出的文本并累计token用量
+func sendEmulatedCompletion(c *gin.Context, targetURL string, requestData map[string]interface{}, requestType string, modelName string, tokenEstimate int, result *emulatedCompletion) (string, error) {
+	body, err := json.Marshal(requestData)
+	if err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return "", err
+	}
+
+	var content string
+	_, failure, err := sendUpstreamJSON(c.Request.Context(), targetURL, body, requestType, modelName, tokenEstimate, func(respBody []byte) (int, int, error) {
+		var response struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+			Model   string `json:"model"`
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(respBody, &response); err != nil || len(response.Choices) == 0 {
+			return 0, 0, fmt.Errorf("解析对话响应失败: %s", string(respBody))
+		}
+
+		content = response.Choices[0].Message.Content
+		result.ID, result.Created = response.ID, response.Created
+		if response.Model != "" {
+			result.Model = response.Model
+		}
+		result.PromptTokens += response.Usage.PromptTokens
+		result.CompletionTokens += response.Usage.CompletionTokens
+		return response.Usage.PromptTokens, response.Usage.CompletionTokens, nil
+	})
+	if err != nil {
+		writeUpstreamError(c, failure, err)
+		return "", err
+	}
+	return content, nil
+}
+
+// hasToolMessages 判断对话历史中是否包含工具调用或工具结果
+func hasToolMessages(messages []interface{}) bool {
+	for _, item := range messages {
+		message, _ := item.(map[string]interface{})
+		if role, _ := message["role"].(string); role == "tool" || role == "function" {
+			return true
+		}
+		if _, ok := message["tool_calls"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEmulatedTools 解析请求中的工具定义，只支持function类型
+func parseEmulatedTools(value interface{}) []emulatedTool {
+	items, _ := value.([]interface{})
+	tools := make([]emulatedTool, 0, len(items))
+	for _, item := range items {
+		toolData, _ := item.(map[string]interface{})
+		function, _ := toolData["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		if name == "" {
+			continue
+		}
+		description, _ := function["description"].(string)
+		parameters, _ := function["parameters"].(map[string]interface{})
+		tools = append(tools, emulatedTool{Name: name, Description: description, Parameters: parameters})
+	}
+	return tools
+}
+
+// parseToolChoice 解析tool_choice，未指定时为auto
+func parseToolChoice(value interface{}) toolChoice {
+	switch typed := value.(type) {
+	case string:
+		if typed == "none" || typed == "required" {
+			return toolChoice{Mode: typed}
+		}
+	case map[string]interface{}:
+		if function, ok := typed["function"].(map[string]interface{}); ok {
+			if name, _ := function["name"].(string); name != "" {
+				return toolChoice{Mode: "required", Name: name}
+			}
+		}
+	}
+	return toolChoice{Mode: "auto"}
+}
+
+// findEmulatedTool 按名称查找工具
+func findEmulatedTool(tools []emulatedTool, name string) *emulatedTool {
+	for i := range tools {
+		if tools[i].Name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+// buildToolPrompt 构建描述工具和调用格式的系统提示词
+func buildToolPrompt(tools []emulatedTool, choice toolChoice, allowParallel bool) string {
+	toolsJSON, _ := json.MarshalIndent(tools, "", "  ")
+
+	var prompt strings.Builder
+	prompt.WriteString("You can call the following tools:\n<tools>\n")
+	prompt.Write(toolsJSON)
+	prompt.WriteString("\n</tools>\n\n")
+	prompt.WriteString("To call tools, reply with ONLY a JSON object in exactly this format, with no other text:\n")
+	prompt.WriteString(`{"tool_calls": [{"name": "<tool name>", "arguments": {<arguments matching the tool's parameters schema>}}]}`)
+	prompt.WriteString("\n\n")
+	switch {
+	case choice.Name != "":
+		prompt.WriteString(fmt.Sprintf("You MUST call the tool \"%s\" in this reply.\n", choice.Name))
+	case choice.Mode == "required":
+		prompt.WriteString("You MUST call at least one tool in this reply.\n")
+	default:
+		prompt.WriteString("If no tool is needed, answer the user normally without any JSON.\n")
+	}
+	if !allowParallel {
+		prompt.WriteString("Call at most one tool per reply.\n")
+	}
+	prompt.WriteString("Tool results will be provided in later messages; use them to answer the user.")
+	return prompt.String()
+}
+
+// convertToolMessages 将历史中的工具调用转换为模型输出的JSON格式，将工具结果转换为用户消息
+func convertToolMessages(messages []interface{}) []interface{} {
+	toolNames := make(map[string]string)
+	converted := make([]interface{}, 0, len(messages))
+	for _, item := range messages {
+		message, ok := item.(map[string]interface{})
+		if !ok {
+			converted = append(converted, item)
+			continue
+		}
+		role, _ := message["role"].(string)
+
+		if toolCalls, ok := message["tool_calls"].([]interface{}); ok && role == "assistant" {
+			calls := make([]map[string]interface{}, 0, len(toolCalls))
+			for _, callItem := range toolCalls {
+				call, _ := callItem.(map[string]interface{})
+				function, _ := call["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				if id, _ := call["id"].(string); id != "" {
+					toolNames[id] = name
+				}
+				var arguments interface{} = map[string]interface{}{}
+				if argumentsText, ok := function["arguments"].(string); ok && argumentsText != "" {
+					json.Unmarshal([]byte(argumentsText), &arguments)
+				}
+				calls = append(calls, map[string]interface{}{"name": name, "arguments": arguments})
+			}
+			callsJSON, _ := json.Marshal(map[string]interface{}{"tool_calls": calls})
+			content := strings.TrimSpace(messageContentText(message["content"]) + "\n" + string(callsJSON))
+			converted = append(converted, map[string]interface{}{"role": "assistant", "content": content})
+			continue
+		}
+
+		if role == "tool" || role == "function" {
+			name, _ := message["name"].(string)
+			callID, _ := message["tool_call_id"].(string)
+			if name == "" {
+				name = toolNames[callID]
+			}
+			converted = append(converted, map[string]interface{}{
+				"role":    "user",
+				"content": fmt.Sprintf("Tool result for %s (call id: %s):\n%s", name, callID, messageContentText(message["content"])),
+			})
+			continue
+		}
+
+		converted = append(converted, message)
+	}
+	return converted
+}
+
+// messageContentText 获取消息内容的文本，内容为数组时拼接其中的文本部分
+func messageContentText(content interface{}) string {
+	switch typed := content.(type) {
+	case string:
+		return typed
+	case []interface{}:
+		var parts []string
+		for _, item := range typed {
+			part, _ := item.(map[string]interface{})
+			if text, ok := part["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// parseEmulatedToolCalls 从模型输出中解析工具调用，输出不是工具调用格式时第二个返回值为false
+// 支持代码块包裹的JSON、前面带有说明文字的JSON、单个调用对象和调用数组
+func parseEmulatedToolCalls(content string) ([]emulatedToolCall, bool) {
+	text := stripCodeFence(content)
+	if !strings.HasPrefix(text, "{") && !strings.HasPrefix(text, "[") {
+		location := toolCallsJSONPattern.FindStringIndex(text)
+		if location == nil {
+			return nil, false
+		}
+		text = text[location[0]:]
+		if end := strings.LastIndex(text, "}"); end >= 0 {
+			text = text[:end+1]
+		}
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+		return nil, false
+	}
+
+	var items []interface{}
+	switch typed := parsed.(type) {
+	case map[string]interface{}:
+		if toolCalls, ok := typed["tool_calls"].([]interface{}); ok {
+			items = toolCalls
+		} else if _, ok := typed["name"].(string); ok {
+			items = []interface{}{typed}
+		}
+	case []interface{}:
+		items = typed
+	}
+	if len(items) == 0 {
+		return nil, false
+	}
+
+	calls := make([]emulatedToolCall, 0, len(items))
+	for _, item := range items {
+		callData, _ := item.(map[string]interface{})
+		if function, ok := callData["function"].(map[string]interface{}); ok {
+			callData = function
+		}
+		name, _ := callData["name"].(string)
+		if name == "" {
+			return nil, false
+		}
+		arguments, ok := callData["arguments"]
+		if !ok {
+			arguments = callData["parameters"]
+		}
+		if argumentsText, ok := arguments.(string); ok {
+			var decoded interface{}
+			if json.Unmarshal([]byte(argumentsText), &decoded) == nil {
+				arguments = decoded
+			}
+		}
+		if arguments == nil {
+			arguments = map[string]interface{}{}
+		}
+		calls = append(calls, emulatedToolCall{ID: newToolCallID(), Name: name, Arguments: arguments})
+	}
+	return calls, true
+}
+
+// validateEmulatedToolCalls 检查工具调用是否符合工具定义和tool_choice的要求，返回发现的问题
+func validateEmulatedToolCalls(calls []emulatedToolCall, isToolCall bool, tools []emulatedTool, choice toolChoice, allowParallel bool) []string {
+	if !isToolCall {
+		if choice.Mode == "required" && len(tools) > 0 {
+			return []string{"a tool call is required but the reply is not a tool call JSON"}
+		}
+		return nil
+	}
+	if len(tools) == 0 || choice.Mode == "none" {
+		return []string{"tools must not be called in this reply"}
+	}
+
+	var problems []string
+	if !allowParallel && len(calls) > 1 {
+		problems = append(problems, "only one tool call is allowed")
+	}
+	for _, call := range calls {
+		tool := findEmulatedTool(tools, call.Name)
+		if tool == nil {
+			problems = append(problems, fmt.Sprintf("unknown tool %q", call.Name))
+			continue
+		}
+		if choice.Name != "" && call.Name != choice.Name {
+			problems = append(problems, fmt.Sprintf("tool %q must be called instead of %q", choice.Name, call.Name))
+		}
+		if _, ok := call.Arguments.(map[string]interface{}); !ok {
+			problems = append(problems, fmt.Sprintf("arguments of %s must be a JSON object", call.Name))
+			continue
+		}
+		if tool.Parameters != nil {
+			for _, schemaError := range validateJSONSchema(call.Arguments, tool.Parameters) {
+				problems = append(problems, fmt.Sprintf("arguments of %s: %s", call.Name, schemaError))
+			}
+		}
+	}
+	return problems
+}
+
+// newToolCallID 生成工具调用ID
+func newToolCallID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "call_" + hex.EncodeToString(buf)
+}
+
+// completionMeta 获取响应的ID和创建时间，上游未返回时生成
+func (result *emulatedCompletion) completionMeta() (string, int64) {
+	id, created := result.ID, result.Created
+	if id == "" {
+		id = "chatcmpl-" + strings.TrimPrefix(newToolCallID(), "call_")
+	}
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+	return id, created
+}
+
+// toolCallsJSON 将工具调用转换为OpenAI格式
+func (result *emulatedCompletion) toolCallsJSON() []gin.H {
+	toolCalls := make([]gin.H, 0, len(result.ToolCalls))
+	for _, call := range result.ToolCalls {
+		arguments, _ := json.Marshal(call.Arguments)
+		toolCalls = append(toolCalls, gin.H{
+			"id":   call.ID,
+			"type": "function",
+			"function": gin.H{
+				"name":      call.Name,
+				"arguments": string(arguments),
+			},
+		})
+	}
+	return toolCalls
+}
+
+// usageJSON 获取累计的token用量
+func (result *emulatedCompletion) usageJSON() gin.H {
+	return gin.H{
+		"prompt_tokens":     result.PromptTokens,
+		"completion_tokens": result.CompletionTokens,
+		"total_tokens":      result.PromptTokens + result.CompletionTokens,
+	}
+}
+
+// writeEmulatedCompletion 以非流式格式返回结果
+func writeEmulatedCompletion(c *gin.Context, result *emulatedCompletion) {
+	id, created := result.completionMeta()
+	message := gin.H{"role": "assistant", "content": result.Content}
+	finishReason := "stop"
+	if len(result.ToolCalls) > 0 {
+		message["content"] = nil
+		message["tool_calls"] = result.toolCallsJSON()
+		finishReason = "tool_calls"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   result.Model,
+		"choices": []gin.H{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": finishReason,
+		}},
+		"usage": result.usageJSON(),
+	})
+}
+
+// writeEmulatedStream 以流式格式返回结果，工具调用按OpenAI的增量格式逐个返回
+func writeEmulatedStream(c *gin.Context, result *emulatedCompletion, includeUsage bool) {
+	id, created := result.completionMeta()
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	writeChunk := func(delta gin.H, finishReason interface{}) {
+		chunk := gin.H{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   result.Model,
+			"choices": []gin.H{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		c.Writer.Flush()
+	}
+
+	writeChunk(gin.H{"role": "assistant", "content": ""}, nil)
+	finishReason := "stop"
+	if len(result.ToolCalls) > 0 {
+		for i, toolCall := range result.toolCallsJSON() {
+			function := toolCall["function"].(gin.H)
+			writeChunk(gin.H{"tool_calls": []gin.H{{
+				"index":    i,
+				"id":       toolCall["id"],
+				"type":     "function",
+				"function": gin.H{"name": function["name"], "arguments": ""},
+			}}}, nil)
+			writeChunk(gin.H{"tool_calls": []gin.H{{
+				"index":    i,
+				"function": gin.H{"arguments": function["arguments"]},
+			}}}, nil)
+		}
+		finishReason = "tool_calls"
+	} else if result.Content != "" {
+		writeChunk(gin.H{"content": result.Content}, nil)
+	}
+	writeChunk(gin.H{}, finishReason)
+
+	if includeUsage {
+		data, _ := json.Marshal(gin.H{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   result.Model,
+			"choices": []gin.H{},
+			"usage":   result.usageJSON(),
+		})
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
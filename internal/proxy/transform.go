@@ -34,7 +34,9 @@ func isReasonModel(modelName string) bool {
 }
 
 // TransformRequestBody 转换请求体，处理OpenAI和硅基流动API之间的差异
-func TransformRequestBody(body []byte, path string) ([]byte, error) {
+// 字段的默认值和调整由模型数据库中的转换规则完成，这里只做必要字段检查和格式转换
+// clientKey为客户端使用的API密钥，用于查找绑定到客户端的提示词模板
+func TransformRequestBody(body []byte, path string, clientKey string) ([]byte, error) {
 	// 如果请求体为空，直接返回
 	if len(body) == 0 {
 		return body, nil
@@ -46,108 +48,34 @@ func TransformRequestBody(body []byte, path string) ([]byte, error) {
 		return nil, err
 	}
 
-	// 对于无版本号路径，确保path与标准格式兼容
-	// 无版本号路径可能只有/chat而不是/chat/completions
-	pathForCheck := path
-	if strings.HasPrefix(path, "/chat") && !strings.Contains(path, "/completions") {
-		// 将/chat请求视为/chat/completions
-		pathForCheck = "/chat/completions"
-		logger.Info("检测到/chat路径请求，将被视为/chat/completions")
-	}
+	// 对于无版本号路径，/chat 视为 /chat/completions
+	endpoint := requestEndpoint(path)
+
+	// 按模型和接口应用转换规则
+	ApplyTransformRules(requestData, endpoint)
 
-	// 处理chat/completions请求
-	if strings.Contains(pathForCheck, "/chat/completions") {
+	switch endpoint {
+	case "chat/completions":
 		// 检查是否有messages字段
 		if _, hasMessages := requestData["messages"]; !hasMessages {
 			logger.Error("chat/completions请求缺少messages字段")
 			return nil, fmt.Errorf("message field is required")
 		}
 
-		// 检查是否有model字段
-		if model, ok := requestData["model"].(string); ok {
-			// 检查是否是推理模型
-			if isReasonModel(model) {
-				// 检查并设置合适的max_tokens值
-				if maxTokens, exists := requestData["max_tokens"]; !exists {
-					// 如果未设置max_tokens，设置默认值16000
-					requestData["max_tokens"] = 16000
-					logger.Info("为推理模型%s自动设置max_tokens=16000", model)
-				} else if maxTokenValue, ok := maxTokens.(float64); ok && maxTokenValue < 1000 {
-					// 如果设置了但值太小，调整到更合理的值
-					requestData["max_tokens"] = 16000
-					logger.Info("推理模型%s检测到过小的max_tokens值(%v)，自动调整为16000", model, maxTokenValue)
-				}
+		// 注入绑定到模型或客户端的提示词模板
+		ApplyPromptTemplates(requestData, clientKey)
 
-				// 确保流式输出
-				if stream, exists := requestData["stream"]; !exists || stream != true {
-					requestData["stream"] = true
-					logger.Info("为推理模型%s强制启用流式输出(stream=true)", model)
-				}
+		// 推理内容的返回方式由本服务处理，不发送到上游
+		delete(requestData, "reasoning_format")
 
-				// 添加足够的超时时间
-				requestData["timeout"] = 3600 // 60分钟
-				logger.Info("为推理模型%s设置API超时时间为60分钟", model)
-			}
-		} else {
-			// 如果没有提供模型，使用默认模型
-			requestData["model"] = "GLM-4"
-		}
-	}
-
-	// 处理completions请求
-	if (strings.Contains(pathForCheck, "/completions") && !strings.Contains(pathForCheck, "/chat/completions")) ||
-		(pathForCheck == "/completions") {
+	case "completions":
 		// 检查是否有prompt字段
 		if _, hasPrompt := requestData["prompt"]; !hasPrompt {
 			logger.Error("completions请求缺少prompt字段")
 			return nil, fmt.Errorf("prompt field is required")
 		}
 
-		// 检查是否有model字段
-		if model, ok := requestData["model"].(string); ok {
-			// 检查是否是推理模型
-			if isReasonModel(model) {
-				// 检查并设置合适的max_tokens值
-				if maxTokens, exists := requestData["max_tokens"]; !exists {
-					// 如果未设置max_tokens，设置默认值16000
-					requestData["max_tokens"] = 16000
-					logger.Info("为推理模型%s自动设置max_tokens=16000", model)
-				} else if maxTokenValue, ok := maxTokens.(float64); ok && maxTokenValue < 1000 {
-					// 如果设置了但值太小，调整到更合理的值
-					requestData["max_tokens"] = 16000
-					logger.Info("推理模型%s检测到过小的max_tokens值(%v)，自动调整为16000", model, maxTokenValue)
-				}
-
-				// 确保流式输出
-				if stream, exists := requestData["stream"]; !exists || stream != true {
-					requestData["stream"] = true
-					logger.Info("为推理模型%s强制启用流式输出(stream=true)", model)
-				}
-
-				// 添加足够的超时时间
-				requestData["timeout"] = 3600 // 60分钟
-				logger.Info("为推理模型%s设置API超时时间为60分钟", model)
-			}
-		} else {
-			// 如果没有提供模型，使用默认模型
-			requestData["model"] = "GLM-4"
-		}
-	}
-
-	// 处理重排序请求
-	if strings.Contains(path, "/rerank") {
-		// 记录日志，便于调试
-		logger.Info("处理重排序请求: %s", path)
-
-		// 检查是否有model字段
-		if _, ok := requestData["model"].(string); !ok {
-			// 如果没有提供模型，使用默认模型
-			requestData["model"] = "BAAI/bge-reranker-v2-m3"
-			logger.Info("未提供model字段，使用默认模型: BAAI/bge-reranker-v2-m3")
-		} else {
-			logger.Info("使用提供的模型: %s", requestData["model"])
-		}
-
+	case "rerank":
 		// 检查必要字段
 		if _, ok := requestData["query"]; !ok {
 			logger.Error("请求中缺少query字段")
@@ -159,91 +87,28 @@ func TransformRequestBody(body []byte, path string) ([]byte, error) {
 			return nil, fmt.Errorf("请求中缺少documents字段")
 		}
 
-		// 设置默认值
-		if _, ok := requestData["top_n"]; !ok {
-			requestData["top_n"] = 10
-		}
-
-		if _, ok := requestData["return_documents"]; !ok {
-			requestData["return_documents"] = true
-		}
-
-		// 记录转换后的请求体，便于调试
-		jsonData, _ := json.Marshal(requestData)
-		logger.Info("转换后的重排序请求体: %s", string(jsonData))
-
-		return json.Marshal(requestData)
-	}
-
-	// 处理图片生成请求
-	if strings.Contains(path, "/images/generations") {
-		// 记录日志，便于调试
-		logger.Info("处理图片生成请求: %s", path)
-
-		// 检查是否有model字段
-		if _, ok := requestData["model"].(string); !ok {
-			// 如果没有提供模型，使用默认模型
-			requestData["model"] = "stabilityai/stable-diffusion-xl-base-1.0"
-			logger.Info("未提供model字段，使用默认模型: stabilityai/stable-diffusion-xl-base-1.0")
-		} else {
-			logger.Info("使用提供的模型: %s", requestData["model"])
-		}
+		logger.Info("处理重排序请求: %s, 模型: %v", path, requestData["model"])
 
+	case "images/generations":
 		// 检查必要字段
 		if _, ok := requestData["prompt"]; !ok {
 			logger.Error("请求中缺少prompt字段")
 			return nil, fmt.Errorf("请求中缺少prompt字段")
 		}
 
-		if _, ok := requestData["n"]; !ok {
-			requestData["n"] = 1
-		}
+		// 上游只返回图片地址，b64_json格式在收到响应后由本服务转换
+		delete(requestData, "response_format")
 
-		if _, ok := requestData["size"]; !ok {
-			requestData["size"] = "1024x1024"
-		}
+		logger.Info("处理图片生成请求: %s, 模型: %v", path, requestData["model"])
 
-		if _, ok := requestData["guidance_scale"]; !ok {
-			requestData["guidance_scale"] = 7.5
-		}
-
-		// 删除stream字段，图片生成API不支持流式响应
-		if _, hasStream := requestData["stream"]; hasStream {
-			delete(requestData, "stream")
-			logger.Info("删除stream字段，图片生成API不支持流式响应")
-		}
-
-		// 记录转换后的请求体，便于调试
-		jsonData, _ := json.Marshal(requestData)
-		logger.Info("转换后的图片生成请求体: %s", string(jsonData))
-
-		return json.Marshal(requestData)
-	}
-
-	// 处理embeddings请求
-	if strings.Contains(path, "/embeddings") {
-		// 记录日志，便于调试
-		logger.Info("处理embeddings请求: %s", path)
-
-		// 检查是否有model字段
-		if _, ok := requestData["model"].(string); !ok {
-			// 如果没有提供模型，使用默认模型
-			requestData["model"] = "BAAI/bge-m3"
-			logger.Info("未提供model字段，使用默认模型: BAAI/bge-m3")
-		} else {
-			logger.Info("使用提供的模型: %s", requestData["model"])
-		}
+	case "embeddings":
+		logger.Info("处理embeddings请求: %s, 模型: %v", path, requestData["model"])
 
 		// 检查input字段格式
 		if input, ok := requestData["input"]; ok {
 			// 如果input是字符串，转换为字符串数组
 			if inputStr, isString := input.(string); isString {
 				requestData["input"] = []string{inputStr}
-				logger.Info("将input字符串转换为数组: [%s]", inputStr)
-			} else if inputArray, isArray := input.([]interface{}); isArray {
-				logger.Info("input是数组，长度: %d", len(inputArray))
-			} else {
-				logger.Info("input字段类型: %T", input)
 			}
 		} else {
 			logger.Error("请求中缺少input字段")
@@ -256,16 +121,9 @@ func TransformRequestBody(body []byte, path string) ([]byte, error) {
 			"model": requestData["model"],
 			"input": requestData["input"],
 		}
-
-		// 记录转换后的请求体，便于调试
-		jsonData, _ := json.Marshal(newRequestData)
-		logger.Info("转换后的embeddings请求体: %s", string(jsonData))
-
 		return json.Marshal(newRequestData)
 	}
 
-	// 不再打印转换后的请求体
-
 	// 重新序列化为JSON
 	return json.Marshal(requestData)
 }
@@ -0,0 +1,311 @@
+/**
+  @author: Hanhai
+  @desc: 请求体转换规则的执行，按模型和接口匹配规则并依次执行设置、默认值、范围限制等操作
+**/
+
+package proxy
+
+import (
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/model"
+	"fmt"
+	"strings"
+)
+
+// requestEndpoint 根据请求路径获取接口名称，如chat/completions、embeddings
+// 无版本号的 /chat 路径视为 /chat/completions
+func requestEndpoint(path string) string {
+	switch {
+	case strings.Contains(path, "/chat/completions"),
+		strings.HasPrefix(path, "/chat") && !strings.Contains(path, "/completions"):
+		return "chat/completions"
+	case strings.Contains(path, "/completions"):
+		return "completions"
+	case strings.Contains(path, "/rerank"):
+		return "rerank"
+	case strings.Contains(path, "/images/generations"):
+		return "images/generations"
+	case strings.Contains(path, "/embeddings"):
+		return "embeddings"
+	}
+
+	endpoint := strings.TrimPrefix(path, "/v1")
+	return strings.Trim(endpoint, "/")
+}
+
+// ApplyTransformRules 按执行顺序对请求数据应用匹配的转换规则
+// 每条规则按执行时的模型名匹配，前面的规则设置的默认模型会影响后面规则的匹配
+func ApplyTransformRules(requestData map[string]interface{}, endpoint string) {
+	for _, rule := range model.GetTransformRules() {
+		if !rule.Enabled {
+			continue
+		}
+		modelName, _ := requestData["model"].(string)
+		if !transformRuleMatches(rule, modelName, endpoint) {
+			continue
+		}
+
+		for _, action := range rule.Actions {
+			if applyTransformAction(requestData, action) {
+				logger.Info("转换规则 %d 对模型 %s 执行了 %s %s", rule.ID, modelName, action.Op, action.Field)
+			}
+		}
+	}
+}
+
+// transformRuleMatches 检查规则是否适用于指定模型和接口
+func transformRuleMatches(rule model.TransformRule, modelName, endpoint string) bool {
+	if len(rule.Endpoints) > 0 {
+		matched := false
+		for _, ruleEndpoint := range rule.Endpoints {
+			if strings.Trim(ruleEndpoint, "/") == endpoint {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return matchModelPattern(rule.Match, modelName)
+}
+
+// matchModelPattern 检查模型是否匹配规则中的模型写法
+// *匹配所有（包括未指定模型），以*结尾为前缀匹配，type:N按模型类型匹配，其余为不区分大小写的精确匹配
+func matchModelPattern(pattern, modelName string) bool {
+	pattern = strings.TrimSpace(pattern)
+	switch {
+	case pattern == "*":
+		return true
+	case modelName == "":
+		return false
+	case strings.HasPrefix(pattern, "type:"):
+		var modelType int
+		if _, err := fmt.Sscanf(pattern, "type:%d", &modelType); err != nil {
+			return false
+		}
+		// 推理模型沿用名称识别，未同步到数据库的DeepSeek R1系列也视为推理模型
+		if modelType == 7 {
+			return isReasonModel(modelName)
+		}
+		actualType, err := model.GetModelType(modelName)
+		return err == nil && actualType == modelType
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(strings.ToLower(modelName), strings.ToLower(strings.TrimSuffix(pattern, "*")))
+	default:
+		return strings.EqualFold(pattern, modelName)
+	}
+}
+
+// applyTransformAction 执行单个转换操作，返回请求数据是否被修改
+func applyTransformAction(data map[string]interface{}, action model.TransformAction) bool {
+	switch action.Op {
+	case model.TransformOpSet:
+		return setFieldValue(data, action.Field, action.Value)
+
+	case model.TransformOpDefault:
+		// 与之前内置的处理一致，"model": null 或非字符串的模型名同样使用默认值
+		if value, exists := getFieldValue(data, action.Field); exists && jsonKind(value) == jsonKind(action.Value) {
+			return false
+		}
+		return setFieldValue(data, action.Field, action.Value)
+
+	case model.TransformOpRemove:
+		return deleteFieldValue(data, action.Field)
+
+	case model.TransformOpClamp:
+		value, exists := getFieldValue(data, action.Field)
+		number, isNumber := value.(float64)
+		if !exists || !isNumber {
+			return false
+		}
+		outOfRange := false
+		replacement := number
+		if action.Min != nil && number < *action.Min {
+			outOfRange = true
+			replacement = *action.Min
+		}
+		if action.Max != nil && number > *action.Max {
+			outOfRange = true
+			replacement = *action.Max
+		}
+		if !outOfRange {
+			return false
+		}
+		// 指定了value时超出范围的值改为value，否则改为边界值
+		if action.Value != nil {
+			return setFieldValue(data, action.Field, action.Value)
+		}
+		return setFieldValue(data, action.Field, replacement)
+
+	case model.TransformOpCapMaxTokens:
+		if action.Max == nil {
+			return false
+		}
+		changed := false
+		for _, field := range []string{"max_tokens", "max_completion_tokens"} {
+			if value, ok := data[field].(float64); ok && value > *action.Max {
+				data[field] = *action.Max
+				changed = true
+			}
+		}
+		return changed
+
+	case model.TransformOpSystemPrompt:
+		prompt, ok := action.Value.(string)
+		if !ok || prompt == "" {
+			return false
+		}
+		return injectSystemPrompt(data, prompt, action.Mode)
+	}
+
+	return false
+}
+
+// combineSystemContent 将提示词合并到系统消息内容之前或之后，内容可以是字符串或内容数组
+func combineSystemContent(existing interface{}, prompt string, prepend bool) (interface{}, bool) {
+	switch content := existing.(type) {
+	case string:
+		if prepend {
+			return prompt + "\n\n" + content, true
+		}
+		return content + "\n\n" + prompt, true
+	case []interface{}:
+		part := map[string]interface{}{"type": "text", "text": prompt}
+		if prepend {
+			return append([]interface{}{part}, content...), true
+		}
+		return append(content, part), true
+	}
+	return nil, false
+}
+
+// injectSystemPrompt 向对话消息中注入系统提示词
+func injectSystemPrompt(data map[string]interface{}, prompt, mode string) bool {
+	messages, ok := data["messages"].([]interface{})
+	if !ok {
+		return false
+	}
+
+	// 找到第一条系统消息
+	systemIndex := -1
+	for i, message := range messages {
+		if msg, ok := message.(map[string]interface{}); ok && msg["role"] == "system" {
+			systemIndex = i
+			break
+		}
+	}
+
+	if systemIndex < 0 {
+		data["messages"] = append([]interface{}{map[string]interface{}{
+			"role":    "system",
+			"content": prompt,
+		}}, messages...)
+		return true
+	}
+
+	systemMessage := messages[systemIndex].(map[string]interface{})
+	switch mode {
+	case model.SystemPromptModePrepend, model.SystemPromptModeAppend:
+		content, ok := combineSystemContent(systemMessage["content"], prompt, mode == model.SystemPromptModePrepend)
+		if !ok {
+			return false
+		}
+		systemMessage["content"] = content
+	case model.SystemPromptModeReplace:
+		// 删除其余的系统消息，只保留替换后的一条
+		filtered := make([]interface{}, 0, len(messages))
+		for i, message := range messages {
+			if msg, ok := message.(map[string]interface{}); ok && msg["role"] == "system" && i != systemIndex {
+				continue
+			}
+			filtered = append(filtered, message)
+		}
+		systemMessage["content"] = prompt
+		data["messages"] = filtered
+	default:
+		// 已有系统消息时不做修改
+		return false
+	}
+	return true
+}
+
+// getFieldValue 获取字段值，字段名支持用.分隔的嵌套字段
+func getFieldValue(data map[string]interface{}, field string) (interface{}, bool) {
+	parts := strings.Split(field, ".")
+	current := data
+	for i, part := range parts {
+		value, exists := current[part]
+		if !exists {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return nil, false
+}
+
+// jsonKind 获取值对应的JSON类型，整数和浮点数都视为数字
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, int32:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// setFieldValue 设置字段值，嵌套字段的上级对象不存在时自动创建
+func setFieldValue(data map[string]interface{}, field string, value interface{}) bool {
+	parts := strings.Split(field, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			if _, exists := current[part]; exists {
+				// 上级字段不是对象，无法设置
+				return false
+			}
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+	return true
+}
+
+// deleteFieldValue 删除字段，字段不存在时返回false
+func deleteFieldValue(data map[string]interface{}, field string) bool {
+	parts := strings.Split(field, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = next
+	}
+	last := parts[len(parts)-1]
+	if _, exists := current[last]; !exists {
+		return false
+	}
+	delete(current, last)
+	return true
+}
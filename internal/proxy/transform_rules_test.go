@@ -0,0 +1,45 @@
+/**
+  @author: Hanhai
+  @desc: 请求体转换操作的测试
+**/
+
+package proxy
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/model"
+	"testing"
+)
+
+func TestDefaultActionReplacesMissingNullAndMistypedValues(t *testing.T) {
+	modelDefault := model.TransformAction{Op: model.TransformOpDefault, Field: "model", Value: "GLM-4"}
+	// 从数据库读取的规则中数字为float64，写入默认规则时为int，两者都视为数字
+	topNDefault := model.TransformAction{Op: model.TransformOpDefault, Field: "top_n", Value: 10}
+
+	tests := []struct {
+		name    string
+		body    string
+		action  model.TransformAction
+		want    interface{}
+		changed bool
+	}{
+		{"字段不存在", `{}`, modelDefault, "GLM-4", true},
+		{"字段为null", `{"model": null}`, modelDefault, "GLM-4", true},
+		{"字段类型不符", `{"model": 123}`, modelDefault, "GLM-4", true},
+		{"已指定字符串", `{"model": "Qwen/Qwen2.5-7B-Instruct"}`, modelDefault, "Qwen/Qwen2.5-7B-Instruct", false},
+		{"已指定数字", `{"top_n": 3}`, topNDefault, 3.0, false},
+		{"数字字段为null", `{"top_n": null}`, topNDefault, 10, true},
+		{"嵌套字段", `{"options": {"model": null}}`, model.TransformAction{Op: model.TransformOpDefault, Field: "options.model", Value: "GLM-4"}, "GLM-4", true},
+	}
+	for _, tt := range tests {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+			t.Fatal(err)
+		}
+		changed := applyTransformAction(data, tt.action)
+		got, _ := getFieldValue(data, tt.action.Field)
+		if changed != tt.changed || got != tt.want {
+			t.Errorf("%s: changed=%v value=%v, want changed=%v value=%v", tt.name, changed, got, tt.changed, tt.want)
+		}
+	}
+}
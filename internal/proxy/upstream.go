@@ -0,0 +1,127 @@
+/**
+  @author: Hanhai
+  @desc: 发送JSON请求到上游的通用流程，负责选择密钥、失败重试、限流冷却和请求统计
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errNoUpstreamKey 没有可用的API密钥
+var errNoUpstreamKey = errors.New("没有可用的API密钥")
+
+// upstreamUsageParser 解析上游的成功响应，返回用于统计的输入和输出token数
+// 返回错误表示响应内容无效，本次请求按失败处理
+type upstreamUsageParser func(respBody []byte) (promptTokens int, completionTokens int, err error)
+
+// sendUpstreamJSON 向上游发送JSON请求，失败时按重试配置更换密钥重试，成功时返回响应体
+// 上游返回错误状态码时返回错误响应内容，由调用方返回给客户端
+func sendUpstreamJSON(ctx context.Context, targetURL string, body []byte, requestType string, modelName string, tokenEstimate int, parse upstreamUsageParser) ([]byte, *upstreamFailure, error) {
+	retryConfig := config.GetConfig().ApiProxy.Retry
+	var lastFailure *upstreamFailure
+	var lastErr error
+	for attempt := 0; attempt <= retryConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoffDelay(attempt-1, retryConfig))
+			logger.Warn("%s请求第%d次重试: %s, 错误: %v", requestType, attempt, targetURL, lastErr)
+		}
+
+		apiKey, release, err := key.AcquireKeyForRequest(ctx, requestType, modelName, tokenEstimate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errNoUpstreamKey, err)
+		}
+		respBody, failure, err := sendUpstreamJSONWithKey(ctx, targetURL, body, apiKey, requestType, modelName, parse)
+		release()
+		if err == nil {
+			return respBody, nil, nil
+		}
+
+		lastFailure, lastErr = failure, err
+		if ctx.Err() != nil || !shouldRetry(err, retryConfig) {
+			break
+		}
+	}
+	return nil, lastFailure, lastErr
+}
+
+// sendUpstreamJSONWithKey 使用指定密钥发送请求并记录密钥状态和统计
+func sendUpstreamJSONWithKey(ctx context.Context, targetURL string, body []byte, apiKey string, requestType string, modelName string, parse upstreamUsageParser) ([]byte, *upstreamFailure, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	utils.SetCommonHeaders(req, apiKey)
+
+	resp, err := utils.CreateClient().Do(req)
+	if err != nil {
+		// 客户端断开或请求被调用方取消时不计为密钥失败
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyRequestStat(apiKey, modelName, 1, 0, 0, false)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	applyRateLimitCooldown(apiKey, modelName, resp)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyRequestStat(apiKey, modelName, 1, 0, 0, false)
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyRequestStat(apiKey, modelName, 1, 0, 0, false)
+		logger.Error("%s请求失败，状态码: %d, 响应: %s", requestType, resp.StatusCode, string(respBody))
+		return nil, &upstreamFailure{statusCode: resp.StatusCode, header: resp.Header, body: respBody},
+			newUpstreamStatusError(fmt.Sprintf("%s请求失败: %s", requestType, string(respBody)), resp.StatusCode)
+	}
+
+	promptTokens, completionTokens, err := parse(respBody)
+	if err != nil {
+		key.UpdateApiKeyStatus(apiKey, false)
+		config.AddDailyRequestStat(apiKey, modelName, 1, 0, 0, false)
+		return nil, nil, err
+	}
+
+	key.UpdateApiKeyStatus(apiKey, true)
+	config.AddKeyRequestStat(apiKey, 1, promptTokens+completionTokens)
+	config.AddDailyRequestStat(apiKey, modelName, 1, promptTokens, completionTokens, true)
+	return respBody, nil, nil
+}
+
+// writeUpstreamError 返回发送上游请求的错误，上游返回的错误响应原样返回
+func writeUpstreamError(c *gin.Context, failure *upstreamFailure, err error) {
+	if c.Request.Context().Err() != nil {
+		logger.Info("客户端已断开，取消请求: %s", c.Request.URL.Path)
+		return
+	}
+	if failure != nil {
+		copyResponseHeaders(c, failure.header)
+		c.Status(failure.statusCode)
+		c.Writer.Write(failure.body)
+		return
+	}
+	if errors.Is(err, errNoUpstreamKey) {
+		writeNoKeyError(c, err, "No suitable API keys available")
+		return
+	}
+	writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("发送请求失败: %v", err))
+}
@@ -0,0 +1,410 @@
+/**
+  @author: Hanhai
+  @desc: 视频生成任务代理，提交任务时记录使用的API密钥，查询状态时使用同一密钥，并可将生成的视频下载到本地保存
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/key"
+	"flowsilicon/internal/logger"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 视频生成请求的token估计值
+	videoTokenEstimate = 1000
+	// 视频请求体和状态响应的最大读取长度
+	maxVideoBodySize = 1 << 20
+	// 下载视频的超时时间
+	videoDownloadTimeout = 30 * time.Minute
+	// 清理过期视频任务的间隔
+	videoCleanupInterval = time.Hour
+	// 本地视频文件的访问路径前缀
+	videoFilesPath = "/video/files/"
+)
+
+var (
+	// 正在下载的视频任务，避免重复下载
+	videoDownloads sync.Map
+	// 确保清理任务只启动一次
+	videoCleanerOnce sync.Once
+)
+
+// videoStatusResponse 视频任务状态的响应
+type videoStatusResponse struct {
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Results struct {
+		Videos []struct {
+			URL string `json:"url"`
+		} `json:"videos"`
+	} `json:"results"`
+}
+
+// isVideoSubmitPath 判断是否为提交视频生成任务的请求
+func isVideoSubmitPath(path string) bool {
+	return strings.Contains(path, "/video/submit")
+}
+
+// isVideoStatusPath 判断是否为查询视频任务状态的请求
+func isVideoStatusPath(path string) bool {
+	return strings.Contains(path, "/video/status")
+}
+
+// isVideoFilePath 判断是否为获取本地视频文件的请求
+func isVideoFilePath(path string) bool {
+	return strings.Contains(path, videoFilesPath)
+}
+
+// sendVideoRequest 使用指定密钥向上游发送视频接口请求，返回状态码、响应头和响应体
+func sendVideoRequest(c *gin.Context, targetURL string, body []byte, apiKey string) (int, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	utils.SetCommonHeaders(req, apiKey)
+
+	resp, err := utils.CreateClient().Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxVideoBodySize))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, respBody, nil
+}
+
+// writeVideoResponse 返回上游的响应，响应体可能被修改，不沿用上游的长度
+func writeVideoResponse(c *gin.Context, statusCode int, header http.Header, body []byte) {
+	copyResponseHeaders(c, header)
+	c.Writer.Header().Del("Content-Length")
+	c.Status(statusCode)
+	c.Writer.Write(body)
+}
+
+// handleVideoSubmit 提交视频生成任务，并记录提交任务使用的API密钥
+func handleVideoSubmit(c *gin.Context, targetURL string) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxVideoBodySize+1))
+	if err != nil || len(bodyBytes) > maxVideoBodySize {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "读取请求体失败或请求体过大")
+		return
+	}
+
+	var requestData struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Request body is empty or invalid JSON")
+		return
+	}
+	if requestData.Prompt == "" {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "Prompt field is required for video generation requests")
+		return
+	}
+	if requestData.Model != "" && isModelDisabled(requestData.Model) {
+		writeProxyError(c, http.StatusForbidden, "invalid_request_error", fmt.Sprintf("模型 %s 已被禁用", requestData.Model))
+		return
+	}
+
+	retryConfig := config.GetConfig().ApiProxy.Retry
+	var lastErr error
+	for attempt := 0; attempt <= retryConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoffDelay(attempt-1, retryConfig))
+			logger.Warn("视频生成任务提交第%d次重试: %s, 错误: %v", attempt, targetURL, lastErr)
+		}
+
+		apiKey, release, err := key.AcquireKeyForRequest(c.Request.Context(), "video", requestData.Model, videoTokenEstimate)
+		if err != nil {
+			writeNoKeyError(c, err, "No suitable API keys available")
+			return
+		}
+
+		logger.InfoWithKey(utils.MaskKey(apiKey), "提交视频生成任务: 模型: %s", requestData.Model)
+		statusCode, header, respBody, err := sendVideoRequest(c, targetURL, bodyBytes, apiKey)
+		release()
+		if err != nil {
+			key.UpdateApiKeyStatus(apiKey, false)
+			config.AddDailyRequestStat(apiKey, requestData.Model, 1, 0, 0, false)
+			lastErr = err
+			if c.Request.Context().Err() != nil || !shouldRetry(err, retryConfig) {
+				break
+			}
+			continue
+		}
+
+		success := statusCode >= 200 && statusCode < 300
+		key.UpdateApiKeyStatus(apiKey, success)
+		config.AddDailyRequestStat(apiKey, requestData.Model, 1, 0, 0, success)
+		if !success {
+			lastErr = newUpstreamStatusError(fmt.Sprintf("提交视频生成任务失败: %s", string(respBody)), statusCode)
+			if attempt < retryConfig.MaxRetries && shouldRetry(lastErr, retryConfig) {
+				continue
+			}
+			logger.Error("提交视频生成任务失败，状态码: %d, 响应: %s", statusCode, string(respBody))
+			writeVideoResponse(c, statusCode, header, respBody)
+			return
+		}
+
+		config.AddKeyRequestStat(apiKey, 1, videoTokenEstimate)
+		var submitResult struct {
+			RequestID string `json:"requestId"`
+		}
+		if json.Unmarshal(respBody, &submitResult) == nil && submitResult.RequestID != "" {
+			job := config.VideoJob{
+				RequestID: submitResult.RequestID,
+				Model:     requestData.Model,
+				Prompt:    requestData.Prompt,
+			}
+			if err := config.CreateVideoJob(job, apiKey); err != nil {
+				logger.Error("记录视频任务 %s 失败: %v", submitResult.RequestID, err)
+			} else {
+				logger.Info("已提交视频生成任务 %s", submitResult.RequestID)
+			}
+			go updateModelCallCount(requestData.Model)
+		}
+
+		writeVideoResponse(c, statusCode, header, respBody)
+		return
+	}
+
+	if c.Request.Context().Err() != nil {
+		logger.Info("客户端已断开，取消提交视频生成任务")
+		return
+	}
+	writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("发送请求失败: %v", lastErr))
+}
+
+// handleVideoStatus 使用提交任务时的API密钥查询视频任务状态
+// 已下载到本地的视频在响应中增加local_url字段
+func handleVideoStatus(c *gin.Context, targetURL string) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxVideoBodySize+1))
+	if err != nil || len(bodyBytes) > maxVideoBodySize {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "读取请求体失败或请求体过大")
+		return
+	}
+
+	var requestData struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil || requestData.RequestID == "" {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error", "RequestId field is required for video status requests")
+		return
+	}
+
+	job, err := config.GetVideoJob(requestData.RequestID)
+	if errors.Is(err, config.ErrVideoJobNotFound) {
+		writeProxyError(c, http.StatusNotFound, "invalid_request_error", "视频任务不存在或不是通过本服务提交的")
+		return
+	}
+	if err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", fmt.Sprintf("读取视频任务失败: %v", err))
+		return
+	}
+
+	apiKey, err := config.GetVideoJobApiKey(job)
+	if err != nil {
+		writeProxyError(c, http.StatusConflict, "invalid_request_error", fmt.Sprintf("无法使用提交任务的API密钥查询状态: %v", err))
+		return
+	}
+
+	statusCode, header, respBody, err := sendVideoRequest(c, targetURL, bodyBytes, apiKey)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		writeProxyError(c, http.StatusBadGateway, "upstream_error", fmt.Sprintf("发送请求失败: %v", err))
+		return
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		writeVideoResponse(c, statusCode, header, respBody)
+		return
+	}
+
+	var result videoStatusResponse
+	if err := json.Unmarshal(respBody, &result); err == nil && result.Status != "" {
+		videoURL := ""
+		if len(result.Results.Videos) > 0 {
+			videoURL = result.Results.Videos[0].URL
+		}
+		if result.Status != job.Status || videoURL != job.VideoURL {
+			if err := config.UpdateVideoJobStatus(job.RequestID, result.Status, result.Reason, videoURL); err != nil {
+				logger.Error("更新视频任务 %s 状态失败: %v", job.RequestID, err)
+			}
+		}
+
+		cfg := config.GetConfig()
+		if result.Status == config.VideoJobStatusSucceed && videoURL != "" && job.LocalFile == "" && cfg.Video.DownloadResults {
+			startVideoDownload(job.RequestID, videoURL)
+		}
+		if job.LocalFile != "" {
+			respBody = addVideoLocalURL(respBody, c.Request.URL.Path, job.RequestID)
+		}
+	}
+
+	writeVideoResponse(c, statusCode, header, respBody)
+}
+
+// addVideoLocalURL 在状态响应中增加本地视频文件的访问地址
+func addVideoLocalURL(respBody []byte, requestPath string, requestID string) []byte {
+	var response map[string]interface{}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return respBody
+	}
+	prefix := strings.TrimSuffix(requestPath, "/video/status")
+	response["local_url"] = prefix + videoFilesPath + requestID
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return respBody
+	}
+	return data
+}
+
+// handleVideoFile 返回下载到本地的视频文件
+func handleVideoFile(c *gin.Context) {
+	path := c.Request.URL.Path
+	requestID := path[strings.Index(path, videoFilesPath)+len(videoFilesPath):]
+
+	job, err := config.GetVideoJob(requestID)
+	if err != nil || job.LocalFile == "" {
+		writeProxyError(c, http.StatusNotFound, "invalid_request_error", "视频文件不存在")
+		return
+	}
+	if _, err := os.Stat(job.LocalFile); err != nil {
+		writeProxyError(c, http.StatusNotFound, "invalid_request_error", "视频文件不存在或已被清理")
+		return
+	}
+	c.File(job.LocalFile)
+}
+
+// getVideoStorageDir 获取视频保存目录
+func getVideoStorageDir() string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Video.StorageDir != "" {
+		return cfg.Video.StorageDir
+	}
+	return filepath.Join(config.GetDataDir(), "videos")
+}
+
+// startVideoDownload 在后台下载生成的视频，同一任务同时只下载一次
+func startVideoDownload(requestID, videoURL string) {
+	if _, loading := videoDownloads.LoadOrStore(requestID, true); loading {
+		return
+	}
+
+	go func() {
+		defer videoDownloads.Delete(requestID)
+
+		localFile, err := downloadVideo(requestID, videoURL)
+		if err != nil {
+			logger.Error("下载视频任务 %s 的结果失败: %v", requestID, err)
+			return
+		}
+		if err := config.SetVideoJobLocalFile(requestID, localFile); err != nil {
+			logger.Error("记录视频任务 %s 的本地文件失败: %v", requestID, err)
+			os.Remove(localFile)
+			return
+		}
+		logger.Info("已将视频任务 %s 的结果保存到: %s", requestID, localFile)
+	}()
+}
+
+// downloadVideo 下载视频到保存目录，返回本地文件路径
+func downloadVideo(requestID, videoURL string) (string, error) {
+	dir := getVideoStorageDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	resp, err := utils.CreateClientWithTimeout(videoDownloadTimeout).Get(videoURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载视频返回状态码 %d", resp.StatusCode)
+	}
+
+	// 任务ID来自上游，只保留安全的字符作为文件名
+	safeName := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, requestID)
+	localFile := filepath.Join(dir, safeName+".mp4")
+
+	// 先写入临时文件，下载完成后再重命名
+	tempFile := localFile + ".part"
+	file, err := os.Create(tempFile)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return "", err
+	}
+	if err := os.Rename(tempFile, localFile); err != nil {
+		os.Remove(tempFile)
+		return "", err
+	}
+	return localFile, nil
+}
+
+// StartVideoJobCleaner 启动后台任务，定期清理超过保留天数的视频任务记录和本地视频文件
+func StartVideoJobCleaner() {
+	videoCleanerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(videoCleanupInterval)
+			defer ticker.Stop()
+			for {
+				cleanupVideoJobs()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// cleanupVideoJobs 清理超过保留天数的视频任务
+func cleanupVideoJobs() {
+	cfg := config.GetConfig()
+	if cfg == nil || cfg.Video.RetentionDays <= 0 {
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -cfg.Video.RetentionDays).Unix()
+	files, err := config.PurgeVideoJobs(before)
+	if err != nil {
+		logger.Error("清理过期视频任务失败: %v", err)
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Error("删除视频文件失败: %v", err)
+		}
+	}
+}
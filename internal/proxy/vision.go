@@ -0,0 +1,288 @@
+/**
+  @author: Hanhai
+  @desc: 多模态对话的图片输入处理，包括图片token估算、图片大小和数量限制、远程图片转为data URL以及视觉模型检查
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/model"
+	"flowsilicon/pkg/utils"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 图片token的估算方式与OpenAI一致：low细节固定85个token，其他按512像素图块每块170个token另加85个
+const (
+	imageBaseTokens    = 85
+	imageTileTokens    = 170
+	defaultImageTokens = 765 // 无法获取图片尺寸时按1024x1024估算
+)
+
+// remoteImageClient 下载客户端提供的远程图片，只允许连接公网地址
+var remoteImageClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: rejectPrivateAddress}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("重定向次数过多")
+		}
+		return nil
+	},
+}
+
+// estimateContentTokens 估算消息内容的token数，内容可以是字符串或包含文本和图片的内容数组
+func estimateContentTokens(content interface{}) int {
+	switch value := content.(type) {
+	case string:
+		return utils.EstimateStringTokens(value)
+	case []interface{}:
+		tokens := 0
+		for _, item := range value {
+			part, _ := item.(map[string]interface{})
+			if text, ok := part["text"].(string); ok {
+				tokens += utils.EstimateStringTokens(text)
+			} else if imageURL, detail, ok := imagePartURL(part); ok {
+				tokens += estimateImageTokens(imageURL, detail)
+			}
+		}
+		return tokens
+	}
+	return 0
+}
+
+// imagePartURL 获取图片内容的地址和细节设置，image_url可以是对象或直接是地址字符串
+func imagePartURL(part map[string]interface{}) (string, string, bool) {
+	if partType, _ := part["type"].(string); partType != "image_url" {
+		return "", "", false
+	}
+	switch value := part["image_url"].(type) {
+	case string:
+		return value, "", true
+	case map[string]interface{}:
+		imageURL, _ := value["url"].(string)
+		detail, _ := value["detail"].(string)
+		return imageURL, detail, true
+	}
+	return "", "", true
+}
+
+// estimateImageTokens 估算一张图片的token数，data URL按图片尺寸计算，远程图片无法获取尺寸时使用默认值
+func estimateImageTokens(imageURL, detail string) int {
+	if detail == "low" {
+		return imageBaseTokens
+	}
+	if !strings.HasPrefix(imageURL, "data:") {
+		return defaultImageTokens
+	}
+	comma := strings.Index(imageURL, ",")
+	if comma < 0 {
+		return defaultImageTokens
+	}
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(imageURL[comma+1:]))
+	imageConfig, _, err := image.DecodeConfig(decoder)
+	if err != nil {
+		return defaultImageTokens
+	}
+	return imageTokensForSize(imageConfig.Width, imageConfig.Height)
+}
+
+// imageTokensForSize 按图片尺寸计算token数：先缩放到2048x2048以内，再将短边缩放到768，按512像素图块计数
+func imageTokensForSize(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return defaultImageTokens
+	}
+	w, h := float64(width), float64(height)
+	if longest := math.Max(w, h); longest > 2048 {
+		w, h = w*2048/longest, h*2048/longest
+	}
+	if shortest := math.Min(w, h); shortest > 768 {
+		w, h = w*768/shortest, h*768/shortest
+	}
+	tiles := int(math.Ceil(w/512) * math.Ceil(h/512))
+	return imageBaseTokens + imageTileTokens*tiles
+}
+
+// prepareVisionInput 检查并处理对话请求中的图片
+// 模型不支持图片输入、图片数量或大小超过限制时返回错误给客户端并返回false；
+// 开启inline_remote_images时将远程图片下载后以data URL发送，避免上游无法访问图片地址
+func prepareVisionInput(c *gin.Context, body []byte) ([]byte, bool) {
+	if !bytes.Contains(body, []byte("image_url")) {
+		return body, true
+	}
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(body, &requestData); err != nil {
+		return body, true
+	}
+
+	var imageParts []map[string]interface{}
+	messages, _ := requestData["messages"].([]interface{})
+	for _, item := range messages {
+		message, _ := item.(map[string]interface{})
+		parts, _ := message["content"].([]interface{})
+		for _, partItem := range parts {
+			if part, ok := partItem.(map[string]interface{}); ok {
+				if _, _, isImage := imagePartURL(part); isImage {
+					imageParts = append(imageParts, part)
+				}
+			}
+		}
+	}
+	if len(imageParts) == 0 {
+		return body, true
+	}
+
+	visionConfig := config.GetConfig().Vision
+	modelName, _ := requestData["model"].(string)
+	if visionConfig.RejectUnsupported && !model.IsVisionModel(modelName) {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("模型 %s 不支持图片输入，请使用视觉模型或移除消息中的图片", modelName))
+		return nil, false
+	}
+	if visionConfig.MaxImages > 0 && len(imageParts) > visionConfig.MaxImages {
+		writeProxyError(c, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("请求中的图片数量 %d 超过上限 %d", len(imageParts), visionConfig.MaxImages))
+		return nil, false
+	}
+
+	maxImageSizeMB := visionConfig.MaxImageSizeMB
+	if maxImageSizeMB <= 0 {
+		maxImageSizeMB = 10
+	}
+	fetchTimeout := time.Duration(visionConfig.FetchTimeout) * time.Second
+	if fetchTimeout <= 0 {
+		fetchTimeout = 15 * time.Second
+	}
+
+	for i, part := range imageParts {
+		imageURL, detail, _ := imagePartURL(part)
+		switch {
+		case strings.HasPrefix(imageURL, "data:"):
+			size, err := dataURLSize(imageURL)
+			if err != nil {
+				writeProxyError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("第%d张图片: %v", i+1, err))
+				return nil, false
+			}
+			if size > maxImageSizeMB<<20 {
+				writeProxyError(c, http.StatusBadRequest, "invalid_request_error",
+					fmt.Sprintf("第%d张图片超过 %d MB", i+1, maxImageSizeMB))
+				return nil, false
+			}
+		case strings.HasPrefix(imageURL, "http://"), strings.HasPrefix(imageURL, "https://"):
+			if visionConfig.InlineRemoteImages {
+				dataURL, err := fetchImageAsDataURL(c.Request.Context(), imageURL, maxImageSizeMB<<20, fetchTimeout)
+				if err != nil {
+					logger.Warn("下载第%d张图片失败: %s, 错误: %v", i+1, imageURL, err)
+					writeProxyError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("获取第%d张图片失败: %v", i+1, err))
+					return nil, false
+				}
+				imageURL = dataURL
+			}
+		default:
+			writeProxyError(c, http.StatusBadRequest, "invalid_request_error",
+				fmt.Sprintf("第%d张图片的地址必须是http(s)地址或data URL", i+1))
+			return nil, false
+		}
+
+		// 统一为对象格式，兼容将image_url写成地址字符串的客户端
+		imageValue := map[string]interface{}{"url": imageURL}
+		if detail != "" {
+			imageValue["detail"] = detail
+		}
+		part["image_url"] = imageValue
+	}
+
+	modifiedBody, err := json.Marshal(requestData)
+	if err != nil {
+		writeProxyError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil, false
+	}
+	return modifiedBody, true
+}
+
+// dataURLSize 获取data URL中图片数据的字节数
+func dataURLSize(dataURL string) (int, error) {
+	comma := strings.Index(dataURL, ",")
+	if comma < 0 {
+		return 0, errors.New("data URL格式无效")
+	}
+	header, payload := dataURL[len("data:"):comma], dataURL[comma+1:]
+	if mediaType := strings.Split(header, ";")[0]; mediaType != "" && !strings.HasPrefix(mediaType, "image/") {
+		return 0, fmt.Errorf("data URL的类型不是图片: %s", mediaType)
+	}
+	if !strings.HasSuffix(header, ";base64") {
+		return len(payload), nil
+	}
+	return base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(payload, "="))), nil
+}
+
+// fetchImageAsDataURL 下载远程图片并转换为base64编码的data URL
+func fetchImageAsDataURL(ctx context.Context, imageURL string, maxBytes int, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := remoteImageClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("图片地址返回状态码 %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxBytes {
+		return "", fmt.Errorf("图片超过 %d MB", maxBytes>>20)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("地址的内容不是图片: %s", contentType)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// rejectPrivateAddress 拒绝连接回环、内网和链路本地地址，防止通过图片地址访问内部服务
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("不允许访问内网地址 %s", host)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+/**
+  @author: Hanhai
+  @desc: 图片token估算和图片大小、数量限制的测试
+**/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testImageDataURL 生成指定尺寸的PNG图片的data URL
+func testImageDataURL(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("生成测试图片失败: %v", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestImageTokensForSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          int
+	}{
+		{512, 512, 255},    // 1个图块
+		{100, 100, 255},    // 小图片也至少1个图块
+		{1024, 1024, 765},  // 短边缩放到768后为2x2个图块
+		{4096, 2048, 1105}, // 先缩放到2048x1024，再缩放到1536x768，3x2个图块
+		{768, 2048, 1445},  // 不需要缩放，2x4个图块
+		{0, 100, defaultImageTokens},
+	}
+	for _, tt := range tests {
+		if got := imageTokensForSize(tt.width, tt.height); got != tt.want {
+			t.Errorf("imageTokensForSize(%d, %d) = %d, want %d", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateImageTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageURL string
+		detail   string
+		want     int
+	}{
+		{"low细节固定token数", testImageDataURL(t, 1024, 1024), "low", imageBaseTokens},
+		{"data URL按尺寸计算", testImageDataURL(t, 512, 512), "", 255},
+		{"high细节按尺寸计算", testImageDataURL(t, 1024, 1024), "high", 765},
+		{"远程图片使用默认值", "https://example.com/a.png", "", defaultImageTokens},
+		{"无法解析的data URL使用默认值", "data:image/png;base64,AAAA", "", defaultImageTokens},
+		{"缺少逗号的data URL使用默认值", "data:image/png", "", defaultImageTokens},
+	}
+	for _, tt := range tests {
+		if got := estimateImageTokens(tt.imageURL, tt.detail); got != tt.want {
+			t.Errorf("%s: estimateImageTokens = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateContentTokens(t *testing.T) {
+	var content []interface{}
+	json.Unmarshal([]byte(`[
+		{"type": "text", "text": ""},
+		{"type": "image_url", "image_url": {"url": "https://example.com/a.png", "detail": "low"}},
+		{"type": "image_url", "image_url": "https://example.com/b.png"},
+		{"type": "input_audio", "input_audio": {"data": "AAAA"}}
+	]`), &content)
+	if got, want := estimateContentTokens(content), imageBaseTokens+defaultImageTokens; got != want {
+		t.Errorf("estimateContentTokens = %d, want %d", got, want)
+	}
+	if got := estimateContentTokens(nil); got != 0 {
+		t.Errorf("estimateContentTokens(nil) = %d, want 0", got)
+	}
+}
+
+func TestDataURLSize(t *testing.T) {
+	tests := []struct {
+		dataURL string
+		want    int
+		wantErr bool
+	}{
+		{"data:image/png;base64,AAAA", 3, false},
+		{"data:image/png;base64,AA==", 1, false},
+		{"data:image/png;base64,AAA=", 2, false},
+		{"data:;base64,AAAA", 3, false}, // 未指定类型
+		{"data:image/svg+xml,<svg/>", 6, false},
+		{"data:text/plain;base64,AAAA", 0, true},
+		{"data:image/png", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := dataURLSize(tt.dataURL)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("dataURLSize(%q) = %d, %v, want %d, 错误 %v", tt.dataURL, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPrepareVisionInput(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	t.Cleanup(func() { config.UpdateConfig(previous) })
+
+	small := testImageDataURL(t, 16, 16)
+	// 超过1MB的data URL，内容不需要是有效的图片
+	large := "data:image/png;base64," + strings.Repeat("A", 2<<20)
+	request := func(model string, urls ...string) string {
+		parts := []interface{}{map[string]interface{}{"type": "text", "text": "描述图片"}}
+		for _, u := range urls {
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": u})
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"model":    model,
+			"messages": []interface{}{map[string]interface{}{"role": "user", "content": parts}},
+		})
+		return string(data)
+	}
+
+	tests := []struct {
+		name              string
+		rejectUnsupported bool
+		maxImages         int
+		maxSizeMB         int
+		body              string
+		wantOK            bool
+		wantErr           string
+	}{
+		{"没有图片时不处理", true, 1, 1, `{"model": "deepseek-ai/DeepSeek-V3", "messages": [{"role": "user", "content": "hi"}]}`, true, ""},
+		{"视觉模型", true, 0, 0, request("Qwen/Qwen2.5-VL-72B-Instruct", small, "https://example.com/a.png"), true, ""},
+		{"拒绝非视觉模型", true, 0, 0, request("deepseek-ai/DeepSeek-V3", small), false, "不支持图片输入"},
+		{"未开启检查时允许非视觉模型", false, 0, 0, request("deepseek-ai/DeepSeek-V3", small), true, ""},
+		{"图片数量超过上限", false, 1, 0, request("Qwen/Qwen2.5-VL-72B-Instruct", small, small), false, "超过上限 1"},
+		{"图片大小超过上限", false, 0, 1, request("Qwen/Qwen2.5-VL-72B-Instruct", small, large), false, "第2张图片超过 1 MB"},
+		{"默认大小上限为10MB", false, 0, 0, request("Qwen/Qwen2.5-VL-72B-Instruct", large), true, ""},
+		{"不支持的图片地址", false, 0, 0, request("Qwen/Qwen2.5-VL-72B-Instruct", "file:///etc/passwd"), false, "必须是http(s)地址或data URL"},
+		{"data URL的类型不是图片", false, 0, 0, request("Qwen/Qwen2.5-VL-72B-Instruct", "data:text/plain;base64,AAAA"), false, "类型不是图片"},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Vision.RejectUnsupported = tt.rejectUnsupported
+		cfg.Vision.MaxImages = tt.maxImages
+		cfg.Vision.MaxImageSizeMB = tt.maxSizeMB
+		config.UpdateConfig(cfg)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+		body, ok := prepareVisionInput(c, []byte(tt.body))
+		if ok != tt.wantOK {
+			t.Fatalf("%s: prepareVisionInput = %v, want %v: %s", tt.name, ok, tt.wantOK, w.Body.String())
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("%s: 响应 = %d %s, want 400 包含 %q", tt.name, w.Code, w.Body.String(), tt.wantErr)
+			}
+			continue
+		}
+
+		// 字符串格式的image_url统一为对象格式
+		var requestData struct {
+			Messages []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		json.Unmarshal(body, &requestData)
+		var parts []map[string]interface{}
+		if json.Unmarshal(requestData.Messages[0].Content, &parts) != nil {
+			continue
+		}
+		for _, part := range parts {
+			if part["type"] != "image_url" {
+				continue
+			}
+			if imageValue, ok := part["image_url"].(map[string]interface{}); !ok || imageValue["url"] == "" {
+				t.Errorf("%s: image_url未转换为对象格式: %v", tt.name, part["image_url"])
+			}
+		}
+	}
+}
+
+func TestFetchImageAsDataURLRejectsPrivateAddress(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer upstream.Close()
+
+	// 本地测试服务监听在回环地址，不允许通过图片地址访问
+	_, err := fetchImageAsDataURL(context.Background(), upstream.URL+"/a.png", 1<<20, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "不允许访问内网地址") {
+		t.Errorf("fetchImageAsDataURL = %v, want 拒绝内网地址", err)
+	}
+}
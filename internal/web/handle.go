@@ -6,7 +6,10 @@
 package web
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flowsilicon/internal/auth"
 	"flowsilicon/internal/common"
 	"flowsilicon/internal/config"
@@ -14,9 +17,11 @@ import (
 	"flowsilicon/internal/logger"
 	"flowsilicon/internal/middleware"
 	"flowsilicon/internal/model"
+	"flowsilicon/internal/proxy"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -44,12 +49,16 @@ func handleListKeys(c *gin.Context) {
 		scoreMap[ks.Key.Key] = ks.Score
 	}
 
-	// 为每个密钥添加得分
+	// 获取每个密钥当前进行中的请求数
+	inFlightCounts := key.GetAllInFlightCounts()
+
+	// 为每个密钥添加得分和进行中请求数
 	for i := range allKeys {
 		// 如果在scoreMap中找到对应的得分，则添加
 		if score, ok := scoreMap[allKeys[i].Key]; ok {
 			allKeys[i].Score = score
 		}
+		allKeys[i].InFlight = inFlightCounts[allKeys[i].Key]
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -60,9 +69,10 @@ func handleListKeys(c *gin.Context) {
 // handleAddKey 处理添加 API 密钥的请求
 func handleAddKey(c *gin.Context) {
 	var req struct {
-		Key              string  `json:"key" binding:"required"`
-		Balance          float64 `json:"balance"`
-		AllowZeroBalance bool    `json:"allow_zero_balance"`
+		Key              string   `json:"key" binding:"required"`
+		Balance          float64  `json:"balance"`
+		AllowZeroBalance bool     `json:"allow_zero_balance"`
+		Tags             []string `json:"tags"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -93,6 +103,9 @@ func handleAddKey(c *gin.Context) {
 
 	// 添加 API 密钥
 	config.AddApiKey(req.Key, req.Balance)
+	if len(req.Tags) > 0 {
+		config.SetApiKeyTags(req.Key, req.Tags)
+	}
 
 	// 重新排序 API 密钥
 	config.SortApiKeysByBalance()
@@ -221,6 +234,7 @@ func handleBatchAddKeys(c *gin.Context) {
 		Keys             []string `json:"keys" binding:"required"`
 		Balance          float64  `json:"balance"`
 		AllowZeroBalance bool     `json:"allow_zero_balance"`
+		Tags             []string `json:"tags"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -247,6 +261,9 @@ func handleBatchAddKeys(c *gin.Context) {
 			// 根据AllowZeroBalance参数决定是否添加余额小于等于0的密钥
 			if balance > 0 || req.AllowZeroBalance {
 				config.AddApiKey(_key, balance)
+				if len(req.Tags) > 0 {
+					config.SetApiKeyTags(_key, req.Tags)
+				}
 				addedCount++
 			} else {
 				skippedCount++
@@ -465,6 +482,271 @@ func handleTestEmbeddings(c *gin.Context) {
 	})
 }
 
+// handleListKeyCooldowns 获取当前处于限流冷却的密钥
+func handleListKeyCooldowns(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"cooldowns": key.GetKeyCooldowns(),
+	})
+}
+
+// handleClearKeyCooldown 清除指定密钥的限流冷却
+func handleClearKeyCooldown(c *gin.Context) {
+	apiKey := c.Param("key")
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Key parameter is required",
+		})
+		return
+	}
+
+	key.ClearKeyCooldown(apiKey)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已清除密钥冷却",
+	})
+}
+
+// handleSetKeyTags 设置指定密钥的标签
+func handleSetKeyTags(c *gin.Context) {
+	apiKey := c.Param("key")
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Key parameter is required",
+		})
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效请求: %v", err),
+		})
+		return
+	}
+
+	if success := config.SetApiKeyTags(apiKey, req.Tags); !success {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API密钥未找到",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API密钥标签已更新",
+		"tags":    config.NormalizeTags(req.Tags),
+	})
+}
+
+// handleListKeyGroups 获取按标签分组的密钥统计
+func handleListKeyGroups(c *gin.Context) {
+	cfg := config.GetConfig()
+	c.JSON(http.StatusOK, gin.H{
+		"groups":       key.GetKeyGroupStats(),
+		"model_rules":  cfg.KeyGroups.ModelRules,
+		"client_rules": maskClientRules(cfg.KeyGroups.ClientRules),
+	})
+}
+
+// oidcClientSecretMask 返回给前端的单点登录客户端密钥掩码，保存设置时传回该值表示不修改
+const oidcClientSecretMask = "******"
+
+// maskOIDCClientSecret 对单点登录的客户端密钥进行掩码，未设置时返回空字符串
+func maskOIDCClientSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return oidcClientSecretMask
+}
+
+// maskClientRules 对客户端分组规则中的客户端密钥进行掩码
+func maskClientRules(rules map[string][]string) map[string][]string {
+	masked := make(map[string][]string, len(rules))
+	for clientKey, tags := range rules {
+		masked[utils.MaskKey(clientKey)] = tags
+	}
+	return masked
+}
+
+// parseTagRules 解析分组路由规则，规则值为标签数组，忽略没有有效标签的规则
+func parseTagRules(rules map[string]interface{}) map[string][]string {
+	result := make(map[string][]string)
+	for name, value := range rules {
+		tagList, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		var tags []string
+		for _, tag := range tagList {
+			if tagStr, ok := tag.(string); ok {
+				tags = append(tags, tagStr)
+			}
+		}
+		if tags = config.NormalizeTags(tags); len(tags) > 0 {
+			result[name] = tags
+		}
+	}
+	return result
+}
+
+// parseStringList 解析字符串数组，忽略空字符串和非字符串元素
+func parseStringList(values []interface{}) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok && strings.TrimSpace(str) != "" {
+			result = append(result, strings.TrimSpace(str))
+		}
+	}
+	return result
+}
+
+// corsPolicyToMap 将跨域策略转换为设置接口返回的格式
+func corsPolicyToMap(policy config.CorsPolicy) gin.H {
+	return gin.H{
+		"enabled":           policy.Enabled,
+		"allowed_origins":   policy.AllowedOrigins,
+		"allowed_methods":   policy.AllowedMethods,
+		"allowed_headers":   policy.AllowedHeaders,
+		"exposed_headers":   policy.ExposedHeaders,
+		"allow_credentials": policy.AllowCredentials,
+		"max_age":           policy.MaxAge,
+	}
+}
+
+// parseCorsPolicy 解析设置中的跨域策略，只更新提供了的字段
+func parseCorsPolicy(data map[string]interface{}, policy *config.CorsPolicy) {
+	if enabled, ok := data["enabled"].(bool); ok {
+		policy.Enabled = enabled
+	}
+	if origins, ok := data["allowed_origins"].([]interface{}); ok {
+		policy.AllowedOrigins = parseStringList(origins)
+	}
+	if methods, ok := data["allowed_methods"].([]interface{}); ok {
+		policy.AllowedMethods = parseStringList(methods)
+		for i := range policy.AllowedMethods {
+			policy.AllowedMethods[i] = strings.ToUpper(policy.AllowedMethods[i])
+		}
+	}
+	if headers, ok := data["allowed_headers"].([]interface{}); ok {
+		policy.AllowedHeaders = parseStringList(headers)
+	}
+	if exposedHeaders, ok := data["exposed_headers"].([]interface{}); ok {
+		policy.ExposedHeaders = parseStringList(exposedHeaders)
+	}
+	if allowCredentials, ok := data["allow_credentials"].(bool); ok {
+		policy.AllowCredentials = allowCredentials
+	}
+	if maxAge, ok := data["max_age"].(float64); ok && maxAge >= 0 {
+		policy.MaxAge = int(maxAge)
+	}
+}
+
+// parseIPFilterRule 解析IP访问控制规则并检查地址格式
+func parseIPFilterRule(data map[string]interface{}, rule *config.IPFilterRule) error {
+	if allow, ok := data["allow"].([]interface{}); ok {
+		list := parseStringList(allow)
+		if invalid, valid := middleware.ValidateIPList(list); !valid {
+			return fmt.Errorf("无效的地址: %s", invalid)
+		}
+		rule.Allow = list
+	}
+	if deny, ok := data["deny"].([]interface{}); ok {
+		list := parseStringList(deny)
+		if invalid, valid := middleware.ValidateIPList(list); !valid {
+			return fmt.Errorf("无效的地址: %s", invalid)
+		}
+		rule.Deny = list
+	}
+	return nil
+}
+
+// handleEnableKeysByTag 启用包含指定标签的所有密钥
+func handleEnableKeysByTag(c *gin.Context) {
+	tag := c.Param("tag")
+	keys := config.GetApiKeysByTag(tag)
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("没有包含标签 %s 的API密钥", tag),
+		})
+		return
+	}
+
+	// 余额低于阈值的密钥会启用失败，单独统计
+	enabledCount := 0
+	var failedKeys []string
+	for _, k := range keys {
+		if config.EnableApiKey(k.Key) {
+			enabledCount++
+		} else {
+			failedKeys = append(failedKeys, utils.MaskKey(k.Key))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     fmt.Sprintf("已启用 %d 个包含标签 %s 的API密钥", enabledCount, tag),
+		"enabled":     enabledCount,
+		"failed_keys": failedKeys,
+	})
+}
+
+// handleDisableKeysByTag 禁用包含指定标签的所有密钥
+func handleDisableKeysByTag(c *gin.Context) {
+	tag := c.Param("tag")
+	keys := config.GetApiKeysByTag(tag)
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("没有包含标签 %s 的API密钥", tag),
+		})
+		return
+	}
+
+	disabledCount := 0
+	for _, k := range keys {
+		if config.DisableApiKey(k.Key) {
+			disabledCount++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("已禁用 %d 个包含标签 %s 的API密钥", disabledCount, tag),
+		"disabled": disabledCount,
+	})
+}
+
+// handleDeleteKeysByTag 删除包含指定标签的所有密钥
+func handleDeleteKeysByTag(c *gin.Context) {
+	tag := c.Param("tag")
+	keys := config.GetApiKeysByTag(tag)
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("没有包含标签 %s 的API密钥", tag),
+		})
+		return
+	}
+
+	// 标记这些API密钥为删除状态
+	for _, k := range keys {
+		config.MarkApiKeyForDeletion(k.Key)
+	}
+
+	// 立即从列表中移除已标记为删除的密钥
+	config.RemoveMarkedApiKeys()
+
+	// 保存更新后的状态
+	if err := config.SaveApiKeys(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("无法保存API密钥状态: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("已删除 %d 个包含标签 %s 的API密钥", len(keys), tag),
+		"deleted": len(keys),
+	})
+}
+
 // handleEnableKey 处理启用 API 密钥的请求
 func handleEnableKey(c *gin.Context) {
 	key := c.Param("key")
@@ -904,6 +1186,13 @@ func handleGetDailyStatsByDate(c *gin.Context) {
 	})
 }
 
+// handleGetQueueStats 获取请求队列的深度和各优先级类别的统计
+func handleGetQueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"queue": key.GetQueueStats(),
+	})
+}
+
 // handleGetSettings 处理获取系统设置的请求
 func handleGetSettings(c *gin.Context) {
 	// 获取当前配置
@@ -919,16 +1208,30 @@ func handleGetSettings(c *gin.Context) {
 	configData := gin.H{
 		"server": gin.H{
 			"port": cfg.Server.Port,
+			"tls": gin.H{
+				"enabled":             cfg.Server.TLS.Enabled,
+				"cert_file":           cfg.Server.TLS.CertFile,
+				"key_file":            cfg.Server.TLS.KeyFile,
+				"self_signed":         cfg.Server.TLS.SelfSigned,
+				"client_ca_file":      cfg.Server.TLS.ClientCAFile,
+				"require_client_cert": cfg.Server.TLS.RequireClientCert,
+			},
 		},
 		"api_proxy": gin.H{
 			"base_url":             cfg.ApiProxy.BaseURL,
 			"model_index":          cfg.ApiProxy.ModelIndex,
 			"model_key_strategies": cfg.App.ModelKeyStrategies,
+			"model_key_selectors":  cfg.App.ModelKeySelectors,
 			"retry": gin.H{
 				"max_retries":             cfg.ApiProxy.Retry.MaxRetries,
 				"retry_delay_ms":          cfg.ApiProxy.Retry.RetryDelayMs,
 				"retry_on_status_codes":   cfg.ApiProxy.Retry.RetryOnStatusCodes,
 				"retry_on_network_errors": cfg.ApiProxy.Retry.RetryOnNetworkErrors,
+				"backoff_multiplier":      cfg.ApiProxy.Retry.BackoffMultiplier,
+				"max_retry_delay_ms":      cfg.ApiProxy.Retry.MaxRetryDelayMs,
+				"jitter_ratio":            cfg.ApiProxy.Retry.JitterRatio,
+				"rate_limit_cooldown_ms":  cfg.ApiProxy.Retry.RateLimitCooldownMs,
+				"max_cooldown_ms":         cfg.ApiProxy.Retry.MaxCooldownMs,
 			},
 		},
 		"proxy": gin.H{
@@ -944,6 +1247,10 @@ func handleGetSettings(c *gin.Context) {
 			"api_key_enabled":    cfg.Security.ApiKeyEnabled,
 			"api_key":            cfg.Security.ApiKey,
 			// 不返回哈希后的密码
+			"login_max_attempts":        cfg.Security.LoginMaxAttempts,
+			"login_window_minutes":      cfg.Security.LoginWindowMinutes,
+			"login_lockout_minutes":     cfg.Security.LoginLockoutMinutes,
+			"login_global_max_failures": cfg.Security.LoginGlobalMaxFailures,
 		},
 		"app": gin.H{
 			"title":                         cfg.App.Title,
@@ -957,6 +1264,11 @@ func handleGetSettings(c *gin.Context) {
 			"success_rate_weight":           cfg.App.SuccessRateWeight,
 			"rpm_weight":                    cfg.App.RPMWeight,
 			"tpm_weight":                    cfg.App.TPMWeight,
+			"composite_weights":             cfg.App.CompositeWeights,
+			"request_type_strategies":       cfg.App.RequestTypeStrategies,
+			"max_concurrency_per_key":       cfg.App.MaxConcurrencyPerKey,
+			"concurrency_wait_timeout_ms":   cfg.App.ConcurrencyWaitTimeoutMs,
+			"key_selectors":                 key.ListKeySelectors(),
 			"auto_update_interval":          cfg.App.AutoUpdateInterval,
 			"stats_refresh_interval":        cfg.App.StatsRefreshInterval,
 			"rate_refresh_interval":         cfg.App.RateRefreshInterval,
@@ -965,6 +1277,78 @@ func handleGetSettings(c *gin.Context) {
 			"hide_icon":                     cfg.App.HideIcon,
 			"disabled_models":               cfg.App.DisabledModels,
 		},
+		"request_queue": gin.H{
+			"max_length":      cfg.RequestQueue.MaxLength,
+			"priority_header": cfg.RequestQueue.PriorityHeader,
+			"default_class":   cfg.RequestQueue.DefaultClass,
+			"classes":         cfg.RequestQueue.Classes,
+			"client_classes":  cfg.RequestQueue.ClientClasses,
+		},
+		"key_groups": gin.H{
+			"model_rules":  cfg.KeyGroups.ModelRules,
+			"client_rules": cfg.KeyGroups.ClientRules,
+		},
+		"prompts": gin.H{
+			"client_names": cfg.Prompts.ClientNames,
+		},
+		"reasoning": gin.H{
+			"mode":         cfg.Reasoning.Mode,
+			"client_modes": cfg.Reasoning.ClientModes,
+		},
+		"video": gin.H{
+			"download_results": cfg.Video.DownloadResults,
+			"storage_dir":      cfg.Video.StorageDir,
+			"retention_days":   cfg.Video.RetentionDays,
+		},
+		"images": gin.H{
+			"archive":         cfg.Images.Archive,
+			"storage_dir":     cfg.Images.StorageDir,
+			"public_base_url": cfg.Images.PublicBaseURL,
+			"retention_days":  cfg.Images.RetentionDays,
+			"max_images":      cfg.Images.MaxImages,
+		},
+		"embeddings": gin.H{
+			"batch_size":         cfg.Embeddings.BatchSize,
+			"max_parallel":       cfg.Embeddings.MaxParallel,
+			"coalesce":           cfg.Embeddings.Coalesce,
+			"coalesce_window_ms": cfg.Embeddings.CoalesceWindowMs,
+		},
+		"structured_output": gin.H{
+			"enforce":             cfg.StructuredOutput.Enforce,
+			"max_repair_attempts": cfg.StructuredOutput.MaxRepairAttempts,
+		},
+		"vision": gin.H{
+			"reject_unsupported":   cfg.Vision.RejectUnsupported,
+			"inline_remote_images": cfg.Vision.InlineRemoteImages,
+			"max_image_size_mb":    cfg.Vision.MaxImageSizeMB,
+			"max_images":           cfg.Vision.MaxImages,
+			"fetch_timeout":        cfg.Vision.FetchTimeout,
+		},
+		"streaming": gin.H{
+			"keepalive_interval": cfg.Streaming.KeepaliveInterval,
+		},
+		"oidc": gin.H{
+			"enabled":         cfg.OIDC.Enabled,
+			"issuer":          cfg.OIDC.Issuer,
+			"client_id":       cfg.OIDC.ClientID,
+			"client_secret":   maskOIDCClientSecret(cfg.OIDC.ClientSecret),
+			"redirect_url":    cfg.OIDC.RedirectURL,
+			"scopes":          cfg.OIDC.Scopes,
+			"allowed_domains": cfg.OIDC.AllowedDomains,
+			"allowed_groups":  cfg.OIDC.AllowedGroups,
+			"groups_claim":    cfg.OIDC.GroupsClaim,
+			"default_role":    cfg.OIDC.DefaultRole,
+		},
+		"cors": gin.H{
+			"proxy": corsPolicyToMap(cfg.Cors.Proxy),
+			"admin": corsPolicyToMap(cfg.Cors.Admin),
+		},
+		"ip_filter": gin.H{
+			"trusted_proxies": cfg.IPFilter.TrustedProxies,
+			"proxy":           gin.H{"allow": cfg.IPFilter.Proxy.Allow, "deny": cfg.IPFilter.Proxy.Deny},
+			"api":             gin.H{"allow": cfg.IPFilter.Api.Allow, "deny": cfg.IPFilter.Api.Deny},
+			"admin":           gin.H{"allow": cfg.IPFilter.Admin.Allow, "deny": cfg.IPFilter.Admin.Deny},
+		},
 		"log": gin.H{
 			"max_size_mb": cfg.Log.MaxSizeMB,
 			"level":       cfg.Log.Level,
@@ -1000,11 +1384,6 @@ func handleSaveSettings(c *gin.Context) {
 		passwordEnabled, passwordEnabledExists := security["password_enabled"].(bool)
 		password, passwordExists := security["password"].(string)
 
-		fmt.Println("passwordEnabledExists", passwordEnabledExists)
-		fmt.Println("passwordEnabled", passwordEnabled)
-		fmt.Println("passwordExists", passwordExists)
-		fmt.Println("password", password)
-
 		// 检查是否尝试启用密码保护但没有提供密码
 		if passwordEnabledExists && passwordEnabled {
 			// 如果当前没有密码，且没有提供新密码，则返回错误
@@ -1035,12 +1414,47 @@ func handleSaveSettings(c *gin.Context) {
 
 	// 创建一个新的Config对象进行更新
 	newConfig := *currentConfig
+	// 记录是否修改了密码
+	passwordChanged := false
 
 	// 服务器设置
 	if server, ok := configData["server"].(map[string]interface{}); ok {
 		if port, ok := server["port"].(float64); ok {
 			newConfig.Server.Port = int(port)
 		}
+		if tlsConfig, ok := server["tls"].(map[string]interface{}); ok {
+			if enabled, ok := tlsConfig["enabled"].(bool); ok {
+				newConfig.Server.TLS.Enabled = enabled
+			}
+			if certFile, ok := tlsConfig["cert_file"].(string); ok {
+				newConfig.Server.TLS.CertFile = strings.TrimSpace(certFile)
+			}
+			if keyFile, ok := tlsConfig["key_file"].(string); ok {
+				newConfig.Server.TLS.KeyFile = strings.TrimSpace(keyFile)
+			}
+			if selfSigned, ok := tlsConfig["self_signed"].(bool); ok {
+				newConfig.Server.TLS.SelfSigned = selfSigned
+			}
+			if clientCAFile, ok := tlsConfig["client_ca_file"].(string); ok {
+				newConfig.Server.TLS.ClientCAFile = strings.TrimSpace(clientCAFile)
+			}
+			if requireClientCert, ok := tlsConfig["require_client_cert"].(bool); ok {
+				newConfig.Server.TLS.RequireClientCert = requireClientCert
+			}
+			if newConfig.Server.TLS.Enabled && !newConfig.Server.TLS.SelfSigned &&
+				(newConfig.Server.TLS.CertFile == "" || newConfig.Server.TLS.KeyFile == "") {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "启用HTTPS时需要指定证书和私钥文件，或开启自签名证书",
+				})
+				return
+			}
+			if newConfig.Server.TLS.RequireClientCert && newConfig.Server.TLS.ClientCAFile == "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "要求客户端证书时需要指定CA证书文件",
+				})
+				return
+			}
+		}
 	}
 
 	// API代理设置
@@ -1065,6 +1479,22 @@ func handleSaveSettings(c *gin.Context) {
 			}
 		}
 
+		// 处理按名称指定的模型策略
+		if modelKeySelectors, ok := apiProxy["model_key_selectors"].(map[string]interface{}); ok {
+			newConfig.App.ModelKeySelectors = make(map[string]string)
+			for modelName, selector := range modelKeySelectors {
+				if selectorName, ok := selector.(string); ok && selectorName != "" {
+					if _, exists := key.GetKeySelector(selectorName); !exists {
+						c.JSON(http.StatusBadRequest, gin.H{
+							"error": fmt.Sprintf("未知的密钥选择策略: %s", selectorName),
+						})
+						return
+					}
+					newConfig.App.ModelKeySelectors[modelName] = selectorName
+				}
+			}
+		}
+
 		// 重试配置
 		if retry, ok := apiProxy["retry"].(map[string]interface{}); ok {
 			if maxRetries, ok := retry["max_retries"].(float64); ok {
@@ -1076,6 +1506,21 @@ func handleSaveSettings(c *gin.Context) {
 			if networkErrors, ok := retry["retry_on_network_errors"].(bool); ok {
 				newConfig.ApiProxy.Retry.RetryOnNetworkErrors = networkErrors
 			}
+			if backoffMultiplier, ok := retry["backoff_multiplier"].(float64); ok {
+				newConfig.ApiProxy.Retry.BackoffMultiplier = backoffMultiplier
+			}
+			if maxRetryDelay, ok := retry["max_retry_delay_ms"].(float64); ok {
+				newConfig.ApiProxy.Retry.MaxRetryDelayMs = int(maxRetryDelay)
+			}
+			if jitterRatio, ok := retry["jitter_ratio"].(float64); ok {
+				newConfig.ApiProxy.Retry.JitterRatio = jitterRatio
+			}
+			if cooldown, ok := retry["rate_limit_cooldown_ms"].(float64); ok {
+				newConfig.ApiProxy.Retry.RateLimitCooldownMs = int(cooldown)
+			}
+			if maxCooldown, ok := retry["max_cooldown_ms"].(float64); ok {
+				newConfig.ApiProxy.Retry.MaxCooldownMs = int(maxCooldown)
+			}
 			if statusCodes, ok := retry["retry_on_status_codes"].([]interface{}); ok {
 				codes := make([]int, 0, len(statusCodes))
 				for _, code := range statusCodes {
@@ -1115,6 +1560,18 @@ func handleSaveSettings(c *gin.Context) {
 		if expirationMinutes, ok := security["expiration_minutes"].(float64); ok {
 			newConfig.Security.ExpirationMinutes = int(expirationMinutes)
 		}
+		if maxAttempts, ok := security["login_max_attempts"].(float64); ok {
+			newConfig.Security.LoginMaxAttempts = int(maxAttempts)
+		}
+		if windowMinutes, ok := security["login_window_minutes"].(float64); ok {
+			newConfig.Security.LoginWindowMinutes = int(windowMinutes)
+		}
+		if lockoutMinutes, ok := security["login_lockout_minutes"].(float64); ok {
+			newConfig.Security.LoginLockoutMinutes = int(lockoutMinutes)
+		}
+		if globalMaxFailures, ok := security["login_global_max_failures"].(float64); ok {
+			newConfig.Security.LoginGlobalMaxFailures = int(globalMaxFailures)
+		}
 
 		// 处理API密钥设置
 		if apiKeyEnabled, ok := security["api_key_enabled"].(bool); ok {
@@ -1127,8 +1584,16 @@ func handleSaveSettings(c *gin.Context) {
 
 		// 处理密码，如果提供了新密码则进行哈希处理
 		if password, ok := security["password"].(string); ok && password != "" {
-			// 使用SHA256哈希保存密码
-			newConfig.Security.Password = auth.HashPassword(password)
+			// 使用bcrypt加盐哈希保存密码
+			hashedPassword, err := auth.HashPassword(password)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("密码处理失败: %v", err),
+				})
+				return
+			}
+			newConfig.Security.Password = hashedPassword
+			passwordChanged = true
 		}
 	}
 
@@ -1172,6 +1637,38 @@ func handleSaveSettings(c *gin.Context) {
 		if tpmWeight, ok := app["tpm_weight"].(float64); ok {
 			newConfig.App.TPMWeight = tpmWeight
 		}
+		if compositeWeights, ok := app["composite_weights"].(map[string]interface{}); ok {
+			newConfig.App.CompositeWeights = make(map[string]float64)
+			for factor, weight := range compositeWeights {
+				if weightValue, ok := weight.(float64); ok {
+					newConfig.App.CompositeWeights[factor] = weightValue
+				}
+			}
+		}
+
+		// 并发控制配置
+		if maxConcurrency, ok := app["max_concurrency_per_key"].(float64); ok {
+			newConfig.App.MaxConcurrencyPerKey = int(maxConcurrency)
+		}
+		if waitTimeout, ok := app["concurrency_wait_timeout_ms"].(float64); ok {
+			newConfig.App.ConcurrencyWaitTimeoutMs = int(waitTimeout)
+		}
+
+		// 请求类型策略配置
+		if requestTypeStrategies, ok := app["request_type_strategies"].(map[string]interface{}); ok {
+			newConfig.App.RequestTypeStrategies = make(map[string]string)
+			for requestType, selector := range requestTypeStrategies {
+				if selectorName, ok := selector.(string); ok && selectorName != "" {
+					if _, exists := key.GetKeySelector(selectorName); !exists {
+						c.JSON(http.StatusBadRequest, gin.H{
+							"error": fmt.Sprintf("未知的密钥选择策略: %s", selectorName),
+						})
+						return
+					}
+					newConfig.App.RequestTypeStrategies[requestType] = selectorName
+				}
+			}
+		}
 
 		// 自动更新配置
 		if autoUpdate, ok := app["auto_update_interval"].(float64); ok {
@@ -1203,48 +1700,379 @@ func handleSaveSettings(c *gin.Context) {
 		}
 	}
 
-	// 日志设置
-	if log, ok := configData["log"].(map[string]interface{}); ok {
-		if maxSize, ok := log["max_size_mb"].(float64); ok {
-			newConfig.Log.MaxSizeMB = int(maxSize)
+	// 请求队列设置
+	if requestQueue, ok := configData["request_queue"].(map[string]interface{}); ok {
+		if maxLength, ok := requestQueue["max_length"].(float64); ok {
+			newConfig.RequestQueue.MaxLength = int(maxLength)
 		}
-		if level, ok := log["level"].(string); ok {
-			newConfig.Log.Level = level
+		if priorityHeader, ok := requestQueue["priority_header"].(string); ok {
+			newConfig.RequestQueue.PriorityHeader = priorityHeader
+		}
+		if classes, ok := requestQueue["classes"].(map[string]interface{}); ok {
+			newConfig.RequestQueue.Classes = make(map[string]int)
+			for class, priority := range classes {
+				if priorityValue, ok := priority.(float64); ok {
+					newConfig.RequestQueue.Classes[strings.ToLower(class)] = int(priorityValue)
+				}
+			}
+		}
+		if defaultClass, ok := requestQueue["default_class"].(string); ok {
+			defaultClass = strings.ToLower(defaultClass)
+			if _, exists := newConfig.RequestQueue.Classes[defaultClass]; defaultClass != "" && len(newConfig.RequestQueue.Classes) > 0 && !exists {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("默认优先级类别 %s 不在已配置的类别中", defaultClass),
+				})
+				return
+			}
+			newConfig.RequestQueue.DefaultClass = defaultClass
+		}
+		if clientClasses, ok := requestQueue["client_classes"].(map[string]interface{}); ok {
+			newConfig.RequestQueue.ClientClasses = make(map[string]string)
+			for clientKey, class := range clientClasses {
+				if className, ok := class.(string); ok && className != "" {
+					newConfig.RequestQueue.ClientClasses[clientKey] = strings.ToLower(className)
+				}
+			}
 		}
 	}
 
-	// 更新配置
-	config.UpdateConfig(&newConfig)
-
-	// 保存到数据库
-	if err := config.SaveConfigToDB(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("保存配置到数据库失败: %v", err),
-		})
-		return
+	// 密钥分组路由设置
+	if keyGroups, ok := configData["key_groups"].(map[string]interface{}); ok {
+		if modelRules, ok := keyGroups["model_rules"].(map[string]interface{}); ok {
+			newConfig.KeyGroups.ModelRules = parseTagRules(modelRules)
+		}
+		if clientRules, ok := keyGroups["client_rules"].(map[string]interface{}); ok {
+			newConfig.KeyGroups.ClientRules = parseTagRules(clientRules)
+		}
 	}
 
-	// 返回成功消息
-	c.JSON(http.StatusOK, gin.H{
-		"message": "配置保存成功",
-	})
-}
+	// 提示词模板设置
+	if prompts, ok := configData["prompts"].(map[string]interface{}); ok {
+		if clientNames, ok := prompts["client_names"].(map[string]interface{}); ok {
+			newConfig.Prompts.ClientNames = make(map[string]string)
+			for clientKey, name := range clientNames {
+				if clientName, ok := name.(string); ok && clientName != "" {
+					newConfig.Prompts.ClientNames[clientKey] = clientName
+				}
+			}
+		}
+	}
 
-// handleRefreshAllKeysBalance 处理刷新所有API密钥余额的请求
-func handleRefreshAllKeysBalance(c *gin.Context) {
-	// 使用新的ForceRefreshAllKeysBalance函数，该函数带有2秒超时
-	err := key.ForceRefreshAllKeysBalance()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("刷新API密钥余额失败: %v", err),
-		})
-		return
+	// 推理内容返回方式设置
+	if reasoning, ok := configData["reasoning"].(map[string]interface{}); ok {
+		if mode, ok := reasoning["mode"].(string); ok {
+			if !proxy.IsValidReasoningMode(mode) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "推理内容返回方式必须是passthrough、strip或think",
+				})
+				return
+			}
+			newConfig.Reasoning.Mode = mode
+		}
+		if clientModes, ok := reasoning["client_modes"].(map[string]interface{}); ok {
+			newConfig.Reasoning.ClientModes = make(map[string]string)
+			for clientKey, value := range clientModes {
+				mode, _ := value.(string)
+				if !proxy.IsValidReasoningMode(mode) {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error": fmt.Sprintf("客户端 %s 的推理内容返回方式必须是passthrough、strip或think", clientKey),
+					})
+					return
+				}
+				newConfig.Reasoning.ClientModes[clientKey] = mode
+			}
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "所有API密钥余额刷新成功",
-	})
-}
+	// 视频任务设置
+	if video, ok := configData["video"].(map[string]interface{}); ok {
+		if downloadResults, ok := video["download_results"].(bool); ok {
+			newConfig.Video.DownloadResults = downloadResults
+		}
+		if storageDir, ok := video["storage_dir"].(string); ok {
+			newConfig.Video.StorageDir = strings.TrimSpace(storageDir)
+		}
+		if retentionDays, ok := video["retention_days"].(float64); ok {
+			if retentionDays < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "视频任务保留天数不能为负数",
+				})
+				return
+			}
+			newConfig.Video.RetentionDays = int(retentionDays)
+		}
+	}
+
+	// 图片归档设置
+	if images, ok := configData["images"].(map[string]interface{}); ok {
+		if archive, ok := images["archive"].(bool); ok {
+			newConfig.Images.Archive = archive
+		}
+		if storageDir, ok := images["storage_dir"].(string); ok {
+			newConfig.Images.StorageDir = strings.TrimSpace(storageDir)
+		}
+		if publicBaseURL, ok := images["public_base_url"].(string); ok {
+			publicBaseURL = strings.TrimRight(strings.TrimSpace(publicBaseURL), "/")
+			if publicBaseURL != "" && !strings.HasPrefix(publicBaseURL, "http://") && !strings.HasPrefix(publicBaseURL, "https://") {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "图片外部访问地址必须以http://或https://开头",
+				})
+				return
+			}
+			newConfig.Images.PublicBaseURL = publicBaseURL
+		}
+		if retentionDays, ok := images["retention_days"].(float64); ok {
+			if retentionDays < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "归档图片保留天数不能为负数",
+				})
+				return
+			}
+			newConfig.Images.RetentionDays = int(retentionDays)
+		}
+		if maxImages, ok := images["max_images"].(float64); ok {
+			if maxImages < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "归档图片保留数量不能为负数",
+				})
+				return
+			}
+			newConfig.Images.MaxImages = int(maxImages)
+		}
+	}
+
+	// embeddings请求拆分与合并设置
+	if embeddings, ok := configData["embeddings"].(map[string]interface{}); ok {
+		// 批量上限为0时使用默认值，负数表示不拆分
+		if batchSize, ok := embeddings["batch_size"].(float64); ok {
+			if batchSize < 0 {
+				batchSize = -1
+			}
+			newConfig.Embeddings.BatchSize = int(batchSize)
+		}
+		if maxParallel, ok := embeddings["max_parallel"].(float64); ok {
+			if maxParallel < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "embeddings并行请求数必须大于0",
+				})
+				return
+			}
+			newConfig.Embeddings.MaxParallel = int(maxParallel)
+		}
+		if coalesce, ok := embeddings["coalesce"].(bool); ok {
+			newConfig.Embeddings.Coalesce = coalesce
+		}
+		if windowMs, ok := embeddings["coalesce_window_ms"].(float64); ok {
+			if windowMs < 0 || windowMs > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "embeddings合并等待时间必须在0到1000毫秒之间",
+				})
+				return
+			}
+			newConfig.Embeddings.CoalesceWindowMs = int(windowMs)
+		}
+	}
+
+	// 结构化输出校验设置
+	if structuredOutput, ok := configData["structured_output"].(map[string]interface{}); ok {
+		if enforce, ok := structuredOutput["enforce"].(bool); ok {
+			newConfig.StructuredOutput.Enforce = enforce
+		}
+		if maxRepairAttempts, ok := structuredOutput["max_repair_attempts"].(float64); ok {
+			if maxRepairAttempts < 0 || maxRepairAttempts > 5 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "结构化输出修正次数必须在0到5之间",
+				})
+				return
+			}
+			newConfig.StructuredOutput.MaxRepairAttempts = int(maxRepairAttempts)
+		}
+	}
+
+	// 图片输入设置
+	if vision, ok := configData["vision"].(map[string]interface{}); ok {
+		if rejectUnsupported, ok := vision["reject_unsupported"].(bool); ok {
+			newConfig.Vision.RejectUnsupported = rejectUnsupported
+		}
+		if inlineRemoteImages, ok := vision["inline_remote_images"].(bool); ok {
+			newConfig.Vision.InlineRemoteImages = inlineRemoteImages
+		}
+		if maxImageSizeMB, ok := vision["max_image_size_mb"].(float64); ok {
+			if maxImageSizeMB < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "单张图片的最大大小必须大于0",
+				})
+				return
+			}
+			newConfig.Vision.MaxImageSizeMB = int(maxImageSizeMB)
+		}
+		if maxImages, ok := vision["max_images"].(float64); ok {
+			if maxImages < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "单次请求的最大图片数量不能为负数",
+				})
+				return
+			}
+			newConfig.Vision.MaxImages = int(maxImages)
+		}
+		if fetchTimeout, ok := vision["fetch_timeout"].(float64); ok {
+			if fetchTimeout < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "下载远程图片的超时时间必须大于0",
+				})
+				return
+			}
+			newConfig.Vision.FetchTimeout = int(fetchTimeout)
+		}
+	}
+
+	// 流式响应设置
+	if streaming, ok := configData["streaming"].(map[string]interface{}); ok {
+		if keepaliveInterval, ok := streaming["keepalive_interval"].(float64); ok {
+			if keepaliveInterval > 300 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "保活注释的间隔不能超过300秒",
+				})
+				return
+			}
+			newConfig.Streaming.KeepaliveInterval = int(keepaliveInterval)
+		}
+	}
+
+	// 单点登录设置
+	if oidc, ok := configData["oidc"].(map[string]interface{}); ok {
+		if enabled, ok := oidc["enabled"].(bool); ok {
+			newConfig.OIDC.Enabled = enabled
+		}
+		if issuer, ok := oidc["issuer"].(string); ok {
+			newConfig.OIDC.Issuer = strings.TrimSpace(issuer)
+		}
+		if clientID, ok := oidc["client_id"].(string); ok {
+			newConfig.OIDC.ClientID = strings.TrimSpace(clientID)
+		}
+		// 掩码表示沿用已保存的客户端密钥
+		if clientSecret, ok := oidc["client_secret"].(string); ok && clientSecret != oidcClientSecretMask {
+			newConfig.OIDC.ClientSecret = clientSecret
+		}
+		if redirectURL, ok := oidc["redirect_url"].(string); ok {
+			newConfig.OIDC.RedirectURL = strings.TrimSpace(redirectURL)
+		}
+		if scopes, ok := oidc["scopes"].([]interface{}); ok {
+			newConfig.OIDC.Scopes = parseStringList(scopes)
+		}
+		if allowedDomains, ok := oidc["allowed_domains"].([]interface{}); ok {
+			newConfig.OIDC.AllowedDomains = parseStringList(allowedDomains)
+		}
+		if allowedGroups, ok := oidc["allowed_groups"].([]interface{}); ok {
+			newConfig.OIDC.AllowedGroups = parseStringList(allowedGroups)
+		}
+		if groupsClaim, ok := oidc["groups_claim"].(string); ok {
+			newConfig.OIDC.GroupsClaim = strings.TrimSpace(groupsClaim)
+		}
+		if defaultRole, ok := oidc["default_role"].(string); ok && auth.IsValidRole(defaultRole) {
+			newConfig.OIDC.DefaultRole = defaultRole
+		}
+	}
+
+	// 跨域设置
+	if cors, ok := configData["cors"].(map[string]interface{}); ok {
+		if proxyPolicy, ok := cors["proxy"].(map[string]interface{}); ok {
+			parseCorsPolicy(proxyPolicy, &newConfig.Cors.Proxy)
+		}
+		if adminPolicy, ok := cors["admin"].(map[string]interface{}); ok {
+			parseCorsPolicy(adminPolicy, &newConfig.Cors.Admin)
+		}
+	}
+
+	// IP访问控制设置
+	if ipFilter, ok := configData["ip_filter"].(map[string]interface{}); ok {
+		if trustedProxies, ok := ipFilter["trusted_proxies"].([]interface{}); ok {
+			list := parseStringList(trustedProxies)
+			if invalid, valid := middleware.ValidateIPList(list); !valid {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("无效的受信任代理地址: %s", invalid),
+				})
+				return
+			}
+			newConfig.IPFilter.TrustedProxies = list
+		}
+		rules := map[string]*config.IPFilterRule{
+			"proxy": &newConfig.IPFilter.Proxy,
+			"api":   &newConfig.IPFilter.Api,
+			"admin": &newConfig.IPFilter.Admin,
+		}
+		for scope, rule := range rules {
+			ruleData, ok := ipFilter[scope].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := parseIPFilterRule(ruleData, rule); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("%s接口的IP访问控制配置无效: %v", scope, err),
+				})
+				return
+			}
+		}
+		// 防止管理员把自己锁在管理界面之外
+		if !middleware.IPAllowed(middleware.ClientIP(c), newConfig.IPFilter.Admin) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "保存后当前IP将无法访问管理界面，请先将当前IP加入允许列表",
+			})
+			return
+		}
+	}
+
+	// 日志设置
+	if log, ok := configData["log"].(map[string]interface{}); ok {
+		if maxSize, ok := log["max_size_mb"].(float64); ok {
+			newConfig.Log.MaxSizeMB = int(maxSize)
+		}
+		if level, ok := log["level"].(string); ok {
+			newConfig.Log.Level = level
+		}
+	}
+
+	// 更新配置
+	config.UpdateConfig(&newConfig)
+
+	// 保存到数据库
+	if err := config.SaveConfigToDB(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("保存配置到数据库失败: %v", err),
+		})
+		return
+	}
+
+	// 修改密码后使所有已登录会话失效，并为当前用户重新签发令牌
+	if passwordChanged {
+		if err := auth.InvalidateAllSessions(); err != nil {
+			logger.Error("使登录会话失效失败: %v", err)
+		} else if newConfig.Security.PasswordEnabled {
+			setAuthCookie(c, c.GetString(middleware.ContextUsernameKey), newConfig.Security.ExpirationMinutes)
+		}
+	}
+
+	// 返回成功消息
+	c.JSON(http.StatusOK, gin.H{
+		"message": "配置保存成功",
+	})
+}
+
+// handleRefreshAllKeysBalance 处理刷新所有API密钥余额的请求
+func handleRefreshAllKeysBalance(c *gin.Context) {
+	// 使用新的ForceRefreshAllKeysBalance函数，该函数带有2秒超时
+	err := key.ForceRefreshAllKeysBalance()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("刷新API密钥余额失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "所有API密钥余额刷新成功",
+	})
+}
 
 // handleSystemRestart 处理系统重启请求
 func handleSystemRestart(c *gin.Context) {
@@ -1834,41 +2662,51 @@ func updateModelTypeHandler(c *gin.Context) {
 	})
 }
 
-// handleModelManagementPage 处理模型管理页面请求
-func handleModelManagementPage(c *gin.Context) {
-	// 获取版本号
-	version := config.GetVersion()
-	if version == "" {
-		version = "v1.0.0" // 默认版本号
+// updateModelToolEmulationHandler 设置模型是否模拟工具调用
+func updateModelToolEmulationHandler(c *gin.Context) {
+	var req struct {
+		ModelID string `json:"model_id"`
+		Enabled bool   `json:"enabled"`
 	}
 
-	// 获取配置
-	cfg := config.GetConfig()
-	if cfg == nil {
-		// 如果配置为空，使用默认标题
-		c.HTML(http.StatusOK, "llmmodel.html", gin.H{
-			"title":   "流动硅基",
-			"version": version,
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 使用配置中的标题
-	c.HTML(http.StatusOK, "llmmodel.html", gin.H{
-		"title":   cfg.App.Title,
-		"version": version,
+	if req.ModelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "模型ID不能为空",
+		})
+		return
+	}
+
+	if err := model.UpdateModelToolEmulation(req.ModelID, req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("更新工具调用模拟设置失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("已将模型 %s 的工具调用模拟设置为 %v", req.ModelID, req.Enabled),
 	})
 }
 
-// deleteModelStrategyHandler 删除模型策略
-func deleteModelStrategyHandler(c *gin.Context) {
-	// 获取请求参数
+// updateModelVisionHandler 设置模型是否支持图片输入
+func updateModelVisionHandler(c *gin.Context) {
 	var req struct {
 		ModelID string `json:"model_id"`
+		Enabled bool   `json:"enabled"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logger.Error("解析请求参数失败: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "解析请求参数失败: " + err.Error(),
@@ -1876,7 +2714,6 @@ func deleteModelStrategyHandler(c *gin.Context) {
 		return
 	}
 
-	// 如果模型ID为空，返回错误
 	if req.ModelID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -1885,60 +2722,403 @@ func deleteModelStrategyHandler(c *gin.Context) {
 		return
 	}
 
-	// 从数据库中删除模型策略
-	err := model.DeleteModelStrategy(req.ModelID)
-	if err != nil {
-		logger.Error("删除模型策略失败: %v", err)
+	if err := model.UpdateModelVision(req.ModelID, req.Enabled); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "删除模型策略失败: " + err.Error(),
+			"message": fmt.Sprintf("更新图片输入支持设置失败: %v", err),
 		})
 		return
 	}
 
-	// 更新配置中的模型策略
-	cfg := config.GetConfig()
-	if cfg.App.ModelKeyStrategies != nil {
-		// 从配置中删除模型策略
-		delete(cfg.App.ModelKeyStrategies, req.ModelID)
-		config.UpdateConfig(cfg)
-		config.SaveConfigToDB()
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": fmt.Sprintf("成功从数据库删除模型 %s 的策略", req.ModelID),
+		"message": fmt.Sprintf("已将模型 %s 的图片输入支持设置为 %v", req.ModelID, req.Enabled),
 	})
 }
 
-// handleLoginPage 处理登录页面请求
-func handleLoginPage(c *gin.Context) {
-	// 获取重定向路径（如果有）
-	redirect, _ := c.Cookie("redirect_after_login")
+// getTransformRulesHandler 获取请求体转换规则列表
+func getTransformRulesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    model.GetTransformRules(),
+	})
+}
 
-	// 如果从查询参数中也传入了重定向路径，优先使用它
-	if redirectParam := c.Query("redirect"); redirectParam != "" {
-		redirect = redirectParam
+// createTransformRuleHandler 创建请求体转换规则
+func createTransformRuleHandler(c *gin.Context) {
+	var rule model.TransformRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
 	}
 
-	// 清除重定向cookie
-	c.SetCookie("redirect_after_login", "", -1, "/", "", false, false)
-
-	// 获取错误信息（如果有）
-	error := c.Query("error")
+	created, err := model.CreateTransformRule(rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("创建转换规则失败: %v", err),
+		})
+		return
+	}
 
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"title":    config.GetConfig().App.Title,
-		"redirect": redirect,
-		"error":    error,
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "转换规则已创建",
+		"data":    created,
 	})
 }
 
-// handleLogin 处理登录请求
-func handleLogin(c *gin.Context) {
-	// 获取表单参数
-	password := c.PostForm("password")
-	redirect := c.PostForm("redirect")
+// updateTransformRuleHandler 更新请求体转换规则
+func updateTransformRuleHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的规则ID",
+		})
+		return
+	}
+
+	var rule model.TransformRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
+	}
+	rule.ID = id
+
+	if err := model.UpdateTransformRule(rule); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, model.ErrTransformRuleNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("更新转换规则失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "转换规则已更新",
+		"data":    rule,
+	})
+}
+
+// deleteTransformRuleHandler 删除请求体转换规则
+func deleteTransformRuleHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的规则ID",
+		})
+		return
+	}
+
+	if err := model.DeleteTransformRule(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, model.ErrTransformRuleNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("删除转换规则失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "转换规则已删除",
+	})
+}
+
+// getPromptTemplatesHandler 获取提示词模板列表
+func getPromptTemplatesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    model.GetPromptTemplates(),
+	})
+}
+
+// createPromptTemplateHandler 创建提示词模板
+func createPromptTemplateHandler(c *gin.Context) {
+	var template model.PromptTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
+	}
+
+	created, err := model.CreatePromptTemplate(template)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("创建提示词模板失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "提示词模板已创建",
+		"data":    created,
+	})
+}
+
+// updatePromptTemplateHandler 更新提示词模板
+func updatePromptTemplateHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的模板ID",
+		})
+		return
+	}
+
+	var template model.PromptTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
+	}
+	template.ID = id
+
+	if err := model.UpdatePromptTemplate(template); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, model.ErrPromptTemplateNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("更新提示词模板失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "提示词模板已更新",
+	})
+}
+
+// deletePromptTemplateHandler 删除提示词模板
+func deletePromptTemplateHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的模板ID",
+		})
+		return
+	}
+
+	if err := model.DeletePromptTemplate(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, model.ErrPromptTemplateNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("删除提示词模板失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "提示词模板已删除",
+	})
+}
+
+// previewPromptTemplatesHandler 预览指定模型和客户端的消息注入提示词模板后的结果
+func previewPromptTemplatesHandler(c *gin.Context) {
+	var req struct {
+		Model     string        `json:"model"`
+		ClientKey string        `json:"client_key"`
+		Messages  []interface{} `json:"messages"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
+	}
+	if req.Messages == nil {
+		req.Messages = []interface{}{}
+	}
+
+	requestData := map[string]interface{}{
+		"model":    req.Model,
+		"messages": req.Messages,
+	}
+	applied := proxy.ApplyPromptTemplates(requestData, req.ClientKey)
+	if applied == nil {
+		applied = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"templates": applied,
+			"messages":  requestData["messages"],
+		},
+	})
+}
+
+// previewTransformHandler 预览请求体经过转换规则后的结果，不会转发请求
+func previewTransformHandler(c *gin.Context) {
+	var req struct {
+		Path      string          `json:"path"`
+		Body      json.RawMessage `json:"body"`
+		ClientKey string          `json:"client_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Body) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数需要包含path和body",
+		})
+		return
+	}
+	if req.Path == "" {
+		req.Path = "/v1/chat/completions"
+	}
+
+	transformed, err := proxy.TransformRequestBody(req.Body, req.Path, req.ClientKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("转换失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    json.RawMessage(transformed),
+	})
+}
+
+// handleModelManagementPage 处理模型管理页面请求
+func handleModelManagementPage(c *gin.Context) {
+	// 获取版本号
+	version := config.GetVersion()
+	if version == "" {
+		version = "v1.0.0" // 默认版本号
+	}
+
+	// 获取配置
+	cfg := config.GetConfig()
+	if cfg == nil {
+		// 如果配置为空，使用默认标题
+		c.HTML(http.StatusOK, "llmmodel.html", gin.H{
+			"title":   "流动硅基",
+			"version": version,
+		})
+		return
+	}
+
+	// 使用配置中的标题
+	c.HTML(http.StatusOK, "llmmodel.html", gin.H{
+		"title":   cfg.App.Title,
+		"version": version,
+	})
+}
+
+// deleteModelStrategyHandler 删除模型策略
+func deleteModelStrategyHandler(c *gin.Context) {
+	// 获取请求参数
+	var req struct {
+		ModelID string `json:"model_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("解析请求参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "解析请求参数失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 如果模型ID为空，返回错误
+	if req.ModelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "模型ID不能为空",
+		})
+		return
+	}
+
+	// 从数据库中删除模型策略
+	err := model.DeleteModelStrategy(req.ModelID)
+	if err != nil {
+		logger.Error("删除模型策略失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除模型策略失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 更新配置中的模型策略
+	cfg := config.GetConfig()
+	if cfg.App.ModelKeyStrategies != nil {
+		// 从配置中删除模型策略
+		delete(cfg.App.ModelKeyStrategies, req.ModelID)
+		config.UpdateConfig(cfg)
+		config.SaveConfigToDB()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("成功从数据库删除模型 %s 的策略", req.ModelID),
+	})
+}
+
+// handleLoginPage 处理登录页面请求
+func handleLoginPage(c *gin.Context) {
+	// 获取重定向路径（如果有）
+	redirect, _ := c.Cookie("redirect_after_login")
+
+	// 如果从查询参数中也传入了重定向路径，优先使用它
+	if redirectParam := c.Query("redirect"); redirectParam != "" {
+		redirect = redirectParam
+	}
+
+	// 清除重定向cookie
+	c.SetCookie("redirect_after_login", "", -1, "/", "", false, false)
+
+	// 获取错误信息（如果有）
+	error := c.Query("error")
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"title":        config.GetConfig().App.Title,
+		"redirect":     redirect,
+		"error":        error,
+		"oidc_enabled": auth.OIDCEnabled(),
+	})
+}
+
+// handleLogin 处理登录请求
+func handleLogin(c *gin.Context) {
+	// 获取表单参数
+	username := strings.TrimSpace(c.PostForm("username"))
+	password := c.PostForm("password")
+	redirect := c.PostForm("redirect")
 
 	// 判断是否是AJAX请求
 	isAjax := c.GetHeader("X-Requested-With") == "XMLHttpRequest" ||
@@ -1950,6 +3130,30 @@ func handleLogin(c *gin.Context) {
 		redirect = "/"
 	}
 
+	// 检查该IP是否因多次登录失败被锁定
+	clientIP := middleware.ClientIP(c)
+	if retryAfter, allowed := auth.CheckLoginAllowed(clientIP); !allowed {
+		logger.Warn("登录尝试过于频繁，已拒绝: IP=%s, 剩余锁定时间=%v", clientIP, retryAfter)
+		config.AddAuditEntry(config.AuditActionLoginLocked, username, clientIP, false, "登录已被锁定")
+		message := fmt.Sprintf("登录失败次数过多，请%d分钟后重试", int(retryAfter.Minutes())+1)
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		if isAjax {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"code":    429,
+				"message": message,
+			})
+		} else {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s", message, redirect))
+		}
+		return
+	}
+
+	// 提供了用户名时使用用户账号登录
+	if username != "" {
+		handleUserLogin(c, username, password, redirect, isAjax)
+		return
+	}
+
 	// 获取配置中的密码
 	cfg := config.GetConfig()
 	if cfg == nil || cfg.Security.Password == "" {
@@ -1966,61 +3170,296 @@ func handleLogin(c *gin.Context) {
 		return
 	}
 
-	// 验证密码
-	if !auth.VerifyPassword(password, cfg.Security.Password) {
-		// 密码错误
-		if isAjax {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"code":    401,
-				"message": "密码错误，请重试",
-			})
-		} else {
-			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s",
-				"密码错误，请重试", redirect))
-		}
+	// 验证密码
+	if !auth.VerifyPassword(password, cfg.Security.Password) {
+		// 密码错误
+		recordLoginFailure(c, "", "密码错误")
+		if isAjax {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "密码错误，请重试",
+			})
+		} else {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s",
+				"密码错误，请重试", redirect))
+		}
+		return
+	}
+
+	// 旧版本的密码哈希在登录成功后升级为bcrypt
+	if auth.PasswordNeedsRehash(cfg.Security.Password) {
+		upgradePasswordHash(password)
+	}
+
+	// 生成并设置Cookie
+	if err := setAuthCookie(c, "", cfg.Security.ExpirationMinutes); err != nil {
+		if isAjax {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "登录处理失败，请稍后重试",
+			})
+		} else {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s",
+				"登录处理失败，请稍后重试", redirect))
+		}
+		return
+	}
+
+	auth.RecordLoginSuccess(clientIP)
+	config.AddAuditEntry(config.AuditActionLogin, "", clientIP, true, "全局密码登录")
+
+	// 响应请求
+	if isAjax {
+		c.JSON(http.StatusOK, gin.H{
+			"code":     200,
+			"message":  "登录成功",
+			"redirect": redirect,
+		})
+	} else {
+		c.Redirect(http.StatusFound, redirect)
+	}
+}
+
+// handleUserLogin 处理使用用户账号的登录请求
+func handleUserLogin(c *gin.Context, username, password, redirect string, isAjax bool) {
+	// 登录失败时统一提示，避免暴露用户是否存在
+	fail := func(status int, message string) {
+		if isAjax {
+			c.JSON(status, gin.H{
+				"code":    status,
+				"message": message,
+			})
+		} else {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s", message, redirect))
+		}
+	}
+
+	user, exists := config.GetUser(username)
+	if !exists || user.Disabled || user.PasswordHash == "" || !auth.VerifyPassword(password, user.PasswordHash) {
+		logger.Warn("用户登录失败: %s", username)
+		recordLoginFailure(c, username, "用户名或密码错误")
+		fail(http.StatusUnauthorized, "用户名或密码错误，请重试")
+		return
+	}
+
+	// 旧版本的密码哈希在登录成功后升级为bcrypt
+	if auth.PasswordNeedsRehash(user.PasswordHash) {
+		if hashedPassword, err := auth.HashPassword(password); err == nil {
+			if err := config.SetUserPassword(user.Username, hashedPassword, false); err != nil {
+				logger.Error("保存升级后的用户密码哈希失败: %v", err)
+			}
+		}
+	}
+
+	if err := setAuthCookie(c, user.Username, config.GetConfig().Security.ExpirationMinutes); err != nil {
+		fail(http.StatusInternalServerError, "登录处理失败，请稍后重试")
+		return
+	}
+
+	logger.Info("用户登录成功: %s, 角色=%s", user.Username, user.Role)
+	auth.RecordLoginSuccess(middleware.ClientIP(c))
+	config.AddAuditEntry(config.AuditActionLogin, user.Username, middleware.ClientIP(c), true, "用户登录，角色="+user.Role)
+
+	if isAjax {
+		c.JSON(http.StatusOK, gin.H{
+			"code":     200,
+			"message":  "登录成功",
+			"redirect": redirect,
+			"role":     user.Role,
+		})
+	} else {
+		c.Redirect(http.StatusFound, redirect)
+	}
+}
+
+// recordLoginFailure 记录登录失败，写入审计日志并在达到阈值时锁定该IP
+func recordLoginFailure(c *gin.Context, username, reason string) {
+	clientIP := middleware.ClientIP(c)
+	config.AddAuditEntry(config.AuditActionLogin, username, clientIP, false, reason)
+	if auth.RecordLoginFailure(clientIP) {
+		logger.Warn("IP %s 登录失败次数过多，已被锁定", clientIP)
+		config.AddAuditEntry(config.AuditActionLoginLocked, username, clientIP, false, "登录失败次数过多，已锁定")
+	}
+}
+
+// setAuthCookie 创建登录会话并将令牌写入Cookie，username为空表示使用全局密码登录
+func setAuthCookie(c *gin.Context, username string, expirationMinutes int) error {
+	// 确定有效期（默认最少60秒）
+	if expirationMinutes <= 0 {
+		expirationMinutes = 1 // 默认至少1分钟
+	}
+
+	// 创建会话并生成Cookie
+	cookieValue, err := auth.CreateSession(username, middleware.ClientIP(c), c.Request.UserAgent(), expirationMinutes)
+	if err != nil {
+		logger.Error("生成认证Cookie失败: %v", err)
+		return err
+	}
+
+	// 设置Cookie - 始终使用绝对过期时间
+	maxAge := expirationMinutes * 60 // 转换为秒
+
+	// 记录日志
+	logger.Info("设置认证Cookie，有效期: %d分钟", expirationMinutes)
+
+	c.SetCookie(middleware.AuthCookieName, cookieValue, maxAge, "/", "", false, true)
+	return nil
+}
+
+// upgradePasswordHash 使用当前的哈希方式重新保存密码
+func upgradePasswordHash(password string) {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Error("升级密码哈希失败: %v", err)
+		return
+	}
+
+	newConfig := *config.GetConfig()
+	newConfig.Security.Password = hashedPassword
+	config.UpdateConfig(&newConfig)
+
+	if err := config.SaveConfigToDB(); err != nil {
+		logger.Error("保存升级后的密码哈希失败: %v", err)
+		return
+	}
+	logger.Info("已将密码哈希升级为bcrypt")
+}
+
+// oidcStateCookieName 单点登录state的Cookie名称，用于把回调绑定到发起登录的浏览器
+const oidcStateCookieName = "flowsilicon_oidc_state"
+
+// oidcRedirectURI 获取单点登录的回调地址，优先使用配置的回调地址
+// 未配置时根据当前请求地址生成，X-Forwarded-Proto和X-Forwarded-Host只在请求来自受信任的反向代理时采用
+func oidcRedirectURI(c *gin.Context) string {
+	if redirectURL := config.GetConfig().OIDC.RedirectURL; redirectURL != "" {
+		return redirectURL
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if middleware.FromTrustedProxy(c) {
+		if proto := strings.ToLower(strings.TrimSpace(c.GetHeader("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := strings.TrimSpace(c.GetHeader("X-Forwarded-Host")); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+	return scheme + "://" + host + "/auth/oidc/callback"
+}
+
+// safeRedirectPath 只允许跳转到站内的相对路径，避免被利用为开放重定向
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// handleOIDCLogin 发起单点登录，跳转到身份提供方的授权页面
+func handleOIDCLogin(c *gin.Context) {
+	redirect := c.Query("redirect")
+	if redirect == "" {
+		redirect, _ = c.Cookie("redirect_after_login")
+	}
+
+	authURL, state, err := auth.BeginOIDCLogin(c.Request.Context(), oidcRedirectURI(c), safeRedirectPath(redirect))
+	if err != nil {
+		logger.Error("发起单点登录失败: %v", err)
+		c.Redirect(http.StatusFound, "/login?error="+url.QueryEscape("单点登录暂不可用，请使用密码登录"))
+		return
+	}
+
+	c.SetCookie(oidcStateCookieName, state, 600, "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// handleOIDCCallback 处理身份提供方的回调，验证通过后创建登录会话
+func handleOIDCCallback(c *gin.Context) {
+	clientIP := middleware.ClientIP(c)
+	fail := func(message, detail string) {
+		logger.Warn("单点登录失败: IP=%s, %s", clientIP, detail)
+		config.AddAuditEntry(config.AuditActionLogin, "", clientIP, false, "单点登录失败: "+detail)
+		c.Redirect(http.StatusFound, "/login?error="+url.QueryEscape(message))
+	}
+
+	// state必须与发起登录时写入浏览器的Cookie一致
+	stateCookie, _ := c.Cookie(oidcStateCookieName)
+	c.SetCookie(oidcStateCookieName, "", -1, "/", "", c.Request.TLS != nil, true)
+
+	if errCode := c.Query("error"); errCode != "" {
+		fail("身份提供方拒绝了登录请求", errCode+" "+c.Query("error_description"))
+		return
+	}
+	state := c.Query("state")
+	if state == "" || state != stateCookie {
+		fail("登录请求无效，请重新登录", "state不匹配")
 		return
 	}
 
-	// 确定有效期（默认最少60秒）
-	expirationMinutes := cfg.Security.ExpirationMinutes
-	if expirationMinutes <= 0 {
-		expirationMinutes = 1 // 默认至少1分钟
+	identity, redirect, err := auth.CompleteOIDCLogin(c.Request.Context(), state, c.Query("code"), oidcRedirectURI(c))
+	if err != nil {
+		fail("单点登录失败: "+err.Error(), err.Error())
+		return
 	}
 
-	// 生成Cookie
-	cookieValue, err := auth.GenerateCookie(expirationMinutes)
+	user, err := ensureOIDCUser(identity)
 	if err != nil {
-		logger.Error("生成认证Cookie失败: %v", err)
-		if isAjax {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"code":    500,
-				"message": "登录处理失败，请稍后重试",
-			})
-		} else {
-			c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=%s&redirect=%s",
-				"登录处理失败，请稍后重试", redirect))
-		}
+		fail(err.Error(), fmt.Sprintf("%s: %v", identity.Email, err))
 		return
 	}
 
-	// 设置Cookie - 始终使用绝对过期时间
-	maxAge := expirationMinutes * 60 // 转换为秒
+	if err := setAuthCookie(c, user.Username, config.GetConfig().Security.ExpirationMinutes); err != nil {
+		logger.Error("生成认证令牌失败: %v", err)
+		c.Redirect(http.StatusFound, "/login?error="+url.QueryEscape("服务器内部错误"))
+		return
+	}
 
-	// 记录日志
-	logger.Info("设置认证Cookie，有效期: %d分钟", expirationMinutes)
+	logger.Info("单点登录成功: %s, 角色=%s", user.Username, user.Role)
+	auth.RecordLoginSuccess(clientIP)
+	config.AddAuditEntry(config.AuditActionLogin, user.Username, clientIP, true, "单点登录，角色="+user.Role)
+	c.Redirect(http.StatusFound, safeRedirectPath(redirect))
+}
 
-	c.SetCookie(middleware.AuthCookieName, cookieValue, maxAge, "/", "", false, true)
+// ensureOIDCUser 获取单点登录对应的用户，首次登录时以默认角色自动创建
+// 用户按身份提供方的发行方和用户标识关联，不会按邮箱关联已有的本地用户，避免接管同名的密码账号
+// 自动创建的用户使用随机密码，只能通过单点登录进入，管理员可在用户管理中调整角色
+func ensureOIDCUser(identity *auth.OIDCIdentity) (config.User, error) {
+	user, exists := config.GetUserByOIDC(identity.Issuer, identity.Subject)
+	if !exists {
+		if _, taken := config.GetUser(identity.Email); taken {
+			return config.User{}, errors.New("该邮箱已被其他用户使用，请联系管理员")
+		}
 
-	// 响应请求
-	if isAjax {
-		c.JSON(http.StatusOK, gin.H{
-			"code":     200,
-			"message":  "登录成功",
-			"redirect": redirect,
-		})
-	} else {
-		c.Redirect(http.StatusFound, redirect)
+		role := config.GetConfig().OIDC.DefaultRole
+		if !auth.IsValidRole(role) {
+			role = auth.RoleViewer
+		}
+
+		randomPassword := make([]byte, 32)
+		if _, err := rand.Read(randomPassword); err != nil {
+			return config.User{}, errors.New("服务器内部错误")
+		}
+		hashedPassword, err := auth.HashPassword(hex.EncodeToString(randomPassword))
+		if err != nil {
+			return config.User{}, errors.New("服务器内部错误")
+		}
+		if err := config.CreateOIDCUser(identity.Email, hashedPassword, role, identity.Issuer, identity.Subject); err != nil && !errors.Is(err, config.ErrUserExists) {
+			return config.User{}, errors.New("创建用户失败")
+		}
+		// 同一用户同时首次登录时可能已由另一个请求创建
+		if user, exists = config.GetUserByOIDC(identity.Issuer, identity.Subject); !exists {
+			return config.User{}, errors.New("该邮箱已被其他用户使用，请联系管理员")
+		}
 	}
+
+	if user.Disabled {
+		return config.User{}, errors.New("该用户已被禁用")
+	}
+	return user, nil
 }
 
 // handleLogout 处理登出请求
@@ -2030,6 +3469,16 @@ func handleLogout(c *gin.Context) {
 		c.GetHeader("Accept") == "application/json" ||
 		c.Query("format") == "json"
 
+	// 撤销当前会话
+	if cookie, err := c.Cookie(middleware.AuthCookieName); err == nil && cookie != "" {
+		if session, err := auth.ResolveSession(cookie); err == nil {
+			if err := config.RevokeLoginSession(session.ID); err != nil {
+				logger.Error("撤销会话失败: %v", err)
+			}
+			config.AddAuditEntry(config.AuditActionLogout, session.Username, middleware.ClientIP(c), true, "")
+		}
+	}
+
 	// 清除认证Cookie
 	c.SetCookie(middleware.AuthCookieName, "", -1, "/", "", false, true)
 
@@ -2063,6 +3512,7 @@ func handleAuthCheck(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"code":    200,
 			"message": "已认证",
+			"role":    auth.RoleAdmin,
 		})
 		return
 	}
@@ -2078,8 +3528,8 @@ func handleAuthCheck(c *gin.Context) {
 	}
 
 	// 验证令牌
-	valid, err := auth.ParseCookie(cookie)
-	if err != nil || !valid {
+	session, err := auth.ResolveSession(cookie)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"code":    401,
 			"message": "认证已过期",
@@ -2089,11 +3539,352 @@ func handleAuthCheck(c *gin.Context) {
 
 	// 认证有效
 	c.JSON(http.StatusOK, gin.H{
-		"code":    200,
-		"message": "已认证",
+		"code":     200,
+		"message":  "已认证",
+		"username": session.Username,
+		"role":     session.Role,
+	})
+}
+
+// handleListUsers 获取所有用户
+func handleListUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"users": config.GetUsers(),
+	})
+}
+
+// handleCreateUser 创建用户
+func handleCreateUser(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效请求: %v", err),
+		})
+		return
+	}
+
+	if !auth.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效的角色: %s", req.Role),
+		})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("密码处理失败: %v", err),
+		})
+		return
+	}
+
+	if err := config.CreateUser(req.Username, hashedPassword, req.Role); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrUserExists) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"error": fmt.Sprintf("创建用户失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "用户创建成功",
+	})
+}
+
+// handleUpdateUser 更新用户的角色、密码或禁用状态
+func handleUpdateUser(c *gin.Context) {
+	username := c.Param("username")
+	user, exists := config.GetUser(username)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "用户不存在",
+		})
+		return
+	}
+
+	var req struct {
+		Password *string `json:"password"`
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效请求: %v", err),
+		})
+		return
+	}
+
+	if req.Role != nil && !auth.IsValidRole(*req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效的角色: %s", *req.Role),
+		})
+		return
+	}
+
+	// 不允许移除最后一个可用的管理员
+	removesAdmin := (req.Role != nil && *req.Role != auth.RoleAdmin) || (req.Disabled != nil && *req.Disabled)
+	if user.Role == auth.RoleAdmin && !user.Disabled && removesAdmin && isLastAdmin(user.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "不能移除最后一个管理员",
+		})
+		return
+	}
+
+	if req.Password != nil && *req.Password != "" {
+		hashedPassword, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("密码处理失败: %v", err),
+			})
+			return
+		}
+		// 修改密码后该用户已签发的令牌全部失效
+		if err := config.SetUserPassword(user.Username, hashedPassword, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("更新用户密码失败: %v", err),
+			})
+			return
+		}
+		config.RevokeUserLoginSessions(user.Username)
+	}
+
+	if req.Role != nil {
+		if err := config.SetUserRole(user.Username, *req.Role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("更新用户角色失败: %v", err),
+			})
+			return
+		}
+	}
+
+	if req.Disabled != nil {
+		if err := config.SetUserDisabled(user.Username, *req.Disabled); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("更新用户状态失败: %v", err),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "用户更新成功",
+	})
+}
+
+// handleDeleteUser 删除用户
+func handleDeleteUser(c *gin.Context) {
+	username := c.Param("username")
+	user, exists := config.GetUser(username)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "用户不存在",
+		})
+		return
+	}
+
+	if user.Role == auth.RoleAdmin && !user.Disabled && isLastAdmin(user.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "不能删除最后一个管理员",
+		})
+		return
+	}
+
+	if err := config.DeleteUser(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("删除用户失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "用户删除成功",
+	})
+}
+
+// handleListSessions 获取所有有效的登录会话
+func handleListSessions(c *gin.Context) {
+	currentSession := c.GetString(middleware.ContextSessionKey)
+	sessions := config.GetActiveLoginSessions()
+
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":         session.ID,
+			"username":   session.Username,
+			"ip":         session.IP,
+			"user_agent": session.UserAgent,
+			"created_at": session.CreatedAt,
+			"expires_at": session.ExpiresAt,
+			"last_seen":  session.LastSeen,
+			"current":    session.ID == currentSession,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": result,
+	})
+}
+
+// handleRevokeSession 撤销指定的登录会话
+func handleRevokeSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	session, exists := config.GetLoginSession(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "会话不存在",
+		})
+		return
+	}
+
+	if err := config.RevokeLoginSession(sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("撤销会话失败: %v", err),
+		})
+		return
+	}
+
+	config.AddAuditEntry(config.AuditActionSessionRevoke, c.GetString(middleware.ContextUsernameKey), middleware.ClientIP(c), true,
+		fmt.Sprintf("撤销会话 %s（用户=%s, IP=%s）", sessionID, session.Username, session.IP))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "会话已撤销",
+	})
+}
+
+// handleGetAuditLog 获取审计日志
+func handleGetAuditLog(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	entries, err := config.GetAuditEntries(c.Query("action"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("获取审计日志失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+// handleListVideoJobs 获取最近的视频生成任务
+func handleListVideoJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	jobs, err := config.ListVideoJobs(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("获取视频任务失败: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobs,
+	})
+}
+
+// handleDeleteVideoJob 删除视频生成任务记录及其本地视频文件
+func handleDeleteVideoJob(c *gin.Context) {
+	localFile, err := config.DeleteVideoJob(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrVideoJobNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error": fmt.Sprintf("删除视频任务失败: %v", err),
+		})
+		return
+	}
+	if localFile != "" {
+		if err := os.Remove(localFile); err != nil && !os.IsNotExist(err) {
+			logger.Error("删除视频文件失败: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "视频任务已删除",
+	})
+}
+
+// handleListGalleryImages 分页获取图库中归档的生成图片
+func handleListGalleryImages(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	images, total, err := config.ListGalleryImages(c.Query("model"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("获取图库失败: %v", err),
+		})
+		return
+	}
+
+	result := make([]gin.H, 0, len(images))
+	for _, image := range images {
+		result = append(result, gin.H{
+			"id":           image.ID,
+			"model":        image.Model,
+			"prompt":       image.Prompt,
+			"size":         image.Size,
+			"masked_key":   image.MaskedKey,
+			"content_type": image.ContentType,
+			"bytes":        image.Bytes,
+			"created_at":   image.CreatedAt,
+			"url":          proxy.ImageFilesPath + image.ID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"images": result,
+		"total":  total,
 	})
 }
 
+// handleDeleteGalleryImage 删除图库中的图片及其本地文件
+func handleDeleteGalleryImage(c *gin.Context) {
+	localFile, err := config.DeleteGalleryImage(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrGalleryImageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error": fmt.Sprintf("删除图片失败: %v", err),
+		})
+		return
+	}
+	if err := os.Remove(localFile); err != nil && !os.IsNotExist(err) {
+		logger.Error("删除归档图片文件失败: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "图片已删除",
+	})
+}
+
+// isLastAdmin 判断移除指定用户后是否没有其他管理员可以登录
+// 设置了全局密码时，使用全局密码登录的会话始终是管理员
+func isLastAdmin(username string) bool {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Security.Password != "" {
+		return false
+	}
+	for _, user := range config.GetUsers() {
+		if user.Username != username && user.Role == auth.RoleAdmin && !user.Disabled {
+			return false
+		}
+	}
+	return true
+}
+
 // getTopModelsHandler 获取调用次数最多的模型
 func getTopModelsHandler(c *gin.Context) {
 	// 默认最多返回3个
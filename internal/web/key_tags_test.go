@@ -0,0 +1,117 @@
+/**
+  @author: Hanhai
+  @desc: 密钥标签和按标签批量操作接口的测试，重复执行同一操作的结果保持不变
+**/
+
+package web
+
+import (
+	"encoding/json"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestKeyTagOperationsAreIdempotent(t *testing.T) {
+	logger.InitLogger()
+	gin.SetMode(gin.TestMode)
+	previous := config.GetConfig()
+	config.UpdateConfig(&config.Config{})
+	keys := []string{"sk-tags-handler-a", "sk-tags-handler-b", "sk-tags-handler-c"}
+	for _, k := range keys {
+		config.AddApiKey(k, 10)
+	}
+	t.Cleanup(func() {
+		for _, k := range keys {
+			config.MarkApiKeyForDeletion(k)
+		}
+		config.UpdateConfig(previous)
+	})
+
+	router := gin.New()
+	router.PUT("/keys/:key/tags", handleSetKeyTags)
+	router.POST("/keys/tags/:tag/enable", handleEnableKeysByTag)
+	router.POST("/keys/tags/:tag/disable", handleDisableKeysByTag)
+
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	tagsOf := func(apiKey string) string {
+		for _, k := range config.GetApiKeys() {
+			if k.Key == apiKey {
+				return strings.Join(k.Tags, ",")
+			}
+		}
+		return ""
+	}
+	disabledOf := func(apiKey string) bool {
+		for _, k := range config.GetApiKeys() {
+			if k.Key == apiKey {
+				return k.Disabled
+			}
+		}
+		return false
+	}
+
+	// 重复设置相同的标签，结果不变
+	for i := 0; i < 2; i++ {
+		for _, k := range keys[:2] {
+			if code, _ := do(http.MethodPut, "/keys/"+k+"/tags", `{"tags": ["Paid", " paid ", "team-a"]}`); code != http.StatusOK {
+				t.Fatalf("第%d次设置标签失败: %d", i+1, code)
+			}
+			if got := tagsOf(k); got != "paid,team-a" {
+				t.Fatalf("第%d次设置后标签 = %s, want paid,team-a", i+1, got)
+			}
+		}
+	}
+	if code, _ := do(http.MethodPut, "/keys/sk-not-exist/tags", `{"tags": ["paid"]}`); code != http.StatusNotFound {
+		t.Errorf("不存在的密钥应返回404: %d", code)
+	}
+
+	// 重复按标签禁用和启用，只影响带该标签的密钥
+	for i := 0; i < 2; i++ {
+		code, resp := do(http.MethodPost, "/keys/tags/paid/disable", "")
+		if code != http.StatusOK || resp["disabled"] != float64(2) {
+			t.Fatalf("第%d次按标签禁用: %d %v", i+1, code, resp)
+		}
+		if !disabledOf(keys[0]) || !disabledOf(keys[1]) || disabledOf(keys[2]) {
+			t.Fatalf("第%d次按标签禁用后状态不正确", i+1)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		code, resp := do(http.MethodPost, "/keys/tags/PAID/enable", "")
+		if code != http.StatusOK || resp["enabled"] != float64(2) {
+			t.Fatalf("第%d次按标签启用: %d %v", i+1, code, resp)
+		}
+		if disabledOf(keys[0]) || disabledOf(keys[1]) {
+			t.Fatalf("第%d次按标签启用后仍有密钥被禁用", i+1)
+		}
+	}
+
+	// 重复移除标签后，按该标签的批量操作找不到密钥
+	for i := 0; i < 2; i++ {
+		if code, _ := do(http.MethodPut, "/keys/"+keys[0]+"/tags", `{"tags": ["team-a"]}`); code != http.StatusOK {
+			t.Fatalf("第%d次移除标签失败: %d", i+1, code)
+		}
+		if got := tagsOf(keys[0]); got != "team-a" {
+			t.Fatalf("第%d次移除后标签 = %s, want team-a", i+1, got)
+		}
+	}
+	if code, resp := do(http.MethodPost, "/keys/tags/paid/disable", ""); code != http.StatusOK || resp["disabled"] != float64(1) {
+		t.Errorf("移除标签后按标签禁用: %d %v", code, resp)
+	}
+	if code, _ := do(http.MethodPost, "/keys/tags/unknown/disable", ""); code != http.StatusNotFound {
+		t.Errorf("没有密钥带该标签时应返回404: %d", code)
+	}
+}
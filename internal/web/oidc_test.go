@@ -0,0 +1,55 @@
+/**
+  @author: Hanhai
+  @desc: 单点登录用户关联的测试，用户按发行方和用户标识关联，不按邮箱关联已有的本地用户
+**/
+
+package web
+
+import (
+	"flowsilicon/internal/auth"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"testing"
+)
+
+func TestEnsureOIDCUserLinksByIssuerAndSubject(t *testing.T) {
+	logger.InitLogger()
+	cfg := &config.Config{}
+	cfg.OIDC.DefaultRole = auth.RoleViewer
+	previous := config.GetConfig()
+	config.UpdateConfig(cfg)
+	t.Cleanup(func() {
+		config.UpdateConfig(previous)
+		for _, username := range []string{"admin@example.com", "bob@example.com"} {
+			config.DeleteUser(username)
+		}
+	})
+
+	// 已有的密码账号不能通过邮箱相同的单点登录身份接管
+	if err := config.CreateUser("admin@example.com", "hash", auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	attacker := &auth.OIDCIdentity{Issuer: "https://idp.example.com", Subject: "attacker", Email: "admin@example.com"}
+	if user, err := ensureOIDCUser(attacker); err == nil {
+		t.Fatalf("不应按邮箱关联已有的本地用户: %+v", user)
+	}
+
+	// 首次登录时创建用户，之后按发行方和用户标识识别
+	bob := &auth.OIDCIdentity{Issuer: "https://idp.example.com", Subject: "bob-sub", Email: "bob@example.com"}
+	user, err := ensureOIDCUser(bob)
+	if err != nil {
+		t.Fatalf("首次单点登录应创建用户: %v", err)
+	}
+	if user.Username != "bob@example.com" || user.Role != auth.RoleViewer || user.OIDCSubject != "bob-sub" {
+		t.Fatalf("创建的用户不正确: %+v", user)
+	}
+	if again, err := ensureOIDCUser(bob); err != nil || again.Username != user.Username {
+		t.Fatalf("再次登录应返回同一用户: %+v, %v", again, err)
+	}
+
+	// 其他身份提供方的同一用户标识不能登录该用户
+	other := &auth.OIDCIdentity{Issuer: "https://other.example.com", Subject: "bob-sub", Email: "bob@example.com"}
+	if user, err := ensureOIDCUser(other); err == nil {
+		t.Fatalf("其他发行方的身份不应关联到已有用户: %+v", user)
+	}
+}
@@ -8,6 +8,7 @@ package web
 
 import (
 	"embed"
+	"flowsilicon/internal/auth"
 	"flowsilicon/internal/config"
 	"flowsilicon/internal/middleware"
 	"flowsilicon/internal/proxy"
@@ -27,11 +28,14 @@ var staticFS embed.FS
 // SetupApiProxy 设置 API 代理路由
 func SetupApiProxy(router *gin.Engine) {
 	// 代理所有 API 请求
-	router.Any("/api/*path", proxy.HandleApiProxy)
+	router.Any("/api/*path", middleware.IPFilterMiddleware(middleware.IPScopeApi), middleware.ProxyCorsMiddleware(),
+		middleware.PriorityMiddleware(), proxy.HandleApiProxy)
 
-	// 添加API密钥验证中间件
+	// 添加IP访问控制、跨域中间件、客户端证书验证、API密钥验证中间件和请求优先级中间件
+	// 跨域中间件需在证书和密钥验证之前，预检请求不携带凭证
 	openaiGroup := router.Group("")
-	openaiGroup.Use(middleware.APIKeyMiddleware())
+	openaiGroup.Use(middleware.IPFilterMiddleware(middleware.IPScopeProxy), middleware.ProxyCorsMiddleware(),
+		middleware.ClientCertMiddleware(), middleware.APIKeyMiddleware(), middleware.PriorityMiddleware())
 
 	// 添加对 OpenAI 格式 API 的支持
 	openaiGroup.Any("/v1/*path", proxy.HandleOpenAIProxy)
@@ -51,29 +55,42 @@ func SetupApiProxy(router *gin.Engine) {
 	openaiGroup.Any("/images", proxy.HandleOpenAIProxy)
 	openaiGroup.Any("/images/*path", proxy.HandleOpenAIProxy)
 
+	// 语音转文字和文字转语音
+	openaiGroup.Any("/audio/*path", proxy.HandleOpenAIProxy)
+
+	// 视频生成任务
+	openaiGroup.Any("/video/*path", proxy.HandleOpenAIProxy)
+
 	// 模型列表
 	openaiGroup.Any("/models", proxy.HandleOpenAIProxy)
 
 	// 重排序
 	openaiGroup.Any("/rerank", proxy.HandleOpenAIProxy)
+	openaiGroup.Any("/v2/rerank", proxy.HandleOpenAIProxy)
 
 	// 用户信息
 	openaiGroup.Any("/user/info", proxy.HandleOpenAIProxy)
+
+	// 归档的生成图片，客户端直接展示图片时无法携带API密钥，不做密钥验证
+	router.GET(proxy.ImageFilesPath+":id", middleware.IPFilterMiddleware(middleware.IPScopeProxy), middleware.ProxyCorsMiddleware(),
+		proxy.HandleImageFile)
 }
 
 // SetupKeysAPI 设置API密钥相关路由
 func SetupKeysAPI(router *gin.Engine) {
+	keysAPI := router.Group("", middleware.IPFilterMiddleware(middleware.IPScopeAdmin), middleware.AdminCorsMiddleware())
+
 	// 获取当前请求统计
-	router.GET("/request-stats/current", handleGetCurrentRequestStats)
+	keysAPI.GET("/request-stats/current", handleGetCurrentRequestStats)
 
 	// 获取每日统计数据
-	router.GET("/request-stats/daily", handleGetDailyStats)
+	keysAPI.GET("/request-stats/daily", handleGetDailyStats)
 
 	// 获取指定日期的统计数据
-	router.GET("/request-stats/daily/:date", handleGetDailyStatsByDate)
+	keysAPI.GET("/request-stats/daily/:date", handleGetDailyStatsByDate)
 
 	// 刷新所有API密钥余额
-	router.POST("/keys/refresh", handleRefreshAllKeysBalance)
+	keysAPI.POST("/keys/refresh", handleRefreshAllKeysBalance)
 }
 
 // SetupWebServer 设置 Web 服务器
@@ -82,6 +99,9 @@ func SetupWebServer(router *gin.Engine) {
 	templ := template.Must(template.New("").ParseFS(templatesFS, "templates/*.html"))
 	router.SetHTMLTemplate(templ)
 
+	// 管理界面的IP访问控制和跨域策略，同时处理管理接口的预检请求
+	router.Use(middleware.IPFilterMiddleware(middleware.IPScopeAdmin), middleware.AdminCorsMiddleware())
+
 	// 添加禁用静态文件缓存的中间件
 	router.Use(func(c *gin.Context) {
 		if strings.HasPrefix(c.Request.URL.Path, "/static-fs/") {
@@ -114,12 +134,19 @@ func SetupWebServer(router *gin.Engine) {
 	router.POST("/auth/login", handleLogin)
 	router.GET("/logout", handleLogout)
 	router.GET("/auth/check", handleAuthCheck)
+	router.GET("/auth/oidc/login", handleOIDCLogin)
+	router.GET("/auth/oidc/callback", handleOIDCCallback)
 
 	// 应用身份验证中间件
 	router.Use(middleware.AuthMiddleware())
 
+	// 按角色划分路由：访客只能查看统计，运维人员可管理密钥和模型，管理员可修改设置和重启系统
+	viewer := router.Group("", middleware.RequireRole(auth.RoleViewer))
+	operator := router.Group("", middleware.RequireRole(auth.RoleOperator))
+	admin := router.Group("", middleware.RequireRole(auth.RoleAdmin))
+
 	// 页面路由
-	router.GET("/", func(c *gin.Context) {
+	viewer.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", gin.H{
 			"title":                  config.GetConfig().App.Title,
 			"max_balance_display":    config.GetConfig().App.MaxBalanceDisplay,
@@ -132,75 +159,114 @@ func SetupWebServer(router *gin.Engine) {
 	})
 
 	// 设置页面
-	router.GET("/setting", func(c *gin.Context) {
+	admin.GET("/setting", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "setting.html", gin.H{
 			"title": config.GetConfig().App.Title,
 		})
 	})
 
 	// 模型管理页面
-	router.GET("/model", handleModelManagementPage)
+	operator.GET("/model", handleModelManagementPage)
 
 	// API 密钥管理
-	router.GET("/keys", handleListKeys)
-	router.POST("/keys", handleAddKey)
-	router.DELETE("/keys/:key", handleDeleteKey)
-	router.POST("/keys/batch", handleBatchAddKeys)
-	router.POST("/keys/check", handleCheckKey)
-	router.POST("/keys/mode", handleSetKeyMode)
-	router.GET("/keys/mode", handleGetKeyMode)
-	router.POST("/keys/:key/enable", handleEnableKey)
-	router.POST("/keys/:key/disable", handleDisableKey)
-	router.DELETE("/keys/zero-balance", handleDeleteZeroBalanceKeys)
-	router.DELETE("/keys/low-balance/:threshold", handleDeleteLowBalanceKeys)
-	router.GET("/test-key", handleGetTestKey)
+	operator.GET("/keys", handleListKeys)
+	operator.POST("/keys", handleAddKey)
+	operator.DELETE("/keys/:key", handleDeleteKey)
+	operator.POST("/keys/batch", handleBatchAddKeys)
+	operator.POST("/keys/check", handleCheckKey)
+	operator.POST("/keys/mode", handleSetKeyMode)
+	operator.GET("/keys/mode", handleGetKeyMode)
+	operator.POST("/keys/:key/enable", handleEnableKey)
+	operator.POST("/keys/:key/disable", handleDisableKey)
+	operator.GET("/keys/cooldowns", handleListKeyCooldowns)
+	operator.DELETE("/keys/:key/cooldown", handleClearKeyCooldown)
+	operator.PUT("/keys/:key/tags", handleSetKeyTags)
+	operator.GET("/keys/groups", handleListKeyGroups)
+	operator.POST("/keys/tags/:tag/enable", handleEnableKeysByTag)
+	operator.POST("/keys/tags/:tag/disable", handleDisableKeysByTag)
+	operator.DELETE("/keys/tags/:tag", handleDeleteKeysByTag)
+	operator.DELETE("/keys/zero-balance", handleDeleteZeroBalanceKeys)
+	operator.DELETE("/keys/low-balance/:threshold", handleDeleteLowBalanceKeys)
+	operator.GET("/test-key", handleGetTestKey)
 
 	// 设置页面的-模型管理API
-	router.GET("/models/list", getModelsHandler)
-	router.POST("/models/sync", syncModelsHandler)
-	router.POST("/models/strategy", updateModelStrategyHandler)
-	router.DELETE("/models/strategy", deleteModelStrategyHandler)
+	operator.GET("/models/list", getModelsHandler)
+	operator.POST("/models/sync", syncModelsHandler)
+	operator.POST("/models/strategy", updateModelStrategyHandler)
+	operator.DELETE("/models/strategy", deleteModelStrategyHandler)
 
 	// 获取常用模型
-	router.GET("/models/top", getTopModelsHandler)
+	operator.GET("/models/top", getTopModelsHandler)
 
 	// 模型管理页面-模型管理API
-	router.GET("/models-api/list", getModelsAPIHandler)
-	router.GET("/models-api/status", getModelsStatusHandler)
-	router.POST("/models-api/update", updateModelsHandler)
-	router.POST("/models-api/type", updateModelTypeHandler)
+	operator.GET("/models-api/list", getModelsAPIHandler)
+	operator.GET("/models-api/status", getModelsStatusHandler)
+	operator.POST("/models-api/update", updateModelsHandler)
+	operator.POST("/models-api/type", updateModelTypeHandler)
+	operator.POST("/models-api/tool-emulation", updateModelToolEmulationHandler)
+	operator.POST("/models-api/vision", updateModelVisionHandler)
+	operator.GET("/models-api/transforms", getTransformRulesHandler)
+	operator.POST("/models-api/transforms", createTransformRuleHandler)
+	operator.POST("/models-api/transforms/preview", previewTransformHandler)
+	operator.PUT("/models-api/transforms/:id", updateTransformRuleHandler)
+	operator.DELETE("/models-api/transforms/:id", deleteTransformRuleHandler)
+	operator.GET("/models-api/prompts", getPromptTemplatesHandler)
+	operator.POST("/models-api/prompts", createPromptTemplateHandler)
+	operator.POST("/models-api/prompts/preview", previewPromptTemplatesHandler)
+	operator.PUT("/models-api/prompts/:id", updatePromptTemplateHandler)
+	operator.DELETE("/models-api/prompts/:id", deletePromptTemplateHandler)
+
+	// 视频生成任务
+	operator.GET("/video-jobs", handleListVideoJobs)
+	operator.DELETE("/video-jobs/:id", handleDeleteVideoJob)
+	operator.GET("/image-gallery", handleListGalleryImages)
+	operator.DELETE("/image-gallery/:id", handleDeleteGalleryImage)
 
 	// API 密钥统计
-	router.GET("/stats", handleStats)
+	viewer.GET("/stats", handleStats)
 
 	// 日志查看
-	router.GET("/logs", handleGetLogs)
+	admin.GET("/logs", handleGetLogs)
 
 	// 测试embeddings API
-	router.POST("/test-chat", handleTestChat)
+	operator.POST("/test-chat", handleTestChat)
 
 	// 测试embeddings API
-	router.POST("/test-embeddings", handleTestEmbeddings)
+	operator.POST("/test-embeddings", handleTestEmbeddings)
 
 	// 测试图片生成API
-	router.POST("/test-images", handleTestImages)
+	operator.POST("/test-images", handleTestImages)
 
 	// 测试模型列表API
-	router.POST("/test-models", handleTestModels)
+	operator.POST("/test-models", handleTestModels)
 
 	// 测试重排序API
-	router.POST("/test-rerank", handleTestRerank)
+	operator.POST("/test-rerank", handleTestRerank)
 
 	// 请求统计数据
-	router.GET("/request-stats", handleRequestStats)
+	viewer.GET("/request-stats", handleRequestStats)
+
+	// 请求队列统计，包含各密钥的排队和并发详情，需要登录后查看
+	viewer.GET("/request-stats/queue", handleGetQueueStats)
 
 	// 设置相关API
-	router.GET("/settings/config", handleGetSettings)
-	router.POST("/settings/config", handleSaveSettings)
+	admin.GET("/settings/config", handleGetSettings)
+	admin.POST("/settings/config", handleSaveSettings)
+
+	// 用户管理API
+	admin.GET("/users", handleListUsers)
+	admin.POST("/users", handleCreateUser)
+	admin.PUT("/users/:username", handleUpdateUser)
+	admin.DELETE("/users/:username", handleDeleteUser)
+
+	// 登录会话与审计日志API
+	admin.GET("/sessions", handleListSessions)
+	admin.DELETE("/sessions/:id", handleRevokeSession)
+	admin.GET("/audit", handleGetAuditLog)
 
 	// 系统重启API
-	router.POST("/system/restart", handleSystemRestart)
+	admin.POST("/system/restart", handleSystemRestart)
 
 	// API密钥代理 - 解决CORS问题
-	router.GET("/proxy/apikeys", handleApiKeyProxy)
+	operator.GET("/proxy/apikeys", handleApiKeyProxy)
 }
@@ -132,6 +132,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     max_stats_entries: getValue('max-stats'),
                     [RECOVERY_INTERVAL]: getValue('recovery-interval'),
                     max_consecutive_failures: getValue('max-failures'),
+                    max_concurrency_per_key: getValue('max-concurrency'),
                     hide_icon: getValue('hide-icon'),
                     balance_weight: getValue('balance-weight'),
                     success_rate_weight: getValue('success-rate-weight'),
@@ -235,6 +236,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     max_stats_entries: getValue('max-stats'),
                     [RECOVERY_INTERVAL]: getValue('recovery-interval'),
                     max_consecutive_failures: getValue('max-failures'),
+                    max_concurrency_per_key: getValue('max-concurrency'),
                     hide_icon: getValue('hide-icon'),
                     balance_weight: getValue('balance-weight'),
                     success_rate_weight: getValue('success-rate-weight'),
@@ -694,6 +696,7 @@ function populateForm(config) {
     setValue('max-stats', config.app.max_stats_entries);
     setValue('recovery-interval', config.app[RECOVERY_INTERVAL]);
     setValue('max-failures', config.app.max_consecutive_failures);
+    setValue('max-concurrency', config.app.max_concurrency_per_key);
     setValue('hide-icon', config.app.hide_icon);
     
     // 权重配置
@@ -1132,6 +1135,7 @@ function saveSettings(callback) {
             max_stats_entries: getValue('max-stats'),
             recovery_interval: getValue('recovery-interval'),
             max_consecutive_failures: getValue('max-failures'),
+            max_concurrency_per_key: getValue('max-concurrency'),
             model_key_strategies: collectModelStrategies(),
             hide_icon: getValue('hide-icon'),
             balance_weight: getValue('balance-weight'),
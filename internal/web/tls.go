@@ -0,0 +1,279 @@
+/**
+  @author: Hanhai
+  @desc: HTTPS服务，支持证书文件热加载、自签名证书生成和客户端证书验证
+**/
+
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flowsilicon/internal/config"
+	"flowsilicon/internal/logger"
+	"flowsilicon/internal/middleware"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 检查证书文件是否更新的最小间隔
+	certReloadInterval = 10 * time.Second
+	// 自签名证书的有效期
+	selfSignedValidity = 365 * 24 * time.Hour
+	// 自签名证书剩余有效期少于该值时重新生成
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// certManager 管理服务器证书和客户端CA证书，文件修改后自动重新加载
+type certManager struct {
+	mutex     sync.Mutex
+	certFile  string
+	keyFile   string
+	caFile    string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	certMod   time.Time
+	keyMod    time.Time
+	caMod     time.Time
+	lastCheck time.Time
+}
+
+// RunServer 启动Web服务器，启用HTTPS时使用TLS监听
+func RunServer(router *gin.Engine, port int) error {
+	addr := fmt.Sprintf(":%d", port)
+	cfg := config.GetConfig()
+	if cfg == nil || !cfg.Server.TLS.Enabled {
+		middleware.SetClientCertRequired(false)
+		return router.Run(addr)
+	}
+
+	manager, err := newCertManager()
+	if err != nil {
+		return err
+	}
+
+	// 按启动时的配置确定是否要求客户端证书，之后修改配置需要重启才生效
+	requireClientCert := cfg.Server.TLS.RequireClientCert && manager.caFile != ""
+	if cfg.Server.TLS.RequireClientCert && manager.caFile == "" {
+		logger.Warn("已开启要求客户端证书，但未配置客户端CA证书文件，不会检查客户端证书")
+	}
+	middleware.SetClientCertRequired(requireClientCert)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: router.Handler(),
+		TLSConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			GetConfigForClient: manager.getConfigForClient,
+		},
+	}
+	logger.Info("已启用HTTPS，证书文件: %s，要求客户端证书: %v", manager.certFile, requireClientCert)
+	return server.ListenAndServeTLS("", "")
+}
+
+// ServerURL 获取本机访问服务的地址
+func ServerURL(port int) string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Server.TLS.Enabled {
+		return fmt.Sprintf("https://localhost:%d", port)
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// newCertManager 根据配置创建证书管理器并加载证书
+func newCertManager() (*certManager, error) {
+	tlsConfig := config.GetConfig().Server.TLS
+	manager := &certManager{
+		certFile: tlsConfig.CertFile,
+		keyFile:  tlsConfig.KeyFile,
+		caFile:   tlsConfig.ClientCAFile,
+	}
+
+	if manager.certFile == "" || manager.keyFile == "" {
+		if !tlsConfig.SelfSigned {
+			return nil, errors.New("启用HTTPS时需要指定证书和私钥文件，或开启自签名证书")
+		}
+		dir := filepath.Join(config.GetDataDir(), "tls")
+		manager.certFile = filepath.Join(dir, "server.crt")
+		manager.keyFile = filepath.Join(dir, "server.key")
+		if err := ensureSelfSignedCert(manager.certFile, manager.keyFile); err != nil {
+			return nil, fmt.Errorf("生成自签名证书失败: %w", err)
+		}
+	}
+
+	if err := manager.reload(true); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+// getConfigForClient 为每个TLS握手返回当前的证书配置
+func (m *certManager) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if err := m.reload(false); err != nil {
+		// 新证书无效时继续使用旧证书
+		logger.Error("重新加载证书失败，继续使用当前证书: %v", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*m.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if m.clientCAs != nil {
+		// 管理界面不要求客户端证书，是否必须提供由代理接口的中间件检查
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = m.clientCAs
+	}
+	return tlsConfig, nil
+}
+
+// reload 检查证书文件是否更新，更新时重新加载；force为true时忽略检查间隔直接加载
+func (m *certManager) reload(force bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !force && time.Since(m.lastCheck) < certReloadInterval {
+		return nil
+	}
+	m.lastCheck = time.Now()
+
+	certMod, err := fileModTime(m.certFile)
+	if err != nil {
+		return err
+	}
+	keyMod, err := fileModTime(m.keyFile)
+	if err != nil {
+		return err
+	}
+	if force || !certMod.Equal(m.certMod) || !keyMod.Equal(m.keyMod) {
+		cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+		if err != nil {
+			return fmt.Errorf("加载证书失败: %w", err)
+		}
+		if !force {
+			logger.Info("证书文件已更新，已重新加载: %s", m.certFile)
+		}
+		m.cert = &cert
+		m.certMod = certMod
+		m.keyMod = keyMod
+	}
+
+	if m.caFile == "" {
+		return nil
+	}
+	caMod, err := fileModTime(m.caFile)
+	if err != nil {
+		return err
+	}
+	if force || !caMod.Equal(m.caMod) {
+		caPEM, err := os.ReadFile(m.caFile)
+		if err != nil {
+			return fmt.Errorf("读取客户端CA证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return errors.New("客户端CA证书文件中没有有效的证书")
+		}
+		if !force {
+			logger.Info("客户端CA证书已更新，已重新加载: %s", m.caFile)
+		}
+		m.clientCAs = pool
+		m.caMod = caMod
+	}
+	return nil
+}
+
+// fileModTime 获取文件的修改时间
+func fileModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// ensureSelfSignedCert 确保自签名证书存在且未临近过期，否则重新生成
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	if certPEM, err := os.ReadFile(certFile); err == nil {
+		if _, err := os.Stat(keyFile); err == nil {
+			if block, _ := pem.Decode(certPEM); block != nil {
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil &&
+					time.Until(cert.NotAfter) > selfSignedRenewBefore {
+					return nil
+				}
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "FlowSilicon", Organization: []string{"FlowSilicon"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	// 加入本机的局域网地址，便于在局域网内通过IP访问
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+
+	logger.Warn("已生成自签名证书: %s，浏览器会提示证书不受信任，生产环境请使用正式证书", certFile)
+	return nil
+}